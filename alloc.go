@@ -0,0 +1,105 @@
+package gloom
+
+import "unsafe"
+
+// Allocator selects how a filter's block memory is allocated. Each allocator
+// falls back to the one before it when unavailable.
+//
+// Probes into a multi-gigabyte filter land on effectively random pages, so
+// with 4 KB pages nearly every operation also misses the TLB. Backing the
+// blocks with 2 MB huge pages lets the TLB cover 512 times more memory.
+type Allocator int
+
+const (
+	// HeapAllocator allocates blocks on the Go heap. It is the default.
+	HeapAllocator Allocator = iota
+
+	// HugePageAllocator allocates blocks with an anonymous mmap aligned to a
+	// huge page boundary and advises the kernel to back it with transparent
+	// huge pages (MADV_HUGEPAGE). It is only available on Linux.
+	HugePageAllocator
+
+	// HugeTLBAllocator allocates blocks from the kernel's explicit hugetlb
+	// pool (MAP_HUGETLB), which must have been reserved beforehand, e.g. via
+	// vm.nr_hugepages. If the pool cannot satisfy the request, it falls back
+	// to HugePageAllocator. It is only available on Linux.
+	HugeTLBAllocator
+)
+
+// String returns the name of the allocator.
+func (a Allocator) String() string {
+	switch a {
+	case HeapAllocator:
+		return "heap"
+	case HugePageAllocator:
+		return "hugepage"
+	case HugeTLBAllocator:
+		return "hugetlb"
+	default:
+		return "unknown"
+	}
+}
+
+// hugePageSize is the huge page size that mmap-backed allocations are
+// rounded and aligned to.
+const hugePageSize = 2 << 20
+
+// allocAligned allocates size bytes of cache-line aligned, zeroed memory
+// using the requested allocator, falling back to the Go heap if it is
+// unavailable. It returns the raw allocation (which must be kept alive, and
+// released with freeAligned), a pointer to the aligned start, and the
+// allocator that actually provided the memory.
+func allocAligned(size int, alloc Allocator) ([]byte, unsafe.Pointer, Allocator) {
+	// Try the requested allocator, then each fallback in turn
+	for alloc = min(alloc, HugeTLBAllocator); alloc > HeapAllocator; alloc-- {
+		if raw, ptr, ok := mmapAligned(size, alloc == HugeTLBAllocator); ok {
+			return raw, ptr, alloc
+		}
+	}
+
+	// Allocate with extra space for alignment
+	raw := make([]byte, size+cacheLineSize-1)
+	addr := uintptr(unsafe.Pointer(&raw[0]))
+	offset := (cacheLineSize - int(addr%cacheLineSize)) % cacheLineSize
+	return raw, unsafe.Pointer(&raw[offset]), HeapAllocator
+}
+
+// freeAligned releases memory returned by allocAligned. Heap memory is left
+// to the garbage collector.
+func freeAligned(raw []byte, alloc Allocator) error {
+	if alloc == HeapAllocator {
+		return nil
+	}
+	return munmap(raw)
+}
+
+// Close releases the filter's memory. For filters created with
+// HugePageAllocator or HugeTLBAllocator the mapping is unmapped, and it is
+// never released otherwise. The filter must not be used after Close.
+func (f *Filter) Close() error {
+	raw, alloc := f.raw, f.alloc
+	f.raw, f.blocks = nil, nil
+	return freeAligned(raw, alloc)
+}
+
+// Allocator returns the allocator that provided the filter's memory, which
+// may differ from the one requested if it was unavailable.
+func (f *Filter) Allocator() Allocator {
+	return f.alloc
+}
+
+// Close releases the filter's memory. For filters created with
+// HugePageAllocator or HugeTLBAllocator the mapping is unmapped, and it is
+// never released otherwise. The filter must not be used after Close, and
+// Close must not be called concurrently with any other method.
+func (f *AtomicFilter) Close() error {
+	raw, alloc := f.raw, f.alloc
+	f.raw, f.blocks = nil, nil
+	return freeAligned(raw, alloc)
+}
+
+// Allocator returns the allocator that provided the filter's memory, which
+// may differ from the one requested if it was unavailable.
+func (f *AtomicFilter) Allocator() Allocator {
+	return f.alloc
+}
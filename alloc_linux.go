@@ -0,0 +1,44 @@
+package gloom
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// mmapAligned allocates size bytes with an anonymous private mapping.
+//
+// With hugetlb, the mapping comes from the explicit hugetlb pool and is
+// already huge page aligned. Otherwise, the mapping is over-allocated by one
+// huge page so that its start can be aligned to a huge page boundary, and
+// the kernel is advised to back it with transparent huge pages.
+func mmapAligned(size int, hugetlb bool) ([]byte, unsafe.Pointer, bool) {
+	length := (size + hugePageSize - 1) &^ (hugePageSize - 1)
+	const prot = syscall.PROT_READ | syscall.PROT_WRITE
+
+	flags := syscall.MAP_PRIVATE | syscall.MAP_ANONYMOUS
+	pad := hugePageSize
+	if hugetlb {
+		// hugetlb mappings are always huge page aligned
+		flags |= syscall.MAP_HUGETLB
+		pad = 0
+	}
+
+	raw, err := syscall.Mmap(-1, 0, length+pad, prot, flags)
+	if err != nil {
+		return nil, nil, false
+	}
+	addr := uintptr(unsafe.Pointer(&raw[0]))
+	offset := int((hugePageSize - addr%hugePageSize) % hugePageSize)
+
+	// Advice is best-effort: it fails harmlessly when THP is disabled, and
+	// the mapping is still usable with regular pages. It is a no-op for
+	// hugetlb mappings.
+	_ = syscall.Madvise(raw[offset:offset+length], syscall.MADV_HUGEPAGE)
+
+	return raw, unsafe.Pointer(&raw[offset]), true
+}
+
+// munmap releases a mapping created by mmapAligned.
+func munmap(raw []byte) error {
+	return syscall.Munmap(raw)
+}
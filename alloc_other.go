@@ -0,0 +1,15 @@
+//go:build !linux
+
+package gloom
+
+import "unsafe"
+
+// mmapAligned is unavailable outside Linux, so allocAligned always falls
+// back to the Go heap.
+func mmapAligned(size int, hugetlb bool) ([]byte, unsafe.Pointer, bool) {
+	return nil, nil, false
+}
+
+func munmap(raw []byte) error {
+	panic("unreachable")
+}
@@ -0,0 +1,130 @@
+package gloom
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestAllocatorString(t *testing.T) {
+	tests := []struct {
+		alloc Allocator
+		want  string
+	}{
+		{HeapAllocator, "heap"},
+		{HugePageAllocator, "hugepage"},
+		{HugeTLBAllocator, "hugetlb"},
+		{Allocator(42), "unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.alloc.String(); got != tt.want {
+			t.Errorf("Allocator(%d).String() = %q, want %q", tt.alloc, got, tt.want)
+		}
+	}
+}
+
+// wantAllocator returns the allocators that may legitimately back a filter
+// requested with alloc on this platform.
+func wantAllocator(alloc Allocator) []Allocator {
+	if runtime.GOOS != "linux" {
+		return []Allocator{HeapAllocator}
+	}
+	switch alloc {
+	case HugeTLBAllocator:
+		// The hugetlb pool is usually empty on test machines
+		return []Allocator{HugeTLBAllocator, HugePageAllocator}
+	case HugePageAllocator:
+		return []Allocator{HugePageAllocator}
+	default:
+		return []Allocator{HeapAllocator}
+	}
+}
+
+func TestAllocators(t *testing.T) {
+	for _, alloc := range []Allocator{HeapAllocator, HugePageAllocator, HugeTLBAllocator, Allocator(-1), Allocator(42)} {
+		t.Run(alloc.String(), func(t *testing.T) {
+			f := NewWithParamsAllocator(1000, 7, alloc)
+			af := NewAtomicWithParamsAllocator(1000, 7, alloc)
+
+			want := wantAllocator(min(max(alloc, HeapAllocator), HugeTLBAllocator))
+			if !containsAllocator(want, f.Allocator()) {
+				t.Errorf("Filter.Allocator() = %v, want one of %v", f.Allocator(), want)
+			}
+			if !containsAllocator(want, af.Allocator()) {
+				t.Errorf("AtomicFilter.Allocator() = %v, want one of %v", af.Allocator(), want)
+			}
+
+			if addr := uintptr(unsafePointer(&f.blocks[0])); addr%cacheLineSize != 0 {
+				t.Errorf("Filter blocks not cache-line aligned: %x", addr)
+			}
+			if addr := uintptr(unsafePointer(&af.blocks[0])); addr%cacheLineSize != 0 {
+				t.Errorf("AtomicFilter blocks not cache-line aligned: %x", addr)
+			}
+
+			// Memory must start zeroed and be fully usable
+			if f.ExactFillRatio() != 0 || af.ExactFillRatio() != 0 {
+				t.Error("expected freshly allocated blocks to be zero")
+			}
+			for i := range 5000 {
+				key := fmt.Appendf(nil, "alloc-%d", i)
+				f.Add(key)
+				af.Add(key)
+			}
+			for i := range 5000 {
+				key := fmt.Appendf(nil, "alloc-%d", i)
+				if !f.Test(key) || !af.Test(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+
+			if err := f.Close(); err != nil {
+				t.Errorf("Filter.Close() failed: %v", err)
+			}
+			if err := af.Close(); err != nil {
+				t.Errorf("AtomicFilter.Close() failed: %v", err)
+			}
+			if f.blocks != nil || af.blocks != nil {
+				t.Error("expected blocks to be released after Close")
+			}
+		})
+	}
+}
+
+func TestHugePageAlignment(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("huge pages are only supported on Linux")
+	}
+
+	f := NewWithParamsAllocator(100000, 7, HugePageAllocator)
+	defer f.Close()
+
+	if addr := uintptr(unsafePointer(&f.blocks[0])); addr%hugePageSize != 0 {
+		t.Errorf("huge page backed blocks not aligned to %d bytes: %x", hugePageSize, addr)
+	}
+}
+
+func TestMmapFailure(t *testing.T) {
+	// No machine can satisfy a 4 EiB mapping, so both variants must fail
+	// cleanly and let allocAligned fall back. On 32-bit platforms any size
+	// that fits in an int might be mapped.
+	if strconv.IntSize < 64 {
+		t.Skip("requires a 64-bit int")
+	}
+	const size = math.MaxInt/2 + 1
+	for _, hugetlb := range []bool{false, true} {
+		if raw, ptr, ok := mmapAligned(size, hugetlb); ok || raw != nil || ptr != nil {
+			t.Errorf("mmapAligned(%d, %v) unexpectedly succeeded", size, hugetlb)
+		}
+	}
+}
+
+func containsAllocator(allocs []Allocator, alloc Allocator) bool {
+	for _, a := range allocs {
+		if a == alloc {
+			return true
+		}
+	}
+	return false
+}
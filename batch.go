@@ -0,0 +1,288 @@
+package gloom
+
+// batchWindow is the number of keys hashed and prefetched together by the
+// batch APIs. It is large enough to keep a dozen or so cache misses in flight
+// at once, and small enough that the per-window state stays on the stack.
+const batchWindow = 16
+
+// blockWindow holds the pre-computed hash values for one window of a batch.
+type blockWindow struct {
+	blockIdx  [batchWindow]uint64
+	intraHash [batchWindow]uint32
+}
+
+// shardedWindow holds the pre-computed shard and hash values for one window
+// of a batch on a ShardedAtomicFilter.
+type shardedWindow struct {
+	shards [batchWindow]*AtomicFilter
+	blockWindow
+}
+
+// touched consumes the value accumulated while prefetching a window. It is
+// never inlined so the compiler cannot prove the prefetch loads are unused
+// and eliminate them.
+//
+//go:noinline
+func touched(uint64) {}
+
+// AddBatch adds every key in keys to the bloom filter.
+//
+// Keys are processed in small windows: the whole window is hashed and its
+// blocks are touched before any bits are set, so the cache misses for the
+// window overlap instead of being paid one key at a time. This is
+// significantly faster than calling Add in a loop on filters larger than
+// the CPU cache.
+func (f *Filter) AddBatch(keys [][]byte) {
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashData(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			f.addWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys = keys[n:]
+	}
+}
+
+// AddStringBatch adds every string in keys to the bloom filter without
+// allocating. See AddBatch for details.
+func (f *Filter) AddStringBatch(keys []string) {
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashString(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			f.addWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys = keys[n:]
+	}
+}
+
+// TestBatch checks every key in keys and stores the result for keys[i] in
+// results[i]. results must be at least as long as keys.
+//
+// Like AddBatch, lookups are processed in windows so their cache misses
+// overlap.
+func (f *Filter) TestBatch(keys [][]byte, results []bool) {
+	results = results[:len(keys)]
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashData(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			results[i] = f.testWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys, results = keys[n:], results[n:]
+	}
+}
+
+// TestStringBatch checks every string in keys without allocating and stores
+// the result for keys[i] in results[i]. results must be at least as long as
+// keys.
+func (f *Filter) TestStringBatch(keys []string, results []bool) {
+	results = results[:len(keys)]
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashString(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			results[i] = f.testWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys, results = keys[n:], results[n:]
+	}
+}
+
+// prefetch loads the first and last word of each block in the window, which
+// covers both cache lines of a 1024-bit block. The loads are independent of
+// each other, so the CPU issues them back to back and the cache misses are
+// serviced in parallel.
+func (f *Filter) prefetch(w *blockWindow, n int) {
+	var acc uint64
+	for i := range n {
+		base := w.blockIdx[i] * f.blockWords
+		acc ^= f.blocks[base] ^ f.blocks[base+f.blockWords-1]
+	}
+	touched(acc)
+}
+
+// AddBatch adds every key in keys to the bloom filter atomically.
+// See Filter.AddBatch for details.
+func (f *AtomicFilter) AddBatch(keys [][]byte) {
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashData(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			f.addWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys = keys[n:]
+	}
+}
+
+// AddStringBatch adds every string in keys to the bloom filter atomically
+// without allocating.
+func (f *AtomicFilter) AddStringBatch(keys []string) {
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashString(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			f.addWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys = keys[n:]
+	}
+}
+
+// TestBatch checks every key in keys and stores the result for keys[i] in
+// results[i]. results must be at least as long as keys.
+// This operation is safe to call concurrently with Add.
+func (f *AtomicFilter) TestBatch(keys [][]byte, results []bool) {
+	results = results[:len(keys)]
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashData(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			results[i] = f.testWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys, results = keys[n:], results[n:]
+	}
+}
+
+// TestStringBatch checks every string in keys and stores the result for
+// keys[i] in results[i]. results must be at least as long as keys.
+func (f *AtomicFilter) TestStringBatch(keys []string, results []bool) {
+	results = results[:len(keys)]
+	var w blockWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			w.blockIdx[i], w.intraHash[i] = hashString(key, f.numBlocks)
+		}
+		f.prefetch(&w, n)
+		for i := range n {
+			results[i] = f.testWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys, results = keys[n:], results[n:]
+	}
+}
+
+// prefetch loads the first and last word of each block in the window.
+func (f *AtomicFilter) prefetch(w *blockWindow, n int) {
+	var acc uint64
+	for i := range n {
+		base := w.blockIdx[i] * f.blockWords
+		acc ^= f.blocks[base].Load() ^ f.blocks[base+f.blockWords-1].Load()
+	}
+	touched(acc)
+}
+
+// AddBatch adds every key in keys to the bloom filter.
+// See Filter.AddBatch for details.
+func (f *ShardedAtomicFilter) AddBatch(keys [][]byte) {
+	var w shardedWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			f.hashInto(&w, i, hashRaw(key))
+		}
+		w.prefetch(n)
+		for i := range n {
+			w.shards[i].addWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys = keys[n:]
+	}
+}
+
+// AddStringBatch adds every string in keys to the bloom filter without
+// allocating.
+func (f *ShardedAtomicFilter) AddStringBatch(keys []string) {
+	var w shardedWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			f.hashInto(&w, i, hashRawString(key))
+		}
+		w.prefetch(n)
+		for i := range n {
+			w.shards[i].addWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys = keys[n:]
+	}
+}
+
+// TestBatch checks every key in keys and stores the result for keys[i] in
+// results[i]. results must be at least as long as keys.
+func (f *ShardedAtomicFilter) TestBatch(keys [][]byte, results []bool) {
+	results = results[:len(keys)]
+	var w shardedWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			f.hashInto(&w, i, hashRaw(key))
+		}
+		w.prefetch(n)
+		for i := range n {
+			results[i] = w.shards[i].testWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys, results = keys[n:], results[n:]
+	}
+}
+
+// TestStringBatch checks every string in keys and stores the result for
+// keys[i] in results[i]. results must be at least as long as keys.
+func (f *ShardedAtomicFilter) TestStringBatch(keys []string, results []bool) {
+	results = results[:len(keys)]
+	var w shardedWindow
+	for len(keys) > 0 {
+		n := min(len(keys), batchWindow)
+		for i, key := range keys[:n] {
+			f.hashInto(&w, i, hashRawString(key))
+		}
+		w.prefetch(n)
+		for i := range n {
+			results[i] = w.shards[i].testWithHash(w.blockIdx[i], w.intraHash[i])
+		}
+		keys, results = keys[n:], results[n:]
+	}
+}
+
+// hashInto routes a raw hash to its shard and stores the shard and
+// block position in slot i of the window.
+func (f *ShardedAtomicFilter) hashInto(w *shardedWindow, i int, h uint64) {
+	shard := f.shards[f.shardIndex(h)]
+	w.shards[i] = shard
+	w.blockIdx[i], w.intraHash[i] = hashSplitSharded(h, shard.numBlocks)
+}
+
+// prefetch loads the first and last word of each block in the window.
+func (w *shardedWindow) prefetch(n int) {
+	var acc uint64
+	for i := range n {
+		shard := w.shards[i]
+		base := w.blockIdx[i] * shard.blockWords
+		acc ^= shard.blocks[base].Load() ^ shard.blocks[base+shard.blockWords-1].Load()
+	}
+	touched(acc)
+}
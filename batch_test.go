@@ -0,0 +1,119 @@
+package gloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+// batchSizes exercises empty batches, partial windows, exact windows and
+// multiple windows.
+var batchSizes = []int{0, 1, batchWindow - 1, batchWindow, batchWindow + 1, 1000}
+
+func makeBatchKeys(prefix string, n int) ([][]byte, []string) {
+	keys := make([][]byte, n)
+	strs := make([]string, n)
+	for i := range n {
+		strs[i] = fmt.Sprintf("%s-%d", prefix, i)
+		keys[i] = []byte(strs[i])
+	}
+	return keys, strs
+}
+
+// batchFilter is the subset of methods shared by all filter types that the
+// batch tests exercise.
+type batchFilter interface {
+	Add([]byte)
+	Test([]byte) bool
+	AddBatch([][]byte)
+	AddStringBatch([]string)
+	TestBatch([][]byte, []bool)
+	TestStringBatch([]string, []bool)
+	Count() uint64
+}
+
+func TestBatchMatchesSingle(t *testing.T) {
+	constructors := []struct {
+		name string
+		new  func() batchFilter
+	}{
+		{"Filter", func() batchFilter { return New(2000, 0.01) }},
+		{"AtomicFilter", func() batchFilter { return NewAtomic(2000, 0.01) }},
+		{"ShardedAtomicFilter", func() batchFilter { return NewShardedAtomic(2000, 0.01, 4) }},
+		{"Filter256", func() batchFilter { return NewWithBlockParams(40, 7, 256) }},
+		{"AtomicFilter1024", func() batchFilter { return NewAtomicWithBlockParams(10, 12, 1024) }},
+	}
+
+	for _, c := range constructors {
+		for _, n := range batchSizes {
+			t.Run(fmt.Sprintf("%s/n=%d", c.name, n), func(t *testing.T) {
+				keys, strs := makeBatchKeys("batch", n)
+				probes, probeStrs := makeBatchKeys("probe", n)
+
+				single := c.new()
+				for _, key := range keys {
+					single.Add(key)
+				}
+
+				byBytes := c.new()
+				byBytes.AddBatch(keys)
+				byStrings := c.new()
+				byStrings.AddStringBatch(strs)
+
+				for _, f := range []batchFilter{byBytes, byStrings} {
+					if f.Count() != uint64(n) {
+						t.Errorf("Count() = %d, want %d", f.Count(), n)
+					}
+
+					results := make([]bool, n)
+					f.TestBatch(keys, results)
+					for i, ok := range results {
+						if !ok {
+							t.Errorf("TestBatch: false negative for key %d", i)
+						}
+					}
+
+					// Probes of absent keys must agree with the single-key path
+					f.TestBatch(probes, results)
+					for i, ok := range results {
+						if want := single.Test(probes[i]); ok != want {
+							t.Errorf("TestBatch(probe %d) = %v, want %v", i, ok, want)
+						}
+					}
+					f.TestStringBatch(probeStrs, results)
+					for i, ok := range results {
+						if want := single.Test(probes[i]); ok != want {
+							t.Errorf("TestStringBatch(probe %d) = %v, want %v", i, ok, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBatchLongerResults(t *testing.T) {
+	f := New(1000, 0.01)
+	keys, strs := makeBatchKeys("long", 10)
+	f.AddBatch(keys)
+
+	// Entries past len(keys) must be left untouched
+	results := make([]bool, 20)
+	f.TestStringBatch(strs, results)
+	for i, ok := range results {
+		if want := i < len(keys); ok != want {
+			t.Errorf("results[%d] = %v, want %v", i, ok, want)
+		}
+	}
+}
+
+func TestBatchShortResultsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic when results is shorter than keys")
+		}
+	}()
+
+	f := New(1000, 0.01)
+	keys, _ := makeBatchKeys("short", 10)
+	f.TestBatch(keys, make([]bool, 5))
+}
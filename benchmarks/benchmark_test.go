@@ -2,6 +2,7 @@ package benchmarks
 
 import (
 	"cmp"
+	"context"
 	"fmt"
 	"slices"
 	"sync"
@@ -794,3 +795,1140 @@ func BenchmarkHistogramTest_Blobloom(b *testing.B) {
 	}
 	hist.reportPercentiles(b)
 }
+
+// ============================================================================
+// Large Filter Batch Benchmarks
+// ============================================================================
+//
+// These benchmarks use a filter far larger than the L3 cache, so nearly every
+// operation is a cache miss. This is where the batch APIs pay off: they overlap
+// the misses of a window of keys instead of serializing them. Each op is a
+// single key, so the results are directly comparable with the one-at-a-time
+// variants.
+
+const (
+	largeNumBlocks = 1 << 22 // 256 MiB of blocks
+	largeK         = 7
+	batchSize      = 256
+)
+
+func newLargeGloom() *gloom.Filter {
+	f := gloom.NewWithParams(largeNumBlocks, largeK)
+	f.AddBatch(testKeys)
+	return f
+}
+
+func newLargeGloomAtomic() *gloom.AtomicFilter {
+	f := gloom.NewAtomicWithParams(largeNumBlocks, largeK)
+	f.AddBatch(testKeys)
+	return f
+}
+
+func BenchmarkLargeAdd_Gloom(b *testing.B) {
+	f := gloom.NewWithParams(largeNumBlocks, largeK)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkLargeAddBatch_Gloom(b *testing.B) {
+	f := gloom.NewWithParams(largeNumBlocks, largeK)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		start := i % benchItems
+		end := min(start+batchSize, benchItems, start+b.N-i)
+		f.AddBatch(testKeys[start:end])
+	}
+}
+
+func BenchmarkLargeTest_Gloom(b *testing.B) {
+	f := newLargeGloom()
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkLargeTestBatch_Gloom(b *testing.B) {
+	f := newLargeGloom()
+	results := make([]bool, batchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		start := i % benchItems
+		end := min(start+batchSize, benchItems, start+b.N-i)
+		f.TestBatch(testKeys[start:end], results)
+	}
+}
+
+func BenchmarkLargeTestStringBatch_Gloom(b *testing.B) {
+	f := newLargeGloom()
+	results := make([]bool, batchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		start := i % benchItems
+		end := min(start+batchSize, benchItems, start+b.N-i)
+		f.TestStringBatch(testKeysStr[start:end], results)
+	}
+}
+
+func BenchmarkLargeAdd_GloomAtomic(b *testing.B) {
+	f := gloom.NewAtomicWithParams(largeNumBlocks, largeK)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkLargeAddBatch_GloomAtomic(b *testing.B) {
+	f := gloom.NewAtomicWithParams(largeNumBlocks, largeK)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		start := i % benchItems
+		end := min(start+batchSize, benchItems, start+b.N-i)
+		f.AddBatch(testKeys[start:end])
+	}
+}
+
+func BenchmarkLargeTest_GloomAtomic(b *testing.B) {
+	f := newLargeGloomAtomic()
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkLargeTestBatch_GloomAtomic(b *testing.B) {
+	f := newLargeGloomAtomic()
+	results := make([]bool, batchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		start := i % benchItems
+		end := min(start+batchSize, benchItems, start+b.N-i)
+		f.TestBatch(testKeys[start:end], results)
+	}
+}
+
+func BenchmarkLargeTestParallel_GloomAtomic(b *testing.B) {
+	f := newLargeGloomAtomic()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f.Test(testKeys[i%benchItems])
+			i++
+		}
+	})
+}
+
+func BenchmarkLargeTestBatchParallel_GloomAtomic(b *testing.B) {
+	f := newLargeGloomAtomic()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		results := make([]bool, batchSize)
+		i := 0
+		for pb.Next() {
+			// pb.Next is per key, so flush a batch every batchSize keys
+			i++
+			if i%batchSize == 0 {
+				start := i % benchItems
+				end := min(start+batchSize, benchItems)
+				f.TestBatch(testKeys[start:end], results)
+			}
+		}
+	})
+}
+
+func BenchmarkLargeAddBatch_GloomSharded(b *testing.B) {
+	// Sized to roughly largeNumBlocks blocks in total, split across 16 shards
+	f := gloom.NewShardedAtomic(largeNumBlocks*gloom.BlockBits/10, benchFPRate, 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		start := i % benchItems
+		end := min(start+batchSize, benchItems, start+b.N-i)
+		f.AddBatch(testKeys[start:end])
+	}
+}
+
+// ============================================================================
+// Bulk Construction Benchmarks
+// ============================================================================
+
+func BenchmarkBuild_GloomSequential(b *testing.B) {
+	for range b.N {
+		f := gloom.New(benchItems, benchFPRate)
+		for _, key := range testKeys {
+			f.Add(key)
+		}
+	}
+	b.ReportMetric(benchItems, "items/op")
+}
+
+func BenchmarkBuild_GloomParallel(b *testing.B) {
+	ctx := context.Background()
+	for range b.N {
+		if _, err := gloom.BuildParallel(ctx, slices.Values(testKeys), benchItems, benchFPRate, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(benchItems, "items/op")
+}
+
+// ============================================================================
+// Serialization Benchmarks
+// ============================================================================
+
+func newSerializationFilter() *gloom.Filter {
+	f := gloom.New(benchItems, benchFPRate)
+	for _, key := range testKeys {
+		f.Add(key)
+	}
+	return f
+}
+
+func BenchmarkSerialize_Marshal(b *testing.B) {
+	f := newSerializationFilter()
+	b.SetBytes(int64(f.NumBlocks() * uint64(f.BlockBits()) / 8))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := f.MarshalBinary(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSerialize_Unmarshal(b *testing.B) {
+	data, err := newSerializationFilter().MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := gloom.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSerialize_UnmarshalInto(b *testing.B) {
+	data, err := newSerializationFilter().MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	dst := &gloom.Filter{}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for range b.N {
+		if err := gloom.UnmarshalBinaryInto(data, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// ============================================================================
+// Register-Blocked Filter Benchmarks
+// ============================================================================
+//
+// RegisterFilter trades memory for speed: each operation is one word load and
+// a mask compare instead of k probes. Compare with the _Gloom and
+// _GloomAtomic variants above, which are sized for the same FP rate, and
+// with the Large benchmarks, which use the same memory.
+
+func BenchmarkAddSequential_GloomRegister(b *testing.B) {
+	f := gloom.NewRegister(benchItems, benchFPRate)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkAddSequential_GloomAtomicRegister(b *testing.B) {
+	f := gloom.NewAtomicRegister(benchItems, benchFPRate)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomRegister(b *testing.B) {
+	f := gloom.NewRegister(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomRegisterString(b *testing.B) {
+	f := gloom.NewRegister(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.TestString(testKeysStr[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomAtomicRegister(b *testing.B) {
+	f := gloom.NewAtomicRegister(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkAddParallel_GloomAtomicRegister(b *testing.B) {
+	f := gloom.NewAtomicRegister(benchItems, benchFPRate)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f.Add(testKeys[i%benchItems])
+			i++
+		}
+	})
+}
+
+func BenchmarkTestParallel_GloomAtomicRegister(b *testing.B) {
+	f := gloom.NewAtomicRegister(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f.Test(testKeys[i%benchItems])
+			i++
+		}
+	})
+}
+
+func BenchmarkLargeTest_GloomRegister(b *testing.B) {
+	f := gloom.NewRegisterWithParams(largeNumBlocks*gloom.BlockWords, 5)
+	for _, key := range testKeys {
+		f.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkFalsePositiveRate_GloomRegister(b *testing.B) {
+	// Reports the measured FP rate at the target load next to Filter's, to
+	// show what the speed costs in accuracy
+	f := gloom.NewRegister(benchItems, benchFPRate)
+	g := gloom.New(benchItems, benchFPRate)
+	for _, key := range testKeys {
+		f.Add(key)
+		g.Add(key)
+	}
+	var fp, gfp int
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Sprintf("absent-%d", i)
+		if f.TestString(key) {
+			fp++
+		}
+		if g.TestString(key) {
+			gfp++
+		}
+	}
+	b.ReportMetric(float64(fp)/float64(b.N), "register-fp")
+	b.ReportMetric(float64(gfp)/float64(b.N), "filter-fp")
+	b.ReportMetric(float64(f.Cap())/benchItems, "register-bits/item")
+	b.ReportMetric(float64(g.Cap())/benchItems, "filter-bits/item")
+}
+
+// ============================================================================
+// Two-Choice Filter Benchmarks
+// ============================================================================
+//
+// TwoChoiceFilter probes two blocks per key to keep block loads balanced. The
+// latency benchmarks compare with the _Gloom variants above; the accuracy
+// benchmark fills filters of the same memory at a low target rate, each with
+// its best k, and reports each one's measured FP rate.
+
+const twoChoiceFPRate = 1e-5
+
+func BenchmarkAddSequential_GloomTwoChoice(b *testing.B) {
+	f := gloom.NewTwoChoice(benchItems, benchFPRate)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomTwoChoice(b *testing.B) {
+	f := gloom.NewTwoChoice(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequentialAbsent_GloomTwoChoice(b *testing.B) {
+	// Absent keys must probe both blocks unless the first one rules them out
+	f := gloom.NewTwoChoice(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	absent := make([][]byte, benchItems)
+	for i := range absent {
+		absent[i] = fmt.Appendf(nil, "absent-%d", i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(absent[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequentialAbsent_Gloom(b *testing.B) {
+	f := gloom.New(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	absent := make([][]byte, benchItems)
+	for i := range absent {
+		absent[i] = fmt.Appendf(nil, "absent-%d", i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(absent[i%benchItems])
+	}
+}
+
+func BenchmarkLargeTest_GloomTwoChoice(b *testing.B) {
+	f := gloom.NewTwoChoiceWithParams(largeNumBlocks, largeK)
+	for _, key := range testKeys {
+		f.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkFalsePositiveRate_GloomTwoChoice(b *testing.B) {
+	numBlocks, k, _ := gloom.OptimalTwoChoiceParams(benchItems, twoChoiceFPRate)
+	twoChoice := gloom.NewTwoChoiceWithParams(numBlocks, k)
+	single := gloom.NewFromPlan(gloom.PlanForBlocks(numBlocks, 512, benchItems))
+	wide := gloom.NewFromPlan(gloom.PlanForBlocks(numBlocks/2, 1024, benchItems))
+	for _, key := range testKeys {
+		twoChoice.Add(key)
+		single.Add(key)
+		wide.Add(key)
+	}
+	var twoChoiceFP, singleFP, wideFP int
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Sprintf("absent-%d", i)
+		if twoChoice.TestString(key) {
+			twoChoiceFP++
+		}
+		if single.TestString(key) {
+			singleFP++
+		}
+		if wide.TestString(key) {
+			wideFP++
+		}
+	}
+	b.ReportMetric(float64(twoChoiceFP)/float64(b.N), "two-choice-fp")
+	b.ReportMetric(float64(singleFP)/float64(b.N), "512-bit-fp")
+	b.ReportMetric(float64(wideFP)/float64(b.N), "1024-bit-fp")
+}
+
+// ============================================================================
+// Pattern Filter Benchmarks
+// ============================================================================
+//
+// PatternFilter sets a precomputed 512-bit mask per key instead of computing k
+// bit positions. The latency benchmarks compare with the _Gloom variants
+// above; the accuracy benchmark fills a PatternFilter and a Filter of the same
+// memory and k and reports each one's measured FP rate.
+
+func BenchmarkAddSequential_GloomPattern(b *testing.B) {
+	f := gloom.NewPattern(benchItems, benchFPRate)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomPattern(b *testing.B) {
+	f := gloom.NewPattern(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequentialAbsent_GloomPattern(b *testing.B) {
+	f := gloom.NewPattern(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	absent := make([][]byte, benchItems)
+	for i := range absent {
+		absent[i] = fmt.Appendf(nil, "absent-%d", i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(absent[i%benchItems])
+	}
+}
+
+func BenchmarkLargeTest_GloomPattern(b *testing.B) {
+	f := gloom.NewPatternWithParams(largeNumBlocks, largeK, gloom.DefaultPatternTableSize, gloom.DefaultPatternSeed)
+	for _, key := range testKeys {
+		f.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkFalsePositiveRate_GloomPattern(b *testing.B) {
+	numBlocks, k, _ := gloom.OptimalPatternParams(benchItems, benchFPRate, gloom.DefaultPatternTableSize)
+	pattern := gloom.NewPatternWithParams(numBlocks, k, gloom.DefaultPatternTableSize, gloom.DefaultPatternSeed)
+	filter := gloom.NewWithParams(numBlocks, k)
+	for _, key := range testKeys {
+		pattern.Add(key)
+		filter.Add(key)
+	}
+	var patternFP, filterFP int
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Sprintf("absent-%d", i)
+		if pattern.TestString(key) {
+			patternFP++
+		}
+		if filter.TestString(key) {
+			filterFP++
+		}
+	}
+	b.ReportMetric(float64(patternFP)/float64(b.N), "pattern-fp")
+	b.ReportMetric(float64(filterFP)/float64(b.N), "filter-fp")
+}
+
+// ============================================================================
+// Cuckoo Filter Benchmarks
+// ============================================================================
+//
+// CuckooFilter stores fingerprints in 4-slot buckets and supports removal.
+// The latency benchmarks compare with the _Gloom variants above, which are
+// sized for the same FP rate; the accuracy benchmark fills a CuckooFilter and
+// a Filter of the same memory at a low target rate and reports each one's
+// measured FP rate.
+
+const cuckooFPRate = 1e-6
+
+func BenchmarkAddSequential_GloomCuckoo(b *testing.B) {
+	// A cuckoo filter stores every copy of a key, so start over once every key
+	// has been added rather than filling it with duplicates
+	f := gloom.NewCuckoo(benchItems, benchFPRate)
+	b.ResetTimer()
+	for i := range b.N {
+		if i%benchItems == 0 && i > 0 {
+			b.StopTimer()
+			f = gloom.NewCuckoo(benchItems, benchFPRate)
+			b.StartTimer()
+		}
+		f.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomCuckoo(b *testing.B) {
+	f := gloom.NewCuckoo(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequentialAbsent_GloomCuckoo(b *testing.B) {
+	// Absent keys must check both buckets
+	f := gloom.NewCuckoo(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	absent := make([][]byte, benchItems)
+	for i := range absent {
+		absent[i] = fmt.Appendf(nil, "absent-%d", i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(absent[i%benchItems])
+	}
+}
+
+func BenchmarkRemoveAdd_GloomCuckoo(b *testing.B) {
+	// Each iteration removes a key and adds it back, keeping the filter full
+	f := gloom.NewCuckoo(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		key := testKeys[i%benchItems]
+		f.Remove(key)
+		f.Add(key)
+	}
+}
+
+func BenchmarkTestParallel_GloomLockedCuckoo(b *testing.B) {
+	f := gloom.NewLockedCuckoo(benchItems, benchFPRate)
+	for i := range benchItems {
+		f.Add(testKeys[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f.Test(testKeys[i%benchItems])
+			i++
+		}
+	})
+}
+
+func BenchmarkLargeTest_GloomCuckoo(b *testing.B) {
+	// The same memory as the other Large benchmarks: eight 4-slot buckets of
+	// 16-bit fingerprints per 512-bit block
+	f := gloom.NewCuckooWithParams(largeNumBlocks*8, 16)
+	for _, key := range testKeys {
+		f.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkFalsePositiveRate_GloomCuckoo(b *testing.B) {
+	cuckoo := gloom.NewCuckoo(benchItems, cuckooFPRate)
+	filter := gloom.NewFromPlan(gloom.PlanForItemsInMemory(benchItems, cuckoo.Cap()/8))
+	for _, key := range testKeys {
+		cuckoo.Add(key)
+		filter.Add(key)
+	}
+	var cuckooFP, filterFP int
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Sprintf("absent-%d", i)
+		if cuckoo.TestString(key) {
+			cuckooFP++
+		}
+		if filter.TestString(key) {
+			filterFP++
+		}
+	}
+	b.ReportMetric(float64(cuckooFP)/float64(b.N), "cuckoo-fp")
+	b.ReportMetric(float64(filterFP)/float64(b.N), "filter-fp")
+	b.ReportMetric(float64(cuckoo.Cap())/benchItems, "bits/item")
+}
+
+// ============================================================================
+// Static Filter Benchmarks
+// ============================================================================
+//
+// StaticFilter is built once from the whole key set. The build benchmark
+// compares with BuildParallel and AddBatch; the lookup benchmarks compare
+// with the _Gloom variants above; the accuracy benchmark fills a Filter with
+// the same memory as an 8-bit StaticFilter and reports each one's measured
+// FP rate.
+
+func BenchmarkBuild_GloomStatic(b *testing.B) {
+	for range b.N {
+		if _, err := gloom.BuildStatic(testKeys, 8); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTestSequential_GloomStatic(b *testing.B) {
+	f, err := gloom.BuildStatic(testKeys, 8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequentialAbsent_GloomStatic(b *testing.B) {
+	f, err := gloom.BuildStatic(testKeys, 8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	absent := make([][]byte, benchItems)
+	for i := range absent {
+		absent[i] = fmt.Appendf(nil, "absent-%d", i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Test(absent[i%benchItems])
+	}
+}
+
+func BenchmarkFalsePositiveRate_GloomStatic(b *testing.B) {
+	static, err := gloom.BuildStatic(testKeys, 8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	filter := gloom.NewFromPlan(gloom.PlanForItemsInMemory(benchItems, static.Cap()/8))
+	for _, key := range testKeys {
+		filter.Add(key)
+	}
+	var staticFP, filterFP int
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Sprintf("absent-%d", i)
+		if static.TestString(key) {
+			staticFP++
+		}
+		if filter.TestString(key) {
+			filterFP++
+		}
+	}
+	b.ReportMetric(float64(staticFP)/float64(b.N), "static-fp")
+	b.ReportMetric(float64(filterFP)/float64(b.N), "filter-fp")
+	b.ReportMetric(static.BitsPerItem(), "bits/item")
+}
+
+// ============================================================================
+// Bloomier Filter Benchmarks
+// ============================================================================
+//
+// BloomierFilter maps each key to a shard ID. The lookup benchmarks compare
+// one Get against testing the key in 16 per-shard Filters at the same false
+// positive rate, and report the memory of each.
+
+const bloomierShards = 16
+
+// bloomierShardOf returns the shard of the i-th test key.
+func bloomierShardOf(i int) uint64 {
+	return uint64(i) % bloomierShards
+}
+
+func BenchmarkGet_GloomBloomier(b *testing.B) {
+	values := make([]uint64, benchItems)
+	for i := range values {
+		values[i] = bloomierShardOf(i)
+	}
+	f, err := gloom.BuildBloomier(testKeys, values, 4, 0.01)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		f.Get(testKeys[i%benchItems])
+	}
+	b.ReportMetric(f.BitsPerItem(), "bits/item")
+}
+
+func BenchmarkGet_GloomShardFilters(b *testing.B) {
+	shards := make([]*gloom.Filter, bloomierShards)
+	for s := range shards {
+		shards[s] = gloom.New(benchItems/bloomierShards, 0.01)
+	}
+	for i, key := range testKeys {
+		shards[bloomierShardOf(i)].Add(key)
+	}
+	var bits uint64
+	for _, f := range shards {
+		bits += f.Cap()
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		key := testKeys[i%benchItems]
+		for _, f := range shards {
+			if f.Test(key) {
+				break
+			}
+		}
+	}
+	b.ReportMetric(float64(bits)/benchItems, "bits/item")
+}
+
+// ============================================================================
+// IBLT Benchmarks
+// ============================================================================
+//
+// An IBLT is sized for the difference between two key sets, not the sets.
+// The benchmarks insert every test key into a table sized for a 1,000-key
+// difference, and list the difference after subtracting.
+
+const ibltDiff = 1000
+
+func BenchmarkInsert_GloomIBLT(b *testing.B) {
+	t := gloom.NewIBLT(gloom.OptimalIBLTCells(ibltDiff), 16)
+	b.ResetTimer()
+	for i := range b.N {
+		t.Insert(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkListEntries_GloomIBLT(b *testing.B) {
+	cells := gloom.OptimalIBLTCells(ibltDiff)
+	a, other := gloom.NewIBLT(cells, 16), gloom.NewIBLT(cells, 16)
+	for i, key := range testKeys {
+		a.Insert(key)
+		if i >= ibltDiff {
+			other.Insert(key)
+		}
+	}
+	if err := a.Subtract(other); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for range b.N {
+		if _, _, err := a.ListEntries(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(a.Cap()/8), "bytes")
+}
+
+// ============================================================================
+// Golomb-Coded Set Benchmarks
+// ============================================================================
+//
+// A GCS is decoded in one pass per TestMany call, so the lookup benchmark
+// checks every test key per iteration and reports the cost per key. The size
+// benchmark reports the serialized size of a GCS and of a Filter at the same
+// false positive rate.
+
+func BenchmarkBuild_GloomGCS(b *testing.B) {
+	for range b.N {
+		gloom.BuildGCS(testKeys, 0.01)
+	}
+}
+
+func BenchmarkTestMany_GloomGCS(b *testing.B) {
+	g := gloom.BuildGCS(testKeys, 0.01)
+	results := make([]bool, benchItems)
+	b.ResetTimer()
+	for range b.N {
+		g.TestMany(testKeys, results)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchItems), "ns/key")
+}
+
+func BenchmarkSerializedSize_GloomGCS(b *testing.B) {
+	g := gloom.BuildGCS(testKeys, 1.0/128)
+	f := gloom.New(benchItems, 1.0/128)
+	for _, key := range testKeys {
+		f.Add(key)
+	}
+	var gcsData, filterData []byte
+	for range b.N {
+		gcsData, _ = g.MarshalBinary()
+		filterData, _ = f.MarshalBinary()
+	}
+	b.ReportMetric(float64(len(gcsData)), "gcs-bytes")
+	b.ReportMetric(float64(len(filterData)), "filter-bytes")
+}
+
+// ============================================================================
+// Cascade Benchmarks
+// ============================================================================
+//
+// A Cascade answers exactly for its universe, here the 1M test keys with
+// every 100th included. Lookups of excluded keys usually stop at level 0,
+// while included keys pass it and test at least one more level.
+
+// cascadeSets returns every 100th test key as the included set.
+func cascadeSets() (included [][]byte) {
+	for i := 0; i < benchItems; i += 100 {
+		included = append(included, testKeys[i])
+	}
+	return included
+}
+
+func BenchmarkBuild_GloomCascade(b *testing.B) {
+	included := cascadeSets()
+	var c *gloom.Cascade
+	for range b.N {
+		c = gloom.BuildCascade(included, testKeys, 0.01)
+	}
+	b.ReportMetric(float64(c.Levels()), "levels")
+	b.ReportMetric(c.BitsPerItem(), "bits/item")
+}
+
+func BenchmarkTestSequential_GloomCascade(b *testing.B) {
+	c := gloom.BuildCascade(cascadeSets(), testKeys, 0.01)
+	b.ResetTimer()
+	for i := range b.N {
+		c.Test(testKeys[i%benchItems])
+	}
+}
+
+// ============================================================================
+// Stable Filter Benchmarks
+// ============================================================================
+//
+// A stable filter decrements P random cells of the key's block on every add,
+// so adds cost more than a Filter's, while lookups cost about the same. The
+// filters use 1 MiB, sized for the benchmark's false positive rate and a 1%
+// false negative tolerance.
+
+const stableMemory = 1 << 20
+
+func BenchmarkAddSequential_GloomStable(b *testing.B) {
+	f := gloom.NewStable(stableMemory, benchFPRate, 0.01)
+	b.ResetTimer()
+	for i := range b.N {
+		f.Add(testKeys[i%benchItems])
+	}
+	b.ReportMetric(float64(f.Decrements()), "decrements")
+}
+
+func BenchmarkTestAndAdd_GloomStable(b *testing.B) {
+	f := gloom.NewStable(stableMemory, benchFPRate, 0.01)
+	b.ResetTimer()
+	for i := range b.N {
+		f.TestAndAdd(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkAddParallel_GloomAtomicStable(b *testing.B) {
+	f := gloom.NewAtomicStable(stableMemory, benchFPRate, 0.01)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			f.Add(testKeys[i%benchItems])
+			i++
+		}
+	})
+}
+
+func BenchmarkOptimalStableParams(b *testing.B) {
+	var p gloom.StableParams
+	for range b.N {
+		p = gloom.OptimalStableParams(stableMemory, benchFPRate, 0.01)
+	}
+	b.ReportMetric(float64(p.Window), "window")
+}
+
+// ============================================================================
+// Deletable Filter Benchmarks
+// ============================================================================
+//
+// A DeletableFilter places keys as a Filter does, and additionally marks the
+// regions where adds collide. The remove benchmark reports the fraction of
+// keys that could be removed from a filter loaded to its expected capacity.
+
+func BenchmarkAddSequential_GloomDeletable(b *testing.B) {
+	d := gloom.NewDeletable(benchItems, benchFPRate)
+	b.ResetTimer()
+	for i := range b.N {
+		d.Add(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomDeletable(b *testing.B) {
+	d := gloom.NewDeletable(benchItems, benchFPRate)
+	for _, key := range testKeys {
+		d.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		d.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkRemove_GloomDeletable(b *testing.B) {
+	var removed, attempted int
+	for i := 0; i < b.N; {
+		b.StopTimer()
+		d := gloom.NewDeletable(benchItems, benchFPRate)
+		for _, key := range testKeys {
+			d.Add(key)
+		}
+		b.StartTimer()
+		for j := 0; j < benchItems && i < b.N; j, i = j+1, i+1 {
+			if d.Remove(testKeys[j]) {
+				removed++
+			}
+			attempted++
+		}
+	}
+	b.ReportMetric(float64(removed)/float64(attempted), "removed-fraction")
+}
+
+// ============================================================================
+// Exception Filter Benchmarks
+// ============================================================================
+//
+// An ExceptionFilter consults its exception list only when the filter
+// reports a key present. Every key of these benchmarks is present, so each
+// lookup pays for the list, which holds 1024 known false positives.
+
+// exceptionFilter adds every test key to e and marks 1024 false positives.
+func exceptionFilter(e *gloom.ExceptionFilter) *gloom.ExceptionFilter {
+	for _, key := range testKeys {
+		e.Add(key)
+	}
+	for i := 0; e.Exceptions() < gloom.DefaultMaxExceptions; i++ {
+		if key := fmt.Appendf(nil, "probe-%d", i); e.Test(key) {
+			e.MarkFalsePositive(key)
+		}
+	}
+	return e
+}
+
+func BenchmarkTestSequential_GloomException(b *testing.B) {
+	e := exceptionFilter(gloom.NewExceptionFilter(gloom.New(benchItems, benchFPRate), 0))
+	b.ResetTimer()
+	for i := range b.N {
+		e.Test(testKeys[i%benchItems])
+	}
+}
+
+func BenchmarkTestParallel_GloomAtomicException(b *testing.B) {
+	e := exceptionFilter(gloom.NewAtomicExceptionFilter(gloom.NewAtomic(benchItems, benchFPRate), 0))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			e.Test(testKeys[i%benchItems])
+			i++
+		}
+	})
+}
+
+// ============================================================================
+// Prefix Filter Benchmarks
+// ============================================================================
+//
+// A PrefixFilter indexes each key's prefix along with the key. These
+// benchmarks use keys of the form "user-N:M", ten under each of 100k
+// prefixes, split at the first ':'. The prefix benchmark probes prefixes
+// that were never added, as a scan the filter lets the caller skip.
+
+const benchPrefixes = benchItems / 10
+
+// prefixKeys returns benchItems keys, ten under each of benchPrefixes
+// prefixes.
+func prefixKeys() [][]byte {
+	keys := make([][]byte, benchItems)
+	for i := range keys {
+		keys[i] = fmt.Appendf(nil, "user-%d:%d", i%benchPrefixes, i)
+	}
+	return keys
+}
+
+func BenchmarkAddSequential_GloomPrefix(b *testing.B) {
+	keys := prefixKeys()
+	p := gloom.NewPrefix(benchItems, benchPrefixes, benchFPRate, gloom.DelimiterPrefix(':', 1))
+	b.ResetTimer()
+	for i := range b.N {
+		p.Add(keys[i%benchItems])
+	}
+}
+
+func BenchmarkTestSequential_GloomPrefix(b *testing.B) {
+	keys := prefixKeys()
+	p := gloom.NewPrefix(benchItems, benchPrefixes, benchFPRate, gloom.DelimiterPrefix(':', 1))
+	for _, key := range keys {
+		p.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		p.Test(keys[i%benchItems])
+	}
+}
+
+func BenchmarkTestPrefix_GloomPrefix(b *testing.B) {
+	keys := prefixKeys()
+	p := gloom.NewPrefix(benchItems, benchPrefixes, benchFPRate, gloom.DelimiterPrefix(':', 1))
+	for _, key := range keys {
+		p.Add(key)
+	}
+	probes := make([][]byte, benchPrefixes)
+	for i := range probes {
+		probes[i] = fmt.Appendf(nil, "group-%d:", i)
+	}
+	var positives int
+	b.ResetTimer()
+	for i := range b.N {
+		if p.TestPrefix(probes[i%benchPrefixes]) {
+			positives++
+		}
+	}
+	b.ReportMetric(float64(positives)/float64(b.N), "fp-rate")
+}
+
+// ============================================================================
+// Range Filter Benchmarks
+// ============================================================================
+//
+// A RangeFilter adds each key at every level, 10 of them for ranges of up to
+// 1024 keys, within a budget of 8 MiB, about 67 bits per key. Keys are spread
+// evenly over the uint64 space, and the probed ranges fall between them, so
+// every positive is a false one.
+
+const (
+	benchRangeBytes = 8 << 20
+	benchMaxRange   = 1 << 10
+)
+
+// rangeKey returns the i-th benchmark key of a RangeFilter.
+func rangeKey(i int) uint64 {
+	return uint64(i) * 0x9e3779b97f4a7c15
+}
+
+func BenchmarkAddSequential_GloomRange(b *testing.B) {
+	r := gloom.NewRange(benchRangeBytes, benchItems, benchMaxRange)
+	b.ResetTimer()
+	for i := range b.N {
+		r.Add(rangeKey(i % benchItems))
+	}
+}
+
+func BenchmarkTestSequential_GloomRange(b *testing.B) {
+	r := gloom.NewRange(benchRangeBytes, benchItems, benchMaxRange)
+	for i := range benchItems {
+		r.Add(rangeKey(i))
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		r.Test(rangeKey(i % benchItems))
+	}
+}
+
+func BenchmarkTestRange_GloomRange(b *testing.B) {
+	r := gloom.NewRange(benchRangeBytes, benchItems, benchMaxRange)
+	for i := range benchItems {
+		r.Add(rangeKey(i))
+	}
+	var positives int
+	b.ResetTimer()
+	for i := range b.N {
+		lo := rangeKey(i%benchItems) + 1<<40
+		if r.TestRange(lo, lo+benchMaxRange-1) {
+			positives++
+		}
+	}
+	b.ReportMetric(float64(positives)/float64(b.N), "fp-rate")
+}
@@ -17,21 +17,27 @@ const cacheLineSize = 64
 // one-hashing for optimal performance.
 //
 // The filter divides memory into 512-bit (64-byte) blocks that fit in a
-// single CPU cache line. Each block is partitioned into k segments using
-// distinct prime sizes, enabling the one-hashing technique where a single
-// hash value generates k independent bit positions via modulo operations.
+// single CPU cache line; NewWithBlockParams selects other block sizes. Each
+// block is partitioned into k segments using distinct prime sizes, enabling
+// the one-hashing technique where a single hash value generates k independent
+// bit positions via modulo operations.
 type Filter struct {
-	raw       []byte   // Raw allocation to keep aligned memory alive for GC
-	blocks    []uint64 // 8 uint64s per block = 512 bits (cache-line aligned)
-	numBlocks uint64   // Total number of 512-bit blocks
-	k         uint32   // Number of hash functions (partitions)
-	primes    []uint32 // Prime partition sizes
-	offsets   []uint32 // Cumulative offsets within block
-	count     uint64   // Number of items added (approximate)
+	raw        []byte      // Raw allocation to keep aligned memory alive for GC
+	alloc      Allocator   // Allocator that provided raw
+	blocks     []uint64    // blockWords uint64s per block (cache-line aligned)
+	numBlocks  uint64      // Total number of blocks
+	blockWords uint64      // Number of uint64s per block, 8 for 512 bits
+	k          uint32      // Number of hash functions (partitions)
+	primes     []uint32    // Prime partition sizes
+	offsets    []uint32    // Cumulative offsets within block
+	probes     *probeTable // Vector constants for the SIMD probe path
+	count      uint64      // Number of items added (approximate)
+	setBits    uint64      // Number of bits set, maintained incrementally
 }
 
 // New creates a new bloom filter optimized for the expected number of items
-// and desired false positive rate.
+// and desired false positive rate, with 512-bit blocks. OptimalBlockParams
+// and NewWithBlockParams may choose larger blocks instead.
 func New(expectedItems uint64, fpRate float64) *Filter {
 	numBlocks, k, _ := OptimalParams(expectedItems, fpRate)
 	return NewWithParams(numBlocks, k)
@@ -40,38 +46,69 @@ func New(expectedItems uint64, fpRate float64) *Filter {
 // NewWithParams creates a new bloom filter with explicit parameters.
 // numBlocks is the number of 512-bit blocks, k is the number of hash functions.
 func NewWithParams(numBlocks uint64, k uint32) *Filter {
+	return NewWithParamsAllocator(numBlocks, k, HeapAllocator)
+}
+
+// NewWithParamsAllocator creates a new bloom filter with explicit parameters
+// whose blocks are allocated by alloc. If alloc is unavailable, the blocks
+// fall back to the Go heap; Allocator reports which one was used. Filters
+// allocated outside the heap must be released with Close, since the garbage
+// collector does not free their memory.
+func NewWithParamsAllocator(numBlocks uint64, k uint32, alloc Allocator) *Filter {
+	return newFilter(numBlocks, k, BlockBits, alloc)
+}
+
+// NewWithBlockParams creates a new bloom filter with explicit parameters and
+// blocks of blockBits bits, which must be 256, 512, or 1024. Larger blocks
+// lower the false positive rate for the same memory, but 1024-bit blocks
+// span two cache lines. k must be between MinK and
+// MaxKForBlockBits(blockBits). Unsupported values fall back to 512-bit
+// blocks and k=7.
+func NewWithBlockParams(numBlocks uint64, k, blockBits uint32) *Filter {
+	return newFilter(numBlocks, k, blockBits, HeapAllocator)
+}
+
+// newFilter creates a new bloom filter, substituting defaults for
+// unsupported parameters.
+func newFilter(numBlocks uint64, k, blockBits uint32, alloc Allocator) *Filter {
 	if numBlocks == 0 {
 		numBlocks = 1
 	}
+	if !ValidBlockBits(blockBits) {
+		blockBits = BlockBits
+	}
 
-	primes := GetPrimePartition(k)
+	primes := GetBlockPartition(k, blockBits)
 	if primes == nil {
 		// Default to k=7 if unsupported
 		k = 7
-		primes = GetPrimePartition(k)
+		primes = GetBlockPartition(k, blockBits)
 	}
 
-	raw, blocks := makeAlignedUint64Slice(int(numBlocks * BlockWords))
-
-	return &Filter{
-		raw:       raw,
-		blocks:    blocks,
-		numBlocks: numBlocks,
-		k:         k,
-		primes:    primes,
-		offsets:   ComputeOffsets(primes),
+	blockWords := uint64(blockBits / 64)
+	raw, blocks, alloc := makeAlignedUint64Slice(int(numBlocks*blockWords), alloc)
+	offsets := ComputeOffsets(primes)
+
+	f := &Filter{
+		raw:        raw,
+		alloc:      alloc,
+		blocks:     blocks,
+		numBlocks:  numBlocks,
+		blockWords: blockWords,
+		k:          k,
+		primes:     primes,
+		offsets:    offsets,
+		probes:     newProbeTable(primes, offsets),
 	}
+	return f
 }
 
 // makeAlignedUint64Slice allocates a cache-line aligned slice of uint64.
-// Returns the raw byte slice (to keep alive for GC) and the aligned uint64 slice.
-func makeAlignedUint64Slice(n int) ([]byte, []uint64) {
-	// Allocate with extra space for alignment
-	raw := make([]byte, n*8+cacheLineSize-1)
-	addr := uintptr(unsafe.Pointer(&raw[0]))
-	offset := (cacheLineSize - int(addr%cacheLineSize)) % cacheLineSize
-	aligned := unsafe.Slice((*uint64)(unsafe.Pointer(&raw[offset])), n)
-	return raw, aligned
+// Returns the raw byte slice (to keep alive for GC), the aligned uint64 slice
+// and the allocator that actually provided the memory.
+func makeAlignedUint64Slice(n int, alloc Allocator) ([]byte, []uint64, Allocator) {
+	raw, ptr, alloc := allocAligned(n*8, alloc)
+	return raw, unsafe.Slice((*uint64)(ptr), n), alloc
 }
 
 // Add adds data to the bloom filter.
@@ -88,17 +125,27 @@ func (f *Filter) AddString(s string) {
 
 // addWithHash sets bits in the filter using pre-computed hash values.
 func (f *Filter) addWithHash(blockIdx uint64, intraHash uint32) {
-	blockBase := blockIdx * BlockWords
+	f.setBits += f.setProbeBits(blockIdx, intraHash)
+	f.count++
+}
+
+// setProbeBits sets the k probe bits for a pre-computed hash and returns how
+// many of them were not already set. It touches only the given block and
+// leaves the filter's counters alone.
+func (f *Filter) setProbeBits(blockIdx uint64, intraHash uint32) uint64 {
+	blockBase := blockIdx * f.blockWords
 
 	// One-hashing: same hash value mod different primes gives independent positions
+	var flipped uint64
 	for i := uint32(0); i < f.k; i++ {
 		bitPos := f.offsets[i] + (intraHash % f.primes[i])
 		wordIdx := bitPos / 64
 		bitIdx := bitPos % 64
-		f.blocks[blockBase+uint64(wordIdx)] |= (1 << bitIdx)
+		word := &f.blocks[blockBase+uint64(wordIdx)]
+		flipped += (^*word >> bitIdx) & 1
+		*word |= 1 << bitIdx
 	}
-
-	f.count++
+	return flipped
 }
 
 // Test checks if data might be in the bloom filter.
@@ -117,7 +164,17 @@ func (f *Filter) TestString(s string) bool {
 
 // testWithHash checks bits in the filter using pre-computed hash values.
 func (f *Filter) testWithHash(blockIdx uint64, intraHash uint32) bool {
-	blockBase := blockIdx * BlockWords
+	blockBase := blockIdx * f.blockWords
+
+	if hasSIMDProbe && f.k >= simdProbeMinK {
+		// Reject most misses with a single scalar probe before paying for
+		// the vector path, which always checks every probe.
+		bitPos := f.offsets[0] + (intraHash % f.primes[0])
+		if f.blocks[blockBase+uint64(bitPos/64)]&(1<<(bitPos%64)) == 0 {
+			return false
+		}
+		return f.probes.test(&f.blocks[blockBase], intraHash)
+	}
 
 	for i := uint32(0); i < f.k; i++ {
 		bitPos := f.offsets[i] + (intraHash % f.primes[i])
@@ -133,7 +190,7 @@ func (f *Filter) testWithHash(blockIdx uint64, intraHash uint32) bool {
 
 // Cap returns the capacity of the filter in bits.
 func (f *Filter) Cap() uint64 {
-	return f.numBlocks * BlockBits
+	return f.numBlocks * f.blockWords * 64
 }
 
 // K returns the number of hash functions (partitions) used.
@@ -146,34 +203,61 @@ func (f *Filter) Count() uint64 {
 	return f.count
 }
 
-// NumBlocks returns the number of 512-bit blocks in the filter.
+// NumBlocks returns the number of blocks in the filter.
 func (f *Filter) NumBlocks() uint64 {
 	return f.numBlocks
 }
 
-// EstimatedFillRatio estimates the proportion of bits that are set.
+// BlockBits returns the number of bits per block.
+func (f *Filter) BlockBits() uint32 {
+	return uint32(f.blockWords * 64)
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add.
 func (f *Filter) EstimatedFillRatio() float64 {
+	return float64(f.setBits) / float64(f.Cap())
+}
+
+// ExactFillRatio computes the proportion of bits that are set by counting
+// every bit in the filter. It takes time proportional to the filter size and
+// is intended for verifying EstimatedFillRatio.
+func (f *Filter) ExactFillRatio() float64 {
+	return float64(popCount(f.blocks)) / float64(f.Cap())
+}
+
+// popCount returns the number of bits set in words.
+func popCount(words []uint64) uint64 {
 	var setBits uint64
-	for _, word := range f.blocks {
+	for _, word := range words {
 		setBits += uint64(bits.OnesCount64(word))
 	}
-	return float64(setBits) / float64(f.numBlocks*BlockBits)
+	return setBits
 }
 
 // EstimatedFalsePositiveRate estimates the current false positive rate
 // based on the number of items added.
 func (f *Filter) EstimatedFalsePositiveRate() float64 {
-	return EstimateFalsePositiveRate(f.numBlocks, f.k, f.count)
+	return EstimateBlockFalsePositiveRate(f.numBlocks, f.k, f.BlockBits(), f.count)
 }
 
 // Serialization constants and errors.
 const (
-	// serializeVersion is the current serialization format version.
+	// serializeVersion is the serialization format version used for filters
+	// with the default 512-bit block.
 	serializeVersion byte = 1
 
-	// headerSize is the size of the serialization header in bytes.
+	// serializeVersionBlockBits is the serialization format version that
+	// records the block size, used for filters with any other block size.
+	serializeVersionBlockBits byte = 2
+
+	// headerSize is the size of the version 1 serialization header in bytes.
 	// Version (1) + K (4) + NumBlocks (8) + Count (8) = 21 bytes
 	headerSize = 21
+
+	// headerSizeBlockBits is the size of the version 2 serialization header
+	// in bytes, which appends BlockBits (4) to the version 1 header.
+	headerSizeBlockBits = headerSize + 4
 )
 
 var (
@@ -187,34 +271,71 @@ var (
 	ErrInvalidK = errors.New("gloom: invalid k value in serialized data")
 )
 
+// nativeLittleEndian reports whether the host stores integers in
+// little-endian byte order, which matches the serialization format.
+var nativeLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// encodeWords writes words to dst as little-endian uint64s. On little-endian
+// hosts the in-memory layout already matches, so it is a single copy.
+func encodeWords(dst []byte, words []uint64) {
+	if nativeLittleEndian {
+		copy(dst, unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(words))), len(words)*8))
+		return
+	}
+	for i, word := range words {
+		binary.LittleEndian.PutUint64(dst[i*8:], word)
+	}
+}
+
+// decodeWords reads little-endian uint64s from src into words.
+func decodeWords(words []uint64, src []byte) {
+	if nativeLittleEndian {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(words))), len(words)*8), src)
+		return
+	}
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(src[i*8:])
+	}
+}
+
 // MarshalBinary serializes the bloom filter to a byte slice.
 // The serialized format is:
 //   - Version (1 byte): serialization format version
 //   - K (4 bytes): number of hash functions (little-endian uint32)
-//   - NumBlocks (8 bytes): number of 512-bit blocks (little-endian uint64)
+//   - NumBlocks (8 bytes): number of blocks (little-endian uint64)
 //   - Count (8 bytes): number of items added (little-endian uint64)
-//   - Blocks (numBlocks * 64 bytes): the bit array data (little-endian uint64s)
+//   - BlockBits (4 bytes, version 2 only): bits per block (little-endian uint32)
+//   - Blocks (numBlocks * BlockBits / 8 bytes): the bit array data (little-endian uint64s)
 //
-// The primes and offsets are not serialized as they can be derived from k.
+// Filters with the default 512-bit block are written as version 1, which
+// has no BlockBits field, so older versions of this package can read them.
+// Other block sizes are written as version 2.
+//
+// The primes and offsets are not serialized as they can be derived from k
+// and the block size.
 func (f *Filter) MarshalBinary() ([]byte, error) {
+	version, size := serializeVersion, uint64(headerSize)
+	if f.blockWords != BlockWords {
+		version, size = serializeVersionBlockBits, headerSizeBlockBits
+	}
+
 	// Calculate total size: header + block data
-	dataSize := f.numBlocks * BlockWords * 8
-	totalSize := headerSize + dataSize
+	dataSize := uint64(len(f.blocks)) * 8
+	totalSize := size + dataSize
 
 	buf := make([]byte, totalSize)
 
 	// Write header
-	buf[0] = serializeVersion
+	buf[0] = version
 	binary.LittleEndian.PutUint32(buf[1:5], f.k)
 	binary.LittleEndian.PutUint64(buf[5:13], f.numBlocks)
 	binary.LittleEndian.PutUint64(buf[13:21], f.count)
+	if version == serializeVersionBlockBits {
+		binary.LittleEndian.PutUint32(buf[21:25], f.BlockBits())
+	}
 
 	// Write block data
-	offset := headerSize
-	for _, word := range f.blocks {
-		binary.LittleEndian.PutUint64(buf[offset:offset+8], word)
-		offset += 8
-	}
+	encodeWords(buf[size:], f.blocks)
 
 	return buf, nil
 }
@@ -222,14 +343,42 @@ func (f *Filter) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary deserializes a bloom filter from a byte slice.
 // Returns an error if the data is invalid or corrupted.
 func UnmarshalBinary(data []byte) (*Filter, error) {
+	f := &Filter{}
+	if err := UnmarshalBinaryInto(data, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// UnmarshalBinaryInto deserializes a bloom filter from a byte slice into dst,
+// reusing dst's block memory (and its allocator) when it is the same size as
+// the serialized filter's. Otherwise dst's memory is released and replaced
+// with a new heap allocation. dst may be a zero Filter.
+//
+// If the data is invalid or corrupted, an error is returned and dst is left
+// unchanged.
+func UnmarshalBinaryInto(data []byte, dst *Filter) error {
 	if len(data) < headerSize {
-		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), headerSize)
+		return fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), headerSize)
 	}
 
 	// Read and validate version
 	version := data[0]
-	if version != serializeVersion {
-		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeVersion)
+	size := uint64(headerSize)
+	blockBits := uint32(BlockBits)
+	switch version {
+	case serializeVersion:
+	case serializeVersionBlockBits:
+		size = headerSizeBlockBits
+		if len(data) < headerSizeBlockBits {
+			return fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), headerSizeBlockBits)
+		}
+		blockBits = binary.LittleEndian.Uint32(data[21:25])
+		if !ValidBlockBits(blockBits) {
+			return fmt.Errorf("%w: block size %d is not supported (valid: 256, 512, 1024)", ErrInvalidData, blockBits)
+		}
+	default:
+		return fmt.Errorf("%w: got version %d, expected %d or %d", ErrUnsupportedVersion, version, serializeVersion, serializeVersionBlockBits)
 	}
 
 	// Read header fields
@@ -238,62 +387,73 @@ func UnmarshalBinary(data []byte) (*Filter, error) {
 	count := binary.LittleEndian.Uint64(data[13:21])
 
 	// Validate k
-	primes := GetPrimePartition(k)
+	primes := GetBlockPartition(k, blockBits)
 	if primes == nil {
-		return nil, fmt.Errorf("%w: k=%d is not supported (valid range: 3-14)", ErrInvalidK, k)
+		return fmt.Errorf("%w: k=%d is not supported (valid range: %d-%d)", ErrInvalidK, k, MinK, MaxKForBlockBits(blockBits))
 	}
 
 	// Validate numBlocks to prevent overflow in subsequent calculations.
-	// Max safe value ensures numBlocks * BlockWords * 8 won't overflow uint64
+	// Max safe value ensures numBlocks * blockWords * 8 won't overflow uint64
 	// and that we can safely convert to int for slice allocation.
 	// We also require at least 1 block for a valid filter.
 	const maxNumBlocks = uint64(1) << 50 // ~1 petabyte of data, more than enough
 	if numBlocks == 0 {
-		return nil, fmt.Errorf("%w: numBlocks cannot be zero", ErrInvalidData)
+		return fmt.Errorf("%w: numBlocks cannot be zero", ErrInvalidData)
 	}
 	if numBlocks > maxNumBlocks {
-		return nil, fmt.Errorf("%w: numBlocks too large (%d)", ErrInvalidData, numBlocks)
+		return fmt.Errorf("%w: numBlocks too large (%d)", ErrInvalidData, numBlocks)
 	}
 
 	// Validate data length (safe from overflow now that numBlocks is bounded)
-	expectedDataLen := numBlocks * BlockWords * 8
-	expectedTotalLen := headerSize + expectedDataLen
+	blockWords := uint64(blockBits / 64)
+	expectedDataLen := numBlocks * blockWords * 8
+	expectedTotalLen := size + expectedDataLen
 	if uint64(len(data)) != expectedTotalLen {
-		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes, expected %d)", ErrInvalidData, len(data), expectedTotalLen)
+		return fmt.Errorf("%w: data length mismatch (got %d bytes, expected %d)", ErrInvalidData, len(data), expectedTotalLen)
 	}
 
-	// Allocate aligned memory for blocks
-	raw, blocks := makeAlignedUint64Slice(int(numBlocks * BlockWords))
+	// Reuse the existing blocks if they are the right size, otherwise
+	// release them and allocate aligned memory for the new ones
+	if uint64(len(dst.blocks)) != numBlocks*blockWords {
+		if dst.alloc != HeapAllocator {
+			// Unmapping a mapping we created ourselves cannot fail
+			_ = dst.Close()
+		}
+		dst.raw, dst.blocks, dst.alloc = makeAlignedUint64Slice(int(numBlocks*blockWords), HeapAllocator)
+	}
 
 	// Read block data
-	offset := headerSize
-	for i := range blocks {
-		blocks[i] = binary.LittleEndian.Uint64(data[offset : offset+8])
-		offset += 8
+	decodeWords(dst.blocks, data[size:])
+
+	if dst.k != k || dst.blockWords != blockWords || dst.probes == nil {
+		dst.primes = primes
+		dst.offsets = ComputeOffsets(primes)
+		dst.probes = newProbeTable(dst.primes, dst.offsets)
 	}
+	dst.numBlocks = numBlocks
+	dst.blockWords = blockWords
+	dst.k = k
+	dst.count = count
+	dst.setBits = popCount(dst.blocks)
 
-	return &Filter{
-		raw:       raw,
-		blocks:    blocks,
-		numBlocks: numBlocks,
-		k:         k,
-		primes:    primes,
-		offsets:   ComputeOffsets(primes),
-		count:     count,
-	}, nil
+	return nil
 }
 
 // AtomicFilter is a thread-safe bloom filter using atomic operations.
 // It uses the same cache-line blocked one-hashing technique as Filter
 // but with atomic.Uint64 for concurrent access.
 type AtomicFilter struct {
-	raw       []byte          // Raw allocation to keep aligned memory alive for GC
-	blocks    []atomic.Uint64 // 8 atomic uint64s per block = 512 bits (cache-line aligned)
-	numBlocks uint64          // Total number of 512-bit blocks
-	k         uint32          // Number of hash functions (partitions)
-	primes    []uint32        // Prime partition sizes
-	offsets   []uint32        // Cumulative offsets within block
-	count     atomic.Uint64   // Number of items added (approximate)
+	raw        []byte          // Raw allocation to keep aligned memory alive for GC
+	alloc      Allocator       // Allocator that provided raw
+	blocks     []atomic.Uint64 // blockWords atomic uint64s per block (cache-line aligned)
+	numBlocks  uint64          // Total number of blocks
+	blockWords uint64          // Number of uint64s per block, 8 for 512 bits
+	k          uint32          // Number of hash functions (partitions)
+	primes     []uint32        // Prime partition sizes
+	offsets    []uint32        // Cumulative offsets within block
+	probes     *probeTable     // Vector constants for the SIMD probe path
+	count      stripedCounter  // Number of items added (approximate)
+	setBits    stripedCounter  // Number of bits set, maintained incrementally
 }
 
 // NewAtomic creates a new thread-safe bloom filter optimized for the
@@ -305,39 +465,68 @@ func NewAtomic(expectedItems uint64, fpRate float64) *AtomicFilter {
 
 // NewAtomicWithParams creates a new thread-safe bloom filter with explicit parameters.
 func NewAtomicWithParams(numBlocks uint64, k uint32) *AtomicFilter {
+	return NewAtomicWithParamsAllocator(numBlocks, k, HeapAllocator)
+}
+
+// NewAtomicWithParamsAllocator creates a new thread-safe bloom filter with
+// explicit parameters whose blocks are allocated by alloc. If alloc is
+// unavailable, the blocks fall back to the Go heap; Allocator reports which
+// one was used. Filters allocated outside the heap must be released with
+// Close, since the garbage collector does not free their memory.
+func NewAtomicWithParamsAllocator(numBlocks uint64, k uint32, alloc Allocator) *AtomicFilter {
+	return newAtomicFilter(numBlocks, k, BlockBits, alloc)
+}
+
+// NewAtomicWithBlockParams creates a new thread-safe bloom filter with
+// explicit parameters and blocks of blockBits bits. See NewWithBlockParams.
+func NewAtomicWithBlockParams(numBlocks uint64, k, blockBits uint32) *AtomicFilter {
+	return newAtomicFilter(numBlocks, k, blockBits, HeapAllocator)
+}
+
+// newAtomicFilter creates a new thread-safe bloom filter, substituting
+// defaults for unsupported parameters.
+func newAtomicFilter(numBlocks uint64, k, blockBits uint32, alloc Allocator) *AtomicFilter {
 	if numBlocks == 0 {
 		numBlocks = 1
 	}
+	if !ValidBlockBits(blockBits) {
+		blockBits = BlockBits
+	}
 
-	primes := GetPrimePartition(k)
+	primes := GetBlockPartition(k, blockBits)
 	if primes == nil {
 		k = 7
-		primes = GetPrimePartition(k)
+		primes = GetBlockPartition(k, blockBits)
 	}
 
-	raw, blocks := makeAlignedAtomicUint64Slice(int(numBlocks * BlockWords))
-
-	return &AtomicFilter{
-		raw:       raw,
-		blocks:    blocks,
-		numBlocks: numBlocks,
-		k:         k,
-		primes:    primes,
-		offsets:   ComputeOffsets(primes),
+	blockWords := uint64(blockBits / 64)
+	raw, blocks, alloc := makeAlignedAtomicUint64Slice(int(numBlocks*blockWords), alloc)
+	offsets := ComputeOffsets(primes)
+
+	f := &AtomicFilter{
+		raw:        raw,
+		alloc:      alloc,
+		blocks:     blocks,
+		numBlocks:  numBlocks,
+		blockWords: blockWords,
+		k:          k,
+		primes:     primes,
+		offsets:    offsets,
+		probes:     newProbeTable(primes, offsets),
+		count:      newStripedCounter(),
+		setBits:    newStripedCounter(),
 	}
+	return f
 }
 
 // makeAlignedAtomicUint64Slice allocates a cache-line aligned slice of atomic.Uint64.
-// Returns the raw byte slice (to keep alive for GC) and the aligned atomic slice.
-func makeAlignedAtomicUint64Slice(n int) ([]byte, []atomic.Uint64) {
+// Returns the raw byte slice (to keep alive for GC), the aligned atomic slice
+// and the allocator that actually provided the memory.
+func makeAlignedAtomicUint64Slice(n int, alloc Allocator) ([]byte, []atomic.Uint64, Allocator) {
 	// atomic.Uint64 is the same size as uint64 (8 bytes)
 	const atomicSize = 8
-	// Allocate with extra space for alignment
-	raw := make([]byte, n*atomicSize+cacheLineSize-1)
-	addr := uintptr(unsafe.Pointer(&raw[0]))
-	offset := (cacheLineSize - int(addr%cacheLineSize)) % cacheLineSize
-	aligned := unsafe.Slice((*atomic.Uint64)(unsafe.Pointer(&raw[offset])), n)
-	return raw, aligned
+	raw, ptr, alloc := allocAligned(n*atomicSize, alloc)
+	return raw, unsafe.Slice((*atomic.Uint64)(ptr), n), alloc
 }
 
 // Add adds data to the bloom filter atomically.
@@ -354,18 +543,25 @@ func (f *AtomicFilter) AddString(s string) {
 
 // addWithHash sets bits atomically using pre-computed hash values.
 func (f *AtomicFilter) addWithHash(blockIdx uint64, intraHash uint32) {
-	blockBase := blockIdx * BlockWords
+	blockBase := blockIdx * f.blockWords
 
+	var flipped uint64
 	for i := uint32(0); i < f.k; i++ {
 		bitPos := f.offsets[i] + (intraHash % f.primes[i])
 		wordIdx := bitPos / 64
 		bitIdx := bitPos % 64
 		mask := uint64(1) << bitIdx
-		// Use atomic OR - most efficient on Go 1.23+
-		f.blocks[blockBase+uint64(wordIdx)].Or(mask)
+		// Use atomic OR - most efficient on Go 1.23+. It returns the old
+		// value, so exactly one writer observes each bit flipping.
+		old := f.blocks[blockBase+uint64(wordIdx)].Or(mask)
+		flipped += (^old >> bitIdx) & 1
 	}
 
-	f.count.Add(1)
+	// Stripe the counts by block so parallel writers rarely share a cache line
+	f.count.add(blockIdx, 1)
+	if flipped > 0 {
+		f.setBits.add(blockIdx, flipped)
+	}
 }
 
 // Test checks if data might be in the bloom filter.
@@ -383,7 +579,17 @@ func (f *AtomicFilter) TestString(s string) bool {
 
 // testWithHash checks bits using pre-computed hash values.
 func (f *AtomicFilter) testWithHash(blockIdx uint64, intraHash uint32) bool {
-	blockBase := blockIdx * BlockWords
+	blockBase := blockIdx * f.blockWords
+
+	if hasSIMDProbe && f.k >= simdProbeMinK {
+		bitPos := f.offsets[0] + (intraHash % f.primes[0])
+		if f.blocks[blockBase+uint64(bitPos/64)].Load()&(1<<(bitPos%64)) == 0 {
+			return false
+		}
+		// Each lane of the vector gather is an aligned 8-byte load, which is
+		// as atomic on amd64 as atomic.Uint64.Load.
+		return f.probes.test((*uint64)(unsafe.Pointer(&f.blocks[blockBase])), intraHash)
+	}
 
 	for i := uint32(0); i < f.k; i++ {
 		bitPos := f.offsets[i] + (intraHash % f.primes[i])
@@ -399,7 +605,7 @@ func (f *AtomicFilter) testWithHash(blockIdx uint64, intraHash uint32) bool {
 
 // Cap returns the capacity of the filter in bits.
 func (f *AtomicFilter) Cap() uint64 {
-	return f.numBlocks * BlockBits
+	return f.numBlocks * f.blockWords * 64
 }
 
 // K returns the number of hash functions (partitions) used.
@@ -408,16 +614,24 @@ func (f *AtomicFilter) K() uint32 {
 }
 
 // Count returns the approximate number of items added to the filter.
+// The count is eventually consistent: it includes every Add that completed
+// before the call, and may or may not include Adds running concurrently.
 func (f *AtomicFilter) Count() uint64 {
-	return f.count.Load()
+	return f.count.load()
 }
 
-// NumBlocks returns the number of 512-bit blocks in the filter.
+// NumBlocks returns the number of blocks in the filter.
 func (f *AtomicFilter) NumBlocks() uint64 {
 	return f.numBlocks
 }
 
-// setBitCount returns the number of bits set in the filter.
+// BlockBits returns the number of bits per block.
+func (f *AtomicFilter) BlockBits() uint32 {
+	return uint32(f.blockWords * 64)
+}
+
+// setBitCount returns the number of bits set in the filter by counting every
+// bit. It is exact only when no Add is running concurrently.
 func (f *AtomicFilter) setBitCount() uint64 {
 	var setBits uint64
 	for i := range f.blocks {
@@ -426,14 +640,47 @@ func (f *AtomicFilter) setBitCount() uint64 {
 	return setBits
 }
 
-// EstimatedFillRatio estimates the proportion of bits that are set.
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add, and is
+// eventually consistent in the same way as Count.
 func (f *AtomicFilter) EstimatedFillRatio() float64 {
-	return float64(f.setBitCount()) / float64(f.numBlocks*BlockBits)
+	return float64(f.setBits.load()) / float64(f.Cap())
+}
+
+// ExactFillRatio computes the proportion of bits that are set by counting
+// every bit in the filter. It takes time proportional to the filter size and
+// is intended for verifying EstimatedFillRatio.
+func (f *AtomicFilter) ExactFillRatio() float64 {
+	return float64(f.setBitCount()) / float64(f.Cap())
 }
 
 // EstimatedFalsePositiveRate estimates the current false positive rate.
 func (f *AtomicFilter) EstimatedFalsePositiveRate() float64 {
-	return EstimateFalsePositiveRate(f.numBlocks, f.k, f.count.Load())
+	return EstimateBlockFalsePositiveRate(f.numBlocks, f.k, f.BlockBits(), f.count.load())
+}
+
+// snapshot copies the filter into a Filter with the same parameters, for
+// serialization. Adds running concurrently may or may not be included.
+func (f *AtomicFilter) snapshot() *Filter {
+	s := newFilter(f.numBlocks, f.k, f.BlockBits(), HeapAllocator)
+	for i := range f.blocks {
+		s.blocks[i] = f.blocks[i].Load()
+	}
+	s.count = f.count.load()
+	s.setBits = popCount(s.blocks)
+	return s
+}
+
+// atomicFromFilter copies a Filter into an AtomicFilter with the same
+// parameters, the inverse of snapshot.
+func atomicFromFilter(s *Filter) *AtomicFilter {
+	f := newAtomicFilter(s.numBlocks, s.k, s.BlockBits(), HeapAllocator)
+	for i, word := range s.blocks {
+		f.blocks[i].Store(word)
+	}
+	f.count.add(0, s.count)
+	f.setBits.add(0, s.setBits)
+	return f
 }
 
 // ShardedAtomicFilter is a thread-safe bloom filter that distributes writes
@@ -538,6 +785,11 @@ func (f *ShardedAtomicFilter) Count() uint64 {
 	return total
 }
 
+// BlockBits returns the number of bits per block in every shard.
+func (f *ShardedAtomicFilter) BlockBits() uint32 {
+	return f.shards[0].BlockBits()
+}
+
 // NumShards returns the number of shards.
 func (f *ShardedAtomicFilter) NumShards() uint64 {
 	return f.numShards
@@ -552,17 +804,29 @@ func (f *ShardedAtomicFilter) NumBlocks() uint64 {
 	return total
 }
 
-// EstimatedFillRatio estimates the average fill ratio across all shards.
+// EstimatedFillRatio returns the average fill ratio across all shards.
+// It runs in time proportional to the number of shards, not their size.
 func (f *ShardedAtomicFilter) EstimatedFillRatio() float64 {
 	var totalBits, setBits uint64
 	for _, shard := range f.shards {
 		totalBits += shard.Cap()
-		setBits += shard.setBitCount()
+		setBits += shard.setBits.load()
 	}
 	// totalBits is always > 0 since shards always have capacity
 	return float64(setBits) / float64(totalBits)
 }
 
+// ExactFillRatio computes the average fill ratio across all shards by
+// counting every bit. It is intended for verifying EstimatedFillRatio.
+func (f *ShardedAtomicFilter) ExactFillRatio() float64 {
+	var totalBits, setBits uint64
+	for _, shard := range f.shards {
+		totalBits += shard.Cap()
+		setBits += shard.setBitCount()
+	}
+	return float64(setBits) / float64(totalBits)
+}
+
 // EstimatedFalsePositiveRate estimates the current false positive rate.
 // For sharded filters, this is approximately the average across shards.
 func (f *ShardedAtomicFilter) EstimatedFalsePositiveRate() float64 {
@@ -3,6 +3,7 @@ package gloom
 import (
 	"fmt"
 	"math"
+	"slices"
 	"sync"
 	"testing"
 	"unsafe"
@@ -89,6 +90,58 @@ func TestFilterEstimatedFillRatio(t *testing.T) {
 	t.Logf("Fill ratio after 500 items: %.4f", ratio)
 }
 
+// TestFillRatioMatchesExact verifies that the incrementally maintained
+// set-bit count agrees exactly with a full popcount of the filter.
+func TestFillRatioMatchesExact(t *testing.T) {
+	f := New(1000, 0.01)
+	af := NewAtomic(1000, 0.01)
+	sf := NewShardedAtomic(1000, 0.01, 4)
+
+	for i := range 2000 { // Overfill so many Adds flip no new bits
+		key := fmt.Appendf(nil, "fill-%d", i)
+		f.Add(key)
+		af.Add(key)
+		sf.Add(key)
+
+		if i%100 != 0 {
+			continue
+		}
+		if got, want := f.EstimatedFillRatio(), f.ExactFillRatio(); got != want {
+			t.Fatalf("Filter after %d adds: EstimatedFillRatio=%f, ExactFillRatio=%f", i+1, got, want)
+		}
+		if got, want := af.EstimatedFillRatio(), af.ExactFillRatio(); got != want {
+			t.Fatalf("AtomicFilter after %d adds: EstimatedFillRatio=%f, ExactFillRatio=%f", i+1, got, want)
+		}
+		if got, want := sf.EstimatedFillRatio(), sf.ExactFillRatio(); got != want {
+			t.Fatalf("ShardedAtomicFilter after %d adds: EstimatedFillRatio=%f, ExactFillRatio=%f", i+1, got, want)
+		}
+	}
+}
+
+// TestAtomicFillRatioConcurrent verifies that concurrent writers never count
+// the same bit flip twice.
+func TestAtomicFillRatioConcurrent(t *testing.T) {
+	f := NewAtomic(10000, 0.01)
+
+	const numGoroutines = 8
+	var wg sync.WaitGroup
+	for g := range numGoroutines {
+		wg.Add(1)
+		go func(goroutineID int) {
+			defer wg.Done()
+			// Overlapping key ranges so goroutines race to set the same bits
+			for i := range 5000 {
+				f.AddString(fmt.Sprintf("item-%d", (goroutineID*1000+i)%10000))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if got, want := f.EstimatedFillRatio(), f.ExactFillRatio(); got != want {
+		t.Errorf("EstimatedFillRatio=%f, ExactFillRatio=%f", got, want)
+	}
+}
+
 func TestAtomicFilterBasic(t *testing.T) {
 	f := NewAtomic(1000, 0.01)
 
@@ -205,6 +258,8 @@ func TestOptimalParams(t *testing.T) {
 		{1000, 0.01, 7},      // 1% FP rate -> k~7
 		{10000, 0.001, 10},   // 0.1% FP rate -> k~10
 		{100000, 0.0001, 13}, // 0.01% FP rate -> k~13
+		{100000, 1e-6, 13},   // k=14 and 15 would be worse at this load
+		{100000, 1e-9, 15},   // k=15 overtakes 13 at light loads
 	}
 
 	for _, tt := range tests {
@@ -212,15 +267,72 @@ func TestOptimalParams(t *testing.T) {
 		t.Logf("items=%d, fpRate=%.4f -> numBlocks=%d, k=%d, bitsPerItem=%.2f",
 			tt.items, tt.fpRate, numBlocks, k, bpi)
 
+		if k != tt.wantK {
+			t.Errorf("k=%d, want %d", k, tt.wantK)
+		}
+
 		// k should be in reasonable range
-		if k < 3 || k > 14 {
-			t.Errorf("k=%d out of supported range [3,14]", k)
+		if k < MinK || k > MaxK {
+			t.Errorf("k=%d out of range [%d,%d]", k, MinK, MaxK)
+		}
+	}
+}
+
+func TestOptimalBlockParams(t *testing.T) {
+	tests := []struct {
+		items         uint64
+		fpRate        float64
+		wantK         uint32
+		wantBlockBits uint32
+	}{
+		{1000, 0.01, 7, 512},       // 1% FP rate -> k~7
+		{10000, 0.001, 11, 512},    // 0.1% FP rate -> k~11 once grown to meet it
+		{100000, 0.0001, 13, 1024}, // 0.01% FP rate -> k~13, 512-bit blocks can't reach it
+		{100000, 1e-9, 20, 1024},   // k above 19 helps at light loads
+		{100000, 0, 13, 1024},      // fpRate defaults to 0.01% as in OptimalParams
+	}
+
+	for _, tt := range tests {
+		numBlocks, k, blockBits, bpi := OptimalBlockParams(tt.items, tt.fpRate)
+		t.Logf("items=%d, fpRate=%.4f -> numBlocks=%d, k=%d, blockBits=%d, bitsPerItem=%.2f",
+			tt.items, tt.fpRate, numBlocks, k, blockBits, bpi)
+
+		if k != tt.wantK {
+			t.Errorf("k=%d, want %d", k, tt.wantK)
+		}
+		if blockBits != tt.wantBlockBits {
+			t.Errorf("blockBits=%d, want %d", blockBits, tt.wantBlockBits)
+		}
+
+		if want := float64(numBlocks*uint64(blockBits)) / float64(tt.items); bpi != want {
+			t.Errorf("bitsPerItem=%.2f, want %.2f", bpi, want)
+		}
+
+		// The filter is grown just enough to meet the target
+		fpRate := tt.fpRate
+		if fpRate == 0 {
+			fpRate = 0.0001
+		}
+		if est := EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, tt.items); est > fpRate {
+			t.Errorf("estimated FP %.3g above target %.3g", est, fpRate)
+		}
+		smallerK := optimalBlockK(numBlocks-1, blockBits, tt.items)
+		if est := EstimateBlockFalsePositiveRate(numBlocks-1, smallerK, blockBits, tt.items); est <= fpRate {
+			t.Errorf("%d blocks already meet the target with estimated FP %.3g", numBlocks-1, est)
+		}
+
+		// The chosen block size must be at least as accurate as the default
+		wantBlocks, wantK, _ := OptimalParams(tt.items, tt.fpRate)
+		est := EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, tt.items)
+		def512 := EstimateFalsePositiveRate(wantBlocks, wantK, tt.items)
+		if est > def512 {
+			t.Errorf("estimated FP %.3g worse than 512-bit blocks' %.3g", est, def512)
 		}
 	}
 }
 
 func TestPrimePartitions(t *testing.T) {
-	for k := uint32(3); k <= 14; k++ {
+	for k := uint32(MinK); k <= MaxK; k++ {
 		primes := GetPrimePartition(k)
 		if primes == nil {
 			t.Errorf("no partition for k=%d", k)
@@ -245,6 +357,364 @@ func TestPrimePartitions(t *testing.T) {
 	}
 }
 
+func TestPrimePartitionsPairwiseCoprime(t *testing.T) {
+	for k := uint32(MinK); k <= MaxK; k++ {
+		primes := GetPrimePartition(k)
+		for i := range primes {
+			for j := range i {
+				a, b := primes[i], primes[j]
+				for b != 0 {
+					a, b = b, a%b
+				}
+				if a != 1 {
+					t.Errorf("k=%d: %d and %d share factor %d", k, primes[i], primes[j], a)
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratedPartitionsStable(t *testing.T) {
+	// Partitions are derived from k when deserializing, so the generated ones
+	// are part of the serialization format and must never change
+	want := map[uint32][]uint32{
+		1:  {512},
+		2:  {241, 271},
+		15: {13, 16, 19, 23, 25, 27, 29, 31, 37, 41, 43, 47, 49, 53, 59},
+	}
+	for k := uint32(MinK); k <= MaxK; k++ {
+		_, inTable := primePartitions[k]
+		_, generated := want[k]
+		if inTable == generated {
+			t.Errorf("k=%d: expected exactly one of the table and generator to provide it", k)
+		}
+	}
+	for k, primes := range want {
+		if got := GetPrimePartition(k); !slices.Equal(got, primes) {
+			t.Errorf("k=%d: got %v, want %v", k, got, primes)
+		}
+	}
+}
+
+func TestGeneratePartitionMatchesTableRules(t *testing.T) {
+	// The generator also handles the table's k values, and its partitions
+	// are never less balanced than the hand-written ones
+	for k := range primePartitions {
+		got := generatePartition(k, BlockBits)
+		if len(got) != int(k) {
+			t.Fatalf("k=%d: expected %d values, got %v", k, k, got)
+		}
+		var sum uint32
+		for _, p := range got {
+			sum += p
+		}
+		if sum != BlockBits {
+			t.Errorf("k=%d: sum=%d, expected %d", k, sum, BlockBits)
+		}
+		if slices.Min(got) < slices.Min(primePartitions[k]) {
+			t.Errorf("k=%d: smallest value %d below table's %d", k, slices.Min(got), slices.Min(primePartitions[k]))
+		}
+	}
+}
+
+func TestGeneratePartitionNearEqual(t *testing.T) {
+	// No value is below a third of the mean, and no partition exists past the
+	// largest supported k, where only tiny values would fit
+	for _, blockBits := range []uint32{256, 512, 1024} {
+		maxK := MaxKForBlockBits(blockBits)
+		for k := uint32(MinK); k <= maxK; k++ {
+			primes := generatePartition(k, blockBits)
+			if len(primes) != int(k) {
+				t.Fatalf("blockBits=%d, k=%d: got %v", blockBits, k, primes)
+			}
+			if smallest := slices.Min(primes); 3*k*smallest < blockBits {
+				t.Errorf("blockBits=%d, k=%d: %d is below a third of the mean", blockBits, k, smallest)
+			}
+		}
+		for k := maxK + 1; k <= maxK+8; k++ {
+			if primes := generatePartition(k, blockBits); primes != nil {
+				t.Errorf("blockBits=%d, k=%d: expected no partition, got %v", blockBits, k, primes)
+			}
+		}
+	}
+}
+
+func TestValidBlockBits(t *testing.T) {
+	tests := []struct {
+		blockBits uint32
+		valid     bool
+		maxK      uint32
+	}{
+		{0, false, 0},
+		{64, false, 0},
+		{128, false, 0},
+		{256, true, 11},
+		{384, false, 0},
+		{512, true, MaxK},
+		{1000, false, 0},
+		{1024, true, 21},
+		{2048, false, 0},
+	}
+	for _, tt := range tests {
+		if got := ValidBlockBits(tt.blockBits); got != tt.valid {
+			t.Errorf("ValidBlockBits(%d) = %v, want %v", tt.blockBits, got, tt.valid)
+		}
+		if got := MaxKForBlockBits(tt.blockBits); got != tt.maxK {
+			t.Errorf("MaxKForBlockBits(%d) = %d, want %d", tt.blockBits, got, tt.maxK)
+		}
+	}
+}
+
+func TestBlockPartitions(t *testing.T) {
+	for _, blockBits := range []uint32{256, 512, 1024} {
+		maxK := MaxKForBlockBits(blockBits)
+		for k := uint32(MinK); k <= maxK; k++ {
+			primes := GetBlockPartition(k, blockBits)
+			if uint32(len(primes)) != k {
+				t.Fatalf("blockBits=%d, k=%d: got %v", blockBits, k, primes)
+			}
+
+			var sum uint32
+			for i, p := range primes {
+				sum += p
+				for _, q := range primes[:i] {
+					a, b := p, q
+					for b != 0 {
+						a, b = b, a%b
+					}
+					if a != 1 {
+						t.Errorf("blockBits=%d, k=%d: %d and %d share factor %d", blockBits, k, p, q, a)
+					}
+				}
+			}
+			if sum != blockBits {
+				t.Errorf("blockBits=%d, k=%d: sum=%d", blockBits, k, sum)
+			}
+		}
+
+		if got := GetBlockPartition(maxK+1, blockBits); got != nil {
+			t.Errorf("blockBits=%d: expected nil for k=%d, got %v", blockBits, maxK+1, got)
+		}
+		if got := GetBlockPartition(0, blockBits); got != nil {
+			t.Errorf("blockBits=%d: expected nil for k=0, got %v", blockBits, got)
+		}
+	}
+
+	if got := GetBlockPartition(7, 384); got != nil {
+		t.Errorf("expected nil for unsupported block size, got %v", got)
+	}
+	if !slices.Equal(GetBlockPartition(7, BlockBits), GetPrimePartition(7)) {
+		t.Error("GetBlockPartition(k, BlockBits) differs from GetPrimePartition(k)")
+	}
+}
+
+func TestBlockPartitionsStable(t *testing.T) {
+	// Like the generated 512-bit partitions, these are part of the
+	// serialization format and must never change
+	want := map[uint32][][]uint32{
+		256: {
+			1:  {256},
+			2:  {107, 149},
+			3:  {83, 89, 84},
+			4:  {53, 59, 71, 73},
+			5:  {43, 47, 59, 61, 46},
+			6:  {29, 37, 41, 43, 47, 59},
+			7:  {29, 31, 37, 41, 43, 47, 28},
+			8:  {17, 19, 23, 29, 37, 41, 43, 47},
+			9:  {17, 19, 23, 29, 31, 37, 41, 43, 16},
+			10: {11, 13, 17, 19, 23, 27, 29, 31, 37, 49},
+			11: {9, 11, 13, 16, 17, 19, 25, 29, 31, 37, 49},
+		},
+		1024: {
+			1:  {1024},
+			2:  {503, 521},
+			3:  {337, 349, 338},
+			4:  {241, 251, 263, 269},
+			5:  {197, 199, 211, 223, 194},
+			6:  {151, 163, 167, 173, 179, 191},
+			7:  {131, 137, 149, 151, 157, 167, 132},
+			8:  {107, 109, 113, 127, 131, 137, 149, 151},
+			9:  {97, 103, 107, 109, 113, 127, 131, 139, 98},
+			10: {73, 83, 89, 97, 101, 103, 107, 113, 127, 131},
+			11: {71, 79, 83, 89, 97, 101, 103, 107, 109, 113, 72},
+			12: {59, 61, 67, 71, 73, 83, 89, 97, 101, 103, 107, 113},
+			13: {53, 59, 61, 67, 71, 79, 83, 89, 97, 101, 103, 107, 54},
+			14: {41, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103},
+			15: {41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 97, 101, 107, 42},
+			16: {29, 31, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101},
+			17: {29, 31, 37, 41, 43, 47, 53, 59, 61, 71, 73, 79, 83, 89, 97, 101, 30},
+			18: {19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 83, 89, 97, 101},
+			19: {19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 22},
+			20: {19, 23, 25, 27, 29, 31, 37, 41, 43, 47, 49, 53, 59, 61, 67, 71, 73, 83, 89, 97},
+			21: {17, 19, 23, 25, 27, 29, 31, 32, 37, 41, 43, 47, 49, 53, 59, 61, 67, 71, 83, 89, 121},
+		},
+	}
+	for blockBits, partitions := range want {
+		for k := uint32(MinK); k <= MaxKForBlockBits(blockBits); k++ {
+			if got := GetBlockPartition(k, blockBits); !slices.Equal(got, partitions[k]) {
+				t.Errorf("blockBits=%d, k=%d: got %v, want %v", blockBits, k, got, partitions[k])
+			}
+		}
+	}
+}
+
+func TestBlockSizes(t *testing.T) {
+	for _, blockBits := range []uint32{256, 512, 1024} {
+		t.Run(fmt.Sprintf("%d", blockBits), func(t *testing.T) {
+			const numBlocks = 50
+			f := NewWithBlockParams(numBlocks, 6, blockBits)
+			af := NewAtomicWithBlockParams(numBlocks, 6, blockBits)
+
+			if f.BlockBits() != blockBits || af.BlockBits() != blockBits {
+				t.Errorf("BlockBits() = %d, %d, want %d", f.BlockBits(), af.BlockBits(), blockBits)
+			}
+			if f.Cap() != numBlocks*uint64(blockBits) || af.Cap() != numBlocks*uint64(blockBits) {
+				t.Errorf("Cap() = %d, %d, want %d", f.Cap(), af.Cap(), numBlocks*blockBits)
+			}
+			if len(f.blocks) != numBlocks*int(blockBits)/64 || len(af.blocks) != numBlocks*int(blockBits)/64 {
+				t.Errorf("expected %d words, got %d and %d", numBlocks*blockBits/64, len(f.blocks), len(af.blocks))
+			}
+
+			for i := range 2000 {
+				key := fmt.Appendf(nil, "block-%d", i)
+				f.Add(key)
+				af.Add(key)
+			}
+			for i := range 2000 {
+				key := fmt.Appendf(nil, "block-%d", i)
+				if !f.Test(key) || !af.Test(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+
+			// Both variants set exactly the same bits
+			for i := range f.blocks {
+				if f.blocks[i] != af.blocks[i].Load() {
+					t.Fatalf("word %d differs: %x vs %x", i, f.blocks[i], af.blocks[i].Load())
+				}
+			}
+			if f.EstimatedFillRatio() != f.ExactFillRatio() || af.EstimatedFillRatio() != af.ExactFillRatio() {
+				t.Errorf("fill ratio mismatch: %f/%f vs %f/%f",
+					f.EstimatedFillRatio(), f.ExactFillRatio(), af.EstimatedFillRatio(), af.ExactFillRatio())
+			}
+
+			want := EstimateBlockFalsePositiveRate(numBlocks, 6, blockBits, 2000)
+			if f.EstimatedFalsePositiveRate() != want || af.EstimatedFalsePositiveRate() != want {
+				t.Errorf("EstimatedFalsePositiveRate() = %g, %g, want %g",
+					f.EstimatedFalsePositiveRate(), af.EstimatedFalsePositiveRate(), want)
+			}
+		})
+	}
+}
+
+func TestNewWithBlockParamsInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		k         uint32
+		blockBits uint32
+		wantK     uint32
+		wantBits  uint32
+	}{
+		{"unsupported block size", 5, 384, 5, 512},
+		{"zero block size", 5, 0, 5, 512},
+		{"k too large for 256", 14, 256, 7, 256},
+		{"k too large for 1024", 24, 1024, 7, 1024},
+		{"zero k", 0, 1024, 7, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewWithBlockParams(10, tt.k, tt.blockBits)
+			af := NewAtomicWithBlockParams(10, tt.k, tt.blockBits)
+			if f.K() != tt.wantK || f.BlockBits() != tt.wantBits {
+				t.Errorf("Filter: got k=%d, blockBits=%d, want %d, %d", f.K(), f.BlockBits(), tt.wantK, tt.wantBits)
+			}
+			if af.K() != tt.wantK || af.BlockBits() != tt.wantBits {
+				t.Errorf("AtomicFilter: got k=%d, blockBits=%d, want %d, %d", af.K(), af.BlockBits(), tt.wantK, tt.wantBits)
+			}
+		})
+	}
+}
+
+func TestShardedAtomicFilterBlockBits(t *testing.T) {
+	// Like NewAtomic, shards keep 512-bit blocks even at tight targets
+	for _, fpRate := range []float64{0.01, 1e-7} {
+		if got := NewShardedAtomic(100000, fpRate, 4).BlockBits(); got != 512 {
+			t.Errorf("fpRate=%g: BlockBits() = %d, want 512", fpRate, got)
+		}
+	}
+}
+
+func TestBlockSizeFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	// The estimate must track the measured rate for every block size, or
+	// OptimalParams would choose between them on bad information
+	for _, tc := range []struct{ blockBits, k uint32 }{
+		{256, 3}, {256, 8}, {256, 13},
+		{1024, 7}, {1024, 12}, {1024, 16},
+	} {
+		t.Run(fmt.Sprintf("%d/k_%d", tc.blockBits, tc.k), func(t *testing.T) {
+			numBlocks := uint64(1_024_000 / tc.blockBits)
+			items := uint64(float64(numBlocks*uint64(tc.blockBits)) * ln2 / float64(tc.k))
+			f := NewWithBlockParams(numBlocks, tc.k, tc.blockBits)
+			for i := range items {
+				f.Add(fmt.Appendf(nil, "item-%d", i))
+			}
+
+			const testItems = 1_000_000
+			var falsePositives int
+			for i := range testItems {
+				if f.Test(fmt.Appendf(nil, "notitem-%d", i)) {
+					falsePositives++
+				}
+			}
+
+			actual := float64(falsePositives) / testItems
+			estimated := f.EstimatedFalsePositiveRate()
+			if actual > estimated*1.5 || actual < estimated/1.5 {
+				t.Errorf("FP rate %.3g too far from estimate %.3g", actual, estimated)
+			}
+			t.Logf("FP rate %.3g (estimate %.3g)", actual, estimated)
+		})
+	}
+}
+
+func TestGeneratedPartitionFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	// Independent probes make the measured rate track the estimate, which
+	// would not hold if partition values shared factors
+	for _, k := range []uint32{1, 2} {
+		t.Run(fmt.Sprintf("k_%d", k), func(t *testing.T) {
+			const numBlocks = 2000
+			items := uint64(float64(numBlocks*BlockBits) * ln2 / float64(k))
+			f := NewWithParams(numBlocks, k)
+			for i := range items {
+				f.Add(fmt.Appendf(nil, "item-%d", i))
+			}
+
+			const testItems = 1_000_000
+			var falsePositives int
+			for i := range testItems {
+				if f.Test(fmt.Appendf(nil, "notitem-%d", i)) {
+					falsePositives++
+				}
+			}
+
+			actual := float64(falsePositives) / testItems
+			estimated := f.EstimatedFalsePositiveRate()
+			if actual > estimated*1.5 || actual < estimated/1.5 {
+				t.Errorf("FP rate %.3g too far from estimate %.3g", actual, estimated)
+			}
+			t.Logf("k=%d: FP rate %.3g (estimate %.3g)", k, actual, estimated)
+		})
+	}
+}
+
 func TestEstimateFalsePositiveRate(t *testing.T) {
 	// Test that the blocked formula gives a higher estimate than the standard
 	// formula, since Poisson variance across blocks increases FP rate.
@@ -274,7 +744,7 @@ func TestEstimateFalsePositiveRate(t *testing.T) {
 }
 
 func TestFilterWithDifferentKValues(t *testing.T) {
-	for k := uint32(3); k <= 14; k++ {
+	for k := uint32(MinK); k <= MaxK; k++ {
 		f := NewWithParams(100, k)
 
 		// Add some items
@@ -581,16 +1051,16 @@ func TestOptimalParamsEdgeCases(t *testing.T) {
 		t.Error("expected non-zero params for 1 item")
 	}
 
-	// Test with very low FP rate (should cap k at 14)
+	// Test with very low FP rate (should cap k at MaxK)
 	_, k, _ = OptimalParams(1000, 0.0000001)
-	if k > 14 {
-		t.Errorf("expected k <= 14, got %d", k)
+	if k != MaxK {
+		t.Errorf("expected k = %d, got %d", MaxK, k)
 	}
 
-	// Test with very high FP rate (should have low k, clamped to 3)
+	// Test with very high FP rate (should have low k, clamped to MinK)
 	_, k, _ = OptimalParams(1000, 0.5)
-	if k < 3 {
-		t.Errorf("expected k >= 3, got %d", k)
+	if k != MinK {
+		t.Errorf("expected k = %d, got %d", MinK, k)
 	}
 
 	// Test with fpRate <= 0 (should default to 0.0001)
@@ -656,11 +1126,11 @@ func TestGetPrimePartitionInvalid(t *testing.T) {
 	if GetPrimePartition(0) != nil {
 		t.Error("expected nil for k=0")
 	}
-	if GetPrimePartition(2) != nil {
-		t.Error("expected nil for k=2")
+	if GetPrimePartition(MaxK+1) != nil {
+		t.Errorf("expected nil for k=%d", MaxK+1)
 	}
-	if GetPrimePartition(15) != nil {
-		t.Error("expected nil for k=15")
+	if GetPrimePartition(100) != nil {
+		t.Error("expected nil for k=100")
 	}
 }
 
@@ -1535,7 +2005,7 @@ func TestPropertyShardedFilterEquivalence(t *testing.T) {
 // TestPropertyDifferentKValues verifies the filter works correctly with all
 // supported k values.
 func TestPropertyDifferentKValues(t *testing.T) {
-	for k := uint32(3); k <= 14; k++ {
+	for k := uint32(MinK); k <= MaxK; k++ {
 		t.Run(fmt.Sprintf("k_%d", k), func(t *testing.T) {
 			f := NewWithParams(100, k)
 
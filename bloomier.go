@@ -0,0 +1,334 @@
+package gloom
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"slices"
+)
+
+const (
+	// MaxBloomierValueBits is the largest value size a BloomierFilter
+	// supports.
+	MaxBloomierValueBits = 32
+
+	// maxBloomierFingerprintBits caps the fingerprint size of a
+	// BloomierFilter, for a false positive rate of 2^-32.
+	maxBloomierFingerprintBits = 32
+
+	// serializeBloomierVersion is the serialization format version of a
+	// BloomierFilter.
+	serializeBloomierVersion byte = 6
+
+	// bloomierHeaderSize is the size of the BloomierFilter serialization
+	// header in bytes: Version (1) + FingerprintBits (4) + ValueBits (4) +
+	// Seed (8) + SegmentLength (4) + SegmentCount (4) + Count (8) = 33 bytes.
+	bloomierHeaderSize = 33
+)
+
+// ErrInvalidValues is returned when the values given to a BloomierFilter do
+// not match its keys or do not fit in its value size.
+var ErrInvalidValues = errors.New("gloom: invalid Bloomier filter values")
+
+// BloomierFilter is an immutable map from a known set of keys to small
+// values, built once like a StaticFilter. It stores no keys or per-key
+// entries: each key maps to three slots whose XOR holds its value and a
+// fingerprint, in about 1.125*(r+f) bits per key for r-bit values and f-bit
+// fingerprints. Keys outside the set are reported absent unless their
+// fingerprint matches, which happens with probability 2^-f. It is safe for
+// concurrent use, since it is never modified.
+//
+// A BloomierFilter replaces one Filter per value, such as one per shard, with
+// a single lookup that returns the value itself rather than every value
+// whose filter might hold the key.
+type BloomierFilter struct {
+	fuseLayout
+	words     []uint64 // Bit-packed slots of fpBits+valueBits bits, plus one word of padding
+	fpBits    uint32   // Bits per fingerprint, 0 to 32
+	valueBits uint32   // Bits per value, 1 to MaxBloomierValueBits
+	count     uint64   // Number of distinct keys
+}
+
+// BuildBloomier builds a BloomierFilter mapping keys[i] to values[i]. Values
+// must fit in valueBits bits, from 1 to MaxBloomierValueBits, and fpRate is
+// the rate at which keys outside the set are reported present, rounded down
+// to a power of 2. An fpRate of 1 or more stores no fingerprints, so every
+// key is reported present with an arbitrary value.
+//
+// A key may repeat with the same value. Construction retries like
+// BuildStatic and returns ErrConstructionFailed if every seed fails, and
+// ErrInvalidValues if the values do not fit or a key repeats with different
+// values.
+func BuildBloomier(keys [][]byte, values []uint64, valueBits uint32, fpRate float64) (*BloomierFilter, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("%w: %d keys but %d values", ErrInvalidValues, len(keys), len(values))
+	}
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hashRaw(key)
+	}
+	return buildBloomier(hashes, slices.Clone(values), valueBits, fpRate, fuseMaxAttempts)
+}
+
+// BuildBloomierStrings builds a BloomierFilter from string keys without
+// converting them to byte slices. See BuildBloomier.
+func BuildBloomierStrings(keys []string, values []uint64, valueBits uint32, fpRate float64) (*BloomierFilter, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("%w: %d keys but %d values", ErrInvalidValues, len(keys), len(values))
+	}
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hashRawString(key)
+	}
+	return buildBloomier(hashes, slices.Clone(values), valueBits, fpRate, fuseMaxAttempts)
+}
+
+// BuildBloomierSeq builds a BloomierFilter from every key and value in
+// pairs. Keys are hashed as they are yielded, so the sequence is consumed
+// once and may reuse the memory of a yielded key. See BuildBloomier.
+func BuildBloomierSeq(pairs iter.Seq2[[]byte, uint64], valueBits uint32, fpRate float64) (*BloomierFilter, error) {
+	var hashes, values []uint64
+	for key, value := range pairs {
+		hashes = append(hashes, hashRaw(key))
+		values = append(values, value)
+	}
+	return buildBloomier(hashes, values, valueBits, fpRate, fuseMaxAttempts)
+}
+
+// bloomierFingerprintBits returns the fingerprint size for a false positive
+// rate: the fewest bits whose rate 2^-f is at most fpRate.
+func bloomierFingerprintBits(fpRate float64) uint32 {
+	if fpRate <= 0 {
+		fpRate = 0.0001 // default to 0.01%
+	}
+	if fpRate >= 1 {
+		return 0
+	}
+	return uint32(min(math.Ceil(-math.Log2(fpRate)), maxBloomierFingerprintBits))
+}
+
+// buildBloomier builds a BloomierFilter from key hashes and their values,
+// which it may reorder, trying at most maxAttempts seeds.
+func buildBloomier(hashes, values []uint64, valueBits uint32, fpRate float64, maxAttempts int) (*BloomierFilter, error) {
+	if valueBits == 0 || valueBits > MaxBloomierValueBits {
+		return nil, fmt.Errorf("%w: value size %d is not supported (valid range: 1-%d)", ErrInvalidValues, valueBits, MaxBloomierValueBits)
+	}
+	for i, v := range values {
+		if v>>valueBits != 0 {
+			return nil, fmt.Errorf("%w: value %d at index %d does not fit in %d bits", ErrInvalidValues, v, i, valueBits)
+		}
+	}
+
+	// Sort by hash so that repeated keys are adjacent, and drop repeats
+	type entry struct{ hash, value uint64 }
+	entries := make([]entry, len(hashes))
+	for i := range hashes {
+		entries[i] = entry{hashes[i], values[i]}
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.value, b.value))
+	})
+	entries = slices.Compact(entries)
+	hashes, values = hashes[:len(entries)], values[:len(entries)]
+	for i, e := range entries {
+		if i > 0 && e.hash == hashes[i-1] {
+			return nil, fmt.Errorf("%w: a key maps to both %d and %d", ErrInvalidValues, values[i-1], e.value)
+		}
+		hashes[i], values[i] = e.hash, e.value
+	}
+
+	layout := newFuseLayout(uint64(len(hashes)))
+	order, positions, err := layout.peel(hashes, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	fpBits := bloomierFingerprintBits(fpRate)
+	width := uint64(fpBits + valueBits)
+	f := &BloomierFilter{
+		fuseLayout: layout,
+		words:      make([]uint64, (layout.numSlots()*width+63)/64+1),
+		fpBits:     fpBits,
+		valueBits:  valueBits,
+		count:      uint64(len(hashes)),
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		h := f.mix(hashes[order[i]])
+		h0, h1, h2 := f.slots(h)
+		slots := [3]uint64{h0, h1, h2}
+		own := positions[i]
+		content := f.fingerprint(h)<<valueBits | values[order[i]]
+		f.store(slots[own], content^f.load(slots[(own+1)%3])^f.load(slots[(own+2)%3]))
+	}
+	return f, nil
+}
+
+// fingerprint returns the fpBits-bit fingerprint of a mixed key hash.
+func (f *BloomierFilter) fingerprint(h uint64) uint64 {
+	return fuseFingerprint(h) & (1<<f.fpBits - 1)
+}
+
+// load returns the contents of slot i.
+func (f *BloomierFilter) load(i uint64) uint64 {
+	width := uint64(f.fpBits + f.valueBits)
+	bit := i * width
+	w, shift := bit/64, bit%64
+	// A shift by 64 yields 0, so the second word adds nothing when the slot
+	// starts a word, and the padding word keeps w+1 in range
+	return (f.words[w]>>shift | f.words[w+1]<<(64-shift)) & (1<<width - 1)
+}
+
+// store sets the contents of slot i, which must be empty.
+func (f *BloomierFilter) store(i, v uint64) {
+	width := uint64(f.fpBits + f.valueBits)
+	bit := i * width
+	w, shift := bit/64, bit%64
+	f.words[w] |= v << shift
+	f.words[w+1] |= v >> (64 - shift)
+}
+
+// Get returns the value of data and true if data might be in the set, or 0
+// and false if it is definitely absent. For a key outside the set that is
+// reported present, the value is arbitrary.
+func (f *BloomierFilter) Get(data []byte) (uint64, bool) {
+	return f.getWithHash(hashRaw(data))
+}
+
+// GetString returns the value of a string without allocating. See Get.
+func (f *BloomierFilter) GetString(s string) (uint64, bool) {
+	return f.getWithHash(hashRawString(s))
+}
+
+// getWithHash looks up a key using its pre-computed raw hash.
+func (f *BloomierFilter) getWithHash(h uint64) (uint64, bool) {
+	h = f.mix(h)
+	h0, h1, h2 := f.slots(h)
+	content := f.load(h0) ^ f.load(h1) ^ f.load(h2) ^ f.fingerprint(h)<<f.valueBits
+	if content>>f.valueBits != 0 {
+		return 0, false
+	}
+	return content, true
+}
+
+// Test checks if data might be in the set.
+// Returns true if the item might be present, false if definitely absent.
+func (f *BloomierFilter) Test(data []byte) bool {
+	_, ok := f.getWithHash(hashRaw(data))
+	return ok
+}
+
+// TestString checks if a string might be in the set without allocating.
+func (f *BloomierFilter) TestString(s string) bool {
+	_, ok := f.getWithHash(hashRawString(s))
+	return ok
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *BloomierFilter) Cap() uint64 {
+	return uint64(len(f.words)) * 64
+}
+
+// Count returns the number of distinct keys the filter was built from.
+func (f *BloomierFilter) Count() uint64 {
+	return f.count
+}
+
+// ValueBits returns the size of the filter's values in bits.
+func (f *BloomierFilter) ValueBits() uint32 {
+	return f.valueBits
+}
+
+// FingerprintBits returns the size of the filter's fingerprints in bits.
+func (f *BloomierFilter) FingerprintBits() uint32 {
+	return f.fpBits
+}
+
+// BitsPerItem returns the filter's memory in bits per distinct key.
+func (f *BloomierFilter) BitsPerItem() float64 {
+	if f.count == 0 {
+		return 0
+	}
+	return float64(f.Cap()) / float64(f.count)
+}
+
+// EstimatedFalsePositiveRate estimates the rate at which keys outside the
+// set are reported present: 2^-f for f-bit fingerprints.
+func (f *BloomierFilter) EstimatedFalsePositiveRate() float64 {
+	return math.Ldexp(1, -int(f.fpBits))
+}
+
+// MarshalBinary serializes the Bloomier filter to a byte slice.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 6
+//   - FingerprintBits (4 bytes): bits per fingerprint (little-endian uint32)
+//   - ValueBits (4 bytes): bits per value (little-endian uint32)
+//   - Seed (8 bytes): seed mixed into key hashes (little-endian uint64)
+//   - SegmentLength (4 bytes): slots per segment (little-endian uint32)
+//   - SegmentCount (4 bytes): number of starting segments (little-endian uint32)
+//   - Count (8 bytes): number of distinct keys (little-endian uint64)
+//   - Slots: bit-packed slots of FingerprintBits+ValueBits bits, followed
+//     by a padding word (little-endian uint64s)
+func (f *BloomierFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, bloomierHeaderSize+len(f.words)*8)
+
+	buf[0] = serializeBloomierVersion
+	binary.LittleEndian.PutUint32(buf[1:5], f.fpBits)
+	binary.LittleEndian.PutUint32(buf[5:9], f.valueBits)
+	binary.LittleEndian.PutUint64(buf[9:17], f.seed)
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(f.segmentLength))
+	binary.LittleEndian.PutUint32(buf[21:25], uint32(f.segmentCount))
+	binary.LittleEndian.PutUint64(buf[25:33], f.count)
+	encodeWords(buf[bloomierHeaderSize:], f.words)
+
+	return buf, nil
+}
+
+// UnmarshalBloomierBinary deserializes a Bloomier filter from a byte slice
+// written by BloomierFilter.MarshalBinary.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalBloomierBinary(data []byte) (*BloomierFilter, error) {
+	if len(data) < bloomierHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), bloomierHeaderSize)
+	}
+	if version := data[0]; version != serializeBloomierVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeBloomierVersion)
+	}
+
+	fpBits := binary.LittleEndian.Uint32(data[1:5])
+	valueBits := binary.LittleEndian.Uint32(data[5:9])
+	seed := binary.LittleEndian.Uint64(data[9:17])
+	segmentLength := uint64(binary.LittleEndian.Uint32(data[17:21]))
+	segmentCount := uint64(binary.LittleEndian.Uint32(data[21:25]))
+	count := binary.LittleEndian.Uint64(data[25:33])
+
+	if fpBits > maxBloomierFingerprintBits {
+		return nil, fmt.Errorf("%w: fingerprint size %d is not supported (valid range: 0-%d)", ErrInvalidData, fpBits, maxBloomierFingerprintBits)
+	}
+	if valueBits == 0 || valueBits > MaxBloomierValueBits {
+		return nil, fmt.Errorf("%w: value size %d is not supported (valid range: 1-%d)", ErrInvalidData, valueBits, MaxBloomierValueBits)
+	}
+	if err := validateFuseLayout(segmentLength, segmentCount); err != nil {
+		return nil, err
+	}
+	layout := makeFuseLayout(seed, segmentLength, segmentCount)
+	// Both layout fields are 32-bit, so the length cannot overflow
+	numWords := (layout.numSlots()*uint64(fpBits+valueBits)+63)/64 + 1
+	if expected := bloomierHeaderSize + numWords*8; uint64(len(data)) != expected {
+		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes, expected %d)", ErrInvalidData, len(data), expected)
+	}
+
+	f := &BloomierFilter{
+		fuseLayout: layout,
+		words:      make([]uint64, numWords),
+		fpBits:     fpBits,
+		valueBits:  valueBits,
+		count:      count,
+	}
+	decodeWords(f.words, data[bloomierHeaderSize:])
+	return f, nil
+}
@@ -0,0 +1,330 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"testing"
+)
+
+// shardKeys returns n keys and a shard ID below numShards for each.
+func shardKeys(n int, numShards uint64) ([]string, []uint64) {
+	keys := make([]string, n)
+	shards := make([]uint64, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("build-%d", i)
+		shards[i] = uint64(i*7919) % numShards
+	}
+	return keys, shards
+}
+
+func TestBloomierFilterBasic(t *testing.T) {
+	keys, shards := shardKeys(10000, 16)
+	f, err := BuildBloomierStrings(keys, shards, 4, 0.01)
+	if err != nil {
+		t.Fatalf("BuildBloomierStrings failed: %v", err)
+	}
+	for i, key := range keys {
+		if v, ok := f.GetString(key); !ok || v != shards[i] {
+			t.Fatalf("GetString(%q) = (%d, %v), want (%d, true)", key, v, ok, shards[i])
+		}
+		if v, ok := f.Get([]byte(key)); !ok || v != shards[i] {
+			t.Fatalf("Get(%q) = (%d, %v), want (%d, true)", key, v, ok, shards[i])
+		}
+		if !f.Test([]byte(key)) || !f.TestString(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	if f.Count() != 10000 || f.ValueBits() != 4 || f.FingerprintBits() != 7 {
+		t.Errorf("got Count() = %d, ValueBits() = %d, FingerprintBits() = %d", f.Count(), f.ValueBits(), f.FingerprintBits())
+	}
+	if f.Cap() != uint64(len(f.words))*64 || f.BitsPerItem() != float64(f.Cap())/10000 {
+		t.Errorf("Cap() = %d, BitsPerItem() = %f", f.Cap(), f.BitsPerItem())
+	}
+	if f.BitsPerItem() > 1.3*11 {
+		t.Errorf("BitsPerItem() = %f for 11-bit slots", f.BitsPerItem())
+	}
+	if fp := f.EstimatedFalsePositiveRate(); fp != 1.0/128 {
+		t.Errorf("EstimatedFalsePositiveRate() = %g, want 1/128", fp)
+	}
+	if v, ok := f.GetString("absent"); ok || v != 0 {
+		t.Errorf("GetString(absent) = (%d, %v)", v, ok)
+	}
+
+	// Every constructor builds the same filter
+	byteKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		byteKeys[i] = []byte(key)
+	}
+	fromBytes, err := BuildBloomier(byteKeys, shards, 4, 0.01)
+	if err != nil {
+		t.Fatalf("BuildBloomier failed: %v", err)
+	}
+	fromSeq, err := BuildBloomierSeq(func(yield func([]byte, uint64) bool) {
+		for i, key := range byteKeys {
+			if !yield(key, shards[i]) {
+				return
+			}
+		}
+	}, 4, 0.01)
+	if err != nil {
+		t.Fatalf("BuildBloomierSeq failed: %v", err)
+	}
+	for _, g := range []*BloomierFilter{fromBytes, fromSeq} {
+		if g.seed != f.seed || !slicesEqual(g.words, f.words) {
+			t.Error("constructors built different filters")
+		}
+	}
+
+	// The caller's values are left alone
+	if want, _ := shardKeys(10000, 16); keys[0] != want[0] || shards[1] != 7919%16 {
+		t.Error("inputs modified")
+	}
+}
+
+// slicesEqual reports whether two word slices are equal.
+func slicesEqual(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBloomierFilterWidths(t *testing.T) {
+	for _, tc := range []struct {
+		valueBits, fpBits uint32
+	}{
+		{1, 0},
+		{1, 1},
+		{3, 5},
+		{7, 13},
+		{16, 16},
+		{MaxBloomierValueBits, 0},
+		{MaxBloomierValueBits, maxBloomierFingerprintBits},
+	} {
+		t.Run(fmt.Sprintf("value_%d/fp_%d", tc.valueBits, tc.fpBits), func(t *testing.T) {
+			keys := make([]string, 3000)
+			values := make([]uint64, len(keys))
+			mask := uint64(1)<<tc.valueBits - 1
+			for i := range keys {
+				keys[i] = fmt.Sprintf("width-%d", i)
+				values[i] = mix64(uint64(i)) & mask
+			}
+			values[0], values[1] = 0, mask
+
+			f, err := BuildBloomierStrings(keys, values, tc.valueBits, math.Ldexp(1, -int(tc.fpBits)))
+			if err != nil {
+				t.Fatalf("BuildBloomierStrings failed: %v", err)
+			}
+			if f.FingerprintBits() != tc.fpBits {
+				t.Fatalf("FingerprintBits() = %d, want %d", f.FingerprintBits(), tc.fpBits)
+			}
+			for i, key := range keys {
+				if v, ok := f.GetString(key); !ok || v != values[i] {
+					t.Fatalf("GetString(%q) = (%d, %v), want (%d, true)", key, v, ok, values[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBloomierFingerprintBits(t *testing.T) {
+	for _, tc := range []struct {
+		fpRate float64
+		want   uint32
+	}{
+		{0, 14},
+		{-1, 14},
+		{1, 0},
+		{2, 0},
+		{0.5, 1},
+		{0.3, 2},
+		{0.01, 7},
+		{1.0 / 256, 8},
+		{1e-20, maxBloomierFingerprintBits},
+	} {
+		if got := bloomierFingerprintBits(tc.fpRate); got != tc.want {
+			t.Errorf("bloomierFingerprintBits(%g) = %d, want %d", tc.fpRate, got, tc.want)
+		}
+	}
+}
+
+func TestBloomierFilterDuplicates(t *testing.T) {
+	keys := []string{"a", "b", "c", "a", "b"}
+	f, err := BuildBloomierStrings(keys, []uint64{1, 2, 3, 1, 2}, 2, 0.01)
+	if err != nil {
+		t.Fatalf("BuildBloomierStrings failed: %v", err)
+	}
+	if f.Count() != 3 {
+		t.Errorf("Count() = %d, want 3 distinct keys", f.Count())
+	}
+	for i, key := range keys[:3] {
+		if v, ok := f.GetString(key); !ok || v != uint64(i+1) {
+			t.Errorf("GetString(%q) = (%d, %v)", key, v, ok)
+		}
+	}
+
+	// A key cannot map to two values
+	if _, err := BuildBloomierStrings(keys, []uint64{1, 2, 3, 0, 2}, 2, 0.01); !errors.Is(err, ErrInvalidValues) {
+		t.Errorf("conflicting values: expected ErrInvalidValues, got %v", err)
+	}
+}
+
+func TestBloomierFilterInvalidValues(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b")}
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"too few values", func() error { _, err := BuildBloomier(keys, []uint64{1}, 4, 0.01); return err }()},
+		{"too few string values", func() error { _, err := BuildBloomierStrings([]string{"a"}, nil, 4, 0.01); return err }()},
+		{"zero value bits", func() error { _, err := BuildBloomier(keys, []uint64{0, 0}, 0, 0.01); return err }()},
+		{"too many value bits", func() error {
+			_, err := BuildBloomier(keys, []uint64{0, 0}, MaxBloomierValueBits+1, 0.01)
+			return err
+		}()},
+		{"value too wide", func() error { _, err := BuildBloomier(keys, []uint64{3, 16}, 4, 0.01); return err }()},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, ErrInvalidValues) {
+			t.Errorf("%s: expected ErrInvalidValues, got %v", tt.name, tt.err)
+		}
+	}
+
+	if _, err := buildBloomier([]uint64{1, 2}, []uint64{0, 0}, 4, 0.01, 0); !errors.Is(err, ErrConstructionFailed) {
+		t.Errorf("no attempts: expected ErrConstructionFailed, got %v", err)
+	}
+}
+
+func TestBloomierFilterEmpty(t *testing.T) {
+	var empty iter.Seq2[[]byte, uint64] = func(func([]byte, uint64) bool) {}
+	f, err := BuildBloomierSeq(empty, 4, 0.01)
+	if err != nil {
+		t.Fatalf("BuildBloomierSeq failed: %v", err)
+	}
+	if f.Count() != 0 || f.BitsPerItem() != 0 {
+		t.Errorf("empty filter: Count() = %d, BitsPerItem() = %f", f.Count(), f.BitsPerItem())
+	}
+}
+
+func TestBloomierFilterSerialize(t *testing.T) {
+	keys, shards := shardKeys(5000, 16)
+	original, err := BuildBloomierStrings(keys, shards, 5, 0.001)
+	if err != nil {
+		t.Fatalf("BuildBloomierStrings failed: %v", err)
+	}
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if data[0] != serializeBloomierVersion || len(data) != bloomierHeaderSize+len(original.words)*8 {
+		t.Errorf("got version %d and %d bytes", data[0], len(data))
+	}
+
+	restored, err := UnmarshalBloomierBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBloomierBinary failed: %v", err)
+	}
+	if restored.ValueBits() != 5 || restored.FingerprintBits() != 10 || restored.Count() != 5000 || restored.seed != original.seed {
+		t.Errorf("params mismatch: got (%d, %d, %d, %#x)", restored.ValueBits(), restored.FingerprintBits(), restored.Count(), restored.seed)
+	}
+	for i, key := range keys {
+		if v, ok := restored.GetString(key); !ok || v != shards[i] {
+			t.Fatalf("GetString(%q) = (%d, %v), want (%d, true)", key, v, ok, shards[i])
+		}
+	}
+
+	again, err := restored.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("second roundtrip produced different bytes")
+	}
+
+	// The formats of StaticFilter and BloomierFilter are not interchangeable
+	staticData, _ := mustBuildStatic(t).MarshalBinary()
+	if _, err := UnmarshalBloomierBinary(staticData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBloomierBinary of a StaticFilter: expected ErrUnsupportedVersion, got %v", err)
+	}
+	if _, err := UnmarshalStaticBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalStaticBinary of a BloomierFilter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestBloomierFilterSerializeInvalid(t *testing.T) {
+	keys, shards := shardKeys(100, 16)
+	f, err := BuildBloomierStrings(keys, shards, 4, 0.01)
+	if err != nil {
+		t.Fatalf("BuildBloomierStrings failed: %v", err)
+	}
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:bloomierHeaderSize-1]},
+		{"truncated slots", data[:len(data)-1]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"fingerprint too large", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], maxBloomierFingerprintBits+1) })},
+		{"zero value bits", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[5:9], 0) })},
+		{"value too large", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[5:9], MaxBloomierValueBits+1) })},
+		{"wider slots", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[5:9], 20) })},
+		{"zero segment length", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[17:21], 0) })},
+		{"segment length not power of 2", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[17:21], 24) })},
+		{"zero segments", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[21:25], 0) })},
+		{"too many segments", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[21:25], math.MaxUint32) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalBloomierBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalBloomierBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestBloomierFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	keys, shards := shardKeys(500_000, 16)
+	for _, fpRate := range []float64{0.1, 0.01, 0.001} {
+		t.Run(fmt.Sprintf("fpRate_%g", fpRate), func(t *testing.T) {
+			f, err := BuildBloomierStrings(keys, shards, 4, fpRate)
+			if err != nil {
+				t.Fatalf("BuildBloomierStrings failed: %v", err)
+			}
+			m := MeasureFalsePositiveRate(f, 2_000_000)
+			if est := f.EstimatedFalsePositiveRate(); !m.Contains(est) || est > fpRate {
+				t.Errorf("measured %g [%g, %g], estimated %g", m.Rate, m.Lower, m.Upper, est)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package gloom
+
+import (
+	"context"
+	"iter"
+	"runtime"
+	"sync"
+)
+
+const (
+	// buildChunkSize is the number of keys the producer hands to a hashing
+	// goroutine at a time.
+	buildChunkSize = 1024
+
+	// buildBufferSize is the number of hashes a hashing goroutine collects for
+	// one block range before handing them to the range's owner.
+	buildBufferSize = 1024
+)
+
+// buildBufferPool recycles the hash buffers passed from hashing goroutines to
+// block owners.
+var buildBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]uint64, 0, buildBufferSize)
+		return &buf
+	},
+}
+
+// BuildParallel builds a Filter sized for expectedItems and fpRate from every
+// key in source, using workers goroutines to hash keys and workers goroutines
+// to set bits. If workers is 0 or negative, GOMAXPROCS is used. workers is
+// capped at GOMAXPROCS and at the number of blocks, since more goroutines
+// could not run at once or would own no blocks.
+//
+// The blocks are split into workers contiguous ranges, and each range is
+// owned by exactly one goroutine, so no two goroutines ever write the same
+// block and no atomics are needed. The result is an ordinary Filter,
+// bit-for-bit identical to adding the same keys sequentially.
+//
+// source is consumed from the calling goroutine, but keys are hashed
+// asynchronously, so the source must not reuse the memory of a yielded key
+// for later keys. If ctx is cancelled, BuildParallel stops consuming source,
+// waits for its goroutines to exit, and returns ctx.Err().
+func BuildParallel(ctx context.Context, source iter.Seq[[]byte], expectedItems uint64, fpRate float64, workers int) (*Filter, error) {
+	f := New(expectedItems, fpRate)
+	workers = buildWorkers(workers, f.numBlocks)
+	numOwners := uint64(workers)
+	blocksPerOwner := (f.numBlocks + numOwners - 1) / numOwners
+
+	// Owners set the bits of their own block range
+	owned := make([]chan *[]uint64, numOwners)
+	setBits := make([]uint64, numOwners)
+	var owners sync.WaitGroup
+	for o := range owned {
+		owned[o] = make(chan *[]uint64, 4)
+		owners.Add(1)
+		go func(o int) {
+			defer owners.Done()
+			var flipped uint64
+			for buf := range owned[o] {
+				for _, packed := range *buf {
+					flipped += f.setProbeBits(packed>>32, uint32(packed))
+				}
+				*buf = (*buf)[:0]
+				buildBufferPool.Put(buf)
+			}
+			setBits[o] = flipped
+		}(o)
+	}
+
+	// Hashers route each key's hash to the owner of its block
+	chunks := make(chan [][]byte, workers)
+	var hashers sync.WaitGroup
+	for range workers {
+		hashers.Add(1)
+		go func() {
+			defer hashers.Done()
+			pending := make([]*[]uint64, numOwners)
+			for chunk := range chunks {
+				for _, key := range chunk {
+					blockIdx, intraHash := hashData(key, f.numBlocks)
+					o := blockIdx / blocksPerOwner
+					if pending[o] == nil {
+						pending[o] = buildBufferPool.Get().(*[]uint64)
+					}
+					// blockIdx < 2^32 since it is derived from the upper 32 hash bits
+					*pending[o] = append(*pending[o], blockIdx<<32|uint64(intraHash))
+					if len(*pending[o]) == buildBufferSize {
+						owned[o] <- pending[o]
+						pending[o] = nil
+					}
+				}
+			}
+			for o, buf := range pending {
+				if buf != nil {
+					owned[o] <- buf
+				}
+			}
+		}()
+	}
+
+	// Produce chunks of keys from the source on the calling goroutine,
+	// checking for cancellation before taking each key
+	var count uint64
+	done := ctx.Done()
+	chunk := make([][]byte, 0, buildChunkSize)
+produce:
+	for key := range source {
+		select {
+		case <-done:
+			break produce
+		default:
+		}
+		chunk = append(chunk, key)
+		if len(chunk) < buildChunkSize {
+			continue
+		}
+		// Hashers always drain chunks, so the send cannot block forever
+		chunks <- chunk
+		count += uint64(len(chunk))
+		chunk = make([][]byte, 0, buildChunkSize)
+	}
+	err := ctx.Err()
+	if err == nil && len(chunk) > 0 {
+		chunks <- chunk
+		count += uint64(len(chunk))
+	}
+
+	close(chunks)
+	hashers.Wait()
+	for o := range owned {
+		close(owned[o])
+	}
+	owners.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	f.count = count
+	for _, flipped := range setBits {
+		f.setBits += flipped
+	}
+	return f, nil
+}
+
+// buildWorkers returns the number of hashing and owning goroutines
+// BuildParallel uses for a filter of numBlocks blocks: workers, or
+// GOMAXPROCS if workers is 0 or negative, capped at GOMAXPROCS and numBlocks.
+// Each hasher buffers hashes for every owner, so memory grows with the
+// square of the count.
+func buildWorkers(workers int, numBlocks uint64) int {
+	procs := runtime.GOMAXPROCS(0)
+	if workers <= 0 || workers > procs {
+		workers = procs
+	}
+	if uint64(workers) > numBlocks {
+		workers = int(numBlocks)
+	}
+	return workers
+}
@@ -0,0 +1,140 @@
+package gloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// keySeq yields n distinct keys.
+func keySeq(n int) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for i := range n {
+			if !yield(fmt.Appendf(nil, "build-%d", i)) {
+				return
+			}
+		}
+	}
+}
+
+func TestBuildParallelMatchesSequential(t *testing.T) {
+	testCases := []struct {
+		name    string
+		items   int
+		fpRate  float64
+		workers int
+	}{
+		{"empty", 0, 0.01, 4},
+		{"partial chunk", buildChunkSize / 2, 0.01, 4},
+		{"exact chunk", buildChunkSize, 0.01, 4},
+		{"single worker", 50000, 0.01, 1},
+		{"default workers", 50000, 0.01, 0},
+		{"many workers", 50000, 0.001, 16},
+		{"more workers than blocks", 10, 0.1, 64},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := BuildParallel(context.Background(), keySeq(tc.items), uint64(tc.items), tc.fpRate, tc.workers)
+			if err != nil {
+				t.Fatalf("BuildParallel failed: %v", err)
+			}
+
+			want := New(uint64(tc.items), tc.fpRate)
+			for key := range keySeq(tc.items) {
+				want.Add(key)
+			}
+
+			if !slices.Equal(got.blocks, want.blocks) {
+				t.Error("blocks differ from sequentially built filter")
+			}
+			if got.Count() != want.Count() {
+				t.Errorf("Count() = %d, want %d", got.Count(), want.Count())
+			}
+			if got.EstimatedFillRatio() != got.ExactFillRatio() {
+				t.Errorf("EstimatedFillRatio() = %f, ExactFillRatio() = %f", got.EstimatedFillRatio(), got.ExactFillRatio())
+			}
+			for key := range keySeq(tc.items) {
+				if !got.Test(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildWorkers(t *testing.T) {
+	const procs = 4
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+	testCases := []struct {
+		name      string
+		workers   int
+		numBlocks uint64
+		want      int
+	}{
+		{"default", 0, 1 << 20, procs},
+		{"negative", -1, 1 << 20, procs},
+		{"single", 1, 1 << 20, 1},
+		{"above GOMAXPROCS", procs + 1, 1 << 20, procs},
+		{"above blocks", procs, 1, 1},
+		{"default above blocks", 0, 1, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := buildWorkers(tc.workers, tc.numBlocks); got != tc.want {
+				t.Errorf("buildWorkers(%d, %d) = %d, want %d", tc.workers, tc.numBlocks, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildParallelCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f, err := BuildParallel(ctx, keySeq(100), 100, 0.01, 4)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if f != nil {
+		t.Error("expected nil filter on cancellation")
+	}
+}
+
+func TestBuildParallelCancelledMidway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel once a few chunks have been produced, and record whether the
+	// source keeps being consumed afterwards
+	const cancelAt = 10 * buildChunkSize
+	var yielded int
+	source := func(yield func([]byte) bool) {
+		for i := 0; ; i++ {
+			if i == cancelAt {
+				cancel()
+			}
+			yielded++
+			if !yield(fmt.Appendf(nil, "cancel-%d", i)) {
+				return
+			}
+		}
+	}
+
+	f, err := BuildParallel(ctx, source, 1_000_000, 0.01, 4)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if f != nil {
+		t.Error("expected nil filter on cancellation")
+	}
+	// The key yielded after cancel is the last one taken
+	if yielded > cancelAt+1 {
+		t.Errorf("source consumed %d keys after cancellation", yielded-cancelAt)
+	}
+}
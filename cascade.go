@@ -0,0 +1,233 @@
+package gloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+const (
+	// cascadeLevelFPRate is the false positive rate of every level after the
+	// first. Each level only has to halve the keys the next one stores,
+	// which Larisch et al., "CRLite: A Scalable System for Pushing All TLS
+	// Revocations to All Browsers" (2017), found close to optimal.
+	cascadeLevelFPRate = 0.5
+
+	// serializeCascadeVersion is the serialization format version of a
+	// Cascade.
+	serializeCascadeVersion byte = 9
+
+	// cascadeHeaderSize is the size of the Cascade serialization header in
+	// bytes: Version (1) + NumLevels (4) + Count (8) = 13 bytes.
+	cascadeHeaderSize = 13
+)
+
+// Cascade is an immutable bloom filter cascade: an exact membership test for
+// a set R within a known universe U, such as the revoked certificates among
+// all issued ones. It has no false positives or false negatives for keys in
+// U, and gives an arbitrary answer for keys outside it.
+//
+// Level 0 is a Filter holding R. Level 1 holds the keys of U\R that are
+// false positives of level 0, level 2 the keys of R that are false positives
+// of level 1, and so on until a level has no false positives left in U. Each
+// level hashes keys differently, so its false positives are independent of
+// the others'. A key's membership is decided by the first level it is
+// absent from: an odd level means it is in R, an even one that it is not.
+//
+// The levels shrink geometrically, so the cascade needs little more memory
+// than level 0. A Cascade is safe for concurrent use, since it is never
+// modified.
+type Cascade struct {
+	levels []*Filter
+	count  uint64 // Number of distinct keys in R
+}
+
+// BuildCascade builds a Cascade for the keys in included, out of a universe
+// of keys. Keys of included need not also be in universe. Level 0 is sized
+// for a false positive rate of fpRate, as in New, and each later level for
+// 1/2.
+//
+// Keys are identified by their 64-bit hashes, so a key of universe whose
+// hash collides with a key of included is treated as included.
+func BuildCascade(included, universe [][]byte, fpRate float64) *Cascade {
+	in := make([]uint64, len(included))
+	for i, key := range included {
+		in[i] = hashRaw(key)
+	}
+	all := make([]uint64, len(universe))
+	for i, key := range universe {
+		all[i] = hashRaw(key)
+	}
+	return buildCascade(in, all, fpRate)
+}
+
+// BuildCascadeStrings builds a Cascade from string keys without converting
+// them to byte slices. See BuildCascade.
+func BuildCascadeStrings(included, universe []string, fpRate float64) *Cascade {
+	in := make([]uint64, len(included))
+	for i, key := range included {
+		in[i] = hashRawString(key)
+	}
+	all := make([]uint64, len(universe))
+	for i, key := range universe {
+		all[i] = hashRawString(key)
+	}
+	return buildCascade(in, all, fpRate)
+}
+
+// cascadeHash returns the hash of a key with raw hash h at a level. Distinct
+// raw hashes stay distinct at every level, so construction always ends.
+func cascadeHash(h uint64, level int) uint64 {
+	return mix64(h + uint64(level)*0x9e3779b97f4a7c15)
+}
+
+// buildCascade builds a Cascade from the raw hashes of the included keys and
+// of the universe, which it may reorder.
+func buildCascade(in, all []uint64, fpRate float64) *Cascade {
+	slices.Sort(in)
+	in = slices.Compact(in)
+	slices.Sort(all)
+	out := slices.DeleteFunc(slices.Compact(all), func(h uint64) bool {
+		_, found := slices.BinarySearch(in, h)
+		return found
+	})
+
+	c := &Cascade{count: uint64(len(in))}
+	for level := 0; len(in) > 0; level++ {
+		f := New(uint64(len(in)), fpRate)
+		for _, h := range in {
+			f.addWithHash(hashSplit(cascadeHash(h, level), f.numBlocks))
+		}
+		c.levels = append(c.levels, f)
+
+		// The next level holds the false positives among the keys this one
+		// must reject, and must in turn reject the keys this one holds
+		var fps []uint64
+		for _, h := range out {
+			if f.testWithHash(hashSplit(cascadeHash(h, level), f.numBlocks)) {
+				fps = append(fps, h)
+			}
+		}
+		in, out = fps, in
+		fpRate = cascadeLevelFPRate
+	}
+	return c
+}
+
+// Test reports whether data is in the included set. The answer is exact for
+// keys of the universe the cascade was built from.
+func (c *Cascade) Test(data []byte) bool {
+	return c.testWithHash(hashRaw(data))
+}
+
+// TestString reports whether s is in the included set without allocating.
+// See Test.
+func (c *Cascade) TestString(s string) bool {
+	return c.testWithHash(hashRawString(s))
+}
+
+// testWithHash checks a key using its pre-computed raw hash.
+func (c *Cascade) testWithHash(h uint64) bool {
+	for level, f := range c.levels {
+		if !f.testWithHash(hashSplit(cascadeHash(h, level), f.numBlocks)) {
+			return level%2 == 1
+		}
+	}
+	// Only keys the last level holds get this far
+	return len(c.levels)%2 == 1
+}
+
+// Levels returns the number of levels in the cascade.
+func (c *Cascade) Levels() int {
+	return len(c.levels)
+}
+
+// Cap returns the total capacity of the cascade's levels in bits.
+func (c *Cascade) Cap() uint64 {
+	var bits uint64
+	for _, f := range c.levels {
+		bits += f.Cap()
+	}
+	return bits
+}
+
+// Count returns the number of distinct keys in the included set.
+func (c *Cascade) Count() uint64 {
+	return c.count
+}
+
+// BitsPerItem returns the cascade's memory in bits per included key.
+func (c *Cascade) BitsPerItem() float64 {
+	if c.count == 0 {
+		return 0
+	}
+	return float64(c.Cap()) / float64(c.count)
+}
+
+// MarshalBinary serializes the cascade to a byte slice.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 9
+//   - NumLevels (4 bytes): number of levels (little-endian uint32)
+//   - Count (8 bytes): number of included keys (little-endian uint64)
+//   - Levels (NumLevels times): the level's size in bytes (little-endian
+//     uint64), followed by the level as written by Filter.MarshalBinary
+func (c *Cascade) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, cascadeHeaderSize, cascadeHeaderSize+c.Cap()/8+uint64(len(c.levels))*(8+headerSize))
+
+	buf[0] = serializeCascadeVersion
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(len(c.levels)))
+	binary.LittleEndian.PutUint64(buf[5:13], c.count)
+
+	for _, f := range c.levels {
+		level, _ := f.MarshalBinary() // Never fails
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(level)))
+		buf = append(buf, level...)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalCascadeBinary deserializes a cascade from a byte slice written by
+// Cascade.MarshalBinary.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalCascadeBinary(data []byte) (*Cascade, error) {
+	if len(data) < cascadeHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), cascadeHeaderSize)
+	}
+	if version := data[0]; version != serializeCascadeVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeCascadeVersion)
+	}
+
+	numLevels := uint64(binary.LittleEndian.Uint32(data[1:5]))
+	count := binary.LittleEndian.Uint64(data[5:13])
+	rest := data[cascadeHeaderSize:]
+
+	// Each level takes at least its length field and a Filter header
+	if numLevels > uint64(len(rest))/(8+headerSize) {
+		return nil, fmt.Errorf("%w: %d levels cannot fit in %d bytes", ErrInvalidData, numLevels, len(rest))
+	}
+
+	c := &Cascade{levels: make([]*Filter, numLevels), count: count}
+	for i := range c.levels {
+		if len(rest) < 8 {
+			return nil, fmt.Errorf("%w: level %d is truncated", ErrInvalidData, i)
+		}
+		n := binary.LittleEndian.Uint64(rest[:8])
+		rest = rest[8:]
+		if n > uint64(len(rest)) {
+			return nil, fmt.Errorf("%w: level %d is truncated (got %d bytes, expected %d)", ErrInvalidData, i, len(rest), n)
+		}
+		f, err := UnmarshalBinary(rest[:n])
+		if err != nil {
+			return nil, fmt.Errorf("level %d: %w", i, err)
+		}
+		c.levels[i] = f
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the last level", ErrInvalidData, len(rest))
+	}
+	return c, nil
+}
@@ -0,0 +1,210 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// cascadeKeys returns a universe of n keys and the included keys, every
+// step-th one.
+func cascadeKeys(n, step int) (included, universe []string) {
+	universe = make([]string, n)
+	for i := range universe {
+		universe[i] = fmt.Sprintf("cert-%d", i)
+		if i%step == 0 {
+			included = append(included, universe[i])
+		}
+	}
+	return included, universe
+}
+
+// checkCascade fails the test if c gives a wrong answer for a key of the
+// universe.
+func checkCascade(t *testing.T, c *Cascade, universe []string, step int) {
+	t.Helper()
+	for i, key := range universe {
+		if got := c.TestString(key); got != (i%step == 0) {
+			t.Fatalf("TestString(%q) = %v", key, got)
+		}
+	}
+}
+
+func TestCascadeBasic(t *testing.T) {
+	included, universe := cascadeKeys(100_000, 10)
+	c := BuildCascadeStrings(included, universe, 0.01)
+	checkCascade(t, c, universe, 10)
+	for _, key := range included[:100] {
+		if !c.Test([]byte(key)) {
+			t.Fatalf("Test(%q) = false", key)
+		}
+	}
+
+	if c.Count() != 10_000 || c.Levels() < 3 {
+		t.Errorf("got Count() = %d, Levels() = %d", c.Count(), c.Levels())
+	}
+	if c.BitsPerItem() != float64(c.Cap())/10_000 {
+		t.Errorf("Cap() = %d, BitsPerItem() = %f", c.Cap(), c.BitsPerItem())
+	}
+
+	// The levels after the first add little to its memory
+	level0 := New(10_000, 0.01).Cap()
+	if c.Cap() > level0*3/2 {
+		t.Errorf("Cap() = %d for a first level of %d bits", c.Cap(), level0)
+	}
+
+	// Byte slice keys build the same cascade
+	toBytes := func(strs []string) [][]byte {
+		keys := make([][]byte, len(strs))
+		for i, s := range strs {
+			keys[i] = []byte(s)
+		}
+		return keys
+	}
+	b := BuildCascade(toBytes(included), toBytes(universe), 0.01)
+	if b.Levels() != c.Levels() || b.Cap() != c.Cap() {
+		t.Errorf("BuildCascade built %d levels of %d bits, BuildCascadeStrings %d of %d", b.Levels(), b.Cap(), c.Levels(), c.Cap())
+	}
+}
+
+func TestCascadeEdgeCases(t *testing.T) {
+	_, universe := cascadeKeys(1000, 10)
+
+	// Nothing included
+	c := BuildCascadeStrings(nil, universe, 0.01)
+	if c.Levels() != 0 || c.Count() != 0 || c.BitsPerItem() != 0 {
+		t.Errorf("empty cascade: Levels() = %d, Count() = %d", c.Levels(), c.Count())
+	}
+	for _, key := range universe {
+		if c.TestString(key) {
+			t.Fatalf("TestString(%q) = true", key)
+		}
+	}
+
+	// Everything included, repeated in both sets, and included keys outside
+	// the universe
+	included := append(append([]string{}, universe...), universe[:10]...)
+	included = append(included, "outside")
+	c = BuildCascadeStrings(included, append(universe, universe...), 0.01)
+	if c.Levels() != 1 || c.Count() != 1001 {
+		t.Errorf("full cascade: Levels() = %d, Count() = %d", c.Levels(), c.Count())
+	}
+	checkCascade(t, c, universe, 1)
+	if !c.TestString("outside") {
+		t.Error("included key outside the universe not found")
+	}
+
+	// A first level with a high false positive rate needs many levels
+	included, universe = cascadeKeys(1000, 2)
+	c = BuildCascadeStrings(included, universe, 0.9)
+	if c.Levels() < 4 {
+		t.Errorf("Levels() = %d", c.Levels())
+	}
+	checkCascade(t, c, universe, 2)
+}
+
+func TestCascadeSerialize(t *testing.T) {
+	included, universe := cascadeKeys(20_000, 7)
+	original := BuildCascadeStrings(included, universe, 0.01)
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if data[0] != serializeCascadeVersion {
+		t.Errorf("got version %d", data[0])
+	}
+
+	restored, err := UnmarshalCascadeBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalCascadeBinary failed: %v", err)
+	}
+	if restored.Levels() != original.Levels() || restored.Count() != original.Count() || restored.Cap() != original.Cap() {
+		t.Errorf("params mismatch: got (%d, %d, %d)", restored.Levels(), restored.Count(), restored.Cap())
+	}
+	checkCascade(t, restored, universe, 7)
+
+	again, err := restored.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("second roundtrip produced different bytes")
+	}
+
+	// The formats of Filter and Cascade are not interchangeable
+	if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBinary of a Cascade: expected ErrUnsupportedVersion, got %v", err)
+	}
+	filterData, _ := NewWithParams(20, 7).MarshalBinary()
+	if _, err := UnmarshalCascadeBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalCascadeBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestCascadeSerializeInvalid(t *testing.T) {
+	included, universe := cascadeKeys(1000, 2)
+	data, err := BuildCascadeStrings(included, universe, 0.9).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	level0 := binary.LittleEndian.Uint64(data[cascadeHeaderSize:])
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:cascadeHeaderSize-1]},
+		{"truncated level", data[:len(data)-1]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"too many levels", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], math.MaxUint32) })},
+		{"level too long", corrupt(func(b []byte) {
+			binary.LittleEndian.PutUint64(b[cascadeHeaderSize:], math.MaxUint64)
+		})},
+		{"next level length truncated", func() []byte {
+			// A whole first level and half of the second's length
+			bad := bytes.Clone(data[:cascadeHeaderSize+8+level0+4])
+			binary.LittleEndian.PutUint32(bad[1:5], 2)
+			return bad
+		}()},
+		{"corrupted level", corrupt(func(b []byte) {
+			binary.LittleEndian.PutUint64(b[cascadeHeaderSize+8+5:], 0) // NumBlocks
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalCascadeBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalCascadeBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	bad = corrupt(func(b []byte) { b[cascadeHeaderSize+8] = 99 })
+	if _, err := UnmarshalCascadeBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("level with a bad version: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestCascadeLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large cascade in short mode")
+	}
+
+	included, universe := cascadeKeys(1_000_000, 100)
+	c := BuildCascadeStrings(included, universe, 0.01)
+	checkCascade(t, c, universe, 100)
+	t.Logf("%d levels, %.1f bits per included key", c.Levels(), c.BitsPerItem())
+}
@@ -0,0 +1,54 @@
+package gloom
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// maxCounterStripes bounds the number of stripes in a stripedCounter so that
+// summing remains cheap even on very large machines.
+const maxCounterStripes = 64
+
+// paddedCounter is an atomic counter padded to occupy a full cache line,
+// preventing false sharing between neighbouring stripes.
+type paddedCounter struct {
+	n atomic.Uint64
+	_ [cacheLineSize - 8]byte
+}
+
+// stripedCounter is a contention-free counter for concurrent writers.
+//
+// Instead of a single shared atomic.Uint64, whose cache line would bounce
+// between cores on every increment, the count is spread across several
+// cache-line padded stripes. Writers pick a stripe from bits of the key's
+// hash, so parallel Adds of distinct keys almost never touch the same line.
+// Load sums all stripes and is therefore eventually consistent: it reflects
+// every Add that happened-before the call, and some subset of concurrent ones.
+type stripedCounter struct {
+	stripes []paddedCounter
+	mask    uint64 // len(stripes) - 1, for fast modulo
+}
+
+// newStripedCounter creates a counter with a stripe count tuned to the
+// current GOMAXPROCS value.
+func newStripedCounter() stripedCounter {
+	n := min(nextPowerOf2(uint64(runtime.GOMAXPROCS(0))), maxCounterStripes)
+	return stripedCounter{
+		stripes: make([]paddedCounter, n),
+		mask:    n - 1,
+	}
+}
+
+// add increments the stripe selected by h by delta.
+func (c *stripedCounter) add(h, delta uint64) {
+	c.stripes[h&c.mask].n.Add(delta)
+}
+
+// load returns the sum of all stripes.
+func (c *stripedCounter) load() uint64 {
+	var total uint64
+	for i := range c.stripes {
+		total += c.stripes[i].n.Load()
+	}
+	return total
+}
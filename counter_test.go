@@ -0,0 +1,51 @@
+package gloom
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestStripedCounterLayout(t *testing.T) {
+	// Each stripe must occupy its own cache line to avoid false sharing
+	if size := unsafe.Sizeof(paddedCounter{}); size != cacheLineSize {
+		t.Errorf("paddedCounter size = %d, want %d", size, cacheLineSize)
+	}
+
+	c := newStripedCounter()
+	n := uint64(len(c.stripes))
+	if n == 0 || n > maxCounterStripes {
+		t.Errorf("stripe count %d out of range [1, %d]", n, maxCounterStripes)
+	}
+	if n&(n-1) != 0 {
+		t.Errorf("stripe count %d is not a power of 2", n)
+	}
+	if c.mask != n-1 {
+		t.Errorf("mask = %d, want %d", c.mask, n-1)
+	}
+}
+
+func TestStripedCounterConcurrent(t *testing.T) {
+	c := newStripedCounter()
+
+	const (
+		numGoroutines  = 16
+		addsPerRoutine = 10000
+	)
+
+	var wg sync.WaitGroup
+	for g := range numGoroutines {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			for i := range uint64(addsPerRoutine) {
+				c.add(id*addsPerRoutine+i, 1)
+			}
+		}(uint64(g))
+	}
+	wg.Wait()
+
+	if got := c.load(); got != numGoroutines*addsPerRoutine {
+		t.Errorf("load() = %d, want %d", got, numGoroutines*addsPerRoutine)
+	}
+}
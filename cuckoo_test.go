@@ -0,0 +1,528 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestCuckooFilterBasic(t *testing.T) {
+	f := NewCuckoo(10000, 0.001)
+
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		var ok bool
+		if i%2 == 0 {
+			ok = f.Add([]byte(key))
+		} else {
+			ok = f.AddString(key)
+		}
+		if !ok {
+			t.Fatalf("Add(%q) failed at load %f", key, f.LoadFactor())
+		}
+	}
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if !f.Test([]byte(key)) || !f.TestString(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	if f.Count() != 10000 {
+		t.Errorf("Count() = %d, want 10000", f.Count())
+	}
+	if want := f.NumBuckets() * CuckooBucketSlots * uint64(f.FingerprintBits()); f.Cap() != want {
+		t.Errorf("Cap() = %d, want %d", f.Cap(), want)
+	}
+	if want := 10000 / float64(f.NumBuckets()*CuckooBucketSlots); f.LoadFactor() != want {
+		t.Errorf("LoadFactor() = %f, want %f", f.LoadFactor(), want)
+	}
+	if fp := f.EstimatedFalsePositiveRate(); fp <= 0 || fp > 0.001 {
+		t.Errorf("EstimatedFalsePositiveRate() = %g, want at most 0.001", fp)
+	}
+	if addr := uintptr(unsafePointer(&f.words[0])); addr%cacheLineSize != 0 {
+		t.Errorf("buckets not cache-line aligned: %x", addr)
+	}
+}
+
+func TestCuckooFilterInvalidParams(t *testing.T) {
+	for _, bits := range []uint32{0, MinFingerprintBits - 1, MaxFingerprintBits + 1} {
+		f := NewCuckooWithParams(0, bits)
+		if f.NumBuckets() != 1 || f.FingerprintBits() != 16 {
+			t.Errorf("NewCuckooWithParams(0, %d) = (%d, %d), want (1, 16)", bits, f.NumBuckets(), f.FingerprintBits())
+		}
+	}
+}
+
+func TestCuckooZeroLanes(t *testing.T) {
+	for bits := uint32(MinFingerprintBits); bits <= MaxFingerprintBits; bits++ {
+		f := NewCuckooWithParams(1, bits)
+		mask := uint64(1)<<bits - 1
+
+		// Every lane value is detected as zero exactly when it is zero,
+		// whatever its neighbors hold
+		for _, v := range []uint64{0, 1, mask >> 1, mask>>1 + 1, mask} {
+			for _, other := range []uint64{0, 1, mask} {
+				for lane := range f.lanes {
+					x := f.laneLow * other
+					x = x&^(mask<<(lane*bits)) | v<<(lane*bits)
+					got := f.zeroLanes(x)>>(lane*bits+bits-1)&1 == 1
+					if got != (v == 0) {
+						t.Fatalf("bits=%d lane=%d v=%#x other=%#x: zero = %v", bits, lane, v, other, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCuckooFingerprint(t *testing.T) {
+	for _, bits := range []uint32{8, 12, 21, 32} {
+		f := NewCuckooWithParams(1000, bits)
+		for _, h := range []uint64{0, math.MaxUint32, math.MaxUint64, 0x123456789abcdef} {
+			i, fp := f.fingerprint(h)
+			if i >= f.numBuckets || fp == 0 || fp>>bits != 0 {
+				t.Errorf("bits=%d h=%#x: bucket %d, fingerprint %#x", bits, h, i, fp)
+			}
+
+			// The alternate of the alternate is the original bucket
+			alt := f.altBucket(i, fp)
+			if alt >= f.numBuckets || f.altBucket(alt, fp) != i {
+				t.Errorf("bits=%d h=%#x: buckets %d and %d are not each other's alternates", bits, h, i, alt)
+			}
+		}
+	}
+}
+
+func TestCuckooFilterRemove(t *testing.T) {
+	f := NewCuckoo(10000, 0.001)
+	for i := range 10000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+
+	for i := 0; i < 10000; i += 2 {
+		key := fmt.Sprintf("item-%d", i)
+		var ok bool
+		if i%4 == 0 {
+			ok = f.Remove([]byte(key))
+		} else {
+			ok = f.RemoveString(key)
+		}
+		if !ok {
+			t.Fatalf("Remove(%q) = false", key)
+		}
+	}
+	if f.Count() != 5000 {
+		t.Errorf("Count() = %d after removals, want 5000", f.Count())
+	}
+
+	var present int
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if i%2 == 1 && !f.TestString(key) {
+			t.Fatalf("false negative for %q after removing others", key)
+		}
+		if i%2 == 0 && f.TestString(key) {
+			present++
+		}
+	}
+	if present > 10 {
+		t.Errorf("%d of 5000 removed keys still test positive", present)
+	}
+
+	if f.RemoveString("never-added") {
+		t.Error("Remove of an absent key returned true")
+	}
+
+	// Removing everything leaves an empty filter
+	for i := 1; i < 10000; i += 2 {
+		f.RemoveString(fmt.Sprintf("item-%d", i))
+	}
+	if f.Count() != 0 {
+		t.Errorf("Count() = %d after removing everything", f.Count())
+	}
+	for _, w := range f.words {
+		if w != 0 {
+			t.Fatal("buckets not empty after removing everything")
+		}
+	}
+}
+
+func TestCuckooFilterDuplicates(t *testing.T) {
+	f := NewCuckooWithParams(100, 16)
+	for range 3 {
+		f.AddString("dup")
+	}
+	if f.Count() != 3 {
+		t.Errorf("Count() = %d, want 3", f.Count())
+	}
+
+	// Each removal takes one copy
+	for i := range 3 {
+		if !f.TestString("dup") {
+			t.Fatalf("false negative after %d removals", i)
+		}
+		if !f.RemoveString("dup") {
+			t.Fatalf("removal %d failed", i+1)
+		}
+	}
+	if f.TestString("dup") || f.RemoveString("dup") {
+		t.Error("key still present after removing every copy")
+	}
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	for _, bits := range []uint32{8, 13, 16, 17, 21, 32} {
+		t.Run(fmt.Sprintf("bits_%d", bits), func(t *testing.T) {
+			f := NewCuckooWithParams(200, bits)
+			var n int
+			for f.AddString(fmt.Sprintf("item-%d", n)) {
+				n++
+			}
+			if f.victimFP == 0 {
+				t.Fatal("filter refused an add without a victim")
+			}
+			if f.LoadFactor() < cuckooMaxLoad {
+				t.Errorf("filled to load %f, below the planned %f", f.LoadFactor(), cuckooMaxLoad)
+			}
+
+			// The key that failed was not added, but every earlier one was,
+			// including the one parked as the victim
+			if f.Count() != uint64(n) {
+				t.Errorf("Count() = %d, want %d", f.Count(), n)
+			}
+			for i := range n {
+				if !f.TestString(fmt.Sprintf("item-%d", i)) {
+					t.Fatalf("false negative for item-%d in a full filter", i)
+				}
+			}
+
+			// Removing a key makes room for the victim and then new keys
+			if !f.RemoveString("item-0") {
+				t.Fatal("Remove failed in a full filter")
+			}
+			for i := 1; f.victimFP != 0; i++ {
+				f.RemoveString(fmt.Sprintf("item-%d", i))
+			}
+			if !f.AddString("late") || !f.TestString("late") {
+				t.Error("Add failed after making room")
+			}
+		})
+	}
+}
+
+func TestCuckooFilterRemoveVictim(t *testing.T) {
+	// A single small bucket fills quickly, and the victim is then found and
+	// removed through its own slot
+	f := NewCuckooWithParams(1, 32)
+	var n int
+	for f.AddString(fmt.Sprintf("item-%d", n)) {
+		n++
+	}
+	if n != CuckooBucketSlots+1 {
+		t.Fatalf("one bucket held %d keys, want %d", n, CuckooBucketSlots+1)
+	}
+
+	var victim string
+	for i := range n {
+		key := fmt.Sprintf("item-%d", i)
+		if _, fp := f.fingerprint(hashRawString(key)); fp == f.victimFP {
+			victim = key
+		}
+	}
+	if !f.TestString(victim) || !f.RemoveString(victim) {
+		t.Fatalf("victim %q not found", victim)
+	}
+	if f.victimFP != 0 || f.Count() != uint64(n-1) {
+		t.Errorf("after removing the victim: victim %#x, Count() = %d", f.victimFP, f.Count())
+	}
+	if f.TestString(victim) {
+		t.Error("removed victim still tests positive")
+	}
+}
+
+func TestCuckooFilterSerialize(t *testing.T) {
+	original := NewCuckooWithParams(20, 21)
+	var n int
+	for original.AddString(fmt.Sprintf("item-%d", n)) {
+		n++
+	}
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if data[0] != serializeCuckooVersion || len(data) != cuckooHeaderSize+(20*4*21+63)/64*8 {
+		t.Errorf("got version %d and %d bytes", data[0], len(data))
+	}
+
+	restored, err := UnmarshalCuckooBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalCuckooBinary failed: %v", err)
+	}
+	if restored.NumBuckets() != 20 || restored.FingerprintBits() != 21 || restored.Count() != uint64(n) {
+		t.Errorf("params mismatch: got (%d, %d, %d)", restored.NumBuckets(), restored.FingerprintBits(), restored.Count())
+	}
+	if restored.victimFP != original.victimFP || restored.victimIdx != original.victimIdx {
+		t.Error("victim not restored")
+	}
+	for i := range n {
+		if !restored.TestString(fmt.Sprintf("item-%d", i)) {
+			t.Fatalf("false negative for item-%d", i)
+		}
+	}
+
+	again, err := restored.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("second roundtrip produced different bytes")
+	}
+
+	// The locked variant shares the format
+	locked, err := UnmarshalLockedCuckooBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalLockedCuckooBinary failed: %v", err)
+	}
+	if lockedData, _ := locked.MarshalBinary(); !bytes.Equal(lockedData, data) {
+		t.Error("locked filter serialized differently")
+	}
+	if _, err := UnmarshalLockedCuckooBinary(data[:1]); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("UnmarshalLockedCuckooBinary of short data: expected ErrInvalidData, got %v", err)
+	}
+
+	// The formats of Filter and CuckooFilter are not interchangeable
+	if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBinary of a CuckooFilter: expected ErrUnsupportedVersion, got %v", err)
+	}
+	filterData, _ := NewWithParams(20, 7).MarshalBinary()
+	if _, err := UnmarshalCuckooBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalCuckooBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestCuckooFilterSerializeInvalid(t *testing.T) {
+	f := NewCuckooWithParams(4, 13)
+	f.AddString("a")
+	f.AddString("b")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:cuckooHeaderSize-1]},
+		{"truncated buckets", data[:len(data)-1]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"fingerprint too small", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], MinFingerprintBits-1) })},
+		{"fingerprint too large", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], MaxFingerprintBits+1) })},
+		{"zero buckets", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], 0) })},
+		{"too many buckets", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], 1<<60) })},
+		{"count too high", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[13:21], 3) })},
+		{"victim too wide", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[21:25], 1<<13) })},
+		{"victim bucket", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[25:33], 4) })},
+		{"victim not counted", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[21:25], 1) })},
+		{"bits past the last slot", corrupt(func(b []byte) { b[len(b)-1] = 0x80 })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalCuckooBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalCuckooBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestOptimalCuckooParams(t *testing.T) {
+	for _, tc := range []struct {
+		fpRate float64
+		bits   uint32
+	}{
+		{0.01, 10},
+		{0.001, 13},
+		{1e-4, 17},
+		{1e-6, 23},
+		{1e-9, 32},
+	} {
+		numBuckets, bits, bitsPerItem := OptimalCuckooParams(100000, tc.fpRate)
+		if bits != tc.bits {
+			t.Errorf("fpRate=%g: chose %d-bit fingerprints, want %d", tc.fpRate, bits, tc.bits)
+		}
+		if fp := EstimateCuckooFalsePositiveRate(numBuckets, bits, 100000); fp > tc.fpRate {
+			t.Errorf("fpRate=%g: estimate %g misses target", tc.fpRate, fp)
+		}
+		if load := 100000 / float64(numBuckets*CuckooBucketSlots); load > cuckooMaxLoad {
+			t.Errorf("fpRate=%g: planned load %f", tc.fpRate, load)
+		}
+		if bitsPerItem != float64(numBuckets*CuckooBucketSlots*uint64(bits))/100000 {
+			t.Errorf("fpRate=%g: bitsPerItem %f inconsistent with %d buckets", tc.fpRate, bitsPerItem, numBuckets)
+		}
+
+		// No fingerprint size reaches the target in less memory
+		size := numBuckets * uint64(bits)
+		for other := uint32(MinFingerprintBits); other <= MaxFingerprintBits; other++ {
+			fewer := (size - 1) / uint64(other)
+			load := 100000 / float64(fewer*CuckooBucketSlots)
+			if load <= cuckooMaxLoad && EstimateCuckooFalsePositiveRate(fewer, other, 100000) <= tc.fpRate {
+				t.Errorf("fpRate=%g: %d-bit fingerprints fit in %d buckets", tc.fpRate, other, fewer)
+			}
+		}
+	}
+
+	// Rates out of range are clamped, and a rate even 32-bit fingerprints
+	// cannot reach at full load leaves buckets underfilled
+	for _, tc := range []struct {
+		items  uint64
+		fpRate float64
+	}{
+		{0, 0.01},
+		{1000, 0},
+		{1000, 1.5},
+		{1000, 1e-12},
+	} {
+		numBuckets, bits, _ := OptimalCuckooParams(tc.items, tc.fpRate)
+		if numBuckets == 0 || !ValidFingerprintBits(bits) {
+			t.Errorf("OptimalCuckooParams(%d, %g) = (%d, %d)", tc.items, tc.fpRate, numBuckets, bits)
+		}
+	}
+	if numBuckets, _, _ := OptimalCuckooParams(1000, 1e-12); 1000/float64(numBuckets*CuckooBucketSlots) > 0.001 {
+		t.Errorf("fpRate=1e-12: %d buckets", numBuckets)
+	}
+}
+
+func TestOptimalCuckooParamsSmallerThanFilter(t *testing.T) {
+	// Short fingerprints in 4-slot buckets take less memory than a Filter
+	// for the same rate from about 2e-3 down
+	for _, fpRate := range []float64{1e-3, 1e-4, 1e-6} {
+		_, _, cuckoo := OptimalCuckooParams(1_000_000, fpRate)
+		_, _, filter := OptimalParams(1_000_000, fpRate)
+		if cuckoo >= filter {
+			t.Errorf("fpRate=%g: %.2f bits per item, Filter needs %.2f", fpRate, cuckoo, filter)
+		}
+	}
+}
+
+func TestEstimateCuckooFalsePositiveRate(t *testing.T) {
+	tests := []struct {
+		name       string
+		numBuckets uint64
+		bits       uint32
+		items      uint64
+	}{
+		{"zero buckets", 0, 16, 100},
+		{"zero items", 100, 16, 0},
+		{"bits too small", 100, MinFingerprintBits - 1, 100},
+		{"bits too large", 100, MaxFingerprintBits + 1, 100},
+	}
+	for _, tt := range tests {
+		if got := EstimateCuckooFalsePositiveRate(tt.numBuckets, tt.bits, tt.items); got != 0 {
+			t.Errorf("%s: got %g, want 0", tt.name, got)
+		}
+	}
+
+	// The load is capped at full, and each fingerprint matches with
+	// probability 1/(2^f - 1)
+	full := EstimateCuckooFalsePositiveRate(1, 8, 1_000_000)
+	if want := -math.Expm1(2 * 4 * math.Log1p(-1.0/255)); math.Abs(full-want) > 1e-12 {
+		t.Errorf("overloaded filter: got %g, want %g", full, want)
+	}
+	if a, b := EstimateCuckooFalsePositiveRate(100, 16, 100), EstimateCuckooFalsePositiveRate(100, 16, 200); a >= b {
+		t.Errorf("rate not increasing with load: %g, %g", a, b)
+	}
+}
+
+func TestCuckooFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, bits := range []uint32{8, 12, 16, 21} {
+		t.Run(fmt.Sprintf("bits_%d", bits), func(t *testing.T) {
+			f := NewCuckooWithParams(5000, bits)
+			items := int(float64(5000*CuckooBucketSlots) * cuckooMaxLoad)
+			for i := range items {
+				f.Add(fmt.Appendf(nil, "item-%d", i))
+			}
+
+			m := MeasureFalsePositiveRate(f, 2_000_000)
+			if est := f.EstimatedFalsePositiveRate(); !m.Contains(est) {
+				t.Errorf("measured %g [%g, %g], estimated %g", m.Rate, m.Lower, m.Upper, est)
+			}
+		})
+	}
+}
+
+func TestLockedCuckooFilter(t *testing.T) {
+	f := NewLockedCuckoo(10000, 0.001)
+	other := NewLockedCuckooWithParams(f.NumBuckets(), f.FingerprintBits())
+	if other.Cap() != f.Cap() || other.FingerprintBits() != f.FingerprintBits() {
+		t.Errorf("NewLockedCuckooWithParams built (%d, %d), want (%d, %d)", other.Cap(), other.FingerprintBits(), f.Cap(), f.FingerprintBits())
+	}
+
+	const goroutines = 8
+	const perGoroutine = 1000
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perGoroutine {
+				key := fmt.Sprintf("g%d-item-%d", g, i)
+				if i%2 == 0 {
+					f.Add([]byte(key))
+				} else {
+					f.AddString(key)
+				}
+				if !f.Test([]byte(key)) || !f.TestString(key) {
+					t.Errorf("false negative for %q", key)
+					return
+				}
+			}
+			for i := 0; i < perGoroutine; i += 2 {
+				key := fmt.Sprintf("g%d-item-%d", g, i)
+				if i%4 == 0 {
+					f.Remove([]byte(key))
+				} else {
+					f.RemoveString(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if f.Count() != goroutines*perGoroutine/2 {
+		t.Errorf("Count() = %d, want %d", f.Count(), goroutines*perGoroutine/2)
+	}
+	if want := float64(f.Count()) / float64(f.NumBuckets()*CuckooBucketSlots); f.LoadFactor() != want {
+		t.Errorf("LoadFactor() = %f, want %f", f.LoadFactor(), want)
+	}
+	if fp := f.EstimatedFalsePositiveRate(); fp <= 0 || fp > 0.001 {
+		t.Errorf("EstimatedFalsePositiveRate() = %g", fp)
+	}
+	for g := range goroutines {
+		for i := 1; i < perGoroutine; i += 2 {
+			if key := fmt.Sprintf("g%d-item-%d", g, i); !f.TestString(key) {
+				t.Fatalf("false negative for %q", key)
+			}
+		}
+	}
+}
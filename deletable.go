@@ -0,0 +1,188 @@
+package gloom
+
+import "math/bits"
+
+// DeletableRegions is the number of regions each block of a DeletableFilter
+// is divided into, one bit of its collision bitmap per region.
+const DeletableRegions = 64
+
+// DeletableFilter is a bloom filter that supports best-effort removal of
+// keys, as described by Rothenberg et al., "The Deletable Bloom Filter: A
+// New Member of the Bloom Family" (2010).
+//
+// Keys are placed exactly as in a Filter. In addition, each block is divided
+// into DeletableRegions regions of equal size, and a 64-bit collision bitmap
+// per block marks the regions where an add found a bit already set. A bit in
+// a region without collisions belongs to exactly one key, so Remove can
+// clear it without affecting any other key. A key with a bit in at least one
+// collision-free region can be removed; the more keys a block holds, the
+// more of its regions collide and the fewer keys remain removable. The
+// bitmap costs 64 bits per block, 1/8 more memory for 512-bit blocks,
+// against 4x for a counting filter.
+//
+// A DeletableFilter is not safe for concurrent use.
+type DeletableFilter struct {
+	filter      *Filter  // Blocks, partitions, and counters, placed as in a Filter
+	collisions  []uint64 // One bitmap of collided regions per block
+	regionShift uint32   // log2 of the bits per region
+}
+
+// NewDeletable creates a new deletable filter optimized for the expected
+// number of items and desired false positive rate, as New does.
+func NewDeletable(expectedItems uint64, fpRate float64) *DeletableFilter {
+	numBlocks, k, _ := OptimalParams(expectedItems, fpRate)
+	return newDeletableFilter(NewWithParams(numBlocks, k))
+}
+
+// NewDeletableWithParams creates a new deletable filter with explicit
+// parameters. numBlocks is the number of 512-bit blocks, k is the number of
+// hash functions. Unsupported values fall back as in NewWithParams.
+func NewDeletableWithParams(numBlocks uint64, k uint32) *DeletableFilter {
+	return newDeletableFilter(NewWithParams(numBlocks, k))
+}
+
+// newDeletableFilter adds a collision bitmap to an empty filter.
+func newDeletableFilter(f *Filter) *DeletableFilter {
+	return &DeletableFilter{
+		filter:      f,
+		collisions:  make([]uint64, f.numBlocks),
+		regionShift: uint32(bits.TrailingZeros64(f.blockWords * 64 / DeletableRegions)),
+	}
+}
+
+// Add adds data to the filter.
+func (d *DeletableFilter) Add(data []byte) {
+	d.addWithHash(hashData(data, d.filter.numBlocks))
+}
+
+// AddString adds a string to the filter without allocating.
+func (d *DeletableFilter) AddString(s string) {
+	d.addWithHash(hashString(s, d.filter.numBlocks))
+}
+
+// addWithHash sets bits in the filter using pre-computed hash values,
+// marking the regions of bits that were already set as collided.
+func (d *DeletableFilter) addWithHash(blockIdx uint64, intraHash uint32) {
+	f := d.filter
+	blockBase := blockIdx * f.blockWords
+
+	for i := uint32(0); i < f.k; i++ {
+		bitPos := f.offsets[i] + (intraHash % f.primes[i])
+		word := &f.blocks[blockBase+uint64(bitPos/64)]
+		if *word&(1<<(bitPos%64)) != 0 {
+			d.collisions[blockIdx] |= 1 << (bitPos >> d.regionShift)
+		} else {
+			*word |= 1 << (bitPos % 64)
+			f.setBits++
+		}
+	}
+	f.count++
+}
+
+// Test checks if data might be in the filter.
+// Returns true if the data might be present (with false positive probability),
+// or false if the data is definitely not present.
+func (d *DeletableFilter) Test(data []byte) bool {
+	return d.filter.Test(data)
+}
+
+// TestString checks if a string might be in the filter without allocating.
+func (d *DeletableFilter) TestString(s string) bool {
+	return d.filter.TestString(s)
+}
+
+// Remove removes data from the filter by clearing its bits that lie in
+// collision-free regions. It returns true if at least one bit was cleared,
+// after which Test reports data as absent. It returns false, leaving the
+// filter unchanged, if data is absent or all of its bits lie in collided
+// regions, where it remains present.
+//
+// Only keys that were added may be removed. Removing a false positive
+// clears bits of other keys, causing false negatives. Likewise a key added
+// twice collides with itself, so it can no longer be removed.
+func (d *DeletableFilter) Remove(data []byte) bool {
+	return d.removeWithHash(hashData(data, d.filter.numBlocks))
+}
+
+// RemoveString removes a string from the filter without allocating. See
+// Remove.
+func (d *DeletableFilter) RemoveString(s string) bool {
+	return d.removeWithHash(hashString(s, d.filter.numBlocks))
+}
+
+// removeWithHash clears the bits in collision-free regions using
+// pre-computed hash values.
+func (d *DeletableFilter) removeWithHash(blockIdx uint64, intraHash uint32) bool {
+	f := d.filter
+	if !f.testWithHash(blockIdx, intraHash) {
+		return false
+	}
+
+	blockBase := blockIdx * f.blockWords
+	collided := d.collisions[blockIdx]
+	var cleared uint64
+	for i := uint32(0); i < f.k; i++ {
+		bitPos := f.offsets[i] + (intraHash % f.primes[i])
+		if collided&(1<<(bitPos>>d.regionShift)) == 0 {
+			f.blocks[blockBase+uint64(bitPos/64)] &^= 1 << (bitPos % 64)
+			cleared++
+		}
+	}
+	if cleared == 0 {
+		return false
+	}
+	f.setBits -= cleared
+	// Removing false positives can remove more keys than were added
+	if f.count > 0 {
+		f.count--
+	}
+	return true
+}
+
+// Cap returns the capacity of the filter in bits, not counting the
+// collision bitmap.
+func (d *DeletableFilter) Cap() uint64 {
+	return d.filter.Cap()
+}
+
+// K returns the number of hash functions (partitions) used.
+func (d *DeletableFilter) K() uint32 {
+	return d.filter.k
+}
+
+// Count returns the approximate number of items in the filter: those added,
+// less those removed.
+func (d *DeletableFilter) Count() uint64 {
+	return d.filter.count
+}
+
+// NumBlocks returns the number of blocks in the filter.
+func (d *DeletableFilter) NumBlocks() uint64 {
+	return d.filter.numBlocks
+}
+
+// BlockBits returns the number of bits per block.
+func (d *DeletableFilter) BlockBits() uint32 {
+	return d.filter.BlockBits()
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add and
+// Remove.
+func (d *DeletableFilter) EstimatedFillRatio() float64 {
+	return d.filter.EstimatedFillRatio()
+}
+
+// EstimatedFalsePositiveRate estimates the current false positive rate
+// based on the number of items in the filter.
+func (d *DeletableFilter) EstimatedFalsePositiveRate() float64 {
+	return d.filter.EstimatedFalsePositiveRate()
+}
+
+// CollisionRatio returns the proportion of regions marked as collided. A key
+// can be removed unless all of its k bits lie in collided regions, which
+// grows likelier as the ratio rises. Collisions are never unmarked, so the
+// ratio only grows.
+func (d *DeletableFilter) CollisionRatio() float64 {
+	return float64(popCount(d.collisions)) / float64(uint64(len(d.collisions))*DeletableRegions)
+}
@@ -0,0 +1,126 @@
+package gloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeletableBasic(t *testing.T) {
+	d := NewDeletable(10_000, 0.01)
+	for i := range 10_000 {
+		key := fmt.Sprintf("key-%d", i)
+		if i%2 == 0 {
+			d.AddString(key)
+		} else {
+			d.Add([]byte(key))
+		}
+	}
+	for i := range 10_000 {
+		key := fmt.Sprintf("key-%d", i)
+		if !d.TestString(key) || !d.Test([]byte(key)) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	// The filter is placed exactly as a Filter of the same parameters
+	f := New(10_000, 0.01)
+	if d.NumBlocks() != f.NumBlocks() || d.K() != f.K() || d.BlockBits() != f.BlockBits() || d.Cap() != f.Cap() {
+		t.Errorf("got (%d, %d, %d), Filter has (%d, %d, %d)", d.NumBlocks(), d.K(), d.BlockBits(), f.NumBlocks(), f.K(), f.BlockBits())
+	}
+	for i := range 10_000 {
+		f.AddString(fmt.Sprintf("key-%d", i))
+	}
+	if d.Count() != 10_000 || d.EstimatedFillRatio() != f.ExactFillRatio() || d.EstimatedFalsePositiveRate() != f.EstimatedFalsePositiveRate() {
+		t.Errorf("got Count() = %d, EstimatedFillRatio() = %f, Filter has %f", d.Count(), d.EstimatedFillRatio(), f.ExactFillRatio())
+	}
+}
+
+func TestDeletableRemove(t *testing.T) {
+	for _, blockBits := range []uint32{256, 512, 1024} {
+		t.Run(fmt.Sprintf("blockBits_%d", blockBits), func(t *testing.T) {
+			d := newDeletableFilter(NewWithBlockParams(200, 7, blockBits))
+			const n = 5_000
+			for i := range n {
+				d.AddString(fmt.Sprintf("key-%d", i))
+			}
+			ratio := d.CollisionRatio()
+			if ratio <= 0 || ratio >= 1 {
+				t.Fatalf("CollisionRatio() = %f", ratio)
+			}
+
+			// Remove every other key, as bytes and as strings
+			var removed int
+			for i := 0; i < n; i += 2 {
+				key := fmt.Sprintf("key-%d", i)
+				var ok bool
+				if i%4 == 0 {
+					ok = d.RemoveString(key)
+				} else {
+					ok = d.Remove([]byte(key))
+				}
+				if ok {
+					removed++
+					if d.TestString(key) {
+						t.Fatalf("%q still present after Remove returned true", key)
+					}
+				} else if !d.TestString(key) {
+					t.Fatalf("%q absent after Remove returned false", key)
+				}
+			}
+
+			// Removal never causes a false negative for the keys left
+			for i := 1; i < n; i += 2 {
+				if key := fmt.Sprintf("key-%d", i); !d.TestString(key) {
+					t.Fatalf("false negative for %q after removing others", key)
+				}
+			}
+
+			// Most keys can be removed, fewer as blocks shrink and collide more
+			rate := float64(removed) / (n / 2)
+			t.Logf("removed %.3f of keys with %.3f of regions collided", rate, ratio)
+			if rate < 0.8 {
+				t.Errorf("removed %f of keys", rate)
+			}
+			if d.Count() != uint64(n-removed) {
+				t.Errorf("Count() = %d after removing %d of %d", d.Count(), removed, n)
+			}
+			if d.EstimatedFillRatio() != d.filter.ExactFillRatio() {
+				t.Errorf("EstimatedFillRatio() = %f, exact %f", d.EstimatedFillRatio(), d.filter.ExactFillRatio())
+			}
+		})
+	}
+}
+
+func TestDeletableRemoveAbsent(t *testing.T) {
+	d := NewDeletableWithParams(1, 7)
+	if d.Remove([]byte("missing")) || d.RemoveString("missing") {
+		t.Error("Remove of an absent key returned true")
+	}
+
+	// A key added twice collides with itself and stays
+	d.AddString("twice")
+	d.AddString("twice")
+	if d.RemoveString("twice") || !d.TestString("twice") {
+		t.Error("key added twice was removed")
+	}
+	if d.CollisionRatio() != float64(d.K())/DeletableRegions {
+		t.Errorf("CollisionRatio() = %f", d.CollisionRatio())
+	}
+
+	// A key added once is removed, and the filter is empty again
+	d = NewDeletableWithParams(1, 7)
+	d.AddString("once")
+	if !d.RemoveString("once") || d.TestString("once") || d.Count() != 0 || d.EstimatedFillRatio() != 0 {
+		t.Errorf("after removing the only key: Count() = %d, EstimatedFillRatio() = %f", d.Count(), d.EstimatedFillRatio())
+	}
+	if d.RemoveString("once") {
+		t.Error("second Remove returned true")
+	}
+
+	// Removing more keys than were counted leaves the count at 0
+	d.AddString("uncounted")
+	d.filter.count = 0
+	if !d.RemoveString("uncounted") || d.Count() != 0 {
+		t.Errorf("after removing an uncounted key: Count() = %d", d.Count())
+	}
+}
@@ -22,6 +22,11 @@
 // on the paper "One-Hashing Bloom Filter", provides excellent bit distribution
 // while being much faster than multiple hash computations.
 //
+// On amd64 CPUs with AVX2 or AVX-512, membership tests compute all k bit
+// positions at once and check them with a single vector gather. The vector
+// path is selected at startup and gives results identical to the portable
+// scalar loop, which is used everywhere else.
+//
 // # Implementations
 //
 // Three filter implementations are provided for different use cases:
@@ -38,6 +43,76 @@
 // auto-tuned to GOMAXPROCS by default. Use this when you have many goroutines
 // performing concurrent writes.
 //
+// [BuildParallel] constructs a [Filter] from a large key source using many
+// goroutines. Each goroutine owns a disjoint range of blocks, so the build
+// needs no atomics and the result is an ordinary [Filter].
+//
+// [RegisterFilter] and [AtomicRegisterFilter] shrink each block to a single
+// 64-bit word and set an item's bits with one precomputed mask, so Add and
+// Test are a single load and compare. They are faster than [Filter] but need
+// more memory for the same false positive rate, and suit uses that tolerate
+// rates of around 1% or more. Size them with [OptimalRegisterParams].
+//
+// [TwoChoiceFilter] gives each key two candidate blocks and adds it to the
+// emptier one, which removes the overloaded blocks that drive a blocked
+// filter's false positive rate at a cost of probing two blocks per Test. It
+// is more accurate than a [Filter] with 1024-bit blocks of the same memory
+// only below about 1e-6. Size it with [OptimalTwoChoiceParams].
+//
+// [PatternFilter] takes each key's bits from a seeded table of precomputed
+// 512-bit masks, so Add is one OR of a cache line and Test one compare. Keys
+// sharing a pattern collide, which limits the rates it reaches economically
+// to about 1e-4 with the default table. Size it with [OptimalPatternParams].
+//
+// [CuckooFilter] stores fingerprints in one of two candidate 4-slot buckets
+// per key, so keys can be removed. It needs less memory than [Filter] at
+// rates from about 2e-3 down to 2e-9, and [LockedCuckooFilter] is its
+// thread-safe variant. Size it with [OptimalCuckooParams].
+//
+// [StaticFilter] is an immutable binary fuse filter built once from a known
+// key set with [BuildStatic], [BuildStaticStrings], or [BuildStaticSeq]. With
+// 8-bit fingerprints it needs about 9 bits per key for a false positive rate
+// of 1/256, far less than a bloom filter, but keys cannot be added later.
+//
+// [BloomierFilter] uses the same layout to map each key of a known set to an
+// r-bit value, such as a shard ID, without storing the keys. Build it with
+// [BuildBloomier], [BuildBloomierStrings], or [BuildBloomierSeq], and look up
+// values with [BloomierFilter.Get].
+//
+// [IBLT] is an invertible Bloom lookup table for set reconciliation. After
+// [IBLT.Subtract] of another replica's table, [IBLT.ListEntries] recovers
+// the keys in only one of the two sets. Size it with [OptimalIBLTCells].
+//
+// [GCS] is a Golomb-coded set, a compressed read-only key set that is
+// smaller to send than a serialized [Filter]. Lookups decode the set, so
+// check keys in batches with [GCS.TestMany].
+//
+// [Cascade] is a bloom filter cascade built with [BuildCascade]: successive
+// [Filter] levels that together answer exactly whether a key of a known
+// universe is in a set, with no false positives.
+//
+// [ExceptionFilter] wraps a [Filter] or [AtomicFilter] with a bounded list
+// of known false positives, recorded with [ExceptionFilter.MarkFalsePositive],
+// that are reported absent without changing the filter's bits.
+//
+// [DeletableFilter] supports best-effort removal. A collision bitmap per
+// block marks the regions where adds overlapped, and [DeletableFilter.Remove]
+// clears only a key's bits outside them, so other keys are never affected.
+//
+// [StableFilter] and [AtomicStableFilter] are stable bloom filters for
+// unbounded streams. Each add decrements random counters before setting the
+// key's, so old keys are forgotten and the false positive rate stays
+// bounded. [OptimalStableParams] computes their parameters from a target
+// false positive rate and false negative tolerance.
+//
+// [PrefixFilter] also indexes the prefix of each key returned by a
+// [PrefixExtractor], such as [DelimiterPrefix], so [PrefixFilter.TestPrefix]
+// can rule out prefix and range scans. Size it with [OptimalPrefixParams].
+//
+// [RangeFilter] answers whether any uint64 key in a range might have been
+// added, from levels of dyadic key prefixes, each a [Filter].
+// [OptimalRangeParams] splits a memory budget across the levels.
+//
 // # Choosing Parameters
 //
 // Use [New], [NewAtomic], or [NewShardedAtomicDefault] with your expected
@@ -49,6 +124,36 @@
 // The functions automatically calculate optimal filter size and number of
 // hash functions. For advanced use cases, [NewWithParams] and [NewAtomicWithParams]
 // allow explicit control over the number of 512-bit blocks and hash functions.
+// Any k from [MinK] to [MaxK] is supported. Larger k would need partitions of
+// the block so small that they filter almost nothing. Because every probe for
+// a key lands in the same block, a larger k does not always lower the false
+// positive rate: with 512-bit blocks, k=14 is never better than k=13, and
+// k=15 only overtakes it for rates of about 1e-5 and below. Above k=13, the
+// automatic choice is the k with the lowest [EstimateBlockFalsePositiveRate].
+// For other block sizes it goes up to [MaxKForBlockBits].
+//
+// [NewWithBlockParams] and [NewAtomicWithBlockParams] also choose the block
+// size: 256, 512, or 1024 bits. At the same memory, larger blocks give a
+// lower false positive rate because items spread more evenly, while 1024-bit
+// blocks cost a second cache line per operation. [New], [NewAtomic], and
+// [OptimalParams] always use 512-bit blocks. [OptimalBlockParams] also
+// chooses the block size for [NewWithBlockParams]: 512 bits unless they
+// cannot get within twice the target rate, which happens for targets below
+// about 1e-3, and then 1024 bits. Unlike [OptimalParams], which sizes
+// filters with the unblocked formula, it then adds blocks until the
+// estimated rate meets the target.
+//
+// A [Plan] answers the inverse questions: [PlanForMemory] finds the most
+// items a memory budget holds at a target rate, [PlanForItemsInMemory] the
+// lowest rate for a number of items in a budget, and [PlanForBlocks] the
+// best k for a fixed number of blocks. Each reports the predicted rate at
+// 50%, 100%, and 150% of the planned items, and [NewFromPlan] builds the
+// filter it describes.
+//
+// [MeasureFalsePositiveRate] measures a filter's false positive rate with
+// keys that were never added, with a confidence interval to compare the
+// model against. [AutoTune] measures candidate parameters on a sample of
+// real keys and returns the best as a [Plan].
 //
 // # False Positive Rate
 //
@@ -64,9 +169,9 @@
 //
 // # Memory Usage
 //
-// Memory usage is determined by the number of 512-bit blocks:
+// Memory usage is determined by the number and size of blocks:
 //
-//	memory_bytes = num_blocks * 64
+//	memory_bytes = num_blocks * block_bits / 8
 //
 // For a filter sized for n items with false positive rate p:
 //
@@ -89,8 +194,14 @@
 //   - Use [AtomicFilter] for read-heavy concurrent workloads
 //   - Use string methods ([Filter.AddString], [Filter.TestString]) to avoid
 //     allocating when you have string keys
+//   - Use the batch methods ([Filter.AddBatch], [Filter.TestBatch]) for bulk
+//     loads and multi-key lookups on filters larger than the CPU cache; they
+//     overlap the cache misses of several keys instead of paying them one at
+//     a time
+//   - Use [UnmarshalBinaryInto] to reload a filter of the same size without
+//     allocating
 //   - Build with GOAMD64=v2 or higher to enable hardware POPCNT for
-//     [Filter.EstimatedFillRatio]
+//     [Filter.ExactFillRatio] and deserialization
 //
 // # References
 //
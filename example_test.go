@@ -222,6 +222,20 @@ func ExampleOptimalParams() {
 	//   Bits per item: 9.6
 }
 
+func ExampleOptimalBlockParams() {
+	// At low rates, 512-bit blocks cannot reach the target, so larger
+	// blocks are chosen, and enough of them to meet it
+	blocks, k, blockBits, _ := gloom.OptimalBlockParams(1_000_000, 0.0001)
+	f := gloom.NewWithBlockParams(blocks, k, blockBits)
+
+	fmt.Printf("Blocks: %d of %d bits\n", f.NumBlocks(), f.BlockBits())
+	fmt.Printf("Hash functions (k): %d\n", f.K())
+
+	// Output:
+	// Blocks: 20581 of 1024 bits
+	// Hash functions (k): 13
+}
+
 func ExampleEstimateFalsePositiveRate() {
 	// Estimate false positive rate for given parameters
 	numBlocks := uint64(1000)
@@ -234,3 +248,22 @@ func ExampleEstimateFalsePositiveRate() {
 	// Output:
 	// Estimated FP rate: 0.91%
 }
+
+func ExamplePlanForMemory() {
+	// How many items fit in 1 MiB at a 1% false positive rate?
+	plan := gloom.PlanForMemory(1<<20, 0.01)
+
+	fmt.Printf("Items: %d\n", plan.ExpectedItems)
+	fmt.Printf("Hash functions (k): %d\n", plan.K)
+	fmt.Printf("FP rate at 50%%/100%%/150%% load: %.3f%% / %.2f%% / %.1f%%\n",
+		plan.FPRateAt50*100, plan.FPRateAt100*100, plan.FPRateAt150*100)
+
+	f := gloom.NewFromPlan(plan)
+	fmt.Println("Capacity in bytes:", f.Cap()/8)
+
+	// Output:
+	// Items: 837271
+	// Hash functions (k): 7
+	// FP rate at 50%/100%/150% load: 0.033% / 1.00% / 5.4%
+	// Capacity in bytes: 1048576
+}
@@ -0,0 +1,406 @@
+package gloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultMaxExceptions is the number of exceptions an ExceptionFilter
+	// holds when created with a bound below 1.
+	DefaultMaxExceptions = 1024
+
+	// serializeExceptionVersion is the serialization format version of an
+	// ExceptionFilter.
+	serializeExceptionVersion byte = 10
+
+	// exceptionHeaderSize is the size of the ExceptionFilter serialization
+	// header in bytes: Version (1) + Atomic (1) + MaxExceptions (8) +
+	// NumExceptions (8) + FilterSize (8) = 26 bytes.
+	exceptionHeaderSize = 26
+)
+
+// exceptionBase is the part of Filter and AtomicFilter an ExceptionFilter
+// uses.
+type exceptionBase interface {
+	addWithHash(blockIdx uint64, intraHash uint32)
+	testWithHash(blockIdx uint64, intraHash uint32) bool
+	NumBlocks() uint64
+}
+
+// Exception table slot states. A slot's hash is only meaningful while the
+// slot is full or referenced.
+const (
+	exceptionEmpty      uint32 = iota // Never held an entry; ends a probe
+	exceptionDeleted                  // Held an entry that was removed
+	exceptionFull                     // Holds an entry
+	exceptionReferenced               // Holds an entry that suppressed a lookup since the clock hand last passed
+)
+
+// exceptionTable is an ExceptionFilter's exception list: an open-addressing
+// hash table with linear probing, kept at most half full so that every probe
+// ends at an empty slot. Lookups read it without locking. Writers, which the
+// filter serializes, update slots in place with atomic stores, and replace
+// the table with a larger one when entries and deleted slots fill half of it.
+type exceptionTable struct {
+	hashes []atomic.Uint64 // Raw hash of the key in each slot
+	states []atomic.Uint32 // State of each slot
+	mask   uint64          // Number of slots minus 1
+
+	// Guarded by the filter's mu
+	used  int      // Slots that are not empty
+	clock []uint64 // Slots of the entries in clock order
+	index []int    // Position in clock of the entry in each slot
+	hand  int      // Next position in clock considered for eviction
+}
+
+// newExceptionTable returns an empty table with room for entries entries
+// and as many writes again before it needs rebuilding.
+func newExceptionTable(entries int) *exceptionTable {
+	size := 8
+	for size < 4*entries {
+		size *= 2
+	}
+	return &exceptionTable{
+		hashes: make([]atomic.Uint64, size),
+		states: make([]atomic.Uint32, size),
+		mask:   uint64(size - 1),
+		index:  make([]int, size),
+	}
+}
+
+// find returns the slot holding hash h, reporting whether there is one.
+func (t *exceptionTable) find(h uint64) (uint64, bool) {
+	for i := mix64(h) & t.mask; ; i = (i + 1) & t.mask {
+		switch state := t.states[i].Load(); {
+		case state == exceptionEmpty:
+			return 0, false
+		case state >= exceptionFull && t.hashes[i].Load() == h:
+			return i, true
+		}
+	}
+}
+
+// full reports whether the table must be rebuilt before another entry is
+// added.
+func (t *exceptionTable) full() bool {
+	return 2*(t.used+1) > len(t.states)
+}
+
+// append adds an entry for hash h, which must be absent, at the end of the
+// clock order.
+func (t *exceptionTable) append(h uint64) {
+	i := mix64(h) & t.mask
+	for t.states[i].Load() >= exceptionFull {
+		i = (i + 1) & t.mask
+	}
+	if t.states[i].Load() == exceptionEmpty {
+		t.used++
+	}
+	// Store the hash first, so a lookup that sees the slot full sees h
+	t.hashes[i].Store(h)
+	t.states[i].Store(exceptionFull)
+	t.index[i] = len(t.clock)
+	t.clock = append(t.clock, i)
+}
+
+// removeAt deletes the entry at position c of the clock order by moving the
+// last entry into its place.
+func (t *exceptionTable) removeAt(c int) {
+	t.states[t.clock[c]].Store(exceptionDeleted)
+	last := len(t.clock) - 1
+	t.clock[c] = t.clock[last]
+	t.index[t.clock[c]] = c
+	t.clock = t.clock[:last]
+	if t.hand >= len(t.clock) {
+		t.hand = 0
+	}
+}
+
+// evict removes one entry chosen by the CLOCK policy. The hand clears the
+// referenced state of each entry it passes until it finds one already
+// clear.
+func (t *exceptionTable) evict() {
+	for {
+		if t.states[t.clock[t.hand]].Swap(exceptionFull) != exceptionReferenced {
+			t.removeAt(t.hand)
+			return
+		}
+		t.hand = (t.hand + 1) % len(t.clock)
+	}
+}
+
+// rebuild returns a copy of the table without deleted slots and with room
+// for at least one more entry, keeping the clock order and hand.
+func (t *exceptionTable) rebuild() *exceptionTable {
+	r := newExceptionTable(len(t.clock) + 1)
+	for _, i := range t.clock {
+		r.append(t.hashes[i].Load())
+		r.states[r.clock[len(r.clock)-1]].Store(t.states[i].Load())
+	}
+	r.hand = t.hand
+	return r
+}
+
+// ExceptionFilter wraps a Filter or AtomicFilter with an exception list: an
+// exact set of keys known to be false positives, such as hot keys whose
+// false positives trigger expensive lookups. A key the filter reports as
+// present is looked up in the list, and reported absent if found there. The
+// underlying filter's bits are never changed, and keys the filter rejects
+// never consult the list.
+//
+// The list holds at most a fixed number of keys. When it is full, marking
+// another key evicts one chosen by the CLOCK policy, an approximation of
+// least recently used: entries that suppressed a lookup since the clock
+// hand last passed them get a second chance.
+//
+// Keys are stored as their 64-bit hashes, the same hash the filter uses, so
+// the list is exact for any key the filter can tell apart. An
+// ExceptionFilter is safe for concurrent use if its filter is an
+// AtomicFilter.
+//
+// Lookups take no lock: the list is a hash table of atomic slots that
+// writers update in place, so readers never wait for each other or for
+// writers. Marking a key, and adding a marked one, takes constant time on
+// average.
+type ExceptionFilter struct {
+	base  exceptionBase
+	mu    sync.Mutex                     // Serializes writers of table
+	table atomic.Pointer[exceptionTable] // Current table, replaced when rebuilt
+	max   int                            // Bound on the number of entries
+}
+
+// NewExceptionFilter wraps f with an exception list of at most
+// maxExceptions keys, or DefaultMaxExceptions if maxExceptions is below 1.
+// Like f, the result is not safe for concurrent use.
+func NewExceptionFilter(f *Filter, maxExceptions int) *ExceptionFilter {
+	return newExceptionFilter(f, maxExceptions)
+}
+
+// NewAtomicExceptionFilter wraps f with an exception list of at most
+// maxExceptions keys, or DefaultMaxExceptions if maxExceptions is below 1.
+// The result is safe for concurrent use.
+func NewAtomicExceptionFilter(f *AtomicFilter, maxExceptions int) *ExceptionFilter {
+	return newExceptionFilter(f, maxExceptions)
+}
+
+// newExceptionFilter wraps base with an empty exception list.
+func newExceptionFilter(base exceptionBase, maxExceptions int) *ExceptionFilter {
+	if maxExceptions < 1 {
+		maxExceptions = DefaultMaxExceptions
+	}
+	e := &ExceptionFilter{base: base, max: maxExceptions}
+	e.table.Store(newExceptionTable(0))
+	return e
+}
+
+// Add adds data to the filter. If data was marked as a false positive, it is
+// removed from the exception list, since it is now present.
+func (e *ExceptionFilter) Add(data []byte) {
+	e.addWithHash(hashRaw(data))
+}
+
+// AddString adds a string to the filter without allocating. See Add.
+func (e *ExceptionFilter) AddString(s string) {
+	e.addWithHash(hashRawString(s))
+}
+
+// addWithHash adds a key using its pre-computed raw hash.
+func (e *ExceptionFilter) addWithHash(h uint64) {
+	e.base.addWithHash(hashSplit(h, e.base.NumBlocks()))
+
+	if _, marked := e.table.Load().find(h); !marked {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t := e.table.Load()
+	if i, ok := t.find(h); ok { // Unless a concurrent Add removed it
+		t.removeAt(t.index[i])
+	}
+}
+
+// Test checks if data might be in the filter.
+// Returns false if the filter rejects data or data is a known false
+// positive, and true otherwise.
+func (e *ExceptionFilter) Test(data []byte) bool {
+	return e.testWithHash(hashRaw(data))
+}
+
+// TestString checks if a string might be in the filter without allocating.
+// See Test.
+func (e *ExceptionFilter) TestString(s string) bool {
+	return e.testWithHash(hashRawString(s))
+}
+
+// testWithHash checks a key using its pre-computed raw hash.
+func (e *ExceptionFilter) testWithHash(h uint64) bool {
+	if !e.base.testWithHash(hashSplit(h, e.base.NumBlocks())) {
+		return false
+	}
+
+	// A lookup racing with a writer may mark a slot that was just reused or
+	// a table that was just replaced, which only makes the clock less
+	// precise. Writing only when the state changes keeps lookups of a hot
+	// key from contending for its cache line.
+	t := e.table.Load()
+	if i, ok := t.find(h); ok {
+		if t.states[i].Load() == exceptionFull {
+			t.states[i].CompareAndSwap(exceptionFull, exceptionReferenced)
+		}
+		return false
+	}
+	return true
+}
+
+// MarkFalsePositive records data as a known false positive, so that Test
+// reports it absent from now on. It returns false, leaving the list
+// unchanged, if the filter already rejects data.
+//
+// Only keys that were never added should be marked; a marked key that was
+// added becomes a false negative until it is added again. If the list is
+// full, another key is evicted and becomes a false positive again.
+func (e *ExceptionFilter) MarkFalsePositive(data []byte) bool {
+	return e.markWithHash(hashRaw(data))
+}
+
+// MarkFalsePositiveString records a string as a known false positive without
+// allocating. See MarkFalsePositive.
+func (e *ExceptionFilter) MarkFalsePositiveString(s string) bool {
+	return e.markWithHash(hashRawString(s))
+}
+
+// markWithHash marks a key using its pre-computed raw hash.
+func (e *ExceptionFilter) markWithHash(h uint64) bool {
+	if !e.base.testWithHash(hashSplit(h, e.base.NumBlocks())) {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t := e.table.Load()
+	if i, ok := t.find(h); ok {
+		t.states[i].Store(exceptionReferenced)
+		return true
+	}
+	if len(t.clock) == e.max {
+		t.evict()
+	}
+	if t.full() {
+		t = t.rebuild()
+		e.table.Store(t)
+	}
+	t.append(h)
+	return true
+}
+
+// Exceptions returns the number of keys in the exception list.
+func (e *ExceptionFilter) Exceptions() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.table.Load().clock)
+}
+
+// MaxExceptions returns the bound on the number of keys in the exception
+// list.
+func (e *ExceptionFilter) MaxExceptions() int {
+	return e.max
+}
+
+// MarshalBinary serializes the filter and its exception list to a byte
+// slice. An AtomicFilter is copied word by word, so adds running
+// concurrently may or may not be included.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 10
+//   - Atomic (1 byte): 1 if the filter is an AtomicFilter, 0 for a Filter
+//   - MaxExceptions (8 bytes): bound on the exception list (little-endian uint64)
+//   - NumExceptions (8 bytes): number of exceptions (little-endian uint64)
+//   - FilterSize (8 bytes): size of the filter in bytes (little-endian uint64)
+//   - Filter (FilterSize bytes): the filter as written by Filter.MarshalBinary
+//   - Exceptions (NumExceptions * 8 bytes): the hashes of the exceptions
+//     (little-endian uint64s), starting at the clock hand
+//
+// Whether entries were recently referenced is not serialized.
+func (e *ExceptionFilter) MarshalBinary() ([]byte, error) {
+	var f *Filter
+	var isAtomic byte
+	switch base := e.base.(type) {
+	case *Filter:
+		f = base
+	case *AtomicFilter:
+		f, isAtomic = base.snapshot(), 1
+	}
+	filterData, _ := f.MarshalBinary() // Never fails
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t := e.table.Load()
+
+	buf := make([]byte, exceptionHeaderSize, exceptionHeaderSize+len(filterData)+8*len(t.clock))
+	buf[0] = serializeExceptionVersion
+	buf[1] = isAtomic
+	binary.LittleEndian.PutUint64(buf[2:10], uint64(e.max))
+	binary.LittleEndian.PutUint64(buf[10:18], uint64(len(t.clock)))
+	binary.LittleEndian.PutUint64(buf[18:26], uint64(len(filterData)))
+	buf = append(buf, filterData...)
+	for i := range t.clock {
+		buf = binary.LittleEndian.AppendUint64(buf, t.hashes[t.clock[(t.hand+i)%len(t.clock)]].Load())
+	}
+
+	return buf, nil
+}
+
+// UnmarshalExceptionBinary deserializes a filter and its exception list from
+// a byte slice written by ExceptionFilter.MarshalBinary. The filter is an
+// AtomicFilter if it was one when serialized.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalExceptionBinary(data []byte) (*ExceptionFilter, error) {
+	if len(data) < exceptionHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), exceptionHeaderSize)
+	}
+	if version := data[0]; version != serializeExceptionVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeExceptionVersion)
+	}
+
+	isAtomic := data[1]
+	maxExceptions := binary.LittleEndian.Uint64(data[2:10])
+	numExceptions := binary.LittleEndian.Uint64(data[10:18])
+	filterSize := binary.LittleEndian.Uint64(data[18:26])
+	rest := data[exceptionHeaderSize:]
+
+	if isAtomic > 1 {
+		return nil, fmt.Errorf("%w: atomic flag %d", ErrInvalidData, isAtomic)
+	}
+	if maxExceptions == 0 || numExceptions > maxExceptions {
+		return nil, fmt.Errorf("%w: %d exceptions with a bound of %d", ErrInvalidData, numExceptions, maxExceptions)
+	}
+	if filterSize > uint64(len(rest)) || numExceptions != (uint64(len(rest))-filterSize)/8 || (uint64(len(rest))-filterSize)%8 != 0 {
+		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes for a %d-byte filter and %d exceptions)", ErrInvalidData, len(rest), filterSize, numExceptions)
+	}
+
+	f, err := UnmarshalBinary(rest[:filterSize])
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+
+	var base exceptionBase = f
+	if isAtomic == 1 {
+		base = atomicFromFilter(f)
+	}
+	e := newExceptionFilter(base, int(min(maxExceptions, math.MaxInt)))
+	t := newExceptionTable(int(numExceptions))
+	e.table.Store(t)
+	for i := range numExceptions {
+		h := binary.LittleEndian.Uint64(rest[filterSize+8*i:])
+		if _, dup := t.find(h); dup {
+			return nil, fmt.Errorf("%w: exception %d is repeated", ErrInvalidData, i)
+		}
+		t.append(h)
+	}
+	return e, nil
+}
@@ -0,0 +1,313 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+// exceptionFilters returns an ExceptionFilter over each kind of filter, with
+// 1000 keys added at a high false positive rate.
+func exceptionFilters(maxExceptions int) map[string]*ExceptionFilter {
+	f, af := New(1000, 0.1), NewAtomic(1000, 0.1)
+	for key := range keySeq(1000) {
+		f.Add(key)
+		af.Add(key)
+	}
+	return map[string]*ExceptionFilter{
+		"Filter":       NewExceptionFilter(f, maxExceptions),
+		"AtomicFilter": NewAtomicExceptionFilter(af, maxExceptions),
+	}
+}
+
+// falsePositives returns n keys never added that e reports as present.
+func falsePositives(e *ExceptionFilter, n int) []string {
+	var fps []string
+	for i := 0; len(fps) < n; i++ {
+		if key := fmt.Sprintf("probe-%d", i); e.TestString(key) {
+			fps = append(fps, key)
+		}
+	}
+	return fps
+}
+
+func TestExceptionFilterBasic(t *testing.T) {
+	for name, e := range exceptionFilters(0) {
+		t.Run(name, func(t *testing.T) {
+			if e.MaxExceptions() != DefaultMaxExceptions {
+				t.Errorf("MaxExceptions() = %d", e.MaxExceptions())
+			}
+
+			fps := falsePositives(e, 100)
+			for i, key := range fps {
+				var ok bool
+				if i%2 == 0 {
+					ok = e.MarkFalsePositiveString(key)
+				} else {
+					ok = e.MarkFalsePositive([]byte(key))
+				}
+				if !ok {
+					t.Fatalf("MarkFalsePositive(%q) = false", key)
+				}
+			}
+			// Marking again changes nothing
+			if !e.MarkFalsePositiveString(fps[0]) || e.Exceptions() != 100 {
+				t.Errorf("Exceptions() = %d", e.Exceptions())
+			}
+
+			for _, key := range fps {
+				if e.TestString(key) || e.Test([]byte(key)) {
+					t.Fatalf("marked key %q still reported present", key)
+				}
+			}
+			for key := range keySeq(1000) {
+				if !e.Test(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+
+			// A key the filter rejects is not a false positive
+			for i := 0; ; i++ {
+				key := fmt.Sprintf("absent-%d", i)
+				if !e.TestString(key) {
+					if e.MarkFalsePositiveString(key) || e.Exceptions() != 100 {
+						t.Errorf("MarkFalsePositive of a rejected key %q returned true", key)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestExceptionFilterAdd(t *testing.T) {
+	for name, e := range exceptionFilters(10) {
+		t.Run(name, func(t *testing.T) {
+			fps := falsePositives(e, 2)
+			e.MarkFalsePositiveString(fps[0])
+			e.MarkFalsePositive([]byte(fps[1]))
+
+			// Adding a marked key makes it present again
+			e.AddString(fps[0])
+			e.Add([]byte(fps[1]))
+			e.AddString("new-key")
+			if !e.TestString(fps[0]) || !e.TestString(fps[1]) || !e.TestString("new-key") || e.Exceptions() != 0 {
+				t.Errorf("after adding marked keys: Exceptions() = %d", e.Exceptions())
+			}
+		})
+	}
+}
+
+func TestExceptionFilterEviction(t *testing.T) {
+	for name, e := range exceptionFilters(3) {
+		t.Run(name, func(t *testing.T) {
+			fps := falsePositives(e, 6)
+			for _, key := range fps[:3] {
+				e.MarkFalsePositiveString(key)
+			}
+
+			// The clock gives the key that suppressed a lookup a second chance
+			e.TestString(fps[0])
+			e.MarkFalsePositiveString(fps[3])
+			if e.Exceptions() != 3 || e.TestString(fps[0]) || !e.TestString(fps[1]) || e.TestString(fps[2]) || e.TestString(fps[3]) {
+				t.Errorf("after evicting once: Exceptions() = %d", e.Exceptions())
+			}
+
+			// Without references, the clock evicts in turn
+			e.MarkFalsePositiveString(fps[4])
+			e.MarkFalsePositiveString(fps[5])
+			if e.Exceptions() != 3 {
+				t.Errorf("Exceptions() = %d", e.Exceptions())
+			}
+			var marked int
+			for _, key := range fps {
+				if !e.TestString(key) {
+					marked++
+				}
+			}
+			if marked != 3 || e.TestString(fps[5]) {
+				t.Errorf("%d keys suppressed, newest suppressed %v", marked, !e.TestString(fps[5]))
+			}
+		})
+	}
+}
+
+func TestExceptionFilterManyMarks(t *testing.T) {
+	for _, maxExceptions := range []int{1 << 20, 1000} {
+		t.Run(fmt.Sprintf("max_%d", maxExceptions), func(t *testing.T) {
+			// Marking takes constant time, so 50,000 marks finish quickly
+			// whether the list grows or evicts
+			e := exceptionFilters(maxExceptions)["Filter"]
+			fps := falsePositives(e, 50_000)
+			for _, key := range fps {
+				e.MarkFalsePositiveString(key)
+			}
+
+			want := min(len(fps), maxExceptions)
+			if e.Exceptions() != want {
+				t.Errorf("Exceptions() = %d, want %d", e.Exceptions(), want)
+			}
+			var suppressed int
+			for _, key := range fps {
+				if !e.TestString(key) {
+					suppressed++
+				}
+			}
+			if suppressed != want {
+				t.Errorf("%d keys suppressed, want %d", suppressed, want)
+			}
+
+			// Deleted slots are dropped when the table is rebuilt, so it
+			// stays proportional to the entries it holds
+			if size := len(e.table.Load().states); size > 8*want {
+				t.Errorf("table has %d slots for %d entries", size, want)
+			}
+		})
+	}
+}
+
+func TestExceptionFilterConcurrent(t *testing.T) {
+	e := exceptionFilters(50)["AtomicFilter"]
+	fps := falsePositives(e, 200)
+
+	const numGoroutines = 8
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for g := range numGoroutines {
+		go func(goroutineID int) {
+			defer wg.Done()
+			for i := range 2000 {
+				key := fps[(goroutineID*31+i)%len(fps)]
+				switch i % 4 {
+				case 0:
+					e.MarkFalsePositiveString(key)
+				case 1:
+					e.AddString(fmt.Sprintf("g%d-item-%d", goroutineID, i))
+				default:
+					e.TestString(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if e.Exceptions() != 50 {
+		t.Errorf("Exceptions() = %d", e.Exceptions())
+	}
+	for key := range keySeq(1000) {
+		if !e.Test(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+}
+
+func TestExceptionFilterSerialize(t *testing.T) {
+	for name, original := range exceptionFilters(20) {
+		t.Run(name, func(t *testing.T) {
+			fps := falsePositives(original, 30)
+			for _, key := range fps {
+				original.MarkFalsePositiveString(key)
+			}
+
+			data, err := original.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary failed: %v", err)
+			}
+			if data[0] != serializeExceptionVersion {
+				t.Errorf("got version %d", data[0])
+			}
+
+			restored, err := UnmarshalExceptionBinary(data)
+			if err != nil {
+				t.Fatalf("UnmarshalExceptionBinary failed: %v", err)
+			}
+			if _, isAtomic := restored.base.(*AtomicFilter); isAtomic != (name == "AtomicFilter") {
+				t.Errorf("restored filter is a %T", restored.base)
+			}
+			if restored.Exceptions() != 20 || restored.MaxExceptions() != 20 {
+				t.Errorf("got Exceptions() = %d, MaxExceptions() = %d", restored.Exceptions(), restored.MaxExceptions())
+			}
+			for _, key := range fps {
+				if restored.TestString(key) != original.TestString(key) {
+					t.Fatalf("TestString(%q) differs after roundtrip", key)
+				}
+			}
+			for key := range keySeq(1000) {
+				if !restored.Test(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+
+			again, err := restored.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary failed: %v", err)
+			}
+			if !bytes.Equal(again, data) {
+				t.Error("second roundtrip produced different bytes")
+			}
+
+			// The formats of Filter and ExceptionFilter are not interchangeable
+			if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+				t.Errorf("UnmarshalBinary of an ExceptionFilter: expected ErrUnsupportedVersion, got %v", err)
+			}
+			filterData, _ := NewWithParams(20, 7).MarshalBinary()
+			if _, err := UnmarshalExceptionBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+				t.Errorf("UnmarshalExceptionBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExceptionFilterSerializeInvalid(t *testing.T) {
+	e := exceptionFilters(10)["Filter"]
+	fps := falsePositives(e, 3)
+	for _, key := range fps {
+		e.MarkFalsePositiveString(key)
+	}
+	data, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	filterSize := int(binary.LittleEndian.Uint64(data[18:26]))
+	exceptions := exceptionHeaderSize + filterSize
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:exceptionHeaderSize-1]},
+		{"truncated exception", data[:len(data)-1]},
+		{"missing exception", data[:len(data)-8]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"bad atomic flag", corrupt(func(b []byte) { b[1] = 2 })},
+		{"zero bound", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[2:10], 0) })},
+		{"too many exceptions", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[2:10], 2) })},
+		{"filter too long", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[18:26], math.MaxUint64) })},
+		{"repeated exception", corrupt(func(b []byte) { copy(b[exceptions+8:], b[exceptions:exceptions+8]) })},
+		{"corrupted filter", corrupt(func(b []byte) {
+			binary.LittleEndian.PutUint64(b[exceptionHeaderSize+5:], 0) // NumBlocks
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalExceptionBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalExceptionBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package gloom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	// fuseMaxSegmentLength caps the segment length of a fuse layout. Longer
+	// segments barely improve the construction success rate.
+	fuseMaxSegmentLength = 1 << 18
+
+	// fuseMaxAttempts is the number of seeds construction tries before giving
+	// up. With distinct keys each attempt fails with probability under 1%,
+	// so running out means the input is pathological.
+	fuseMaxAttempts = 100
+
+	// fuseSeed seeds the sequence of per-attempt seeds, so building the same
+	// keys always gives the same structure.
+	fuseSeed = 0x676c6f6f6d // "gloom"
+)
+
+// ErrConstructionFailed is returned when a StaticFilter or BloomierFilter
+// cannot be built from its keys.
+var ErrConstructionFailed = errors.New("gloom: filter construction failed")
+
+// fuseLayout maps keys to three slots of an array, as in a binary fuse
+// filter. StaticFilter and BloomierFilter store different slot contents in
+// the same layout.
+type fuseLayout struct {
+	seed               uint64 // Seed mixed into every key hash
+	segmentLength      uint64 // Slots per segment, a power of 2
+	segmentCount       uint64 // Number of segments a key's first slot can be in
+	segmentCountLength uint64 // segmentCount * segmentLength
+}
+
+// newFuseLayout returns the layout for size distinct keys. The sizing follows
+// Graf and Lemire, "Binary Fuse Filters: Fast and Smaller Than Xor Filters"
+// (2022): segments shrink relative to the array as it grows, and small
+// arrays get extra slack so construction still succeeds.
+func newFuseLayout(size uint64) fuseLayout {
+	n := float64(max(size, 1))
+	segmentLength := min(uint64(1)<<int(math.Floor(math.Log(n)/math.Log(3.33)+2.25)), fuseMaxSegmentLength)
+
+	var capacity uint64
+	if size > 1 {
+		sizeFactor := max(1.125, 0.875+0.25*math.Log(1e6)/math.Log(n))
+		capacity = uint64(math.Round(n * sizeFactor))
+	}
+
+	// A key's slots span three consecutive segments, so its first slot can
+	// only be in the first segmentCount of them
+	segmentCount := uint64(1)
+	if total := (capacity + segmentLength - 1) / segmentLength; total > 2 {
+		segmentCount = total - 2
+	}
+	return makeFuseLayout(0, segmentLength, segmentCount)
+}
+
+// makeFuseLayout returns the layout with the given parameters, as read from
+// a serialized header.
+func makeFuseLayout(seed, segmentLength, segmentCount uint64) fuseLayout {
+	return fuseLayout{
+		seed:               seed,
+		segmentLength:      segmentLength,
+		segmentCount:       segmentCount,
+		segmentCountLength: segmentCount * segmentLength,
+	}
+}
+
+// validateFuseLayout checks serialized layout parameters, returning an error
+// wrapping ErrInvalidData if they are out of range.
+func validateFuseLayout(segmentLength, segmentCount uint64) error {
+	if segmentLength == 0 || segmentLength > fuseMaxSegmentLength || segmentLength&(segmentLength-1) != 0 {
+		return fmt.Errorf("%w: segment length %d is not a power of 2 up to %d", ErrInvalidData, segmentLength, fuseMaxSegmentLength)
+	}
+	if segmentCount == 0 {
+		return fmt.Errorf("%w: segment count is 0", ErrInvalidData)
+	}
+	return nil
+}
+
+// numSlots returns the number of slots in the array.
+func (l *fuseLayout) numSlots() uint64 {
+	return (l.segmentCount + 2) * l.segmentLength
+}
+
+// mix returns the mixed hash of a key's raw hash under the layout's seed.
+func (l *fuseLayout) mix(h uint64) uint64 {
+	return mix64(h + l.seed)
+}
+
+// slots returns the three slots of a mixed key hash: one in each of three
+// consecutive segments.
+func (l *fuseLayout) slots(h uint64) (h0, h1, h2 uint64) {
+	hi, _ := bits.Mul64(h, l.segmentCountLength)
+	mask := l.segmentLength - 1
+	h0 = hi
+	h1 = (h0 + l.segmentLength) ^ (h>>18)&mask
+	h2 = (h0 + 2*l.segmentLength) ^ h&mask
+	return h0, h1, h2
+}
+
+// fuseFingerprint returns the fingerprint of a mixed key hash, of which
+// only the low bits are stored.
+func fuseFingerprint(h uint64) uint64 {
+	return h ^ h>>32
+}
+
+// peel chooses a seed for which the keys with the given distinct raw hashes
+// can be assigned slots, trying at most maxAttempts seeds. It returns the
+// indices of the keys in peeling order and the position, 0 to 2, of each
+// one's own slot.
+//
+// Peeling works on the 3-hypergraph whose vertices are slots and whose edges
+// are keys: a slot used by a single remaining key is assigned to that key,
+// which is then removed, until every key has a slot of its own or none is
+// left. Filling slots in reverse peeling order then writes each key's own
+// slot after its other two are final.
+func (l *fuseLayout) peel(hashes []uint64, maxAttempts int) (order []uint64, positions []uint8, err error) {
+	numSlots := l.numSlots()
+
+	// For each slot, the number of keys using it times 4 plus the XOR of
+	// which of their three slots it is, and the XOR of their indices. A slot
+	// used by one key thus identifies the key and its position
+	counts := make([]uint32, numSlots)
+	xors := make([]uint64, numSlots)
+	queue := make([]uint64, 0, numSlots)
+	order = make([]uint64, 0, len(hashes))
+	positions = make([]uint8, 0, len(hashes))
+
+	state := uint64(fuseSeed)
+	for range maxAttempts {
+		l.seed = splitmix64(&state)
+		clear(counts)
+		clear(xors)
+		queue, order, positions = queue[:0], order[:0], positions[:0]
+
+		for i, h := range hashes {
+			h0, h1, h2 := l.slots(l.mix(h))
+			for pos, s := range [3]uint64{h0, h1, h2} {
+				counts[s] += 4
+				counts[s] ^= uint32(pos)
+				xors[s] ^= uint64(i)
+			}
+		}
+
+		for s := range numSlots {
+			if counts[s]>>2 == 1 {
+				queue = append(queue, s)
+			}
+		}
+		for len(queue) > 0 {
+			s := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			if counts[s]>>2 != 1 {
+				continue // Peeled since it was queued
+			}
+
+			i, pos := xors[s], uint8(counts[s]&3)
+			order = append(order, i)
+			positions = append(positions, pos)
+
+			// Remove the key from its other two slots
+			h0, h1, h2 := l.slots(l.mix(hashes[i]))
+			slots := [3]uint64{h0, h1, h2}
+			for _, other := range [2]uint8{(pos + 1) % 3, (pos + 2) % 3} {
+				o := slots[other]
+				counts[o] -= 4
+				counts[o] ^= uint32(other)
+				xors[o] ^= i
+				if counts[o]>>2 == 1 {
+					queue = append(queue, o)
+				}
+			}
+		}
+		if len(order) == len(hashes) {
+			return order, positions, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: %d keys did not fit after %d attempts", ErrConstructionFailed, len(hashes), maxAttempts)
+}
@@ -0,0 +1,384 @@
+package gloom
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// MaxGCSRiceBits is the largest Rice parameter a GCS supports, for a
+	// false positive rate of 2^-32.
+	MaxGCSRiceBits = 32
+
+	// serializeGCSVersion is the serialization format version of a GCS.
+	serializeGCSVersion byte = 8
+
+	// gcsHeaderSize is the size of the GCS serialization header in bytes:
+	// Version (1) + RiceBits (4) + Count (8) = 13 bytes.
+	gcsHeaderSize = 13
+
+	// gcsPadding is the number of zero bytes kept after the encoded stream,
+	// so the decoder can always load a whole word.
+	gcsPadding = 8
+
+	// gcsUnaryChunk is the number of unary bits the decoder consumes from
+	// one word. A word loaded at any bit offset holds at least 57 valid bits.
+	gcsUnaryChunk = 56
+)
+
+// GCS is a Golomb-coded set: an immutable, compressed set of key hashes for
+// sending read-only filters over the network. It is built once from a known
+// set of keys and needs about P+1.5 bits per key for a false positive rate
+// of 2^-P, where a Filter needs about 1.44*P bits and its serialized blocks
+// are padded to whole cache lines.
+//
+// Each key's hash is mapped to a value below n*2^P for n keys, and the
+// sorted values are stored as Golomb-Rice coded differences: the quotient of
+// a difference by 2^P in unary, and its remainder in P bits. Testing a key
+// decodes the stream from the start, so a single Test takes time linear in
+// the number of keys; TestMany checks many keys in one pass. A GCS is meant
+// to be compact in transit and on edge nodes that check keys in batches,
+// not to serve random lookups. It is safe for concurrent use, since it is
+// never modified.
+type GCS struct {
+	data     []byte // Encoded stream followed by gcsPadding zero bytes
+	count    uint64 // Number of distinct key hashes
+	riceBits uint32 // Rice parameter P
+	valueMax uint64 // count << riceBits, the range of hashed values
+}
+
+// BuildGCS builds a GCS from keys with a false positive rate of at most
+// fpRate, rounded down to a power of 2. An fpRate of 0 or below falls back
+// to 0.01%, and one of 1 or above uses a Rice parameter of 1. Duplicate keys
+// are counted once.
+func BuildGCS(keys [][]byte, fpRate float64) *GCS {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hashRaw(key)
+	}
+	return buildGCS(hashes, gcsRiceBits(fpRate))
+}
+
+// BuildGCSStrings builds a GCS from string keys without converting them to
+// byte slices. See BuildGCS.
+func BuildGCSStrings(keys []string, fpRate float64) *GCS {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hashRawString(key)
+	}
+	return buildGCS(hashes, gcsRiceBits(fpRate))
+}
+
+// BuildGCSSeq builds a GCS from every key in keys. Keys are hashed as they
+// are yielded, so the sequence is consumed once and may reuse the memory of
+// a yielded key. See BuildGCS.
+func BuildGCSSeq(keys iter.Seq[[]byte], fpRate float64) *GCS {
+	var hashes []uint64
+	for key := range keys {
+		hashes = append(hashes, hashRaw(key))
+	}
+	return buildGCS(hashes, gcsRiceBits(fpRate))
+}
+
+// gcsRiceBits returns the Rice parameter for a false positive rate: the
+// fewest bits P whose rate 2^-P is at most fpRate.
+func gcsRiceBits(fpRate float64) uint32 {
+	if fpRate <= 0 {
+		fpRate = 0.0001 // default to 0.01%
+	}
+	return uint32(min(max(math.Ceil(-math.Log2(fpRate)), 1), MaxGCSRiceBits))
+}
+
+// buildGCS builds a GCS from key hashes, which it may reorder.
+func buildGCS(hashes []uint64, riceBits uint32) *GCS {
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+
+	g := &GCS{count: uint64(len(hashes)), riceBits: riceBits}
+	g.valueMax = g.count << riceBits
+
+	// Mapping hashes to values preserves their order, so the values are
+	// already sorted
+	var w gcsWriter
+	var prev uint64
+	for _, h := range hashes {
+		v := g.value(h)
+		w.writeRice(v-prev, riceBits)
+		prev = v
+	}
+	g.data = w.bytes()
+	return g
+}
+
+// value maps a raw key hash to its value below valueMax.
+func (g *GCS) value(h uint64) uint64 {
+	v, _ := bits.Mul64(h, g.valueMax)
+	return v
+}
+
+// gcsWriter appends bits to a stream, least significant bit first.
+type gcsWriter struct {
+	words []uint64
+	n     uint64 // Number of bits written
+}
+
+// writeBits appends the low nbits bits of v, where nbits is 1 to 64.
+func (w *gcsWriter) writeBits(v uint64, nbits uint32) {
+	if nbits < 64 {
+		v &= 1<<nbits - 1
+	}
+	off := w.n % 64
+	if off == 0 {
+		w.words = append(w.words, 0)
+	}
+	w.words[len(w.words)-1] |= v << off
+	if off+uint64(nbits) > 64 {
+		w.words = append(w.words, v>>(64-off))
+	}
+	w.n += uint64(nbits)
+}
+
+// writeRice appends v as a unary quotient, v>>riceBits one bits followed by
+// a zero bit, and a riceBits-bit remainder.
+func (w *gcsWriter) writeRice(v uint64, riceBits uint32) {
+	for q := v >> riceBits; ; q -= 64 {
+		if q < 64 {
+			w.writeBits(1<<q-1, uint32(q)+1)
+			break
+		}
+		w.writeBits(math.MaxUint64, 64)
+	}
+	w.writeBits(v, riceBits)
+}
+
+// bytes returns the stream as bytes followed by gcsPadding zero bytes.
+func (w *gcsWriter) bytes() []byte {
+	data := make([]byte, (w.n+7)/8+gcsPadding)
+	for i, word := range w.words {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], word)
+		copy(data[i*8:len(data)-gcsPadding], buf[:])
+	}
+	return data
+}
+
+// gcsReader decodes a stream written by gcsWriter.
+type gcsReader struct {
+	data []byte // Stream followed by gcsPadding zero bytes
+	pos  uint64 // Bit position of the next read
+}
+
+// peek returns at least 57 bits of the stream from the current position.
+func (r *gcsReader) peek() uint64 {
+	return binary.LittleEndian.Uint64(r.data[r.pos/8:]) >> (r.pos % 8)
+}
+
+// readUnary reads a unary quotient.
+func (r *gcsReader) readUnary() uint64 {
+	var q uint64
+	for {
+		ones := uint64(bits.TrailingZeros64(^r.peek()))
+		if ones < gcsUnaryChunk {
+			r.pos += ones + 1
+			return q + ones
+		}
+		q += gcsUnaryChunk
+		r.pos += gcsUnaryChunk
+	}
+}
+
+// readBits reads an nbits-bit remainder, where nbits is at most 32.
+func (r *gcsReader) readBits(nbits uint32) uint64 {
+	v := r.peek() & (1<<nbits - 1)
+	r.pos += uint64(nbits)
+	return v
+}
+
+// Test checks if data might be in the set by decoding the set up to its
+// value. Returns true if the item might be present, false if definitely
+// absent.
+func (g *GCS) Test(data []byte) bool {
+	return g.testWithHash(hashRaw(data))
+}
+
+// TestString checks if a string might be in the set without allocating.
+func (g *GCS) TestString(s string) bool {
+	return g.testWithHash(hashRawString(s))
+}
+
+// testWithHash checks a key using its pre-computed raw hash.
+func (g *GCS) testWithHash(h uint64) bool {
+	target := g.value(h)
+	r := gcsReader{data: g.data}
+	var v uint64
+	for range g.count {
+		v += r.readUnary()<<g.riceBits | r.readBits(g.riceBits)
+		if v >= target {
+			return v == target
+		}
+	}
+	return false
+}
+
+// gcsQuery is a key's value and its index in a TestMany call.
+type gcsQuery struct {
+	value uint64
+	index int
+}
+
+// TestMany checks every key in keys and stores the result for keys[i] in
+// results[i]. results must be at least as long as keys.
+//
+// The keys' values are sorted and merged with the set in a single decoding
+// pass, so checking many keys costs little more than checking one.
+func (g *GCS) TestMany(keys [][]byte, results []bool) {
+	queries := make([]gcsQuery, len(keys))
+	for i, key := range keys {
+		queries[i] = gcsQuery{g.value(hashRaw(key)), i}
+	}
+	g.testQueries(queries, results[:len(keys)])
+}
+
+// TestManyStrings checks every string in keys and stores the result for
+// keys[i] in results[i]. results must be at least as long as keys. See
+// TestMany.
+func (g *GCS) TestManyStrings(keys []string, results []bool) {
+	queries := make([]gcsQuery, len(keys))
+	for i, key := range keys {
+		queries[i] = gcsQuery{g.value(hashRawString(key)), i}
+	}
+	g.testQueries(queries, results[:len(keys)])
+}
+
+// testQueries merges queries with the set's values and stores each query's
+// result at its index.
+func (g *GCS) testQueries(queries []gcsQuery, results []bool) {
+	slices.SortFunc(queries, func(a, b gcsQuery) int {
+		return cmp.Compare(a.value, b.value)
+	})
+
+	r := gcsReader{data: g.data}
+	var v, decoded uint64
+	for _, q := range queries {
+		for decoded < g.count && (decoded == 0 || v < q.value) {
+			v += r.readUnary()<<g.riceBits | r.readBits(g.riceBits)
+			decoded++
+		}
+		results[q.index] = decoded > 0 && v == q.value
+	}
+}
+
+// Cap returns the size of the encoded set in bits, rounded up to a whole
+// byte.
+func (g *GCS) Cap() uint64 {
+	return uint64(len(g.data)-gcsPadding) * 8
+}
+
+// Count returns the number of distinct key hashes in the set.
+func (g *GCS) Count() uint64 {
+	return g.count
+}
+
+// RiceBits returns the set's Rice parameter P.
+func (g *GCS) RiceBits() uint32 {
+	return g.riceBits
+}
+
+// BitsPerItem returns the size of the encoded set in bits per distinct key.
+func (g *GCS) BitsPerItem() float64 {
+	if g.count == 0 {
+		return 0
+	}
+	return float64(g.Cap()) / float64(g.count)
+}
+
+// EstimatedFalsePositiveRate estimates the false positive rate of the set:
+// the probability that an absent key's value equals one of n values drawn
+// from n*2^P, slightly under 2^-P.
+func (g *GCS) EstimatedFalsePositiveRate() float64 {
+	if g.count == 0 {
+		return 0
+	}
+	return -math.Expm1(float64(g.count) * math.Log1p(-1/float64(g.valueMax)))
+}
+
+// MarshalBinary serializes the set to a byte slice.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 8
+//   - RiceBits (4 bytes): Rice parameter P, 1 to 32 (little-endian uint32)
+//   - Count (8 bytes): number of values (little-endian uint64)
+//   - Values (rest): the sorted values, each below Count<<RiceBits, as
+//     differences from the previous value. Each difference is written as
+//     difference>>RiceBits one bits, a zero bit, and its low RiceBits bits,
+//     least significant first. Bits are packed into bytes from the least
+//     significant bit, and the last byte is padded with zero bits.
+func (g *GCS) MarshalBinary() ([]byte, error) {
+	stream := g.data[:len(g.data)-gcsPadding]
+	buf := make([]byte, gcsHeaderSize+len(stream))
+
+	buf[0] = serializeGCSVersion
+	binary.LittleEndian.PutUint32(buf[1:5], g.riceBits)
+	binary.LittleEndian.PutUint64(buf[5:13], g.count)
+	copy(buf[gcsHeaderSize:], stream)
+
+	return buf, nil
+}
+
+// UnmarshalGCSBinary deserializes a set from a byte slice written by
+// GCS.MarshalBinary. The whole stream is decoded to validate it.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalGCSBinary(data []byte) (*GCS, error) {
+	if len(data) < gcsHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), gcsHeaderSize)
+	}
+	if version := data[0]; version != serializeGCSVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeGCSVersion)
+	}
+
+	riceBits := binary.LittleEndian.Uint32(data[1:5])
+	count := binary.LittleEndian.Uint64(data[5:13])
+	stream := data[gcsHeaderSize:]
+	numBits := uint64(len(stream)) * 8
+
+	if riceBits < 1 || riceBits > MaxGCSRiceBits {
+		return nil, fmt.Errorf("%w: Rice parameter %d out of range [1, %d]", ErrInvalidData, riceBits, MaxGCSRiceBits)
+	}
+	// Each value takes at least riceBits+1 bits, which also keeps
+	// count<<riceBits from overflowing
+	if count > numBits/(uint64(riceBits)+1) {
+		return nil, fmt.Errorf("%w: %d values cannot fit in %d bytes", ErrInvalidData, count, len(stream))
+	}
+
+	g := &GCS{
+		data:     append(slices.Clone(stream), make([]byte, gcsPadding)...),
+		count:    count,
+		riceBits: riceBits,
+		valueMax: count << riceBits,
+	}
+
+	// Reads stay within the padding as long as each one starts within the
+	// stream, so it is enough to check the position after each read
+	r := gcsReader{data: g.data}
+	var v uint64
+	for i := range count {
+		q := r.readUnary()
+		if r.pos > numBits || q > g.valueMax>>riceBits {
+			return nil, fmt.Errorf("%w: value %d is corrupted", ErrInvalidData, i)
+		}
+		v += q<<riceBits | r.readBits(riceBits)
+		if r.pos > numBits || v >= g.valueMax {
+			return nil, fmt.Errorf("%w: value %d is corrupted", ErrInvalidData, i)
+		}
+	}
+	if (numBits-r.pos)/8 != 0 || (numBits > r.pos && stream[len(stream)-1]>>(8-(numBits-r.pos)) != 0) {
+		return nil, fmt.Errorf("%w: data after the last value", ErrInvalidData)
+	}
+	return g, nil
+}
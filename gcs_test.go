@@ -0,0 +1,283 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestGCSBasic(t *testing.T) {
+	for _, fpRate := range []float64{0.01, 0.0001, 1e-9} {
+		t.Run(fmt.Sprintf("fpRate_%g", fpRate), func(t *testing.T) {
+			keys := slices.Collect(keySeq(10000))
+			strs := make([]string, len(keys))
+			for i, key := range keys {
+				strs[i] = string(key)
+			}
+
+			g := BuildGCS(keys, fpRate)
+			for _, key := range strs[:500] {
+				if !g.Test([]byte(key)) || !g.TestString(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+			results := make([]bool, len(keys))
+			g.TestMany(keys, results)
+			for i, ok := range results {
+				if !ok {
+					t.Fatalf("TestMany: false negative for %q", keys[i])
+				}
+			}
+			clear(results)
+			g.TestManyStrings(strs, results)
+			for i, ok := range results {
+				if !ok {
+					t.Fatalf("TestManyStrings: false negative for %q", strs[i])
+				}
+			}
+
+			riceBits := gcsRiceBits(fpRate)
+			if g.Count() != 10000 || g.RiceBits() != riceBits {
+				t.Errorf("got Count() = %d, RiceBits() = %d", g.Count(), g.RiceBits())
+			}
+			if g.BitsPerItem() != float64(g.Cap())/10000 {
+				t.Errorf("Cap() = %d, BitsPerItem() = %f", g.Cap(), g.BitsPerItem())
+			}
+			// Golomb-Rice coding of uniform values takes about P+1.5 bits each
+			if bpi := g.BitsPerItem(); bpi < float64(riceBits)+1 || bpi > float64(riceBits)+2 {
+				t.Errorf("BitsPerItem() = %f with Rice parameter %d", bpi, riceBits)
+			}
+			if fp := g.EstimatedFalsePositiveRate(); fp > fpRate || fp < math.Ldexp(1, -int(riceBits))*0.99 {
+				t.Errorf("EstimatedFalsePositiveRate() = %g", fp)
+			}
+
+			// Every constructor builds the same set
+			for _, other := range []*GCS{BuildGCSStrings(strs, fpRate), BuildGCSSeq(keySeq(len(keys)), fpRate)} {
+				if !bytes.Equal(other.data, g.data) {
+					t.Error("constructors built different sets")
+				}
+			}
+		})
+	}
+}
+
+func TestGCSRiceBits(t *testing.T) {
+	for _, tc := range []struct {
+		fpRate float64
+		want   uint32
+	}{
+		{0, 14},
+		{-1, 14},
+		{1, 1},
+		{2, 1},
+		{0.5, 1},
+		{0.3, 2},
+		{0.01, 7},
+		{1.0 / 1024, 10},
+		{1e-20, MaxGCSRiceBits},
+	} {
+		if got := gcsRiceBits(tc.fpRate); got != tc.want {
+			t.Errorf("gcsRiceBits(%g) = %d, want %d", tc.fpRate, got, tc.want)
+		}
+	}
+}
+
+func TestGCSTestMany(t *testing.T) {
+	g := BuildGCSSeq(keySeq(1000), 0.01)
+
+	// Unsorted present, absent, and repeated keys, and a longer results slice
+	var keys [][]byte
+	for i := range 3000 {
+		key := fmt.Appendf(nil, "build-%d", (i*7919)%2000)
+		keys = append(keys, key)
+	}
+	keys = append(keys, keys[0], keys[1])
+	results := make([]bool, len(keys)+5)
+	g.TestMany(keys, results)
+	for i, key := range keys {
+		if results[i] != g.Test(key) {
+			t.Fatalf("TestMany(%q) = %v, Test = %v", key, results[i], g.Test(key))
+		}
+	}
+
+	// A value of 0 is found both ways
+	h := []uint64{0, 1 << 40, math.MaxUint64}
+	z := buildGCS(slices.Clone(h), 4)
+	res := make([]bool, 3)
+	z.testQueries([]gcsQuery{{0, 0}, {1, 1}, {z.value(math.MaxUint64), 2}}, res)
+	if !z.testWithHash(0) || !res[0] || res[1] || !res[2] {
+		t.Errorf("value 0: testWithHash = %v, testQueries = %v", z.testWithHash(0), res)
+	}
+}
+
+func TestGCSLongQuotients(t *testing.T) {
+	// Hashes at both ends of the range make a quotient of hundreds of bits,
+	// longer than a decoder word
+	hashes := []uint64{0, math.MaxUint64}
+	for i := range 300 {
+		hashes = append(hashes, uint64(i))
+	}
+	g := buildGCS(slices.Clone(hashes), 1)
+	for _, h := range hashes {
+		if !g.testWithHash(h) {
+			t.Fatalf("false negative for hash %#x", h)
+		}
+	}
+	data, _ := g.MarshalBinary()
+	if _, err := UnmarshalGCSBinary(data); err != nil {
+		t.Errorf("UnmarshalGCSBinary failed: %v", err)
+	}
+}
+
+func TestGCSEmpty(t *testing.T) {
+	g := BuildGCS(nil, 0.01)
+	results := []bool{true}
+	g.TestManyStrings([]string{"a"}, results)
+	if g.Count() != 0 || g.Cap() != 0 || g.BitsPerItem() != 0 || g.EstimatedFalsePositiveRate() != 0 || g.TestString("a") || results[0] {
+		t.Errorf("empty set: Count() = %d, Cap() = %d, results = %v", g.Count(), g.Cap(), results)
+	}
+	data, _ := g.MarshalBinary()
+	if restored, err := UnmarshalGCSBinary(data); err != nil || restored.Count() != 0 {
+		t.Errorf("UnmarshalGCSBinary of an empty set: %v", err)
+	}
+}
+
+func TestGCSSizeComparison(t *testing.T) {
+	const n = 100_000
+	for _, fpRate := range []float64{1.0 / 128, 1.0 / 1024, 1.0 / 65536} {
+		g := BuildGCSSeq(keySeq(n), fpRate)
+		f := New(n, fpRate)
+		for key := range keySeq(n) {
+			f.Add(key)
+		}
+
+		gcsData, _ := g.MarshalBinary()
+		filterData, _ := f.MarshalBinary()
+		ratio := float64(len(gcsData)) / float64(len(filterData))
+		t.Logf("fpRate %g: GCS %d bytes, Filter %d bytes (%.2fx)", fpRate, len(gcsData), len(filterData), ratio)
+		if ratio > 0.9 {
+			t.Errorf("fpRate %g: GCS is %.2fx the size of the Filter", fpRate, ratio)
+		}
+	}
+}
+
+func TestGCSSerialize(t *testing.T) {
+	original := BuildGCSSeq(keySeq(5000), 0.001)
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if data[0] != serializeGCSVersion || len(data) != gcsHeaderSize+int(original.Cap()/8) {
+		t.Errorf("got version %d and %d bytes", data[0], len(data))
+	}
+
+	restored, err := UnmarshalGCSBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalGCSBinary failed: %v", err)
+	}
+	if restored.RiceBits() != 10 || restored.Count() != 5000 {
+		t.Errorf("params mismatch: got (%d, %d)", restored.RiceBits(), restored.Count())
+	}
+	for key := range keySeq(5000) {
+		if !restored.Test(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	again, err := restored.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("second roundtrip produced different bytes")
+	}
+
+	// The formats of Filter and GCS are not interchangeable
+	if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBinary of a GCS: expected ErrUnsupportedVersion, got %v", err)
+	}
+	filterData, _ := NewWithParams(20, 7).MarshalBinary()
+	if _, err := UnmarshalGCSBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalGCSBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestGCSSerializeInvalid(t *testing.T) {
+	// A set whose stream does not end on a byte, so it has padding bits
+	var data []byte
+	for n := 100; data == nil; n++ {
+		g := BuildGCSSeq(keySeq(n), 1.0/128)
+		r := gcsReader{data: g.data}
+		for range g.count {
+			r.readUnary()
+			r.readBits(g.riceBits)
+		}
+		if r.pos%8 != 0 {
+			data, _ = g.MarshalBinary()
+		}
+	}
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	header := func(riceBits uint32, count uint64, stream ...byte) []byte {
+		b := make([]byte, gcsHeaderSize, gcsHeaderSize+len(stream))
+		b[0] = serializeGCSVersion
+		binary.LittleEndian.PutUint32(b[1:5], riceBits)
+		binary.LittleEndian.PutUint64(b[5:13], count)
+		return append(b, stream...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:gcsHeaderSize-1]},
+		{"truncated values", data[:len(data)-1]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"padding bits set", corrupt(func(b []byte) { b[len(b)-1] |= 0x80 })},
+		{"zero Rice parameter", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], 0) })},
+		{"Rice parameter too large", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], MaxGCSRiceBits+1) })},
+		{"too many values", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], math.MaxUint64) })},
+		{"unterminated quotient", header(1, 2, 0xff)},
+		{"quotient too large", header(1, 1, 0b011)},
+		{"value too large", header(1, 1, 0b101)},
+		{"remainder past end", header(1, 3, 0b01110000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalGCSBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalGCSBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestGCSFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	// Each Test decodes the set, so measure a small one
+	for _, fpRate := range []float64{1.0 / 16, 1.0 / 256} {
+		t.Run(fmt.Sprintf("fpRate_%g", fpRate), func(t *testing.T) {
+			g := BuildGCSSeq(keySeq(1000), fpRate)
+			m := MeasureFalsePositiveRate(g, 200_000)
+			if est := g.EstimatedFalsePositiveRate(); !m.Contains(est) {
+				t.Errorf("measured %g [%g, %g], estimated %g", m.Rate, m.Lower, m.Upper, est)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ module github.com/jcalabro/gloom
 
 go 1.23
 
-require github.com/zeebo/xxh3 v1.0.2
-
-require github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+require (
+	github.com/klauspost/cpuid/v2 v2.0.9
+	github.com/zeebo/xxh3 v1.0.2
+)
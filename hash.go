@@ -44,3 +44,25 @@ func hashSplitSharded(h uint64, numBlocks uint64) (blockIdx uint64, intraHash ui
 	intraHash = uint32(h)
 	return
 }
+
+// splitmix64 advances a splitmix64 generator and returns its next output.
+// It seeds the package's pattern tables and false positive probes, whose
+// sequences must never change.
+func splitmix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// mix64 is the murmur3 64-bit finalizer, which makes every input bit affect
+// every output bit. It is a bijection, so distinct inputs stay distinct.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,344 @@
+package gloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// DefaultIBLTKeySize is the key size an IBLT falls back to when given an
+	// invalid one, enough for a SHA-256 digest.
+	DefaultIBLTKeySize = 32
+
+	// ibltHashes is the number of cells each key is stored in, one in each
+	// third of the table.
+	ibltHashes = 3
+
+	// ibltCellsPerKey and ibltSlack size a table for a difference of d keys
+	// as ibltCellsPerKey*d + ibltSlack*(sqrt(d)+1) cells, fitted to keep the
+	// measured listing failure rate under 1%.
+	ibltCellsPerKey = 1.23
+	ibltSlack       = 12
+
+	// serializeIBLTVersion is the serialization format version of an IBLT.
+	serializeIBLTVersion byte = 7
+
+	// ibltHeaderSize is the size of the IBLT serialization header in bytes:
+	// Version (1) + KeySize (4) + NumCells (8) = 13 bytes.
+	ibltHeaderSize = 13
+)
+
+var (
+	// ErrKeyTooLong is returned when a key is longer than an IBLT's key size.
+	ErrKeyTooLong = errors.New("gloom: key longer than IBLT key size")
+
+	// ErrIncompatibleIBLT is returned when subtracting an IBLT with a
+	// different number of cells or key size.
+	ErrIncompatibleIBLT = errors.New("gloom: incompatible IBLT")
+
+	// ErrIncompleteListing is returned when an IBLT holds too many entries to
+	// list them all.
+	ErrIncompleteListing = errors.New("gloom: IBLT entries could not all be listed")
+)
+
+// IBLT is an invertible Bloom lookup table for set reconciliation. Like a
+// counting bloom filter, each key is added to several cells, but each cell
+// also holds the XOR of its keys and of their hashes, so a cell holding a
+// single key gives the key back.
+//
+// To find the keys that differ between two replicas, each inserts its keys
+// into an IBLT of the same size, one sends its IBLT to the other, and the
+// receiver subtracts it from its own. Keys in both cancel out, and
+// ListEntries recovers the rest, as long as there are not many more of them
+// than the table was sized for with OptimalIBLTCells. The table's size
+// depends on the size of the difference, not of the sets.
+//
+// Keys are byte strings of at most KeySize bytes, and each key should be
+// inserted at most once. An IBLT is not safe for concurrent use.
+type IBLT struct {
+	counts   []int64  // Inserted minus deleted keys per cell
+	hashSums []uint64 // XOR of the xxh3 hashes of each cell's keys
+	keySums  []byte   // XOR of each cell's keys, zero-padded to keySize bytes
+	keySize  int      // Maximum key length in bytes
+	cellsPer uint64   // Cells per third of the table
+}
+
+// NewIBLT creates an IBLT with at least numCells cells, for keys of up to
+// keySize bytes. The number of cells is rounded up to a multiple of 3, and a
+// keySize below 1 falls back to DefaultIBLTKeySize.
+func NewIBLT(numCells uint64, keySize int) *IBLT {
+	if keySize < 1 {
+		keySize = DefaultIBLTKeySize
+	}
+	cellsPer := max((numCells+ibltHashes-1)/ibltHashes, 1)
+	n := cellsPer * ibltHashes
+	return &IBLT{
+		counts:   make([]int64, n),
+		hashSums: make([]uint64, n),
+		keySums:  make([]byte, n*uint64(keySize)),
+		keySize:  keySize,
+		cellsPer: cellsPer,
+	}
+}
+
+// OptimalIBLTCells returns the number of cells an IBLT needs to list a
+// difference of expectedDiff keys with a failure probability below 1%.
+//
+// Large tables need about 1.23 cells per key, the threshold at which peeling
+// a random 3-hypergraph succeeds. Small ones need proportionally more slack,
+// since a few keys can easily cover each other's cells, so the result adds
+// cells in proportion to the square root of the difference.
+func OptimalIBLTCells(expectedDiff uint64) uint64 {
+	d := float64(expectedDiff)
+	cells := uint64(math.Ceil(ibltCellsPerKey*d + ibltSlack*(math.Sqrt(d)+1)))
+	return (cells + ibltHashes - 1) / ibltHashes * ibltHashes
+}
+
+// cell returns the cell of the key with raw hash h in third j of the table.
+func (t *IBLT) cell(h uint64, j int) uint64 {
+	i, _ := bits.Mul64(mix64(h+uint64(j)*0x9e3779b97f4a7c15), t.cellsPer)
+	return uint64(j)*t.cellsPer + i
+}
+
+// ibltUpdate adds delta to the count of each of the key's cells and XORs the
+// key and its hash into them.
+func ibltUpdate[K []byte | string](t *IBLT, key K, h uint64, delta int64) {
+	for j := range ibltHashes {
+		c := t.cell(h, j)
+		t.counts[c] += delta
+		t.hashSums[c] ^= h
+		sum := t.keySums[c*uint64(t.keySize):]
+		for i := range len(key) {
+			sum[i] ^= key[i]
+		}
+	}
+}
+
+// Insert adds key to the table. It returns ErrKeyTooLong if the key is
+// longer than KeySize bytes.
+func (t *IBLT) Insert(key []byte) error {
+	if len(key) > t.keySize {
+		return t.errKeyTooLong(len(key))
+	}
+	ibltUpdate(t, key, hashRaw(key), 1)
+	return nil
+}
+
+// InsertString adds a string key to the table without converting it to a
+// byte slice. See Insert.
+func (t *IBLT) InsertString(key string) error {
+	if len(key) > t.keySize {
+		return t.errKeyTooLong(len(key))
+	}
+	ibltUpdate(t, key, hashRawString(key), 1)
+	return nil
+}
+
+// Delete removes key from the table. Deleting a key that was never inserted
+// records it as a negative entry, which ListEntries reports as deleted. It
+// returns ErrKeyTooLong if the key is longer than KeySize bytes.
+func (t *IBLT) Delete(key []byte) error {
+	if len(key) > t.keySize {
+		return t.errKeyTooLong(len(key))
+	}
+	ibltUpdate(t, key, hashRaw(key), -1)
+	return nil
+}
+
+// DeleteString removes a string key from the table without converting it to
+// a byte slice. See Delete.
+func (t *IBLT) DeleteString(key string) error {
+	if len(key) > t.keySize {
+		return t.errKeyTooLong(len(key))
+	}
+	ibltUpdate(t, key, hashRawString(key), -1)
+	return nil
+}
+
+// errKeyTooLong returns the error for a key of n bytes.
+func (t *IBLT) errKeyTooLong(n int) error {
+	return fmt.Errorf("%w: got %d bytes, key size is %d", ErrKeyTooLong, n, t.keySize)
+}
+
+// Subtract removes the entries of other from t, cell by cell, so that t
+// holds the keys inserted into t but not other as inserted entries and the
+// keys inserted into other but not t as deleted ones. Both tables must have
+// the same number of cells and key size, or ErrIncompatibleIBLT is returned.
+func (t *IBLT) Subtract(other *IBLT) error {
+	if other.keySize != t.keySize || other.cellsPer != t.cellsPer {
+		return fmt.Errorf("%w: got %d cells with %d-byte keys, expected %d cells with %d-byte keys",
+			ErrIncompatibleIBLT, other.NumCells(), other.keySize, t.NumCells(), t.keySize)
+	}
+	for c := range t.counts {
+		t.counts[c] -= other.counts[c]
+		t.hashSums[c] ^= other.hashSums[c]
+	}
+	for i := range t.keySums {
+		t.keySums[i] ^= other.keySums[i]
+	}
+	return nil
+}
+
+// pureKey returns the key in cell c if the cell holds a single inserted or
+// deleted key. A key's length is not stored, so it is taken to be the
+// shortest length, with trailing zero bytes dropped, whose hash matches.
+func (t *IBLT) pureKey(c uint64) ([]byte, bool) {
+	if count := t.counts[c]; count != 1 && count != -1 {
+		return nil, false
+	}
+	sum := t.keySums[c*uint64(t.keySize) : (c+1)*uint64(t.keySize)]
+	n := len(sum)
+	for n > 0 && sum[n-1] == 0 {
+		n--
+	}
+	for ; n <= len(sum); n++ {
+		if hashRaw(sum[:n]) == t.hashSums[c] {
+			return sum[:n], true
+		}
+	}
+	return nil, false
+}
+
+// ListEntries recovers the entries of the table without modifying it: the
+// keys that were inserted more often than deleted, and those deleted more
+// often than inserted. After Subtract, these are the keys only in t and the
+// keys only in other.
+//
+// Listing repeatedly takes the key out of a cell holding a single key, which
+// may leave other cells with a single key. If too many keys remain for that
+// to empty the table, ListEntries returns the keys it recovered along with
+// ErrIncompleteListing.
+func (t *IBLT) ListEntries() (inserted, deleted [][]byte, err error) {
+	w := &IBLT{
+		counts:   slices.Clone(t.counts),
+		hashSums: slices.Clone(t.hashSums),
+		keySums:  slices.Clone(t.keySums),
+		keySize:  t.keySize,
+		cellsPer: t.cellsPer,
+	}
+
+	var queue []uint64
+	for c := range w.counts {
+		if w.counts[c] == 1 || w.counts[c] == -1 {
+			queue = append(queue, uint64(c))
+		}
+	}
+	for len(queue) > 0 {
+		c := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		key, ok := w.pureKey(c)
+		if !ok {
+			continue // Emptied since it was queued, or holds several keys
+		}
+
+		key = slices.Clone(key)
+		count := w.counts[c]
+		if count > 0 {
+			inserted = append(inserted, key)
+		} else {
+			deleted = append(deleted, key)
+		}
+
+		h := w.hashSums[c]
+		ibltUpdate(w, key, h, -count)
+		for j := range ibltHashes {
+			if o := w.cell(h, j); w.counts[o] == 1 || w.counts[o] == -1 {
+				queue = append(queue, o)
+			}
+		}
+	}
+
+	for c := range w.counts {
+		if w.counts[c] != 0 || w.hashSums[c] != 0 {
+			return inserted, deleted, fmt.Errorf("%w: recovered %d keys", ErrIncompleteListing, len(inserted)+len(deleted))
+		}
+	}
+	return inserted, deleted, nil
+}
+
+// NumCells returns the number of cells in the table.
+func (t *IBLT) NumCells() uint64 {
+	return uint64(len(t.counts))
+}
+
+// KeySize returns the maximum key length in bytes.
+func (t *IBLT) KeySize() int {
+	return t.keySize
+}
+
+// Cap returns the size of the table in bits.
+func (t *IBLT) Cap() uint64 {
+	return t.NumCells() * uint64(16+t.keySize) * 8
+}
+
+// MarshalBinary serializes the IBLT to a byte slice.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 7
+//   - KeySize (4 bytes): maximum key length in bytes (little-endian uint32)
+//   - NumCells (8 bytes): number of cells, a multiple of 3 (little-endian uint64)
+//   - Cells (numCells * (16+keySize) bytes): for each cell, the count
+//     (little-endian int64), the hash XOR (little-endian uint64), and the
+//     key XOR (keySize bytes)
+func (t *IBLT) MarshalBinary() ([]byte, error) {
+	cellSize := 16 + t.keySize
+	buf := make([]byte, ibltHeaderSize+len(t.counts)*cellSize)
+
+	buf[0] = serializeIBLTVersion
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(t.keySize))
+	binary.LittleEndian.PutUint64(buf[5:13], t.NumCells())
+
+	for c := range t.counts {
+		cell := buf[ibltHeaderSize+c*cellSize:]
+		binary.LittleEndian.PutUint64(cell[0:8], uint64(t.counts[c]))
+		binary.LittleEndian.PutUint64(cell[8:16], t.hashSums[c])
+		copy(cell[16:cellSize], t.keySums[c*t.keySize:])
+	}
+
+	return buf, nil
+}
+
+// UnmarshalIBLTBinary deserializes an IBLT from a byte slice written by
+// IBLT.MarshalBinary.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalIBLTBinary(data []byte) (*IBLT, error) {
+	if len(data) < ibltHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), ibltHeaderSize)
+	}
+	if version := data[0]; version != serializeIBLTVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeIBLTVersion)
+	}
+
+	keySize := uint64(binary.LittleEndian.Uint32(data[1:5]))
+	numCells := binary.LittleEndian.Uint64(data[5:13])
+
+	if keySize == 0 {
+		return nil, fmt.Errorf("%w: key size is 0", ErrInvalidData)
+	}
+	if numCells == 0 || numCells%ibltHashes != 0 {
+		return nil, fmt.Errorf("%w: cell count %d is not a positive multiple of %d", ErrInvalidData, numCells, ibltHashes)
+	}
+	// Check the cell count against the data before multiplying, so the
+	// expected length cannot overflow
+	cellSize := 16 + keySize
+	if numCells > uint64(len(data))/cellSize {
+		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes for %d cells)", ErrInvalidData, len(data), numCells)
+	}
+	if expected := ibltHeaderSize + numCells*cellSize; uint64(len(data)) != expected {
+		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes, expected %d)", ErrInvalidData, len(data), expected)
+	}
+
+	t := NewIBLT(numCells, int(keySize))
+	for c := range t.counts {
+		cell := data[ibltHeaderSize+uint64(c)*cellSize:]
+		t.counts[c] = int64(binary.LittleEndian.Uint64(cell[0:8]))
+		t.hashSums[c] = binary.LittleEndian.Uint64(cell[8:16])
+		copy(t.keySums[uint64(c)*keySize:], cell[16:cellSize])
+	}
+	return t, nil
+}
@@ -0,0 +1,312 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+// sortedStrings returns keys as sorted strings.
+func sortedStrings(keys [][]byte) []string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = string(key)
+	}
+	slices.Sort(strs)
+	return strs
+}
+
+func TestIBLTReconcile(t *testing.T) {
+	cells := OptimalIBLTCells(100)
+	a, b := NewIBLT(cells, 16), NewIBLT(cells, 16)
+
+	// 10,000 shared keys, 60 only in a and 40 only in b
+	var onlyA, onlyB []string
+	for i := range 10000 {
+		key := fmt.Sprintf("shared-%d", i)
+		if err := a.InsertString(key); err != nil {
+			t.Fatalf("InsertString failed: %v", err)
+		}
+		if err := b.Insert([]byte(key)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	for i := range 60 {
+		onlyA = append(onlyA, fmt.Sprintf("a-%d", i))
+		a.InsertString(onlyA[i])
+	}
+	for i := range 40 {
+		onlyB = append(onlyB, fmt.Sprintf("b-%d", i))
+		b.InsertString(onlyB[i])
+	}
+
+	if err := a.Subtract(b); err != nil {
+		t.Fatalf("Subtract failed: %v", err)
+	}
+	inserted, deleted, err := a.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	slices.Sort(onlyA)
+	slices.Sort(onlyB)
+	if got := sortedStrings(inserted); !slices.Equal(got, onlyA) {
+		t.Errorf("inserted = %v, want %v", got, onlyA)
+	}
+	if got := sortedStrings(deleted); !slices.Equal(got, onlyB) {
+		t.Errorf("deleted = %v, want %v", got, onlyB)
+	}
+
+	// Listing leaves the table alone
+	if again, _, err := a.ListEntries(); err != nil || len(again) != len(inserted) {
+		t.Errorf("second ListEntries got %d keys, err %v", len(again), err)
+	}
+}
+
+func TestIBLTInsertDelete(t *testing.T) {
+	tbl := NewIBLT(30, 8)
+	keys := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("12345678"),
+		{'x', 0},
+		{'x', 0, 0},
+		{0, 0, 0},
+	}
+	for _, key := range keys {
+		if err := tbl.Insert(key); err != nil {
+			t.Fatalf("Insert(%q) failed: %v", key, err)
+		}
+	}
+	if err := tbl.Delete([]byte("a")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := tbl.DeleteString("never"); err != nil {
+		t.Fatalf("DeleteString failed: %v", err)
+	}
+
+	inserted, deleted, err := tbl.ListEntries()
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+
+	// Keys with trailing zero bytes come back at their own length
+	want := []string{"", "12345678", "x\x00", "x\x00\x00", "\x00\x00\x00"}
+	slices.Sort(want)
+	if got := sortedStrings(inserted); !slices.Equal(got, want) {
+		t.Errorf("inserted = %q, want %q", got, want)
+	}
+	if got := sortedStrings(deleted); !slices.Equal(got, []string{"never"}) {
+		t.Errorf("deleted = %q, want [never]", got)
+	}
+
+	// Deleting every key empties the table
+	for _, key := range keys {
+		tbl.Delete(key)
+	}
+	tbl.InsertString("a")
+	tbl.InsertString("never")
+	if inserted, deleted, err := tbl.ListEntries(); err != nil || len(inserted)+len(deleted) != 0 {
+		t.Errorf("emptied table listed %q and %q, err %v", inserted, deleted, err)
+	}
+	for c := range tbl.counts {
+		if tbl.counts[c] != 0 || tbl.hashSums[c] != 0 {
+			t.Fatalf("cell %d not empty", c)
+		}
+	}
+}
+
+func TestIBLTKeyTooLong(t *testing.T) {
+	tbl := NewIBLT(12, 4)
+	for name, err := range map[string]error{
+		"Insert":       tbl.Insert([]byte("12345")),
+		"InsertString": tbl.InsertString("12345"),
+		"Delete":       tbl.Delete([]byte("12345")),
+		"DeleteString": tbl.DeleteString("12345"),
+	} {
+		if !errors.Is(err, ErrKeyTooLong) {
+			t.Errorf("%s: expected ErrKeyTooLong, got %v", name, err)
+		}
+	}
+	if !slices.Equal(tbl.counts, make([]int64, 12)) {
+		t.Error("rejected keys modified the table")
+	}
+}
+
+func TestIBLTParams(t *testing.T) {
+	tests := []struct {
+		numCells, wantCells uint64
+		keySize, wantSize   int
+	}{
+		{0, 3, 8, 8},
+		{10, 12, 1, 1},
+		{12, 12, 0, DefaultIBLTKeySize},
+		{100, 102, -1, DefaultIBLTKeySize},
+	}
+	for _, tt := range tests {
+		tbl := NewIBLT(tt.numCells, tt.keySize)
+		if tbl.NumCells() != tt.wantCells || tbl.KeySize() != tt.wantSize {
+			t.Errorf("NewIBLT(%d, %d): got %d cells, %d-byte keys", tt.numCells, tt.keySize, tbl.NumCells(), tbl.KeySize())
+		}
+		if tbl.Cap() != tbl.NumCells()*uint64(16+tbl.KeySize())*8 {
+			t.Errorf("Cap() = %d", tbl.Cap())
+		}
+	}
+
+	prev := uint64(0)
+	for _, d := range []uint64{0, 1, 2, 10, 100, 1000, 100000} {
+		cells := OptimalIBLTCells(d)
+		if cells%ibltHashes != 0 || cells <= prev || float64(cells) < 1.23*float64(d) {
+			t.Errorf("OptimalIBLTCells(%d) = %d", d, cells)
+		}
+		prev = cells
+	}
+	if ratio := float64(OptimalIBLTCells(1_000_000)) / 1_000_000; ratio > 1.25 {
+		t.Errorf("OptimalIBLTCells(1e6) uses %.3f cells per key", ratio)
+	}
+}
+
+func TestIBLTSubtractIncompatible(t *testing.T) {
+	tbl := NewIBLT(30, 8)
+	for _, other := range []*IBLT{NewIBLT(33, 8), NewIBLT(30, 9)} {
+		if err := tbl.Subtract(other); !errors.Is(err, ErrIncompatibleIBLT) {
+			t.Errorf("expected ErrIncompatibleIBLT, got %v", err)
+		}
+	}
+}
+
+func TestIBLTIncompleteListing(t *testing.T) {
+	// Far more keys than cells
+	tbl := NewIBLT(OptimalIBLTCells(10), 16)
+	for i := range 200 {
+		tbl.InsertString(fmt.Sprintf("over-%d", i))
+	}
+	inserted, deleted, err := tbl.ListEntries()
+	if !errors.Is(err, ErrIncompleteListing) {
+		t.Fatalf("expected ErrIncompleteListing, got %v", err)
+	}
+	if len(inserted) >= 200 || len(deleted) != 0 {
+		t.Errorf("got %d inserted and %d deleted keys", len(inserted), len(deleted))
+	}
+
+	// A cell whose count says it holds one key but whose hash matches no key
+	// is not listed
+	tbl = NewIBLT(3, 4)
+	tbl.counts[0], tbl.hashSums[0] = 1, 12345
+	if _, _, err := tbl.ListEntries(); !errors.Is(err, ErrIncompleteListing) {
+		t.Errorf("expected ErrIncompleteListing, got %v", err)
+	}
+}
+
+func TestIBLTSerialize(t *testing.T) {
+	original := NewIBLT(OptimalIBLTCells(20), 12)
+	for i := range 15 {
+		original.InsertString(fmt.Sprintf("item-%d", i))
+	}
+	original.DeleteString("gone")
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if data[0] != serializeIBLTVersion || len(data) != ibltHeaderSize+int(original.NumCells())*(16+12) {
+		t.Errorf("got version %d and %d bytes", data[0], len(data))
+	}
+
+	restored, err := UnmarshalIBLTBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalIBLTBinary failed: %v", err)
+	}
+	if restored.NumCells() != original.NumCells() || restored.KeySize() != 12 {
+		t.Errorf("params mismatch: got (%d, %d)", restored.NumCells(), restored.KeySize())
+	}
+	inserted, deleted, err := restored.ListEntries()
+	if err != nil || len(inserted) != 15 || len(deleted) != 1 || string(deleted[0]) != "gone" {
+		t.Errorf("restored table listed %d inserted and %q deleted, err %v", len(inserted), deleted, err)
+	}
+
+	again, err := restored.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("second roundtrip produced different bytes")
+	}
+
+	// The formats of Filter and IBLT are not interchangeable
+	if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBinary of an IBLT: expected ErrUnsupportedVersion, got %v", err)
+	}
+	filterData, _ := NewWithParams(20, 7).MarshalBinary()
+	if _, err := UnmarshalIBLTBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalIBLTBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestIBLTSerializeInvalid(t *testing.T) {
+	data, err := NewIBLT(9, 4).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:ibltHeaderSize-1]},
+		{"truncated cells", data[:len(data)-1]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"zero key size", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], 0) })},
+		{"wrong key size", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], 5) })},
+		{"zero cells", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], 0) })},
+		{"cells not multiple of 3", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], 8) })},
+		{"too many cells", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], math.MaxUint64) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalIBLTBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalIBLTBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestIBLTListingFailureRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	const trials = 500
+	for _, d := range []int{5, 50, 500} {
+		t.Run(fmt.Sprintf("diff_%d", d), func(t *testing.T) {
+			failures := 0
+			for trial := range trials {
+				tbl := NewIBLT(OptimalIBLTCells(uint64(d)), 16)
+				for i := range d {
+					tbl.InsertString(fmt.Sprintf("diff-%d-%d", trial, i))
+				}
+				if _, _, err := tbl.ListEntries(); err != nil {
+					failures++
+				}
+			}
+			// Sized for under 1%, so 3% allows for sampling noise
+			if failures > trials*3/100 {
+				t.Errorf("%d of %d listings failed", failures, trials)
+			}
+		})
+	}
+}
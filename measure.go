@@ -0,0 +1,143 @@
+package gloom
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// measureSeed seeds the probe keys of MeasureFalsePositiveRate, so every
+	// measurement of the same filter gives the same result.
+	measureSeed = 0x676c6f6f6d // "gloom"
+
+	// measureZ is the standard normal quantile of the 99% confidence
+	// interval reported by MeasureFalsePositiveRate.
+	measureZ = 2.5758293035489004
+)
+
+// Tester is implemented by every filter in this package.
+type Tester interface {
+	Test(data []byte) bool
+}
+
+// Measurement is an empirical false positive rate, as returned by
+// MeasureFalsePositiveRate.
+type Measurement struct {
+	Probes         uint64  // Number of absent keys tested
+	FalsePositives uint64  // Number of absent keys that tested positive
+	Rate           float64 // FalsePositives / Probes
+	Lower          float64 // Lower bound of the 99% confidence interval
+	Upper          float64 // Upper bound of the 99% confidence interval
+}
+
+// Contains reports whether rate lies within the measurement's 99% confidence
+// interval, for example to check a modeled rate against reality.
+func (m Measurement) Contains(rate float64) bool {
+	return rate >= m.Lower && rate <= m.Upper
+}
+
+// MeasureFalsePositiveRate tests probes keys that were never added to f and
+// reports how many tested positive.
+//
+// The probe keys are 16 pseudo-random bytes from a fixed sequence, so they
+// are absent from any filter not built from that sequence, and measuring the
+// same filter twice gives the same result. The confidence interval is the
+// Wilson score interval, which stays meaningful when few or no probes test
+// positive.
+func MeasureFalsePositiveRate(f Tester, probes uint64) Measurement {
+	m := Measurement{Probes: probes, Upper: 1}
+	if probes == 0 {
+		return m
+	}
+
+	var key [16]byte
+	state := uint64(measureSeed)
+	for range probes {
+		binary.LittleEndian.PutUint64(key[:8], splitmix64(&state))
+		binary.LittleEndian.PutUint64(key[8:], splitmix64(&state))
+		if f.Test(key[:]) {
+			m.FalsePositives++
+		}
+	}
+
+	n := float64(probes)
+	m.Rate = float64(m.FalsePositives) / n
+	z2 := measureZ * measureZ
+	center := (m.Rate + z2/(2*n)) / (1 + z2/n)
+	half := measureZ / (1 + z2/n) * math.Sqrt(m.Rate*(1-m.Rate)/n+z2/(4*n*n))
+	m.Lower = max(center-half, 0)
+	m.Upper = min(center+half, 1)
+	if m.FalsePositives == probes {
+		m.Upper = 1 // Exact, where the formula is off by rounding
+	}
+	return m
+}
+
+// AutoTune chooses the parameters of a Filter for expectedItems keys within
+// a memory budget of bytes by measuring candidates rather than trusting the
+// model. sample is a representative sample of the real keys; if
+// expectedItems is 0, the sample is the whole set.
+//
+// Each candidate is a filter scaled down to the sample, with the same
+// number of items per block as the full filter, filled with the sample and
+// measured with probes absent keys. For 512-bit and 1024-bit blocks, the
+// search starts from the k EstimateBlockFalsePositiveRate predicts is best
+// and moves k up or down while the measured rate keeps falling. Measuring
+// catches what the model assumes away, such as duplicate keys, which set no
+// new bits and favor a larger k.
+//
+// It returns the plan for the full filter with the best measured candidate
+// and that candidate's measurement. As in PlanForItemsInMemory, 1024-bit
+// blocks are chosen only when they measure less than half the rate of
+// 512-bit blocks. With an empty sample there is nothing to measure, and it
+// returns PlanForItemsInMemory's plan and an empty measurement.
+func AutoTune(sample [][]byte, expectedItems, bytes, probes uint64) (Plan, Measurement) {
+	if expectedItems == 0 {
+		expectedItems = uint64(len(sample))
+	}
+	if len(sample) == 0 {
+		return PlanForItemsInMemory(expectedItems, bytes), Measurement{Upper: 1}
+	}
+	scale := float64(len(sample)) / float64(expectedItems)
+
+	var best [2]struct {
+		plan Plan
+		m    Measurement
+	}
+	for i, blockBits := range []uint32{BlockBits, MaxBlockBits} {
+		numBlocks := budgetBlocks(bytes, blockBits)
+		sampleBlocks := max(uint64(math.Round(float64(numBlocks)*scale)), 1)
+		modelK, _ := bestPlanK(numBlocks, blockBits, expectedItems)
+
+		measure := func(k uint32) Measurement {
+			f := NewWithBlockParams(sampleBlocks, k, blockBits)
+			for _, key := range sample {
+				f.Add(key)
+			}
+			return MeasureFalsePositiveRate(f, probes)
+		}
+
+		// Walk k from the model's choice while the measured rate keeps
+		// falling, trying larger k first. Ties keep the model's choice
+		bestK, bestM := modelK, measure(modelK)
+		for _, step := range []int{1, -1} {
+			moved := false
+			for k := int(bestK) + step; k >= MinK && k <= int(MaxKForBlockBits(blockBits)); k += step {
+				m := measure(uint32(k))
+				if m.FalsePositives >= bestM.FalsePositives {
+					break
+				}
+				bestK, bestM, moved = uint32(k), m, true
+			}
+			if moved {
+				break
+			}
+		}
+		best[i].plan, best[i].m = newPlan(numBlocks, bestK, blockBits, expectedItems), bestM
+	}
+
+	if best[1].m.FalsePositives*2 < best[0].m.FalsePositives {
+		return best[1].plan, best[1].m
+	}
+	return best[0].plan, best[0].m
+}
@@ -0,0 +1,218 @@
+package gloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Every filter can be measured
+var (
+	_ Tester = (*Filter)(nil)
+	_ Tester = (*AtomicFilter)(nil)
+	_ Tester = (*ShardedAtomicFilter)(nil)
+	_ Tester = (*RegisterFilter)(nil)
+	_ Tester = (*AtomicRegisterFilter)(nil)
+	_ Tester = (*TwoChoiceFilter)(nil)
+	_ Tester = (*PatternFilter)(nil)
+	_ Tester = (*CuckooFilter)(nil)
+	_ Tester = (*LockedCuckooFilter)(nil)
+	_ Tester = (*StaticFilter)(nil)
+	_ Tester = (*BloomierFilter)(nil)
+	_ Tester = (*GCS)(nil)
+	_ Tester = (*StableFilter)(nil)
+	_ Tester = (*AtomicStableFilter)(nil)
+	_ Tester = (*DeletableFilter)(nil)
+	_ Tester = (*ExceptionFilter)(nil)
+	_ Tester = (*PrefixFilter)(nil)
+)
+
+func TestMeasureFalsePositiveRate(t *testing.T) {
+	f := NewWithParams(1000, 7)
+
+	// No probe can match an empty filter
+	m := MeasureFalsePositiveRate(f, 10000)
+	if m.Probes != 10000 || m.FalsePositives != 0 || m.Rate != 0 || m.Lower != 0 {
+		t.Errorf("empty filter: got %+v", m)
+	}
+	if m.Upper <= 0 || m.Upper > 0.001 {
+		t.Errorf("empty filter: upper bound %g", m.Upper)
+	}
+
+	for i := range 50000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+	m = MeasureFalsePositiveRate(f, 100000)
+	if m.FalsePositives == 0 || m.Rate != float64(m.FalsePositives)/100000 {
+		t.Errorf("filled filter: got %+v", m)
+	}
+	if !m.Contains(m.Rate) || m.Contains(m.Lower/2) || m.Contains(min(2*m.Upper, 1)) {
+		t.Errorf("interval [%g, %g] inconsistent with rate %g", m.Lower, m.Upper, m.Rate)
+	}
+	if again := MeasureFalsePositiveRate(f, 100000); again != m {
+		t.Errorf("second measurement %+v differs from %+v", again, m)
+	}
+
+	// Without probes nothing is known
+	if m := MeasureFalsePositiveRate(f, 0); m != (Measurement{Upper: 1}) {
+		t.Errorf("zero probes: got %+v", m)
+	}
+
+	// A filter that matches everything
+	full := NewWithParams(1, 7)
+	for i := range 10000 {
+		full.AddString(fmt.Sprintf("item-%d", i))
+	}
+	if m := MeasureFalsePositiveRate(full, 1000); m.Rate != 1 || m.Upper != 1 || m.Lower >= 1 {
+		t.Errorf("full filter: got %+v", m)
+	}
+}
+
+func TestMeasureMatchesModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	const probes = 2_000_000
+	fill := func(f interface{ AddString(string) }, items int) {
+		for i := range items {
+			f.AddString(fmt.Sprintf("item-%d", i))
+		}
+	}
+
+	for _, tc := range []struct {
+		blockBits, k uint32
+		items        int
+	}{
+		{256, 5, 30000},
+		{512, 4, 40000},
+		{512, 7, 100000},
+		{512, 10, 60000},
+		{512, 14, 40000},
+		{1024, 12, 60000},
+		{1024, 16, 40000},
+		{1024, 20, 30000},
+	} {
+		t.Run(fmt.Sprintf("blockBits_%d/k_%d", tc.blockBits, tc.k), func(t *testing.T) {
+			f := NewWithBlockParams(2000*BlockBits/uint64(tc.blockBits), tc.k, tc.blockBits)
+			af := NewAtomicWithBlockParams(2000*BlockBits/uint64(tc.blockBits), tc.k, tc.blockBits)
+			fill(f, tc.items)
+			fill(af, tc.items)
+
+			for name, g := range map[string]interface {
+				Tester
+				EstimatedFalsePositiveRate() float64
+			}{"Filter": f, "AtomicFilter": af} {
+				m := MeasureFalsePositiveRate(g, probes)
+				if estimate := g.EstimatedFalsePositiveRate(); !m.Contains(estimate) {
+					t.Errorf("%s: estimate %.3g outside measured [%.3g, %.3g]", name, estimate, m.Lower, m.Upper)
+				}
+			}
+		})
+	}
+
+	t.Run("RegisterFilter", func(t *testing.T) {
+		f := NewRegisterWithParams(20000, 4)
+		fill(f, 100000)
+		if m := MeasureFalsePositiveRate(f, probes); !m.Contains(f.EstimatedFalsePositiveRate()) {
+			t.Errorf("estimate %.3g outside measured [%.3g, %.3g]", f.EstimatedFalsePositiveRate(), m.Lower, m.Upper)
+		}
+	})
+
+	t.Run("TwoChoiceFilter", func(t *testing.T) {
+		f := NewTwoChoiceWithParams(2000, 10)
+		fill(f, 70000)
+		if m := MeasureFalsePositiveRate(f, probes); !m.Contains(f.EstimatedFalsePositiveRate()) {
+			t.Errorf("estimate %.3g outside measured [%.3g, %.3g]", f.EstimatedFalsePositiveRate(), m.Lower, m.Upper)
+		}
+	})
+
+	// The pattern model treats bits as independent, which overestimates the
+	// rate slightly, so it is only an upper bound
+	t.Run("PatternFilter", func(t *testing.T) {
+		f := NewPatternWithParams(2000, 7, DefaultPatternTableSize, DefaultPatternSeed)
+		fill(f, 100000)
+		if m := MeasureFalsePositiveRate(f, probes); f.EstimatedFalsePositiveRate() < m.Lower {
+			t.Errorf("estimate %.3g below measured [%.3g, %.3g]", f.EstimatedFalsePositiveRate(), m.Lower, m.Upper)
+		}
+	})
+}
+
+func tuneSample(n, copies int) [][]byte {
+	sample := make([][]byte, n)
+	for i := range sample {
+		sample[i] = fmt.Appendf(nil, "key-%d", i/copies)
+	}
+	return sample
+}
+
+func TestAutoTune(t *testing.T) {
+	const items, probes = 100000, 200000
+	sample := tuneSample(10000, 1)
+
+	for _, tc := range []struct {
+		bitsPerItem uint64
+		blockBits   uint32
+	}{
+		{10, 512},
+		{16, 512},
+	} {
+		budget := items * tc.bitsPerItem / 8
+		p, m := AutoTune(sample, items, budget, probes)
+		model := PlanForItemsInMemory(items, budget)
+		name := fmt.Sprintf("bitsPerItem=%d", tc.bitsPerItem)
+		checkPlan(t, name, p)
+
+		if p.ExpectedItems != items || p.Bytes > budget || p.BlockBits != tc.blockBits {
+			t.Errorf("%s: got %d items in %d bytes of %d-bit blocks", name, p.ExpectedItems, p.Bytes, p.BlockBits)
+		}
+		if p.K+2 < model.K || p.K > model.K+2 {
+			t.Errorf("%s: tuned k=%d far from the model's %d", name, p.K, model.K)
+		}
+		if m.Probes != probes || m.Rate > 2*model.FPRateAt100+m.Upper-m.Lower {
+			t.Errorf("%s: measured %+v, model predicts %g", name, m, model.FPRateAt100)
+		}
+	}
+}
+
+func TestAutoTuneWideBlocks(t *testing.T) {
+	// At low rates 1024-bit blocks more than halve the rate, but telling
+	// them apart takes enough probes to see a few dozen false positives
+	const items, budget = 100000, 100000 * 28 / 8
+	p, m := AutoTune(tuneSample(10000, 1), items, budget, 2_000_000)
+	if p.BlockBits != MaxBlockBits || p.Bytes > budget || p.Bytes+MaxBlockBits/8 <= budget {
+		t.Errorf("got %d bytes of %d-bit blocks, measured %+v", p.Bytes, p.BlockBits, m)
+	}
+}
+
+func TestAutoTuneDuplicates(t *testing.T) {
+	// Each key appears twice, so the filters hold half as many distinct
+	// keys as the model expects and more hash functions pay off
+	const items, budget = 100000, 100000 * 10 / 8
+	p, m := AutoTune(tuneSample(10000, 2), items, budget, 1_000_000)
+	model := PlanForItemsInMemory(items, budget)
+	if p.K <= model.K+1 {
+		t.Errorf("tuned k=%d, model's k=%d", p.K, model.K)
+	}
+	if m.Upper >= model.FPRateAt100/2 {
+		t.Errorf("measured [%g, %g], model predicts %g", m.Lower, m.Upper, model.FPRateAt100)
+	}
+}
+
+func TestAutoTuneEdgeCases(t *testing.T) {
+	// Nothing to measure
+	p, m := AutoTune(nil, 1000, 1000, 1000)
+	if p != PlanForItemsInMemory(1000, 1000) || m != (Measurement{Upper: 1}) {
+		t.Errorf("empty sample: got %+v, %+v", p, m)
+	}
+
+	// Without expected items, the sample is the whole set
+	sample := tuneSample(5000, 1)
+	if p, _ := AutoTune(sample, 0, 5000, 10000); p.ExpectedItems != 5000 || p.NumBlocks != 5000/64 {
+		t.Errorf("whole sample: got %+v", p)
+	}
+
+	// Without probes every candidate ties, so the model's choice stands
+	if p, m := AutoTune(sample, 0, 5000, 0); p.K != PlanForBlocks(5000/64, 512, 5000).K || p.BlockBits != 512 || m.Probes != 0 {
+		t.Errorf("zero probes: got %+v, %+v", p, m)
+	}
+}
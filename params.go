@@ -1,18 +1,70 @@
 package gloom
 
-import "math"
+import (
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+	"sync"
+)
 
 const (
-	// BlockBits is the number of bits per block (cache line size).
+	// BlockBits is the default number of bits per block (cache line size).
 	BlockBits = 512
-	// BlockWords is the number of uint64s per block.
+	// BlockWords is the number of uint64s per default block.
 	BlockWords = BlockBits / 64 // 8
+
+	// MinBlockBits is the smallest supported block size, half a cache line.
+	MinBlockBits = 256
+	// MaxBlockBits is the largest supported block size, two cache lines.
+	MaxBlockBits = 1024
+	// numBlockSizes is the number of supported block sizes, which are the
+	// powers of two from MinBlockBits to MaxBlockBits.
+	numBlockSizes = 3
 	// ln2 is the natural logarithm of 2.
 	ln2 = 0.6931471805599453
 	// ln2Squared is ln(2)^2.
 	ln2Squared = 0.4804530139182014
+
+	// MinK is the smallest supported number of hash functions.
+	MinK = 1
+	// MaxK is the largest supported number of hash functions with the
+	// default 512-bit block. Beyond it, every partition of the block into
+	// pairwise coprime values has a value below a third of the mean, too
+	// small to be useful (see generatePartition).
+	MaxK = 15
+	// maxKAnyBlock is the largest supported number of hash functions with
+	// any block size.
+	maxKAnyBlock = 21
 )
 
+// ValidBlockBits reports whether blockBits is a supported block size: 256,
+// 512, or 1024.
+func ValidBlockBits(blockBits uint32) bool {
+	return blockBits >= MinBlockBits && blockBits <= MaxBlockBits && blockBits&(blockBits-1) == 0
+}
+
+// blockSizeIndex returns the index of a supported block size, from 0 for
+// MinBlockBits up.
+func blockSizeIndex(blockBits uint32) int {
+	return bits.TrailingZeros32(blockBits / MinBlockBits)
+}
+
+// MaxKForBlockBits returns the largest supported number of hash functions
+// for a block size, or 0 if the block size is not supported.
+func MaxKForBlockBits(blockBits uint32) uint32 {
+	switch blockBits {
+	case 256:
+		return 11
+	case 512:
+		return MaxK
+	case 1024:
+		return maxKAnyBlock
+	default:
+		return 0
+	}
+}
+
 // primePartitions contains pre-computed partition configurations for different
 // k values. Each configuration contains k strictly distinct values that sum to
 // exactly 512 bits (the block size).
@@ -26,6 +78,8 @@ const (
 // 1. Strictly distinct (required for one-hashing independence)
 // 2. Sum to exactly 512 to maximize block utilization
 // 3. As large as possible for good modulo distribution
+//
+// Supported k values outside this table are built by generatePartition.
 var primePartitions = map[uint32][]uint32{
 	3:  {167, 173, 172},                                          // sum = 512 (172 is even filler)
 	4:  {109, 127, 137, 139},                                     // sum = 512, all prime
@@ -44,6 +98,69 @@ var primePartitions = map[uint32][]uint32{
 // OptimalParams calculates the optimal bloom filter parameters.
 // Returns the number of blocks, number of hash functions (k), and bits per item.
 func OptimalParams(expectedItems uint64, fpRate float64) (numBlocks uint64, k uint32, bitsPerItem float64) {
+	expectedItems, fpRate = optimalInputs(expectedItems, fpRate)
+
+	// Optimal bits per item: -ln(fpRate) / ln(2)^2
+	bitsPerItem = -math.Log(fpRate) / ln2Squared
+
+	// Total bits needed
+	totalBits := float64(expectedItems) * bitsPerItem
+
+	numBlocks, k = optimalBlockParams(expectedItems, totalBits, BlockBits)
+	return numBlocks, k, bitsPerItem
+}
+
+// OptimalBlockParams is like OptimalParams, but also chooses the block size
+// in bits, for use with NewWithBlockParams.
+//
+// It uses the default 512-bit block, so each operation touches a single
+// cache line, unless block load variance would put the false positive rate
+// of a 512-bit block more than twice over fpRate. Then 1024-bit blocks are
+// used instead, whose lower variance lifts that floor at the cost of a second
+// cache line. Smaller blocks are never more accurate for the same memory, so
+// 256-bit blocks are only used when requested explicitly.
+//
+// Unlike OptimalParams, it then adds blocks until
+// EstimateBlockFalsePositiveRate meets fpRate, so the filter reaches the
+// target despite block load variance, and bitsPerItem is the memory per item
+// of the result.
+func OptimalBlockParams(expectedItems uint64, fpRate float64) (numBlocks uint64, k, blockBits uint32, bitsPerItem float64) {
+	expectedItems, fpRate = optimalInputs(expectedItems, fpRate)
+	totalBits := float64(expectedItems) * -math.Log(fpRate) / ln2Squared
+
+	blockBits = BlockBits
+	numBlocks, k = optimalBlockParams(expectedItems, totalBits, blockBits)
+	if EstimateFalsePositiveRate(numBlocks, k, expectedItems) > 2*fpRate {
+		blockBits = MaxBlockBits
+		numBlocks, k = optimalBlockParams(expectedItems, totalBits, blockBits)
+	}
+
+	// Grow the filter until the estimate meets fpRate. Once the doubling
+	// stops, hi meets it and lo does not, unless no growth was needed
+	fits := func(numBlocks uint64) bool {
+		k := optimalBlockK(numBlocks, blockBits, expectedItems)
+		return EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, expectedItems) <= fpRate
+	}
+	lo, hi := numBlocks, numBlocks
+	for !fits(hi) {
+		lo, hi = hi, 2*hi
+	}
+	for hi-lo > 1 {
+		if mid := lo + (hi-lo)/2; fits(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	numBlocks, k = hi, optimalBlockK(hi, blockBits, expectedItems)
+	bitsPerItem = float64(numBlocks*uint64(blockBits)) / float64(expectedItems)
+
+	return numBlocks, k, blockBits, bitsPerItem
+}
+
+// optimalInputs substitutes defaults for an expectedItems of 0 and an out of
+// range fpRate.
+func optimalInputs(expectedItems uint64, fpRate float64) (uint64, float64) {
 	if expectedItems == 0 {
 		expectedItems = 1
 	}
@@ -53,34 +170,331 @@ func OptimalParams(expectedItems uint64, fpRate float64) (numBlocks uint64, k ui
 	if fpRate >= 1 {
 		fpRate = 0.99
 	}
+	return expectedItems, fpRate
+}
 
-	// Optimal bits per item: -ln(fpRate) / ln(2)^2
-	bitsPerItem = -math.Log(fpRate) / ln2Squared
-
-	// Total bits needed
-	totalBits := float64(expectedItems) * bitsPerItem
-
+// optimalBlockParams returns the number of blocks of blockBits bits needed to
+// hold totalBits, and the optimal k for them.
+func optimalBlockParams(expectedItems uint64, totalBits float64, blockBits uint32) (numBlocks uint64, k uint32) {
 	// Round up to nearest block (always >= 1 since totalBits > 0)
-	numBlocks = uint64(math.Ceil(totalBits / BlockBits))
+	numBlocks = uint64(math.Ceil(totalBits / float64(blockBits)))
+	return numBlocks, optimalBlockK(numBlocks, blockBits, expectedItems)
+}
 
+// optimalBlockK returns the optimal k for expectedItems in numBlocks blocks
+// of blockBits bits.
+func optimalBlockK(numBlocks uint64, blockBits uint32, expectedItems uint64) uint32 {
 	// Actual bits per item given block rounding
-	actualBitsPerItem := float64(numBlocks*BlockBits) / float64(expectedItems)
+	actualBitsPerItem := float64(numBlocks*uint64(blockBits)) / float64(expectedItems)
 
 	// Optimal k: (m/n) * ln(2) = bitsPerItem * ln(2)
 	kFloat := actualBitsPerItem * ln2
-	k = uint32(math.Round(kFloat))
+	k := uint32(math.Round(kFloat))
 
-	// Clamp k to supported range
-	k = max(k, 3)
-	k = min(k, 14)
+	// Clamp k to supported range. Above roundedMaxK, the extra probes of a
+	// larger k need smaller partitions, which cost more to block load
+	// variance than the probes gain, so the rounded k is only an upper bound
+	// and the best k up to it is chosen with EstimateBlockFalsePositiveRate.
+	k = max(k, MinK)
+	k = min(k, MaxKForBlockBits(blockBits))
+	if k > roundedMaxK {
+		k, _ = bestBlockK(numBlocks, blockBits, expectedItems, roundedMaxK, k)
+	}
+	return k
+}
 
-	return numBlocks, k, bitsPerItem
+// roundedMaxK is the largest k optimalBlockParams takes from the unblocked
+// formula as is.
+//
+// With 512-bit blocks, the partition for k=14 includes 11 and 13, which fill
+// up after a few items in a block, so EstimateBlockFalsePositiveRate puts it
+// behind k=13 at every load. k=15 has larger partitions and overtakes k=13
+// from about 32 bits per item, for rates of about 1e-5 and below. With
+// 1024-bit blocks, the best k likewise falls short of the unblocked optimum
+// at moderate loads.
+const roundedMaxK = 13
+
+// bestBlockK returns the k from minK to maxK with the lowest
+// EstimateBlockFalsePositiveRate for items in numBlocks blocks of blockBits
+// bits, and that rate. Ties go to the smaller k.
+func bestBlockK(numBlocks uint64, blockBits uint32, items uint64, minK, maxK uint32) (bestK uint32, bestFP float64) {
+	bestK, bestFP = minK, math.Inf(1)
+	for k := minK; k <= maxK; k++ {
+		if fp := EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, items); fp < bestFP {
+			bestK, bestFP = k, fp
+		}
+	}
+	return bestK, bestFP
+}
+
+// generatedPartitions caches the partitions built by generatePartition for
+// supported k values that are missing from primePartitions, indexed by block
+// size and k.
+var generatedPartitions [numBlockSizes][maxKAnyBlock + 1]struct {
+	once   sync.Once
+	primes []uint32
 }
 
-// GetPrimePartition returns the prime partition for the given k value.
-// Returns nil if k is not supported.
+// GetPrimePartition returns the prime partition for the given k value with
+// the default 512-bit block. Returns nil if k is not supported.
 func GetPrimePartition(k uint32) []uint32 {
-	return primePartitions[k]
+	return GetBlockPartition(k, BlockBits)
+}
+
+// GetBlockPartition returns the prime partition for the given k value and
+// block size in bits. Returns nil if the combination is not supported.
+func GetBlockPartition(k, blockBits uint32) []uint32 {
+	if blockBits == BlockBits {
+		if primes, ok := primePartitions[k]; ok {
+			return primes
+		}
+	}
+	if k < MinK || k > MaxKForBlockBits(blockBits) {
+		return nil
+	}
+	g := &generatedPartitions[blockSizeIndex(blockBits)][k]
+	g.once.Do(func() { g.primes = generatePartition(k, blockBits) })
+	return g.primes
+}
+
+// generatePartition builds a partition for k values that are missing from
+// primePartitions, following the same rules: k strictly distinct values that
+// sum to exactly blockBits, made of odd primes plus one even filler when k is
+// odd. The filler shares no factor with the primes, so the values are
+// pairwise coprime and the k probes are independent. Among all such
+// partitions, the one whose smallest value is largest is chosen.
+//
+// The values must also be near-equal: none below a third of the mean
+// blockBits/k, about the spread of the hand-written table. A partition of a
+// few bits has its probe bit set once its block holds a few items, so it
+// costs a probe without filtering anything. It returns nil if no such
+// partition exists, which is the case for every k above 11 with 256-bit
+// blocks, 15 with 512-bit blocks, and 21 with 1024-bit blocks.
+//
+// If no such partition of odd primes and a filler exists, powers of distinct
+// primes are tried instead (see primePowerPartition). Values that share a
+// factor d correlate their probes modulo d, which raises the false positive
+// rate well above EstimateFalsePositiveRate, so there is no fallback to
+// values that are not pairwise coprime.
+func generatePartition(k, blockBits uint32) []uint32 {
+	minValue := max((blockBits+3*k-1)/(3*k), 1)
+
+	// A filler of 0 stands for no filler
+	numPrimes := int(k)
+	fillers := []uint32{0}
+	if k%2 == 1 {
+		numPrimes--
+		fillers = fillers[:0]
+		for filler := minValue + minValue%2; filler <= blockBits; filler += 2 {
+			fillers = append(fillers, filler)
+		}
+	}
+
+	var best []uint32
+	bestMin := minValue - 1
+	for _, filler := range fillers {
+		// Only primes above bestMin are considered, so any partition found
+		// beats the best so far
+		primes := primeSubset(numPrimes, blockBits-filler, filler, bestMin)
+		if primes == nil {
+			continue
+		}
+		best, bestMin = primes, filler
+		if len(primes) > 0 && (filler == 0 || primes[0] < filler) {
+			bestMin = primes[0]
+		}
+		if filler != 0 {
+			best = append(best, filler)
+		}
+	}
+	if best == nil {
+		best = primePowerPartition(k, blockBits, minValue)
+	}
+	return best
+}
+
+// primePowerPartition returns k distinct prime powers with distinct bases, in
+// ascending order, that sum to blockBits and are all at least minValue. If
+// several exist, it returns the one whose smallest value is largest. It
+// returns nil if none exists.
+//
+// Powers of distinct primes are pairwise coprime, like the values of
+// primeSubset, and admitting powers such as 16, 25, and 27 makes room for
+// more values of a useful size in the block.
+func primePowerPartition(k, blockBits, minValue uint32) []uint32 {
+	// One group of candidate powers per base prime, at most one of which is
+	// used
+	var groups [][]uint32
+	var thresholds []uint32
+	for p := uint32(2); p <= blockBits; p++ {
+		if p > 2 && (p%2 == 0 || !isOddPrime(p)) {
+			continue
+		}
+		var powers []uint32
+		for q := p; q <= blockBits; q *= p {
+			if q >= minValue {
+				powers = append(powers, q)
+			}
+		}
+		if len(powers) > 0 {
+			groups = append(groups, powers)
+			thresholds = append(thresholds, powers...)
+		}
+	}
+	slices.Sort(thresholds)
+	thresholds = slices.Compact(thresholds)
+
+	// reachable reports whether values of at least floor can form the
+	// partition, and returns the sums reachable before each group
+	reachable := func(floor uint32) (bool, [][]sumSet) {
+		reach := make([]sumSet, k+1)
+		reach[0][0] = 1
+		history := make([][]sumSet, 0, len(groups)+1)
+		for _, powers := range groups {
+			history = append(history, slices.Clone(reach))
+			prev := history[len(history)-1]
+			for _, q := range powers {
+				if q < floor {
+					continue
+				}
+				for c := k; c > 0; c-- {
+					reach[c].orShifted(&prev[c-1], q)
+				}
+			}
+		}
+		history = append(history, reach)
+		return reach[k].has(blockBits), history
+	}
+
+	// Lowering the floor only adds candidates, so the largest floor that
+	// still reaches the target is found by binary search
+	i := sort.Search(len(thresholds), func(i int) bool {
+		ok, _ := reachable(thresholds[len(thresholds)-1-i])
+		return ok
+	})
+	if i == len(thresholds) {
+		return nil
+	}
+	floor := thresholds[len(thresholds)-1-i]
+	_, history := reachable(floor)
+
+	// Walk back through the groups, taking a power from each group the sum
+	// cannot skip
+	values := make([]uint32, 0, k)
+	c, sum := k, blockBits
+	for g := len(groups) - 1; c > 0; g-- {
+		if history[g][c].has(sum) {
+			continue
+		}
+		for _, q := range groups[g] {
+			if q >= floor && q <= sum && history[g][c-1].has(sum-q) {
+				values = append(values, q)
+				c--
+				sum -= q
+				break
+			}
+		}
+	}
+	slices.Sort(values)
+	return values
+}
+
+// primeSubset returns count distinct odd primes, in ascending order, that
+// sum to sum, do not divide exclude, and are all greater than floor. If
+// several exist, it returns the one whose smallest prime is largest. It
+// returns nil if none exists.
+func primeSubset(count int, sum, exclude, floor uint32) []uint32 {
+	if count == 0 {
+		if sum == 0 {
+			return []uint32{}
+		}
+		return nil
+	}
+
+	var candidates []uint32
+	for p := sum - sum%2 + 1; p > floor && p > 2; p -= 2 {
+		if p <= sum && isOddPrime(p) && (exclude == 0 || exclude%p != 0) {
+			candidates = append(candidates, p)
+		}
+	}
+
+	// reach[c] holds the sums of c distinct candidates seen so far. Adding
+	// candidates in descending order, the first one that makes the target
+	// reachable is the largest possible smallest prime.
+	reach := make([]sumSet, count+1)
+	reach[0][0] = 1
+	last := -1
+	for i, p := range candidates {
+		for c := count; c > 0; c-- {
+			reach[c].orShifted(&reach[c-1], p)
+		}
+		if reach[count].has(sum) {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return nil
+	}
+
+	// Replay the candidates up to the last one, keeping each step's state so
+	// the chosen primes can be recovered by walking back
+	clear(reach)
+	reach[0][0] = 1
+	history := [][]sumSet{slices.Clone(reach)}
+	for _, p := range candidates[:last] {
+		for c := count; c > 0; c-- {
+			reach[c].orShifted(&reach[c-1], p)
+		}
+		history = append(history, slices.Clone(reach))
+	}
+
+	primes := make([]uint32, 0, count)
+	c, s := count, sum
+	for j := last; c > 0; j-- {
+		if history[j][c].has(s) {
+			continue
+		}
+		primes = append(primes, candidates[j])
+		c--
+		s -= candidates[j]
+	}
+	return primes
+}
+
+// isOddPrime reports whether the odd number n > 2 is prime.
+func isOddPrime(n uint32) bool {
+	for d := uint32(3); d*d <= n; d += 2 {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sumWords is the number of words in a bitset of the sums 0..MaxBlockBits.
+const sumWords = MaxBlockBits/64 + 1
+
+// sumSet is a bitset of the sums 0..MaxBlockBits.
+type sumSet [sumWords]uint64
+
+func (s *sumSet) has(sum uint32) bool {
+	return s[sum/64]&(1<<(sum%64)) != 0
+}
+
+// orShifted sets every sum in src plus shift, dropping those past
+// MaxBlockBits.
+func (s *sumSet) orShifted(src *sumSet, shift uint32) {
+	words, rem := int(shift/64), shift%64
+	for i := sumWords - 1; i >= words; i-- {
+		w := src[i-words] << rem
+		if i > words {
+			// A shift by 64 yields 0, so rem == 0 needs no special case
+			w |= src[i-words-1] >> (64 - rem)
+		}
+		s[i] |= w
+	}
+	s[sumWords-1] &= 1<<(MaxBlockBits%64+1) - 1
 }
 
 // ComputeOffsets computes the cumulative bit offsets for each partition.
@@ -124,11 +538,17 @@ func partitionedBlockFP(primes []uint32, j float64) float64 {
 // assumes uniform bit placement across the entire block and underestimates
 // the FP rate of partitioned blocked filters.
 func EstimateFalsePositiveRate(numBlocks uint64, k uint32, itemsAdded uint64) float64 {
+	return EstimateBlockFalsePositiveRate(numBlocks, k, BlockBits, itemsAdded)
+}
+
+// EstimateBlockFalsePositiveRate is like EstimateFalsePositiveRate, but for
+// blocks of blockBits bits rather than the default 512.
+func EstimateBlockFalsePositiveRate(numBlocks uint64, k, blockBits uint32, itemsAdded uint64) float64 {
 	if numBlocks == 0 || itemsAdded == 0 {
 		return 0
 	}
 
-	primes := GetPrimePartition(k)
+	primes := GetBlockPartition(k, blockBits)
 	lambda := float64(itemsAdded) / float64(numBlocks) // expected items per block
 
 	// For very large lambda, the Poisson variance relative to the mean is
@@ -138,7 +558,7 @@ func EstimateFalsePositiveRate(numBlocks uint64, k uint32, itemsAdded uint64) fl
 			return partitionedBlockFP(primes, lambda)
 		}
 		// Fallback for unsupported k values
-		s := float64(BlockBits)
+		s := float64(blockBits)
 		kf := float64(k)
 		m := float64(numBlocks) * s
 		return math.Pow(1-math.Exp(-kf*float64(itemsAdded)/m), kf)
@@ -153,7 +573,7 @@ func EstimateFalsePositiveRate(numBlocks uint64, k uint32, itemsAdded uint64) fl
 
 	// Precompute fallback values for unsupported k
 	kf := float64(k)
-	s := float64(BlockBits)
+	s := float64(blockBits)
 
 	for j := 0; j <= maxJ; j++ {
 		if j > 0 {
@@ -0,0 +1,447 @@
+package gloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// MaxPatternK is the largest supported number of bits per pattern.
+	MaxPatternK = 32
+
+	// MinPatternTableSize is the smallest supported pattern table size.
+	MinPatternTableSize = 1
+	// MaxPatternTableSize is the largest supported pattern table size, whose
+	// 512-bit patterns take 4 MiB.
+	MaxPatternTableSize = 1 << 16
+	// DefaultPatternTableSize is the pattern table size used by NewPattern.
+	// Its 64 KiB of patterns fit in a typical L2 cache.
+	DefaultPatternTableSize = 1 << 10
+	// DefaultPatternSeed is the pattern generator seed used by NewPattern.
+	DefaultPatternSeed = 0
+
+	// patternRotations is the number of distinct rotations applied to each
+	// table entry: 8 word rotations times 64 bit rotations, selected by 9 more
+	// hash bits.
+	patternRotations = 512
+
+	// maxPatternBitsPerItem bounds the memory OptimalPatternParams will
+	// spend trying to reach a false positive rate.
+	maxPatternBitsPerItem = 256
+
+	// serializePatternVersion is the serialization format version of a
+	// PatternFilter. It differs from Filter's versions so neither type can be
+	// mistaken for the other.
+	serializePatternVersion byte = 3
+
+	// patternHeaderSize is the size of the PatternFilter serialization header
+	// in bytes: Version (1) + K (4) + NumBlocks (8) + Count (8) +
+	// TableSize (4) + Seed (8) = 33 bytes.
+	patternHeaderSize = 33
+)
+
+// PatternFilter is a cache-line blocked bloom filter that takes each key's k
+// bits from a precomputed table of 512-bit patterns. It is NOT safe for
+// concurrent use.
+//
+// Where Filter computes k bit positions with a modulo per probe, a
+// PatternFilter loads one table entry and compares it against the block with
+// eight ANDs. Each entry is also rotated by 9 bits of the hash, so the table
+// yields 512 times as many distinct patterns as it has entries. Keys whose
+// patterns collide cannot be told apart within a block, so a larger table
+// lowers the false positive rate, at the cost of cache space for the table.
+type PatternFilter struct {
+	raw       []byte   // Raw allocation to keep aligned memory alive for GC
+	blocks    []uint64 // 8 uint64s per block = 512 bits (cache-line aligned)
+	numBlocks uint64   // Total number of 512-bit blocks
+	k         uint32   // Number of bits set in each pattern
+	seed      uint64   // Seed the patterns were generated from
+	rawTable  []byte   // Raw allocation backing patterns, shared with other filters
+	patterns  []uint64 // tableSize patterns of 8 uint64s (cache-line aligned)
+	tableBits uint32   // log2 of the number of patterns
+	count     uint64   // Number of items added
+	setBits   uint64   // Number of bits set, maintained incrementally
+}
+
+// ValidPatternTableSize reports whether tableSize is a supported pattern
+// table size: a power of two from MinPatternTableSize to MaxPatternTableSize.
+func ValidPatternTableSize(tableSize uint32) bool {
+	return tableSize >= MinPatternTableSize && tableSize <= MaxPatternTableSize && tableSize&(tableSize-1) == 0
+}
+
+// NewPattern creates a new pattern-table bloom filter optimized for the
+// expected number of items and desired false positive rate, with the default
+// table size and seed.
+func NewPattern(expectedItems uint64, fpRate float64) *PatternFilter {
+	numBlocks, k, _ := OptimalPatternParams(expectedItems, fpRate, DefaultPatternTableSize)
+	return NewPatternWithParams(numBlocks, k, DefaultPatternTableSize, DefaultPatternSeed)
+}
+
+// NewPatternWithParams creates a new pattern-table bloom filter with explicit
+// parameters. numBlocks is the number of 512-bit blocks, k is the number of
+// bits in each pattern, from MinK to MaxPatternK, and tableSize is the number
+// of patterns. Filters only agree on which bits a key sets if they share k,
+// tableSize, and seed. An unsupported k falls back to 7, and an unsupported
+// tableSize to DefaultPatternTableSize.
+func NewPatternWithParams(numBlocks uint64, k, tableSize uint32, seed uint64) *PatternFilter {
+	if numBlocks == 0 {
+		numBlocks = 1
+	}
+	if k < MinK || k > MaxPatternK {
+		k = 7
+	}
+	if !ValidPatternTableSize(tableSize) {
+		tableSize = DefaultPatternTableSize
+	}
+
+	raw, blocks, _ := makeAlignedUint64Slice(int(numBlocks*BlockWords), HeapAllocator)
+	f := &PatternFilter{
+		raw:       raw,
+		blocks:    blocks,
+		numBlocks: numBlocks,
+	}
+	f.setPatterns(k, tableSize, seed)
+	return f
+}
+
+// patternTableKey identifies the parameters a pattern table is generated
+// from.
+type patternTableKey struct {
+	k, tableSize uint32
+	seed         uint64
+}
+
+// patternTable is a generated pattern table, shared by every PatternFilter
+// with the same parameters.
+type patternTable struct {
+	once     sync.Once
+	raw      []byte   // Raw allocation backing patterns
+	patterns []uint64 // tableSize patterns of 8 uint64s (cache-line aligned)
+}
+
+// patternTables caches the pattern table for each patternTableKey, generated
+// on first use. Tables are never evicted, so each distinct set of parameters
+// a process uses keeps up to 4 MiB alive.
+var patternTables sync.Map // patternTableKey -> *patternTable
+
+// setPatterns points the filter at the shared pattern table for k,
+// tableSize, and seed.
+func (f *PatternFilter) setPatterns(k, tableSize uint32, seed uint64) {
+	key := patternTableKey{k: k, tableSize: tableSize, seed: seed}
+	v, ok := patternTables.Load(key)
+	if !ok {
+		v, _ = patternTables.LoadOrStore(key, new(patternTable))
+	}
+	t := v.(*patternTable)
+	t.once.Do(func() { t.raw, t.patterns = generatePatterns(k, tableSize, seed) })
+
+	f.rawTable, f.patterns = t.raw, t.patterns
+	f.k = k
+	f.seed = seed
+	f.tableBits = uint32(bits.TrailingZeros32(tableSize))
+}
+
+// generatePatterns builds a table of tableSize patterns with exactly k of
+// their 512 bits set, chosen uniformly by a splitmix64 sequence starting
+// from seed.
+func generatePatterns(k, tableSize uint32, seed uint64) (raw []byte, patterns []uint64) {
+	raw, patterns, _ = makeAlignedUint64Slice(int(tableSize*BlockWords), HeapAllocator)
+	state := seed
+	for i := uint32(0); i < tableSize; i++ {
+		pattern := patterns[i*BlockWords : (i+1)*BlockWords]
+		for set := uint32(0); set < k; {
+			bitPos := splitmix64(&state) >> 55 // 0-511
+			if mask := uint64(1) << (bitPos % 64); pattern[bitPos/64]&mask == 0 {
+				pattern[bitPos/64] |= mask
+				set++
+			}
+		}
+	}
+	return raw, patterns
+}
+
+// pattern returns the table entry for an intra-block hash, along with the
+// word and bit rotations to apply to it.
+func (f *PatternFilter) pattern(intraHash uint32) (pattern []uint64, wordRot uint32, bitRot int) {
+	idx := intraHash & (1<<f.tableBits - 1)
+	rot := intraHash >> f.tableBits
+	return f.patterns[idx*BlockWords : (idx+1)*BlockWords : (idx+1)*BlockWords], rot >> 6 & 7, int(rot & 63)
+}
+
+// Add adds data to the filter.
+func (f *PatternFilter) Add(data []byte) {
+	blockIdx, intraHash := hashData(data, f.numBlocks)
+	f.addWithHash(blockIdx, intraHash)
+}
+
+// AddString adds a string to the filter without allocating.
+func (f *PatternFilter) AddString(s string) {
+	blockIdx, intraHash := hashString(s, f.numBlocks)
+	f.addWithHash(blockIdx, intraHash)
+}
+
+// addWithHash ORs the key's pattern into its block using pre-computed hash
+// values.
+func (f *PatternFilter) addWithHash(blockIdx uint64, intraHash uint32) {
+	pattern, wordRot, bitRot := f.pattern(intraHash)
+	block := f.blocks[blockIdx*BlockWords : (blockIdx+1)*BlockWords : (blockIdx+1)*BlockWords]
+
+	var flipped int
+	for w := range block {
+		mask := bits.RotateLeft64(pattern[(uint32(w)+wordRot)&7], bitRot)
+		flipped += bits.OnesCount64(mask &^ block[w])
+		block[w] |= mask
+	}
+	f.setBits += uint64(flipped)
+	f.count++
+}
+
+// Test checks if data might be in the filter.
+// Returns true if the item might be present, false if definitely absent.
+func (f *PatternFilter) Test(data []byte) bool {
+	blockIdx, intraHash := hashData(data, f.numBlocks)
+	return f.testWithHash(blockIdx, intraHash)
+}
+
+// TestString checks if a string might be in the filter without allocating.
+func (f *PatternFilter) TestString(s string) bool {
+	blockIdx, intraHash := hashString(s, f.numBlocks)
+	return f.testWithHash(blockIdx, intraHash)
+}
+
+// testWithHash checks the key's pattern against its block using
+// pre-computed hash values.
+func (f *PatternFilter) testWithHash(blockIdx uint64, intraHash uint32) bool {
+	pattern, wordRot, bitRot := f.pattern(intraHash)
+	block := f.blocks[blockIdx*BlockWords : (blockIdx+1)*BlockWords : (blockIdx+1)*BlockWords]
+
+	// Accumulate missing bits rather than branching on each word, since the
+	// whole block is in one cache line anyway
+	var missing uint64
+	for w := range block {
+		mask := bits.RotateLeft64(pattern[(uint32(w)+wordRot)&7], bitRot)
+		missing |= mask &^ block[w]
+	}
+	return missing == 0
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *PatternFilter) Cap() uint64 {
+	return f.numBlocks * BlockBits
+}
+
+// K returns the number of bits set in each pattern.
+func (f *PatternFilter) K() uint32 {
+	return f.k
+}
+
+// Count returns the number of items added to the filter.
+func (f *PatternFilter) Count() uint64 {
+	return f.count
+}
+
+// NumBlocks returns the number of 512-bit blocks in the filter.
+func (f *PatternFilter) NumBlocks() uint64 {
+	return f.numBlocks
+}
+
+// TableSize returns the number of patterns in the filter's table.
+func (f *PatternFilter) TableSize() uint32 {
+	return 1 << f.tableBits
+}
+
+// Seed returns the seed the filter's patterns were generated from.
+func (f *PatternFilter) Seed() uint64 {
+	return f.seed
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add.
+func (f *PatternFilter) EstimatedFillRatio() float64 {
+	return float64(f.setBits) / float64(f.Cap())
+}
+
+// ExactFillRatio computes the proportion of bits that are set by counting
+// every bit in the filter.
+func (f *PatternFilter) ExactFillRatio() float64 {
+	return float64(popCount(f.blocks)) / float64(f.Cap())
+}
+
+// EstimatedFalsePositiveRate estimates the current false positive rate
+// based on the number of items added.
+func (f *PatternFilter) EstimatedFalsePositiveRate() float64 {
+	return EstimatePatternFalsePositiveRate(f.numBlocks, f.k, f.TableSize(), f.count)
+}
+
+// MarshalBinary serializes the pattern filter to a byte slice.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 3
+//   - K (4 bytes): number of bits per pattern (little-endian uint32)
+//   - NumBlocks (8 bytes): number of 512-bit blocks (little-endian uint64)
+//   - Count (8 bytes): number of items added (little-endian uint64)
+//   - TableSize (4 bytes): number of patterns (little-endian uint32)
+//   - Seed (8 bytes): pattern generator seed (little-endian uint64)
+//   - Blocks (numBlocks * 64 bytes): the bit array data (little-endian uint64s)
+//
+// The pattern table is not serialized as it can be regenerated from k, the
+// table size, and the seed.
+func (f *PatternFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, patternHeaderSize+len(f.blocks)*8)
+
+	buf[0] = serializePatternVersion
+	binary.LittleEndian.PutUint32(buf[1:5], f.k)
+	binary.LittleEndian.PutUint64(buf[5:13], f.numBlocks)
+	binary.LittleEndian.PutUint64(buf[13:21], f.count)
+	binary.LittleEndian.PutUint32(buf[21:25], f.TableSize())
+	binary.LittleEndian.PutUint64(buf[25:33], f.seed)
+	encodeWords(buf[patternHeaderSize:], f.blocks)
+
+	return buf, nil
+}
+
+// UnmarshalPatternBinary deserializes a pattern filter from a byte slice
+// written by PatternFilter.MarshalBinary.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalPatternBinary(data []byte) (*PatternFilter, error) {
+	if len(data) < patternHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), patternHeaderSize)
+	}
+	if version := data[0]; version != serializePatternVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializePatternVersion)
+	}
+
+	k := binary.LittleEndian.Uint32(data[1:5])
+	numBlocks := binary.LittleEndian.Uint64(data[5:13])
+	count := binary.LittleEndian.Uint64(data[13:21])
+	tableSize := binary.LittleEndian.Uint32(data[21:25])
+	seed := binary.LittleEndian.Uint64(data[25:33])
+
+	if k < MinK || k > MaxPatternK {
+		return nil, fmt.Errorf("%w: k=%d is not supported (valid range: %d-%d)", ErrInvalidK, k, MinK, MaxPatternK)
+	}
+	if !ValidPatternTableSize(tableSize) {
+		return nil, fmt.Errorf("%w: table size %d is not a power of two from %d to %d", ErrInvalidData, tableSize, MinPatternTableSize, MaxPatternTableSize)
+	}
+
+	// Bound numBlocks as UnmarshalBinaryInto does, so the length check
+	// cannot overflow
+	const maxNumBlocks = uint64(1) << 50
+	if numBlocks == 0 || numBlocks > maxNumBlocks {
+		return nil, fmt.Errorf("%w: numBlocks=%d is out of range (1-%d)", ErrInvalidData, numBlocks, maxNumBlocks)
+	}
+	if expected := patternHeaderSize + numBlocks*BlockWords*8; uint64(len(data)) != expected {
+		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes, expected %d)", ErrInvalidData, len(data), expected)
+	}
+
+	f := NewPatternWithParams(numBlocks, k, tableSize, seed)
+	decodeWords(f.blocks, data[patternHeaderSize:])
+	f.count = count
+	f.setBits = popCount(f.blocks)
+	return f, nil
+}
+
+// OptimalPatternParams calculates the parameters of a PatternFilter with a
+// table of tableSize patterns for the expected number of items and desired
+// false positive rate. Returns the number of 512-bit blocks, the number of
+// bits per pattern (k), and bits per item. An unsupported tableSize is
+// treated as DefaultPatternTableSize.
+//
+// Pattern collisions put a floor under the false positive rate of a block
+// holding j items of about j/(512*tableSize), so low rates need a larger
+// table or many more blocks. Memory is capped at 256 bits per item; check
+// the result with EstimatePatternFalsePositiveRate when that matters.
+func OptimalPatternParams(expectedItems uint64, fpRate float64, tableSize uint32) (numBlocks uint64, k uint32, bitsPerItem float64) {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if fpRate <= 0 {
+		fpRate = 0.0001 // default to 0.01%
+	}
+	if fpRate >= 1 {
+		fpRate = 0.99
+	}
+	if !ValidPatternTableSize(tableSize) {
+		tableSize = DefaultPatternTableSize
+	}
+
+	// Binary search for the fewest blocks whose best k reaches fpRate,
+	// starting from the size of an unblocked filter, which is a lower bound
+	n := float64(expectedItems)
+	lo := max(uint64(n*-math.Log(fpRate)/ln2Squared/BlockBits), 1)
+	hi := max(uint64(math.Ceil(n*maxPatternBitsPerItem/BlockBits)), lo)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, fp := bestPatternK(mid, tableSize, expectedItems); fp <= fpRate {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	numBlocks = lo
+	k, _ = bestPatternK(numBlocks, tableSize, expectedItems)
+	return numBlocks, k, float64(numBlocks*BlockBits) / n
+}
+
+// bestPatternK returns the k with the lowest estimated false positive rate
+// for a PatternFilter of numBlocks blocks holding items, and that rate.
+func bestPatternK(numBlocks uint64, tableSize uint32, items uint64) (bestK uint32, bestFP float64) {
+	bestFP = math.Inf(1)
+	for k := uint32(MinK); k <= MaxPatternK; k++ {
+		if fp := EstimatePatternFalsePositiveRate(numBlocks, k, tableSize, items); fp < bestFP {
+			bestK, bestFP = k, fp
+		}
+	}
+	return bestK, bestFP
+}
+
+// EstimatePatternFalsePositiveRate estimates the false positive rate of a
+// PatternFilter with the given parameters.
+//
+// As for EstimateFalsePositiveRate, the number of items J in a block follows
+// a Poisson distribution. Each item sets k of the block's 512 bits, so a bit
+// is set with probability 1 - (1 - k/512)^J, and a query whose k bits are
+// drawn independently matches with that probability to the power k. A query
+// also matches when its pattern, out of tableSize * 512 rotated ones, is
+// exactly that of one of the J items:
+//
+//	FP = E[c(J) + (1 - c(J)) (1 - (1 - k/512)^J)^k]
+//	c(J) = 1 - (1 - 1/(512 * tableSize))^J
+func EstimatePatternFalsePositiveRate(numBlocks uint64, k, tableSize uint32, itemsAdded uint64) float64 {
+	if numBlocks == 0 || itemsAdded == 0 || k < MinK || k > MaxPatternK || !ValidPatternTableSize(tableSize) {
+		return 0
+	}
+
+	kf := float64(k)
+	logMiss := math.Log1p(-kf / BlockBits)
+	logUnique := math.Log1p(-1 / (patternRotations * float64(tableSize)))
+	blockFP := func(j float64) float64 {
+		same := -math.Expm1(j * logUnique)
+		return same + (1-same)*math.Pow(-math.Expm1(j*logMiss), kf)
+	}
+
+	lambda := float64(itemsAdded) / float64(numBlocks) // expected items per block
+	if lambda > 10000 {
+		return blockFP(lambda)
+	}
+
+	// Poisson-weighted sum over the number of items in a block, in log space
+	// as in EstimateBlockFalsePositiveRate
+	maxJ := int(lambda + 10*math.Sqrt(lambda) + 20)
+	var fp, logFactorial float64
+	logLambda := math.Log(lambda)
+	for j := 1; j <= maxJ; j++ {
+		logFactorial += math.Log(float64(j))
+		prob := math.Exp(-lambda + float64(j)*logLambda - logFactorial)
+		if prob < 1e-15 && j > int(lambda) {
+			break
+		}
+		fp += prob * blockFP(float64(j))
+	}
+	return min(fp, 1)
+}
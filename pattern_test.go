@@ -0,0 +1,339 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestPatternFilterBasic(t *testing.T) {
+	f := NewPattern(10000, 0.01)
+
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if i%2 == 0 {
+			f.Add([]byte(key))
+		} else {
+			f.AddString(key)
+		}
+	}
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if !f.Test([]byte(key)) || !f.TestString(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	if f.Count() != 10000 {
+		t.Errorf("Count() = %d, want 10000", f.Count())
+	}
+	if f.Cap() != f.NumBlocks()*BlockBits {
+		t.Errorf("Cap() = %d, want %d", f.Cap(), f.NumBlocks()*BlockBits)
+	}
+	if f.TableSize() != DefaultPatternTableSize || f.Seed() != DefaultPatternSeed {
+		t.Errorf("got table size %d and seed %d, want the defaults", f.TableSize(), f.Seed())
+	}
+	if f.EstimatedFillRatio() != f.ExactFillRatio() {
+		t.Errorf("EstimatedFillRatio %f != ExactFillRatio %f", f.EstimatedFillRatio(), f.ExactFillRatio())
+	}
+	if fp := f.EstimatedFalsePositiveRate(); fp <= 0 || fp > 0.01 {
+		t.Errorf("EstimatedFalsePositiveRate() = %g, want at most 0.01", fp)
+	}
+	if addr := uintptr(unsafePointer(&f.patterns[0])); addr%cacheLineSize != 0 {
+		t.Errorf("patterns not cache-line aligned: %x", addr)
+	}
+}
+
+func TestPatternFilterInvalidParams(t *testing.T) {
+	tests := []struct {
+		k, tableSize         uint32
+		wantK, wantTableSize uint32
+	}{
+		{0, 64, 7, 64},
+		{MaxPatternK + 1, 64, 7, 64},
+		{5, 0, 5, DefaultPatternTableSize},
+		{5, 100, 5, DefaultPatternTableSize},
+		{5, MaxPatternTableSize * 2, 5, DefaultPatternTableSize},
+	}
+	for _, tt := range tests {
+		f := NewPatternWithParams(0, tt.k, tt.tableSize, 1)
+		if f.K() != tt.wantK || f.TableSize() != tt.wantTableSize || f.NumBlocks() != 1 {
+			t.Errorf("NewPatternWithParams(0, %d, %d) = (%d, %d, %d), want (1, %d, %d)",
+				tt.k, tt.tableSize, f.NumBlocks(), f.K(), f.TableSize(), tt.wantK, tt.wantTableSize)
+		}
+	}
+}
+
+func TestPatternTable(t *testing.T) {
+	for _, k := range []uint32{MinK, 7, MaxPatternK} {
+		for _, tableSize := range []uint32{MinPatternTableSize, 64} {
+			f := NewPatternWithParams(1, k, tableSize, 42)
+			for i := range tableSize {
+				if got := popCount(f.patterns[i*BlockWords : (i+1)*BlockWords]); got != uint64(k) {
+					t.Fatalf("k=%d, tableSize=%d: pattern %d has %d bits set", k, tableSize, i, got)
+				}
+			}
+
+			// Every rotation keeps exactly k bits
+			for _, h := range []uint32{0, tableSize, tableSize << 6, ^uint32(0)} {
+				pattern, wordRot, bitRot := f.pattern(h)
+				var n int
+				for w := range uint32(BlockWords) {
+					n += bits.OnesCount64(bits.RotateLeft64(pattern[(w+wordRot)&7], bitRot))
+				}
+				if n != int(k) {
+					t.Errorf("k=%d, h=%#x: rotated pattern has %d bits set", k, h, n)
+				}
+			}
+		}
+	}
+}
+
+func TestPatternTableSeed(t *testing.T) {
+	a := NewPatternWithParams(100, 7, 64, 1)
+	b := NewPatternWithParams(100, 7, 64, 1)
+	c := NewPatternWithParams(100, 7, 64, 2)
+	if !slices.Equal(a.patterns, b.patterns) {
+		t.Error("same seed generated different patterns")
+	}
+	if slices.Equal(a.patterns, c.patterns) {
+		t.Error("different seeds generated the same patterns")
+	}
+	if &a.patterns[0] != &b.patterns[0] {
+		t.Error("filters with the same parameters do not share a table")
+	}
+	if d := NewPatternWithParams(100, 8, 64, 1); &d.patterns[0] == &a.patterns[0] {
+		t.Error("filters with different k share a table")
+	}
+
+	// The generator is part of the serialization format and must never change
+	want := [BlockWords]uint64{0, 0, 0, 0x800000000, 0x400000800, 0x2000000000000000, 0x40, 0x2000000000002}
+	if got := [BlockWords]uint64(a.patterns[:BlockWords]); got != want {
+		t.Errorf("first pattern for seed 1 is %#x, want %#x", got, want)
+	}
+}
+
+func TestPatternTableConcurrent(t *testing.T) {
+	// Filters created at once with new parameters all get the same table
+	const n = 8
+	var wg sync.WaitGroup
+	filters := make([]*PatternFilter, n)
+	for i := range filters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			filters[i] = NewPatternWithParams(10, 5, 128, 0xc0ffee)
+		}()
+	}
+	wg.Wait()
+	for _, f := range filters[1:] {
+		if &f.patterns[0] != &filters[0].patterns[0] {
+			t.Fatal("concurrently created filters do not share a table")
+		}
+	}
+}
+
+func TestPatternFilterSerialize(t *testing.T) {
+	original := NewPatternWithParams(50, 9, 256, 12345)
+	for i := range 2000 {
+		original.AddString(fmt.Sprintf("item-%d", i))
+	}
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if data[0] != serializePatternVersion || len(data) != patternHeaderSize+50*64 {
+		t.Errorf("got version %d and %d bytes", data[0], len(data))
+	}
+	if seed := binary.LittleEndian.Uint64(data[25:33]); seed != 12345 {
+		t.Errorf("header seed = %d, want 12345", seed)
+	}
+
+	restored, err := UnmarshalPatternBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalPatternBinary failed: %v", err)
+	}
+	if restored.K() != 9 || restored.NumBlocks() != 50 || restored.TableSize() != 256 || restored.Seed() != 12345 {
+		t.Errorf("params mismatch: got (%d, %d, %d, %d)", restored.K(), restored.NumBlocks(), restored.TableSize(), restored.Seed())
+	}
+	if restored.Count() != 2000 || restored.EstimatedFillRatio() != original.EstimatedFillRatio() {
+		t.Errorf("counters mismatch: got (%d, %f), want (2000, %f)", restored.Count(), restored.EstimatedFillRatio(), original.EstimatedFillRatio())
+	}
+	if !slices.Equal(restored.patterns, original.patterns) {
+		t.Error("regenerated patterns differ")
+	}
+	for i := range 2000 {
+		if !restored.TestString(fmt.Sprintf("item-%d", i)) {
+			t.Fatalf("false negative for item-%d", i)
+		}
+	}
+
+	again, err := restored.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("second roundtrip produced different bytes")
+	}
+
+	// The formats of Filter and PatternFilter are not interchangeable
+	if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBinary of a PatternFilter: expected ErrUnsupportedVersion, got %v", err)
+	}
+	filterData, _ := NewWithParams(50, 7).MarshalBinary()
+	if _, err := UnmarshalPatternBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalPatternBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestPatternFilterSerializeInvalid(t *testing.T) {
+	data, err := NewPatternWithParams(4, 7, 64, 1).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrInvalidData},
+		{"short header", data[:patternHeaderSize-1], ErrInvalidData},
+		{"truncated blocks", data[:len(data)-1], ErrInvalidData},
+		{"extra bytes", append(bytes.Clone(data), 0), ErrInvalidData},
+		{"k zero", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], 0) }), ErrInvalidK},
+		{"k too large", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], MaxPatternK+1) }), ErrInvalidK},
+		{"zero blocks", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], 0) }), ErrInvalidData},
+		{"too many blocks", corrupt(func(b []byte) { binary.LittleEndian.PutUint64(b[5:13], 1<<60) }), ErrInvalidData},
+		{"table size", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[21:25], 100) }), ErrInvalidData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalPatternBinary(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestOptimalPatternParams(t *testing.T) {
+	for _, fpRate := range []float64{0.1, 0.01, 0.001, 1e-4} {
+		numBlocks, k, bitsPerItem := OptimalPatternParams(100000, fpRate, DefaultPatternTableSize)
+		if fp := EstimatePatternFalsePositiveRate(numBlocks, k, DefaultPatternTableSize, 100000); fp > fpRate {
+			t.Errorf("fpRate=%g: estimate %g misses target", fpRate, fp)
+		}
+		if fp := EstimatePatternFalsePositiveRate(numBlocks-1, k, DefaultPatternTableSize, 100000); fp <= fpRate {
+			t.Errorf("fpRate=%g: one block fewer also reaches target", fpRate)
+		}
+		if bitsPerItem != float64(numBlocks*BlockBits)/100000 {
+			t.Errorf("fpRate=%g: bitsPerItem %f inconsistent with %d blocks", fpRate, bitsPerItem, numBlocks)
+		}
+	}
+
+	// Larger tables collide less, so they need fewer blocks at low rates
+	small, _, _ := OptimalPatternParams(100000, 1e-5, 64)
+	large, _, _ := OptimalPatternParams(100000, 1e-5, MaxPatternTableSize)
+	if large >= small {
+		t.Errorf("table of %d needs %d blocks, table of 64 needs %d", MaxPatternTableSize, large, small)
+	}
+}
+
+func TestOptimalPatternParamsEdgeCases(t *testing.T) {
+	for _, tc := range []struct {
+		items     uint64
+		fpRate    float64
+		tableSize uint32
+	}{
+		{0, 0.01, 64},
+		{1000, 0, 64},
+		{1000, 1.5, 64},
+		{1000, 0.01, 0},
+		{1000, 1e-12, 1},
+	} {
+		numBlocks, k, bitsPerItem := OptimalPatternParams(tc.items, tc.fpRate, tc.tableSize)
+		if numBlocks == 0 || k < MinK || k > MaxPatternK || bitsPerItem > maxPatternBitsPerItem+BlockBits {
+			t.Errorf("OptimalPatternParams(%d, %g, %d) = (%d, %d, %f)", tc.items, tc.fpRate, tc.tableSize, numBlocks, k, bitsPerItem)
+		}
+	}
+}
+
+func TestEstimatePatternFalsePositiveRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		numBlocks uint64
+		k         uint32
+		tableSize uint32
+		items     uint64
+	}{
+		{"zero blocks", 0, 7, 64, 100},
+		{"zero items", 100, 7, 64, 0},
+		{"zero k", 100, 0, 64, 100},
+		{"k too large", 100, MaxPatternK + 1, 64, 100},
+		{"bad table size", 100, 7, 100, 100},
+	}
+	for _, tt := range tests {
+		if got := EstimatePatternFalsePositiveRate(tt.numBlocks, tt.k, tt.tableSize, tt.items); got != 0 {
+			t.Errorf("%s: got %g, want 0", tt.name, got)
+		}
+	}
+
+	if got := EstimatePatternFalsePositiveRate(1, 7, 64, 1_000_000); got < 0.999 || got > 1 {
+		t.Errorf("overloaded filter: got %g", got)
+	}
+
+	// A single pattern matches every item in its block regardless of k
+	one := EstimatePatternFalsePositiveRate(1000, 20, 1, 5000)
+	many := EstimatePatternFalsePositiveRate(1000, 20, MaxPatternTableSize, 5000)
+	if one <= many {
+		t.Errorf("single pattern estimate %g not above large table's %g", one, many)
+	}
+}
+
+func TestPatternFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, tc := range []struct {
+		k, tableSize uint32
+		items        int
+	}{
+		{7, 1024, 100000},
+		{10, 1024, 70000},
+		{7, 1, 100000},
+		{8, 16, 60000},
+	} {
+		t.Run(fmt.Sprintf("k_%d/table_%d", tc.k, tc.tableSize), func(t *testing.T) {
+			f := NewPatternWithParams(2000, tc.k, tc.tableSize, 0)
+			for i := range tc.items {
+				f.Add(fmt.Appendf(nil, "item-%d", i))
+			}
+
+			const testItems = 1_000_000
+			var falsePositives int
+			for i := range testItems {
+				if f.Test(fmt.Appendf(nil, "notitem-%d", i)) {
+					falsePositives++
+				}
+			}
+
+			actual := float64(falsePositives) / testItems
+			estimated := f.EstimatedFalsePositiveRate()
+			if actual > estimated*1.2 || actual < estimated/1.2 {
+				t.Errorf("FP rate %.3g too far from estimate %.3g", actual, estimated)
+			}
+			t.Logf("FP rate %.3g (estimate %.3g)", actual, estimated)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package gloom
+
+import "math"
+
+// Plan describes the size and predicted accuracy of a Filter, AtomicFilter,
+// or ShardedAtomicFilter. The PlanFor functions answer capacity questions
+// from different starting points, and NewFromPlan, NewAtomicFromPlan, and
+// NewShardedAtomicFromPlan build a filter from the result.
+//
+// Predicted rates use the partitioned Poisson model of
+// EstimateBlockFalsePositiveRate, so they account for block load variance.
+type Plan struct {
+	NumBlocks     uint64  // Number of blocks
+	K             uint32  // Number of hash functions (partitions)
+	BlockBits     uint32  // Bits per block: 256, 512, or 1024
+	Bytes         uint64  // Memory taken by the blocks
+	ExpectedItems uint64  // Number of items the plan is sized for
+	BitsPerItem   float64 // Bits of memory per expected item
+	FPRateAt50    float64 // Predicted false positive rate at half the expected items
+	FPRateAt100   float64 // Predicted false positive rate at the expected items
+	FPRateAt150   float64 // Predicted false positive rate at 1.5x the expected items
+}
+
+// newPlan fills in a Plan's derived fields from its parameters.
+func newPlan(numBlocks uint64, k, blockBits uint32, expectedItems uint64) Plan {
+	p := Plan{
+		NumBlocks:     numBlocks,
+		K:             k,
+		BlockBits:     blockBits,
+		Bytes:         numBlocks * uint64(blockBits) / 8,
+		ExpectedItems: expectedItems,
+		FPRateAt50:    EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, expectedItems/2),
+		FPRateAt100:   EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, expectedItems),
+		FPRateAt150:   EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, expectedItems+expectedItems/2),
+	}
+	if expectedItems > 0 {
+		p.BitsPerItem = float64(numBlocks*uint64(blockBits)) / float64(expectedItems)
+	}
+	return p
+}
+
+// PlanForItems returns the plan New uses for the expected number of items
+// and desired false positive rate, so NewFromPlan(PlanForItems(n, p)) builds
+// the same filter as New(n, p). Its predicted rates may differ from fpRate,
+// since OptimalParams sizes filters with the unblocked formula.
+func PlanForItems(expectedItems uint64, fpRate float64) Plan {
+	numBlocks, k, _ := OptimalParams(expectedItems, fpRate)
+	return newPlan(numBlocks, k, BlockBits, expectedItems)
+}
+
+// PlanForMemory returns the plan that holds the most items within a memory
+// budget of bytes while keeping the predicted false positive rate at or
+// below fpRate. Budgets below one block are rounded up to one block.
+// ExpectedItems is 0 if even a single item would exceed fpRate.
+//
+// As in OptimalBlockParams, 1024-bit blocks are used only when 512-bit
+// blocks holding as many items would exceed twice fpRate.
+func PlanForMemory(bytes uint64, fpRate float64) Plan {
+	if fpRate <= 0 {
+		fpRate = 0.0001 // default to 0.01%, as in OptimalParams
+	}
+	if fpRate >= 1 {
+		fpRate = 0.99
+	}
+
+	blockBits := uint32(BlockBits)
+	numBlocks := budgetBlocks(bytes, MaxBlockBits)
+	items := maxPlanItems(numBlocks, MaxBlockBits, fpRate)
+	if _, fp := bestPlanK(budgetBlocks(bytes, BlockBits), BlockBits, items); fp > 2*fpRate {
+		blockBits = MaxBlockBits
+	} else {
+		numBlocks = budgetBlocks(bytes, BlockBits)
+		items = maxPlanItems(numBlocks, BlockBits, fpRate)
+	}
+
+	k, _ := bestPlanK(numBlocks, blockBits, items)
+	return newPlan(numBlocks, k, blockBits, items)
+}
+
+// PlanForItemsInMemory returns the plan with the lowest predicted false
+// positive rate for the expected number of items within a memory budget of
+// bytes. Budgets below one block are rounded up to one block.
+//
+// 1024-bit blocks are used only when they more than halve the false positive
+// rate of 512-bit blocks, since each probe then touches two cache lines.
+func PlanForItemsInMemory(expectedItems, bytes uint64) Plan {
+	numBlocks, blockBits := budgetBlocks(bytes, BlockBits), uint32(BlockBits)
+	k, fp := bestPlanK(numBlocks, blockBits, expectedItems)
+
+	wideBlocks := budgetBlocks(bytes, MaxBlockBits)
+	if wideK, wideFP := bestPlanK(wideBlocks, MaxBlockBits, expectedItems); fp > 2*wideFP {
+		numBlocks, k, blockBits = wideBlocks, wideK, MaxBlockBits
+	}
+	return newPlan(numBlocks, k, blockBits, expectedItems)
+}
+
+// PlanForBlocks returns the plan with the lowest predicted false positive
+// rate for the expected number of items in numBlocks blocks of blockBits
+// bits. As in NewWithBlockParams, an unsupported block size falls back to
+// 512 bits and zero blocks to one.
+func PlanForBlocks(numBlocks uint64, blockBits uint32, expectedItems uint64) Plan {
+	if numBlocks == 0 {
+		numBlocks = 1
+	}
+	if !ValidBlockBits(blockBits) {
+		blockBits = BlockBits
+	}
+	k, _ := bestPlanK(numBlocks, blockBits, expectedItems)
+	return newPlan(numBlocks, k, blockBits, expectedItems)
+}
+
+// budgetBlocks returns the number of blocks of blockBits bits that fit in
+// bytes, and at least one.
+func budgetBlocks(bytes uint64, blockBits uint32) uint64 {
+	return max(bytes/uint64(blockBits/8), 1)
+}
+
+// bestPlanK returns the k with the lowest predicted false positive rate for
+// items in numBlocks blocks of blockBits bits, and that rate.
+//
+// Block load variance only lowers the best k below the unblocked optimum of
+// ln2 bits per item, so k more than one above it are not considered.
+func bestPlanK(numBlocks uint64, blockBits uint32, items uint64) (bestK uint32, bestFP float64) {
+	maxK := MaxKForBlockBits(blockBits)
+	if unblocked := ln2 * float64(numBlocks) * float64(blockBits) / float64(items); unblocked < float64(maxK) {
+		maxK = min(uint32(math.Ceil(unblocked))+1, maxK)
+	}
+	return bestBlockK(numBlocks, blockBits, items, MinK, maxK)
+}
+
+// maxPlanItems returns the most items numBlocks blocks of blockBits bits can
+// hold with a predicted false positive rate at or below fpRate, using the
+// best k for each count.
+func maxPlanItems(numBlocks uint64, blockBits uint32, fpRate float64) uint64 {
+	// A blocked filter never beats an unblocked one with the best real k,
+	// which bounds the search. That k is below 1 once there are more than
+	// ln2 items per bit, and then k=1 is best
+	totalBits := float64(numBlocks) * float64(blockBits)
+	bound := totalBits * ln2Squared / -math.Log(fpRate)
+	if bound > totalBits*ln2 {
+		bound = totalBits * -math.Log1p(-fpRate)
+	}
+	lo, hi := uint64(0), uint64(bound)+1
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if _, fp := bestPlanK(numBlocks, blockBits, mid); fp <= fpRate {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// NewFromPlan creates a new bloom filter with the parameters of a Plan.
+func NewFromPlan(p Plan) *Filter {
+	return NewWithBlockParams(p.NumBlocks, p.K, p.BlockBits)
+}
+
+// NewAtomicFromPlan creates a new thread-safe bloom filter with the
+// parameters of a Plan.
+func NewAtomicFromPlan(p Plan) *AtomicFilter {
+	return NewAtomicWithBlockParams(p.NumBlocks, p.K, p.BlockBits)
+}
+
+// NewShardedAtomicFromPlan creates a new sharded thread-safe bloom filter
+// with the parameters of a Plan, splitting its blocks evenly across shards.
+// numShards must be a power of 2 (will be rounded up if not).
+func NewShardedAtomicFromPlan(p Plan, numShards uint64) *ShardedAtomicFilter {
+	numShards = nextPowerOf2(numShards)
+	blocksPerShard := (p.NumBlocks + numShards - 1) / numShards
+
+	shards := make([]*AtomicFilter, numShards)
+	for i := range shards {
+		shards[i] = NewAtomicWithBlockParams(blocksPerShard, p.K, p.BlockBits)
+	}
+
+	return &ShardedAtomicFilter{
+		shards:    shards,
+		numShards: numShards,
+		mask:      numShards - 1,
+	}
+}
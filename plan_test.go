@@ -0,0 +1,201 @@
+package gloom
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// checkPlan verifies that a Plan's derived fields match its parameters.
+func checkPlan(t *testing.T, name string, p Plan) {
+	t.Helper()
+	if p.NumBlocks == 0 || !ValidBlockBits(p.BlockBits) || GetBlockPartition(p.K, p.BlockBits) == nil {
+		t.Fatalf("%s: invalid parameters %+v", name, p)
+	}
+	if p.Bytes != p.NumBlocks*uint64(p.BlockBits)/8 {
+		t.Errorf("%s: Bytes = %d for %d blocks of %d bits", name, p.Bytes, p.NumBlocks, p.BlockBits)
+	}
+	if p.ExpectedItems > 0 && p.BitsPerItem != float64(p.Bytes*8)/float64(p.ExpectedItems) {
+		t.Errorf("%s: BitsPerItem = %f", name, p.BitsPerItem)
+	}
+	if want := EstimateBlockFalsePositiveRate(p.NumBlocks, p.K, p.BlockBits, p.ExpectedItems); p.FPRateAt100 != want {
+		t.Errorf("%s: FPRateAt100 = %g, want %g", name, p.FPRateAt100, want)
+	}
+	if p.ExpectedItems > 1 && (p.FPRateAt50 >= p.FPRateAt100 || p.FPRateAt100 >= p.FPRateAt150) {
+		t.Errorf("%s: rates not increasing with load: %g, %g, %g", name, p.FPRateAt50, p.FPRateAt100, p.FPRateAt150)
+	}
+}
+
+// checkBestK verifies that no other k predicts a lower rate for a Plan.
+func checkBestK(t *testing.T, name string, p Plan) {
+	t.Helper()
+	for k := uint32(MinK); k <= MaxKForBlockBits(p.BlockBits); k++ {
+		if fp := EstimateBlockFalsePositiveRate(p.NumBlocks, k, p.BlockBits, p.ExpectedItems); fp < p.FPRateAt100 {
+			t.Errorf("%s: k=%d predicts %g, below k=%d's %g", name, k, fp, p.K, p.FPRateAt100)
+		}
+	}
+}
+
+func TestPlanForItems(t *testing.T) {
+	for _, fpRate := range []float64{0.1, 0.01, 0.001, 1e-5} {
+		p := PlanForItems(100000, fpRate)
+		checkPlan(t, fmt.Sprintf("fpRate=%g", fpRate), p)
+
+		// The plan is exactly the filter New builds
+		f, g := NewFromPlan(p), New(100000, fpRate)
+		if f.NumBlocks() != g.NumBlocks() || f.K() != g.K() || f.BlockBits() != g.BlockBits() {
+			t.Errorf("fpRate=%g: plan builds (%d, %d, %d), New builds (%d, %d, %d)", fpRate,
+				f.NumBlocks(), f.K(), f.BlockBits(), g.NumBlocks(), g.K(), g.BlockBits())
+		}
+	}
+}
+
+func TestPlanForMemory(t *testing.T) {
+	const budget = 64 << 10
+	for _, tc := range []struct {
+		fpRate    float64
+		blockBits uint32
+	}{
+		{0.1, 512},
+		{0.01, 512},
+		{0.001, 512},
+		{1e-5, 1024},
+		{1e-6, 1024},
+	} {
+		name := fmt.Sprintf("fpRate=%g", tc.fpRate)
+		p := PlanForMemory(budget, tc.fpRate)
+		checkPlan(t, name, p)
+		checkBestK(t, name, p)
+
+		if p.Bytes != budget || p.BlockBits != tc.blockBits {
+			t.Errorf("%s: got %d bytes of %d-bit blocks, want %d of %d-bit", name, p.Bytes, p.BlockBits, budget, tc.blockBits)
+		}
+		if p.FPRateAt100 > tc.fpRate {
+			t.Errorf("%s: predicted rate %g misses target", name, p.FPRateAt100)
+		}
+
+		// One more item misses the target with every k
+		if _, fp := bestPlanK(p.NumBlocks, p.BlockBits, p.ExpectedItems+1); fp <= tc.fpRate {
+			t.Errorf("%s: %d items also reach the target", name, p.ExpectedItems+1)
+		}
+
+		// The inverse query agrees
+		if q := PlanForItemsInMemory(p.ExpectedItems, budget); q.FPRateAt100 > tc.fpRate {
+			t.Errorf("%s: PlanForItemsInMemory predicts %g", name, q.FPRateAt100)
+		}
+	}
+}
+
+func TestPlanForMemoryEdgeCases(t *testing.T) {
+	// Rates out of range are clamped like OptimalParams
+	if p := PlanForMemory(64<<10, 0); p.FPRateAt100 > 0.0001 || p.ExpectedItems == 0 {
+		t.Errorf("fpRate=0: got %+v", p)
+	}
+	if p := PlanForMemory(64<<10, 1.5); p.FPRateAt100 > 0.99 || p.ExpectedItems == 0 {
+		t.Errorf("fpRate=1.5: got %+v", p)
+	}
+
+	// Budgets below one block get one block
+	p := PlanForMemory(10, 0.01)
+	checkPlan(t, "tiny budget", p)
+	if p.NumBlocks != 1 || p.ExpectedItems == 0 {
+		t.Errorf("tiny budget: got %+v", p)
+	}
+
+	// A rate no single item can reach leaves nothing to hold
+	p = PlanForMemory(64<<10, 1e-60)
+	if p.ExpectedItems != 0 || p.BitsPerItem != 0 || p.FPRateAt150 != 0 {
+		t.Errorf("unreachable rate: got %+v", p)
+	}
+}
+
+func TestPlanForItemsInMemory(t *testing.T) {
+	const budget = 64 << 10
+	for _, tc := range []struct {
+		items     uint64
+		blockBits uint32
+	}{
+		{100000, 512},
+		{50000, 512},
+		{25000, 512},
+		{20000, 1024},
+		{15000, 1024},
+		{10000, 1024},
+	} {
+		name := fmt.Sprintf("items=%d", tc.items)
+		p := PlanForItemsInMemory(tc.items, budget)
+		checkPlan(t, name, p)
+		checkBestK(t, name, p)
+		if p.Bytes != budget || p.BlockBits != tc.blockBits || p.ExpectedItems != tc.items {
+			t.Errorf("%s: got %d bytes of %d-bit blocks for %d items", name, p.Bytes, p.BlockBits, p.ExpectedItems)
+		}
+
+		// The other block size is either less accurate or not worth a second
+		// cache line
+		narrow := PlanForBlocks(budget*8/BlockBits, BlockBits, tc.items)
+		wide := PlanForBlocks(budget*8/MaxBlockBits, MaxBlockBits, tc.items)
+		if (p.BlockBits == MaxBlockBits) != (narrow.FPRateAt100 > 2*wide.FPRateAt100) {
+			t.Errorf("%s: chose %d-bit blocks with rates %g (512) and %g (1024)", name, p.BlockBits, narrow.FPRateAt100, wide.FPRateAt100)
+		}
+	}
+
+	if p := PlanForItemsInMemory(0, 0); p.NumBlocks != 1 || p.FPRateAt100 != 0 {
+		t.Errorf("empty plan: got %+v", p)
+	}
+}
+
+func TestPlanForBlocks(t *testing.T) {
+	for _, blockBits := range []uint32{256, 512, 1024} {
+		for _, items := range []uint64{100, 10000, 34000, 100000} {
+			name := fmt.Sprintf("blockBits=%d/items=%d", blockBits, items)
+			p := PlanForBlocks(1000, blockBits, items)
+			checkPlan(t, name, p)
+			checkBestK(t, name, p)
+			if p.NumBlocks != 1000 || p.BlockBits != blockBits {
+				t.Errorf("%s: got %d blocks of %d bits", name, p.NumBlocks, p.BlockBits)
+			}
+		}
+	}
+
+	// Unsupported values fall back like NewWithBlockParams
+	if p := PlanForBlocks(0, 100, 10); p.NumBlocks != 1 || p.BlockBits != BlockBits {
+		t.Errorf("invalid params: got %+v", p)
+	}
+}
+
+func TestNewFromPlan(t *testing.T) {
+	p := PlanForBlocks(1000, 1024, 20000)
+
+	f := NewFromPlan(p)
+	af := NewAtomicFromPlan(p)
+	for _, got := range [][3]uint64{
+		{f.NumBlocks(), uint64(f.K()), uint64(f.BlockBits())},
+		{af.NumBlocks(), uint64(af.K()), uint64(af.BlockBits())},
+	} {
+		if got != [3]uint64{p.NumBlocks, uint64(p.K), uint64(p.BlockBits)} {
+			t.Errorf("filter built with %v, want %+v", got, p)
+		}
+	}
+
+	sf := NewShardedAtomicFromPlan(p, 6)
+	if sf.NumShards() != 8 || sf.K() != p.K || sf.BlockBits() != p.BlockBits {
+		t.Errorf("sharded filter: %d shards, k=%d, %d-bit blocks", sf.NumShards(), sf.K(), sf.BlockBits())
+	}
+	if sf.Cap() < p.NumBlocks*uint64(p.BlockBits) || sf.Cap() > (p.NumBlocks+8)*uint64(p.BlockBits) {
+		t.Errorf("sharded filter Cap() = %d, plan has %d bits", sf.Cap(), p.NumBlocks*uint64(p.BlockBits))
+	}
+
+	for i := range 20000 {
+		key := fmt.Sprintf("item-%d", i)
+		f.AddString(key)
+		sf.AddString(key)
+	}
+	if fp := f.EstimatedFalsePositiveRate(); math.Abs(fp-p.FPRateAt100) > 1e-12 {
+		t.Errorf("filled filter estimates %g, plan predicted %g", fp, p.FPRateAt100)
+	}
+	for i := range 20000 {
+		if !sf.TestString(fmt.Sprintf("item-%d", i)) {
+			t.Fatalf("false negative for item-%d", i)
+		}
+	}
+}
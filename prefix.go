@@ -0,0 +1,387 @@
+package gloom
+
+import (
+	"bytes"
+	"math"
+	"unsafe"
+)
+
+// PrefixExtractor returns the length of the prefix of key that a
+// PrefixFilter indexes, or -1 if key has none. It must not modify or retain
+// key.
+//
+// For TestPrefix to be correct, an extractor must be consistent: if it
+// returns n for a string p, it must return n for every key that begins with
+// p. FixedPrefix and DelimiterPrefix are.
+type PrefixExtractor func(key []byte) int
+
+// FixedPrefix returns a PrefixExtractor for the first n bytes of each key,
+// or at least 1. Keys shorter than n have no prefix.
+func FixedPrefix(n int) PrefixExtractor {
+	n = max(n, 1)
+	return func(key []byte) int {
+		if len(key) < n {
+			return -1
+		}
+		return n
+	}
+}
+
+// DelimiterPrefix returns a PrefixExtractor for each key up to and including
+// the count-th occurrence of delim, or the first if count is below 1. Keys
+// with fewer occurrences have no prefix. For example, DelimiterPrefix(':', 2)
+// extracts "user:123:" from "user:123:name".
+func DelimiterPrefix(delim byte, count int) PrefixExtractor {
+	count = max(count, 1)
+	return func(key []byte) int {
+		n := 0
+		for range count {
+			i := bytes.IndexByte(key[n:], delim)
+			if i < 0 {
+				return -1
+			}
+			n += i + 1
+		}
+		return n
+	}
+}
+
+// PrefixFilter is a bloom filter that answers prefix queries as well as
+// point queries, so that a prefix or range scan can skip data with no key
+// under its prefix.
+//
+// Adding a key also adds the prefix its PrefixExtractor returns. The prefix
+// chooses a group of spread adjacent blocks, and the key's hash chooses one
+// of them, so a point lookup touches a single block. The prefix entry is
+// stored in every block of the group, so the key and its prefix share a
+// cache line. Keys without a prefix are placed as in a Filter. Within a
+// block, the key and the prefix each set k bits from independent hashes.
+//
+// Since keys sharing a prefix share a group, a prefix with many keys
+// crowds its blocks and raises the false positive rate of the keys in them.
+// Spreading groups over more blocks evens the load out, at the cost of a
+// prefix entry per block, and of Add and TestPrefix touching every block of
+// the group. OptimalPrefixParams chooses the spread and size for this. A
+// PrefixFilter is not safe for concurrent use.
+type PrefixFilter struct {
+	filter  *Filter         // Blocks, partitions, and counters, as in a Filter
+	spread  uint32          // Adjacent blocks the keys of a prefix are spread over
+	extract PrefixExtractor // Nil if keys have no prefixes
+}
+
+// NewPrefix creates a new prefix filter for the expected number of keys,
+// under expectedPrefixes distinct prefixes, with the desired false positive
+// rate. See OptimalPrefixParams.
+func NewPrefix(expectedItems, expectedPrefixes uint64, fpRate float64, extract PrefixExtractor) *PrefixFilter {
+	numBlocks, k, blockBits, spread, _ := OptimalPrefixParams(expectedItems, expectedPrefixes, fpRate)
+	return NewPrefixWithParams(numBlocks, k, blockBits, spread, extract)
+}
+
+// NewPrefixWithParams creates a new prefix filter with explicit parameters.
+// Unsupported values fall back as in NewWithBlockParams. spread is the
+// number of adjacent blocks the keys of a prefix are spread over, clamped
+// to between 1 and the number of blocks. If extract is nil, keys have no
+// prefixes, and the filter behaves as a Filter.
+func NewPrefixWithParams(numBlocks uint64, k, blockBits, spread uint32, extract PrefixExtractor) *PrefixFilter {
+	f := NewWithBlockParams(numBlocks, k, blockBits)
+	spread = uint32(min(uint64(max(spread, 1)), f.numBlocks))
+	return &PrefixFilter{filter: f, spread: spread, extract: extract}
+}
+
+// stringBytes returns the bytes of s without copying them. They must not be
+// modified.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// prefixIntraHash returns the intra-block hash of a prefix entry with raw
+// hash h, independent of the bits that chose its block and of the
+// intra-block hash of a key with the same raw hash.
+func prefixIntraHash(h uint64) uint32 {
+	return uint32(mix64(h))
+}
+
+// groupBlock returns block i of the group of a prefix with raw hash h.
+func (p *PrefixFilter) groupBlock(h uint64, i uint32) uint64 {
+	first, _ := hashSplit(h, p.filter.numBlocks)
+	return (first + uint64(i)) % p.filter.numBlocks
+}
+
+// locate returns the block of a key with raw hash h, its intra-block hash,
+// and the raw hash of its prefix, if it has one. The upper 32 bits of h,
+// which a Filter uses to choose the block, choose the block within the
+// prefix's group.
+func (p *PrefixFilter) locate(key []byte, h uint64) (blockIdx uint64, intraHash uint32, prefixHash uint64, hasPrefix bool) {
+	n := -1
+	if p.extract != nil {
+		n = p.extract(key)
+	}
+	if n < 0 {
+		blockIdx, intraHash = hashSplit(h, p.filter.numBlocks)
+		return blockIdx, intraHash, 0, false
+	}
+	prefixHash = hashRaw(key[:n])
+	i := uint32((h >> 32) * uint64(p.spread) >> 32)
+	return p.groupBlock(prefixHash, i), uint32(h), prefixHash, true
+}
+
+// Add adds data and its prefix to the filter.
+func (p *PrefixFilter) Add(data []byte) {
+	p.add(data, hashRaw(data))
+}
+
+// AddString adds a string and its prefix to the filter without allocating.
+func (p *PrefixFilter) AddString(s string) {
+	p.add(stringBytes(s), hashRawString(s))
+}
+
+// add adds a key with raw hash h and its prefix.
+func (p *PrefixFilter) add(key []byte, h uint64) {
+	blockIdx, intraHash, prefixHash, hasPrefix := p.locate(key, h)
+	p.filter.addWithHash(blockIdx, intraHash)
+	if !hasPrefix {
+		return
+	}
+	// Prefixes are not counted as items
+	prefixIntra := prefixIntraHash(prefixHash)
+	for i := range p.spread {
+		p.filter.setBits += p.filter.setProbeBits(p.groupBlock(prefixHash, i), prefixIntra)
+	}
+}
+
+// Test checks if data might be in the filter.
+// Returns true if the data might be present (with false positive probability),
+// or false if the data is definitely not present.
+func (p *PrefixFilter) Test(data []byte) bool {
+	blockIdx, intraHash, _, _ := p.locate(data, hashRaw(data))
+	return p.filter.testWithHash(blockIdx, intraHash)
+}
+
+// TestString checks if a string might be in the filter without allocating.
+func (p *PrefixFilter) TestString(s string) bool {
+	blockIdx, intraHash, _, _ := p.locate(stringBytes(s), hashRawString(s))
+	return p.filter.testWithHash(blockIdx, intraHash)
+}
+
+// TestPrefix checks if any key beginning with prefix might be in the filter.
+// It returns false if no such key was added, so a scan of prefix can be
+// skipped. A prefix that was added is in every block of its group, so this
+// probes blocks of the group until one rejects it, up to Spread of them.
+//
+// The prefix the extractor returns for prefix is tested, so a scan may use
+// a longer prefix than the filter indexes: with FixedPrefix(4), a scan of
+// "user:12" tests "user". A prefix too short to have one of its own could
+// begin any key with a prefix, so TestPrefix returns true.
+func (p *PrefixFilter) TestPrefix(prefix []byte) bool {
+	n := -1
+	if p.extract != nil {
+		n = p.extract(prefix)
+	}
+	if n < 0 {
+		return true
+	}
+	h := hashRaw(prefix[:n])
+	prefixIntra := prefixIntraHash(h)
+	for i := range p.spread {
+		if !p.filter.testWithHash(p.groupBlock(h, i), prefixIntra) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestPrefixString checks if any key beginning with a string might be in
+// the filter without allocating. See TestPrefix.
+func (p *PrefixFilter) TestPrefixString(prefix string) bool {
+	return p.TestPrefix(stringBytes(prefix))
+}
+
+// Cap returns the capacity of the filter in bits.
+func (p *PrefixFilter) Cap() uint64 {
+	return p.filter.Cap()
+}
+
+// K returns the number of hash functions (partitions) used.
+func (p *PrefixFilter) K() uint32 {
+	return p.filter.k
+}
+
+// Count returns the approximate number of keys added to the filter, not
+// counting their prefixes.
+func (p *PrefixFilter) Count() uint64 {
+	return p.filter.count
+}
+
+// NumBlocks returns the number of blocks in the filter.
+func (p *PrefixFilter) NumBlocks() uint64 {
+	return p.filter.numBlocks
+}
+
+// BlockBits returns the number of bits per block.
+func (p *PrefixFilter) BlockBits() uint32 {
+	return p.filter.BlockBits()
+}
+
+// Spread returns the number of adjacent blocks the keys of a prefix are
+// spread over.
+func (p *PrefixFilter) Spread() uint32 {
+	return p.spread
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set, by keys
+// and prefixes. It runs in constant time using a set-bit count maintained
+// by Add.
+func (p *PrefixFilter) EstimatedFillRatio() float64 {
+	return p.filter.EstimatedFillRatio()
+}
+
+// EstimatePrefixFalsePositiveRate estimates the false positive rate of a
+// prefix filter holding itemsAdded keys under prefixes distinct prefixes,
+// taken to have equally many keys each, spread over groups of spread
+// blocks.
+//
+// A query for a key that was not added lands in a block of its prefix's
+// group. If that prefix was added, the block holds its share of the group's
+// keys and the prefix entry, on top of those of the other groups that
+// overlap the block at random. This function returns the false positive
+// rate of such queries:
+//
+//	FP = E[∏ᵢ (1 - (1 - 1/pᵢ)^((J+1)·g))]  where J ~ Poisson((prefixes-1)·s/B)
+//
+// with g = n/(prefixes·s) + 1 entries per group and block, and s the spread,
+// at most B. Queries for prefixes that were not added, and keys whose
+// prefix was not, land in blocks without a group of their own, and see a
+// lower rate. With no prefixes, it is EstimateBlockFalsePositiveRate. If k
+// is not supported for blockBits, it returns 1.
+func EstimatePrefixFalsePositiveRate(numBlocks uint64, k, blockBits, spread uint32, itemsAdded, prefixes uint64) float64 {
+	if prefixes == 0 || itemsAdded == 0 {
+		return EstimateBlockFalsePositiveRate(numBlocks, k, blockBits, itemsAdded)
+	}
+	primes := GetBlockPartition(k, blockBits)
+	if primes == nil {
+		return 1
+	}
+	numBlocks = max(numBlocks, 1)
+	s := float64(min(uint64(max(spread, 1)), numBlocks))
+
+	group := float64(itemsAdded)/float64(prefixes)/s + 1   // Keys and their prefix
+	lambda := float64(prefixes-1) * s / float64(numBlocks) // Other groups per block
+	if lambda == 0 {
+		return min(partitionedBlockFP(primes, group), 1)
+	}
+	maxJ := int(lambda + 10*math.Sqrt(lambda) + 20)
+	var fp float64
+	for j := 0; j <= maxJ; j++ {
+		lj, _ := math.Lgamma(float64(j) + 1)
+		logProb := -lambda + float64(j)*math.Log(lambda) - lj
+		fp += math.Exp(logProb) * partitionedBlockFP(primes, float64(j+1)*group)
+	}
+	return min(fp, 1)
+}
+
+// bestPrefixK returns the k with the lowest estimated false positive rate
+// for a prefix filter, and that rate.
+func bestPrefixK(numBlocks uint64, blockBits, spread uint32, items, prefixes uint64) (bestK uint32, bestFP float64) {
+	bestK, bestFP = MinK, math.Inf(1)
+	for k := uint32(MinK); k <= MaxKForBlockBits(blockBits); k++ {
+		if fp := EstimatePrefixFalsePositiveRate(numBlocks, k, blockBits, spread, items, prefixes); fp < bestFP {
+			bestK, bestFP = k, fp
+		}
+	}
+	return bestK, bestFP
+}
+
+// prefixFloor returns the lowest false positive rate a prefix filter can
+// reach with blocks of blockBits bits and groups of spread blocks, however
+// many blocks there are: that of a block holding just its share of the
+// query's own group.
+func prefixFloor(blockBits, spread uint32, items, prefixes uint64) float64 {
+	group := float64(items)/float64(prefixes)/float64(spread) + 1
+	floor := 1.0
+	for k := uint32(MinK); k <= MaxKForBlockBits(blockBits); k++ {
+		floor = min(floor, partitionedBlockFP(GetBlockPartition(k, blockBits), group))
+	}
+	return floor
+}
+
+// OptimalPrefixParams calculates the parameters of a prefix filter for
+// expectedItems keys under expectedPrefixes distinct prefixes, with the
+// desired false positive rate for keys. It returns the number of blocks, k,
+// the block size in bits, the spread, and bits per key, which include the
+// prefix entries.
+//
+// Each group of keys sharing a prefix is spread over adjacent blocks, with
+// a prefix entry in each, so blocks are loaded less evenly than in a
+// Filter. The filter is sized with EstimatePrefixFalsePositiveRate for each
+// spread from 1 up to the group size, doubling, and the spread that needs
+// the fewest blocks wins. Large groups thus get a spread that keeps their
+// share of a block small, and unique prefixes a spread of 1. Spreads whose
+// lowest reachable rate, that of a block holding just its share of one
+// group, is above half of fpRate are skipped. The block size is that of
+// OptimalBlockParams for the keys and prefixes together, and the filter
+// never has fewer blocks than that Filter.
+//
+// Only a rate below twice that of a block holding two entries is out of
+// reach. The filter is then sized for that rate, with a spread of the group
+// size, rather than spend ever more blocks approaching it; check the result
+// with EstimatePrefixFalsePositiveRate when that matters.
+//
+// expectedPrefixes is capped at expectedItems, since each key has at most
+// one prefix. With no prefixes, it returns the parameters of
+// OptimalBlockParams and a spread of 1.
+func OptimalPrefixParams(expectedItems, expectedPrefixes uint64, fpRate float64) (numBlocks uint64, k, blockBits, spread uint32, bitsPerItem float64) {
+	expectedItems = max(expectedItems, 1)
+	expectedPrefixes = min(expectedPrefixes, expectedItems)
+	if expectedPrefixes == 0 {
+		numBlocks, k, blockBits, bitsPerItem = OptimalBlockParams(expectedItems, fpRate)
+		return numBlocks, k, blockBits, 1, bitsPerItem
+	}
+	_, fpRate = optimalInputs(expectedItems, fpRate)
+
+	// Spreads double up to the first that gives each block at most one key
+	// of a group
+	group := float64(expectedItems) / float64(expectedPrefixes)
+	maxSpread := uint32(1)
+	for float64(maxSpread) < group {
+		maxSpread *= 2
+	}
+
+	_, _, blockBits, _ = OptimalBlockParams(expectedItems+expectedPrefixes, fpRate)
+	target := max(fpRate, 2*prefixFloor(blockBits, maxSpread, expectedItems, expectedPrefixes))
+	for s := uint32(1); s <= maxSpread; s *= 2 {
+		if 2*prefixFloor(blockBits, s, expectedItems, expectedPrefixes) > target {
+			continue
+		}
+
+		// A Filter sized for every entry bounds the blocks from below.
+		// Double them until the target is met, then search for the fewest
+		// that meet it
+		lo, _, loBits, _ := OptimalBlockParams(expectedItems+expectedPrefixes*uint64(s), fpRate)
+		lo = max(lo*uint64(loBits)/uint64(blockBits), uint64(s))
+		if numBlocks != 0 && lo >= numBlocks {
+			break // Larger spreads only add prefix entries
+		}
+		hi := lo
+		for {
+			if _, fp := bestPrefixK(hi, blockBits, s, expectedItems, expectedPrefixes); fp <= target {
+				break
+			}
+			lo, hi = hi+1, hi*2
+		}
+		for lo < hi {
+			mid := lo + (hi-lo)/2
+			if _, fp := bestPrefixK(mid, blockBits, s, expectedItems, expectedPrefixes); fp <= target {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		if numBlocks != 0 && hi >= numBlocks {
+			break // Past the best spread, larger ones need more blocks
+		}
+		numBlocks, spread = hi, s
+	}
+
+	k, _ = bestPrefixK(numBlocks, blockBits, spread, expectedItems, expectedPrefixes)
+	return numBlocks, k, blockBits, spread, float64(numBlocks) * float64(blockBits) / float64(expectedItems)
+}
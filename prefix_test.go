@@ -0,0 +1,341 @@
+package gloom
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestPrefixExtractors(t *testing.T) {
+	tests := []struct {
+		name    string
+		extract PrefixExtractor
+		key     string
+		want    int
+	}{
+		{"fixed", FixedPrefix(4), "user:123", 4},
+		{"fixed exact", FixedPrefix(4), "user", 4},
+		{"fixed short", FixedPrefix(4), "usr", -1},
+		{"fixed below 1", FixedPrefix(0), "user", 1},
+		{"fixed below 1 empty", FixedPrefix(0), "", -1},
+		{"delimiter", DelimiterPrefix(':', 1), "user:123:name", 5},
+		{"delimiter second", DelimiterPrefix(':', 2), "user:123:name", 9},
+		{"delimiter trailing", DelimiterPrefix(':', 2), "user:123:", 9},
+		{"delimiter missing", DelimiterPrefix(':', 2), "user:123", -1},
+		{"delimiter below 1", DelimiterPrefix('/', 0), "a/b/c", 2},
+		{"delimiter empty", DelimiterPrefix('/', 1), "", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.extract([]byte(tt.key)); got != tt.want {
+				t.Errorf("extract(%q) = %d, want %d", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixFilterBasic(t *testing.T) {
+	p := NewPrefix(10_000, 100, 0.01, DelimiterPrefix(':', 1))
+	for i := range 10_000 {
+		key := fmt.Sprintf("user%d:%d", i%100, i)
+		if i%2 == 0 {
+			p.AddString(key)
+		} else {
+			p.Add([]byte(key))
+		}
+	}
+	// Keys without a prefix are placed as in a Filter
+	p.AddString("no-delimiter")
+
+	for i := range 10_000 {
+		key := fmt.Sprintf("user%d:%d", i%100, i)
+		if !p.TestString(key) || !p.Test([]byte(key)) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+	if !p.TestString("no-delimiter") || !p.Test([]byte("no-delimiter")) {
+		t.Error("false negative for a key without a prefix")
+	}
+	for i := range 100 {
+		prefix := fmt.Sprintf("user%d:", i)
+		if !p.TestPrefixString(prefix) || !p.TestPrefix([]byte(prefix)) {
+			t.Fatalf("TestPrefix(%q) = false", prefix)
+		}
+		// A scan may use a longer prefix than the filter indexes
+		if !p.TestPrefixString(prefix + "12") {
+			t.Fatalf("TestPrefix(%q) = false", prefix+"12")
+		}
+	}
+	// A prefix too short to have one of its own could begin any key
+	if !p.TestPrefixString("user") || !p.TestPrefix(nil) {
+		t.Error("TestPrefix of a prefix without a prefix = false")
+	}
+
+	// Prefixes are not counted as items, but set bits
+	if p.Count() != 10_001 {
+		t.Errorf("Count() = %d", p.Count())
+	}
+	if p.EstimatedFillRatio() != p.filter.ExactFillRatio() || p.EstimatedFillRatio() == 0 {
+		t.Errorf("EstimatedFillRatio() = %f, ExactFillRatio() = %f", p.EstimatedFillRatio(), p.filter.ExactFillRatio())
+	}
+
+	numBlocks, k, blockBits, spread, _ := OptimalPrefixParams(10_000, 100, 0.01)
+	if p.NumBlocks() != numBlocks || p.K() != k || p.BlockBits() != blockBits || p.Spread() != spread || p.Cap() != numBlocks*uint64(blockBits) {
+		t.Errorf("got (%d, %d, %d, %d), want (%d, %d, %d, %d)", p.NumBlocks(), p.K(), p.BlockBits(), p.Spread(), numBlocks, k, blockBits, spread)
+	}
+}
+
+// usedBlocks returns the indexes of the blocks of p with a bit set.
+func usedBlocks(p *PrefixFilter) []uint64 {
+	var used []uint64
+	words := int(p.filter.blockWords)
+	for i := 0; i < len(p.filter.blocks); i += words {
+		if slices.ContainsFunc(p.filter.blocks[i:i+words], func(w uint64) bool { return w != 0 }) {
+			used = append(used, uint64(i/words))
+		}
+	}
+	return used
+}
+
+func TestPrefixFilterSharedBlock(t *testing.T) {
+	// The keys of a prefix and the prefix itself share one block
+	p := NewPrefixWithParams(1000, 7, 512, 1, FixedPrefix(4))
+	for i := range 50 {
+		p.AddString(fmt.Sprintf("abcd-%d", i))
+	}
+	if used := usedBlocks(p); len(used) != 1 {
+		t.Errorf("keys of one prefix set bits in %d blocks", len(used))
+	}
+}
+
+func TestPrefixFilterSpread(t *testing.T) {
+	// The keys of a prefix are spread over adjacent blocks, each of which
+	// holds the prefix, wrapping around at the end
+	for _, numBlocks := range []uint64{1000, 5} {
+		p := NewPrefixWithParams(numBlocks, 7, 512, 4, FixedPrefix(4))
+		if p.Spread() != 4 {
+			t.Fatalf("Spread() = %d", p.Spread())
+		}
+		for i := range 50 {
+			p.AddString(fmt.Sprintf("abcd-%d", i))
+		}
+		used := usedBlocks(p)
+		first, _ := hashSplit(hashRawString("abcd"), numBlocks)
+		want := []uint64{first, (first + 1) % numBlocks, (first + 2) % numBlocks, (first + 3) % numBlocks}
+		slices.Sort(want)
+		if !slices.Equal(used, want) {
+			t.Errorf("%d blocks: keys of one prefix set bits in blocks %v, want %v", numBlocks, used, want)
+		}
+		for i := range 50 {
+			if key := fmt.Sprintf("abcd-%d", i); !p.TestString(key) {
+				t.Fatalf("%d blocks: false negative for %q", numBlocks, key)
+			}
+		}
+		if !p.TestPrefixString("abcd") {
+			t.Errorf("%d blocks: TestPrefix of an added prefix = false", numBlocks)
+		}
+		if numBlocks == 1000 && p.TestPrefixString("wxyz") {
+			t.Error("TestPrefix of a prefix never added = true")
+		}
+	}
+
+	// The spread is clamped to between 1 and the number of blocks
+	if p := NewPrefixWithParams(100, 7, 512, 0, nil); p.Spread() != 1 {
+		t.Errorf("spread 0: Spread() = %d", p.Spread())
+	}
+	if p := NewPrefixWithParams(100, 7, 512, 1000, nil); p.Spread() != 100 {
+		t.Errorf("spread 1000: Spread() = %d", p.Spread())
+	}
+}
+
+func TestPrefixFilterHotPrefix(t *testing.T) {
+	// Every key under one prefix would saturate a single block; spread over
+	// the whole filter, they are as accurate as in a Filter
+	p := NewPrefix(10_000, 1, 0.01, FixedPrefix(4))
+	for i := range 10_000 {
+		p.AddString(fmt.Sprintf("abcd-%d", i))
+	}
+	var fps int
+	const probes = 100_000
+	for i := range probes {
+		if p.TestString(fmt.Sprintf("abcd-x%d", i)) {
+			fps++
+		}
+	}
+	if rate := float64(fps) / probes; rate > 0.01 {
+		t.Errorf("point false positive rate %g under a hot prefix", rate)
+	}
+	if !p.TestPrefixString("abcd") {
+		t.Error("TestPrefix of the hot prefix = false")
+	}
+}
+
+func TestPrefixFilterNilExtractor(t *testing.T) {
+	// Without an extractor, the filter is placed exactly as a Filter
+	p := NewPrefixWithParams(100, 7, 512, 1, nil)
+	f := NewWithBlockParams(100, 7, 512)
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		p.AddString(key)
+		f.AddString(key)
+	}
+	if !slices.Equal(p.filter.blocks, f.blocks) || p.EstimatedFillRatio() != f.EstimatedFillRatio() {
+		t.Error("filter without an extractor differs from a Filter")
+	}
+	if !p.TestPrefixString("anything") {
+		t.Error("TestPrefix without an extractor = false")
+	}
+}
+
+func TestPrefixFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, groupSize := range []int{1, 10, 100, 1000} {
+		t.Run(fmt.Sprintf("group_%d", groupSize), func(t *testing.T) {
+			const items, fpRate = 200_000, 0.01
+			prefixes := items / groupSize
+			p := NewPrefix(items, uint64(prefixes), fpRate, DelimiterPrefix(':', 1))
+			for i := range items {
+				p.AddString(fmt.Sprintf("p%d:%d", i%prefixes, i))
+			}
+
+			// Absent keys under added prefixes land in crowded blocks
+			const probes = 200_000
+			var fps int
+			for i := range probes {
+				if p.TestString(fmt.Sprintf("p%d:x%d", i%prefixes, i)) {
+					fps++
+				}
+			}
+			rate := float64(fps) / probes
+			est := EstimatePrefixFalsePositiveRate(p.NumBlocks(), p.K(), p.BlockBits(), p.Spread(), items, uint64(prefixes))
+			if math.Abs(rate-est) > 0.15*est {
+				t.Errorf("measured %g, estimated %g", rate, est)
+			}
+			if rate > fpRate*1.15 {
+				t.Errorf("measured %g, want at most %g", rate, fpRate)
+			}
+
+			// Prefixes never added land in random blocks
+			var prefixFPs int
+			for i := range probes {
+				if p.TestPrefixString(fmt.Sprintf("q%d:", i)) {
+					prefixFPs++
+				}
+			}
+			if prefixRate := float64(prefixFPs) / probes; prefixRate > fpRate {
+				t.Errorf("prefix false positive rate %g, want at most %g", prefixRate, fpRate)
+			}
+		})
+	}
+}
+
+func TestEstimatePrefixFalsePositiveRate(t *testing.T) {
+	// Without prefixes, it is that of a Filter
+	if got, want := EstimatePrefixFalsePositiveRate(1000, 7, 512, 1, 10_000, 0), EstimateBlockFalsePositiveRate(1000, 7, 512, 10_000); got != want {
+		t.Errorf("no prefixes: got %g, want %g", got, want)
+	}
+	if got := EstimatePrefixFalsePositiveRate(1000, 7, 512, 1, 0, 100); got != 0 {
+		t.Errorf("no items: got %g", got)
+	}
+	if got := EstimatePrefixFalsePositiveRate(1000, 99, 512, 1, 10_000, 100); got != 1 {
+		t.Errorf("unsupported k: got %g", got)
+	}
+	if got := EstimatePrefixFalsePositiveRate(0, 7, 512, 1, 10_000, 100); got != EstimatePrefixFalsePositiveRate(1, 7, 512, 1, 10_000, 100) {
+		t.Errorf("zero blocks: got %g", got)
+	}
+	// The spread is clamped as in NewPrefixWithParams
+	if got := EstimatePrefixFalsePositiveRate(100, 7, 512, 0, 10_000, 100); got != EstimatePrefixFalsePositiveRate(100, 7, 512, 1, 10_000, 100) {
+		t.Errorf("spread 0: got %g", got)
+	}
+	if got := EstimatePrefixFalsePositiveRate(100, 7, 512, 1000, 10_000, 100); got != EstimatePrefixFalsePositiveRate(100, 7, 512, 100, 10_000, 100) {
+		t.Errorf("spread above blocks: got %g", got)
+	}
+
+	// A single group spread over every block sees no other groups
+	if got, want := EstimatePrefixFalsePositiveRate(100, 7, 512, 100, 1000, 1), partitionedBlockFP(GetBlockPartition(7, 512), 11); got != want {
+		t.Errorf("one group: got %g, want %g", got, want)
+	}
+
+	// Grouping keys by prefix loads blocks less evenly than a Filter with
+	// the same entries, and spreading large groups evens them out
+	for _, prefixes := range []uint64{100_000, 10_000, 1000} {
+		fp := EstimatePrefixFalsePositiveRate(20_000, 7, 512, 1, 100_000, prefixes)
+		if even := EstimateBlockFalsePositiveRate(20_000, 7, 512, 100_000+prefixes); fp <= even {
+			t.Errorf("%d prefixes: got %g, a Filter has %g", prefixes, fp, even)
+		}
+		if spread := EstimatePrefixFalsePositiveRate(20_000, 7, 512, 8, 100_000, prefixes); prefixes < 100_000 && spread >= fp {
+			t.Errorf("%d prefixes: spread over 8 blocks %g, over 1 %g", prefixes, spread, fp)
+		}
+	}
+}
+
+func TestOptimalPrefixParams(t *testing.T) {
+	tests := []struct {
+		items, prefixes uint64
+		fpRate          float64
+		spread          uint32
+	}{
+		{1_000_000, 1_000_000, 0.01, 1},
+		{1_000_000, 100_000, 0.01, 2},
+		{1_000_000, 10_000, 0.01, 32},
+		{1_000_000, 1000, 0.01, 256},
+		{100_000, 1000, 0.001, 32},
+		{10_000, 1, 0.01, 256},
+		{1000, 100, 0, 4},
+		{1000, 100, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d_%d_%g", tt.items, tt.prefixes, tt.fpRate), func(t *testing.T) {
+			numBlocks, k, blockBits, spread, bitsPerItem := OptimalPrefixParams(tt.items, tt.prefixes, tt.fpRate)
+			if spread != tt.spread {
+				t.Errorf("spread = %d, want %d", spread, tt.spread)
+			}
+			if want := float64(numBlocks) * float64(blockBits) / float64(tt.items); bitsPerItem != want {
+				t.Errorf("bitsPerItem = %f, want %f", bitsPerItem, want)
+			}
+
+			// The block size and a lower bound on the blocks come from a
+			// Filter for every entry
+			fNumBlocks, _, fBlockBits, _ := OptimalBlockParams(tt.items+tt.prefixes, tt.fpRate)
+			if blockBits != fBlockBits {
+				t.Errorf("blockBits = %d, a Filter has %d", blockBits, fBlockBits)
+			}
+			if numBlocks < fNumBlocks || numBlocks < uint64(spread) {
+				t.Errorf("%d blocks, a Filter has %d", numBlocks, fNumBlocks)
+			}
+
+			// The rate is met with no more blocks than needed
+			fpRate := min(max(tt.fpRate, 0.0001), 0.99)
+			if fp := EstimatePrefixFalsePositiveRate(numBlocks, k, blockBits, spread, tt.items, tt.prefixes); fp > fpRate {
+				t.Errorf("estimated rate %g above %g", fp, fpRate)
+			}
+			if _, fp := bestPrefixK(numBlocks-1, blockBits, spread, tt.items, tt.prefixes); fp <= fpRate && numBlocks > max(fNumBlocks, uint64(spread)) {
+				t.Errorf("%d blocks also reach %g", numBlocks-1, fp)
+			}
+		})
+	}
+
+	// A rate below that of a block holding two entries cannot be reached,
+	// and the filter is sized for twice that rate
+	numBlocks, k, blockBits, spread, _ := OptimalPrefixParams(1000, 1, 1e-30)
+	if spread != 1024 {
+		t.Errorf("unreachable rate: spread = %d", spread)
+	}
+	if fp := EstimatePrefixFalsePositiveRate(numBlocks, k, blockBits, spread, 1000, 1); fp > 2*prefixFloor(blockBits, spread, 1000, 1) {
+		t.Errorf("unreachable rate: estimate %g", fp)
+	}
+
+	// Prefixes are capped at items, and without prefixes it is OptimalBlockParams
+	a, b, c, d, e := OptimalPrefixParams(1000, 5000, 0.01)
+	if f, g, h, i, j := OptimalPrefixParams(1000, 1000, 0.01); a != f || b != g || c != h || d != i || e != j {
+		t.Errorf("more prefixes than items: got (%d, %d, %d, %d, %f)", a, b, c, d, e)
+	}
+	a, b, c, d, e = OptimalPrefixParams(0, 0, 0.01)
+	if f, g, h, i := OptimalBlockParams(1, 0.01); a != f || b != g || c != h || d != 1 || e != i {
+		t.Errorf("no prefixes: got (%d, %d, %d, %d, %f)", a, b, c, d, e)
+	}
+}
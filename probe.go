@@ -0,0 +1,58 @@
+package gloom
+
+// simdProbeMinK is the smallest k for which the vector probe path is used.
+// Below it, the scalar loop is as fast as the vector setup cost.
+const simdProbeMinK = 5
+
+// probeLanes is the number of probes the widest vector path handles per
+// iteration. Probe tables are padded to a multiple of it.
+const probeLanes = 8
+
+// probeTable holds the per-k constants used by the vectorized probe path.
+//
+// Vector units have no integer modulo, so h % p is computed in float64 as
+// h - floor((h+0.5) * (1/p)) * p. Every intermediate is an integer below
+// 2^33 or a product whose rounding error is far smaller than the 0.5/p
+// margin introduced by the +0.5 bias, so the result is exact and the bit
+// positions are identical to those of the scalar loop.
+//
+// The tables are padded with copies of the first probe, which leaves the
+// result unchanged since that bit is always checked anyway.
+type probeTable struct {
+	inv       []float64 // 1 / primes[i]
+	primes    []float64 // primes[i] as float64
+	offsets   []int32   // Cumulative offsets within block
+	lanesAVX2 int       // k rounded up to a multiple of 4
+}
+
+// newProbeTable builds the padded vector constants for a prime partition.
+func newProbeTable(primes, offsets []uint32) *probeTable {
+	lanes := (len(primes) + probeLanes - 1) / probeLanes * probeLanes
+	t := &probeTable{
+		inv:       make([]float64, lanes),
+		primes:    make([]float64, lanes),
+		offsets:   make([]int32, lanes),
+		lanesAVX2: (len(primes) + 3) &^ 3,
+	}
+	for i := range lanes {
+		j := i
+		if j >= len(primes) {
+			j = 0
+		}
+		t.inv[i] = 1 / float64(primes[j])
+		t.primes[i] = float64(primes[j])
+		t.offsets[i] = int32(offsets[j])
+	}
+	return t
+}
+
+// test reports whether all k probe bits for intraHash are set in the block
+// starting at block, using the widest vector unit available.
+// It must only be called when hasSIMDProbe is true.
+func (t *probeTable) test(block *uint64, intraHash uint32) bool {
+	h := float64(intraHash)
+	if hasAVX512Probe {
+		return probeAVX512(block, h, h+0.5, &t.inv[0], &t.primes[0], &t.offsets[0], len(t.inv))
+	}
+	return probeAVX2(block, h, h+0.5, &t.inv[0], &t.primes[0], &t.offsets[0], t.lanesAVX2)
+}
@@ -0,0 +1,24 @@
+package gloom
+
+import "github.com/klauspost/cpuid/v2"
+
+// The vector probe paths are selected once at startup. They are variables
+// rather than constants so tests can force the scalar fallback and compare
+// the two.
+var (
+	hasAVX2Probe   = cpuid.CPU.Has(cpuid.AVX2)
+	hasAVX512Probe = cpuid.CPU.Has(cpuid.AVX512F)
+	hasSIMDProbe   = hasAVX2Probe || hasAVX512Probe
+)
+
+// probeAVX2 checks the probe bits of one block four probes at a time.
+// lanes must be a positive multiple of 4.
+//
+//go:noescape
+func probeAVX2(block *uint64, h, hq float64, inv, primes *float64, offsets *int32, lanes int) bool
+
+// probeAVX512 checks the probe bits of one block eight probes at a time.
+// lanes must be a positive multiple of 8.
+//
+//go:noescape
+func probeAVX512(block *uint64, h, hq float64, inv, primes *float64, offsets *int32, lanes int) bool
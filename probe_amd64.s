@@ -0,0 +1,114 @@
+#include "textflag.h"
+
+// func probeAVX2(block *uint64, h, hq float64, inv, primes *float64, offsets *int32, lanes int) bool
+TEXT ·probeAVX2(SB), NOSPLIT, $0-57
+	MOVQ block+0(FP), AX
+	MOVQ inv+24(FP), BX
+	MOVQ primes+32(FP), CX
+	MOVQ offsets+40(FP), DX
+	MOVQ lanes+48(FP), SI
+
+	VBROADCASTSD h+8(FP), Y0   // h
+	VBROADCASTSD hq+16(FP), Y1 // h + 0.5
+
+	// Y2 = 1 in every qword, X3 = 63 in every dword
+	VPCMPEQQ Y2, Y2, Y2
+	VPSRLQ   $63, Y2, Y2
+	VPCMPEQD X3, X3, X3
+	VPSRLD   $26, X3, X3
+
+loopAVX2:
+	// bitPos = offsets[i] + (h - floor((h+0.5) / p) * p)
+	VMULPD      (BX), Y1, Y4
+	VROUNDPD    $1, Y4, Y4
+	VMULPD      (CX), Y4, Y4
+	VSUBPD      Y4, Y0, Y4
+	VCVTTPD2DQY Y4, X4
+	VPADDD      (DX), X4, X4
+
+	// X5 = word index, Y4 = 1 << (bitPos % 64)
+	VPSRLD    $6, X4, X5
+	VPAND     X3, X4, X4
+	VPMOVZXDQ X4, Y4
+	VPSLLVQ   Y4, Y2, Y4
+
+	// Gather the four words and check that every probe bit is set
+	VPCMPEQQ   Y6, Y6, Y6
+	VPXOR      Y7, Y7, Y7
+	VPGATHERDQ Y6, (AX)(X5*8), Y7
+	VPAND      Y4, Y7, Y7
+	VPCMPEQQ   Y4, Y7, Y7
+	VMOVMSKPD  Y7, DI
+	CMPL       DI, $0x0f
+	JNE        missAVX2
+
+	ADDQ $32, BX
+	ADDQ $32, CX
+	ADDQ $16, DX
+	SUBQ $4, SI
+	JNZ  loopAVX2
+
+	VZEROUPPER
+	MOVB $1, ret+56(FP)
+	RET
+
+missAVX2:
+	VZEROUPPER
+	MOVB $0, ret+56(FP)
+	RET
+
+// func probeAVX512(block *uint64, h, hq float64, inv, primes *float64, offsets *int32, lanes int) bool
+TEXT ·probeAVX512(SB), NOSPLIT, $0-57
+	MOVQ block+0(FP), AX
+	MOVQ inv+24(FP), BX
+	MOVQ primes+32(FP), CX
+	MOVQ offsets+40(FP), DX
+	MOVQ lanes+48(FP), SI
+
+	VBROADCASTSD h+8(FP), Z0   // h
+	VBROADCASTSD hq+16(FP), Z1 // h + 0.5
+
+	// Z2 = 1 in every qword, Y3 = 63 in every dword
+	VPTERNLOGQ $0xff, Z2, Z2, Z2
+	VPSRLQ     $63, Z2, Z2
+	VPCMPEQD   Y3, Y3, Y3
+	VPSRLD     $26, Y3, Y3
+
+loopAVX512:
+	// bitPos = offsets[i] + (h - floor((h+0.5) / p) * p)
+	VMULPD      (BX), Z1, Z4
+	VRNDSCALEPD $9, Z4, Z4
+	VMULPD      (CX), Z4, Z4
+	VSUBPD      Z4, Z0, Z4
+	VCVTTPD2DQ  Z4, Y4
+	VPADDD      (DX), Y4, Y4
+
+	// Y5 = word index, Z4 = 1 << (bitPos % 64)
+	VPSRLD    $6, Y4, Y5
+	VPAND     Y3, Y4, Y4
+	VPMOVZXDQ Y4, Z4
+	VPSLLVQ   Z4, Z2, Z4
+
+	// Gather the eight words and check that every probe bit is set
+	KXNORW     K1, K1, K1
+	VPGATHERDQ (AX)(Y5*8), K1, Z7
+	VPANDQ     Z4, Z7, Z7
+	VPCMPEQQ   Z4, Z7, K2
+	KMOVW      K2, DI
+	CMPL       DI, $0xff
+	JNE        missAVX512
+
+	ADDQ $64, BX
+	ADDQ $64, CX
+	ADDQ $32, DX
+	SUBQ $8, SI
+	JNZ  loopAVX512
+
+	VZEROUPPER
+	MOVB $1, ret+56(FP)
+	RET
+
+missAVX512:
+	VZEROUPPER
+	MOVB $0, ret+56(FP)
+	RET
@@ -0,0 +1,232 @@
+package gloom
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// withScalarProbe runs fn with the vector probe path disabled.
+func withScalarProbe(fn func()) {
+	saved := hasSIMDProbe
+	hasSIMDProbe = false
+	defer func() { hasSIMDProbe = saved }()
+	fn()
+}
+
+// simdProbeVariants returns the vector probe implementations supported by
+// the current CPU.
+func simdProbeVariants(t *testing.T) map[string]func(t *probeTable, block *uint64, h uint32) bool {
+	t.Helper()
+
+	variants := make(map[string]func(t *probeTable, block *uint64, h uint32) bool)
+	if hasAVX2Probe {
+		variants["AVX2"] = func(t *probeTable, block *uint64, h uint32) bool {
+			hf := float64(h)
+			return probeAVX2(block, hf, hf+0.5, &t.inv[0], &t.primes[0], &t.offsets[0], t.lanesAVX2)
+		}
+	}
+	if hasAVX512Probe {
+		variants["AVX512"] = func(t *probeTable, block *uint64, h uint32) bool {
+			hf := float64(h)
+			return probeAVX512(block, hf, hf+0.5, &t.inv[0], &t.primes[0], &t.offsets[0], len(t.inv))
+		}
+	}
+	if len(variants) == 0 {
+		t.Skip("CPU supports neither AVX2 nor AVX-512")
+	}
+	return variants
+}
+
+// TestSIMDProbeMatchesScalar checks that every vector probe path returns
+// exactly the same result as the scalar loop, for every k and for blocks
+// ranging from empty to full.
+func TestSIMDProbeMatchesScalar(t *testing.T) {
+	variants := simdProbeVariants(t)
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for k := uint32(MinK); k <= MaxK; k++ {
+		f := NewWithParams(64, k)
+
+		// Fill each block to a different density so both outcomes are common
+		for i := range f.blocks {
+			density := float64(i/BlockWords) / float64(f.numBlocks)
+			for bit := range 64 {
+				if rng.Float64() < density {
+					f.blocks[i] |= 1 << bit
+				}
+			}
+		}
+
+		for name, probe := range variants {
+			t.Run(fmt.Sprintf("%s/k=%d", name, k), func(t *testing.T) {
+				var hits int
+				for range 20000 {
+					blockIdx := rng.Uint64N(f.numBlocks)
+					h := rng.Uint32()
+
+					var want bool
+					withScalarProbe(func() { want = f.testWithHash(blockIdx, h) })
+					got := probe(f.probes, &f.blocks[blockIdx*BlockWords], h)
+					if got != want {
+						t.Fatalf("block %d, h=%#x: got %v, want %v", blockIdx, h, got, want)
+					}
+					if got {
+						hits++
+					}
+				}
+				if hits == 0 || hits == 20000 {
+					t.Errorf("degenerate test: %d hits", hits)
+				}
+			})
+		}
+	}
+}
+
+// TestSIMDProbeExactBits checks that the vector paths require precisely the
+// bits set by Add: all present is a hit, and clearing any one is a miss.
+// Hash values near multiples of the primes and the extremes of the uint32
+// range are included, since they are where float rounding would go wrong.
+func TestSIMDProbeExactBits(t *testing.T) {
+	variants := simdProbeVariants(t)
+
+	hashes := []uint32{0, 1, 2, ^uint32(0), ^uint32(0) - 1, 1 << 31, 1<<31 - 1}
+	for _, p := range GetPrimePartition(14) {
+		for _, m := range []uint32{1, 1000, 1 << 20, ^uint32(0) / p} {
+			hashes = append(hashes, p*m-1, p*m, p*m+1)
+		}
+	}
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 1000 {
+		hashes = append(hashes, rng.Uint32())
+	}
+
+	for k := uint32(MinK); k <= MaxK; k++ {
+		for name, probe := range variants {
+			t.Run(fmt.Sprintf("%s/k=%d", name, k), func(t *testing.T) {
+				f := NewWithParams(1, k)
+				block := &f.blocks[0]
+
+				for _, h := range hashes {
+					clear(f.blocks)
+					f.addWithHash(0, h)
+					if !probe(f.probes, block, h) {
+						t.Fatalf("h=%#x: miss with all probe bits set", h)
+					}
+
+					for i := range f.k {
+						bitPos := f.offsets[i] + h%f.primes[i]
+						f.blocks[bitPos/64] &^= 1 << (bitPos % 64)
+						if probe(f.probes, block, h) {
+							t.Fatalf("h=%#x: hit with probe %d cleared", h, i)
+						}
+						f.blocks[bitPos/64] |= 1 << (bitPos % 64)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestSIMDProbeBlockSizes checks that the vector paths agree with the scalar
+// loop for blocks smaller and larger than a cache line, whose offsets reach
+// below and beyond bit 512.
+func TestSIMDProbeBlockSizes(t *testing.T) {
+	if !hasSIMDProbe {
+		t.Skip("CPU supports neither AVX2 nor AVX-512")
+	}
+
+	for _, blockBits := range []uint32{256, 1024} {
+		for k := uint32(simdProbeMinK); k <= MaxKForBlockBits(blockBits); k++ {
+			f := NewWithBlockParams(20, k, blockBits)
+			af := NewAtomicWithBlockParams(20, k, blockBits)
+			// Fill the blocks to about 95% so hits are common even for large k
+			items := 3 * 20 * int(blockBits) / int(k)
+			for i := range items {
+				f.AddString(fmt.Sprintf("item-%d", i))
+				af.AddString(fmt.Sprintf("item-%d", i))
+			}
+
+			var hits int
+			for i := range 5000 {
+				key := fmt.Sprintf("probe-%d", i)
+				got, gotAtomic := f.TestString(key), af.TestString(key)
+				var want bool
+				withScalarProbe(func() { want = f.TestString(key) })
+				if got != want || gotAtomic != want {
+					t.Fatalf("blockBits=%d, k=%d: TestString(%q) = %v, %v, scalar = %v", blockBits, k, key, got, gotAtomic, want)
+				}
+				if got {
+					hits++
+				}
+			}
+			if hits == 0 {
+				t.Errorf("blockBits=%d, k=%d: degenerate test with no hits", blockBits, k)
+			}
+		}
+	}
+}
+
+// TestSIMDProbeAtomicFilter checks that AtomicFilter's vector path agrees
+// with its scalar path.
+func TestSIMDProbeAtomicFilter(t *testing.T) {
+	if !hasSIMDProbe {
+		t.Skip("CPU supports neither AVX2 nor AVX-512")
+	}
+
+	f := NewAtomic(1000, 0.01)
+	for i := range 1000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+
+	for i := range 5000 {
+		key := fmt.Sprintf("probe-%d", i)
+		got := f.TestString(key)
+		var want bool
+		withScalarProbe(func() { want = f.TestString(key) })
+		if got != want {
+			t.Errorf("TestString(%q) = %v, scalar = %v", key, got, want)
+		}
+	}
+}
+
+// TestSIMDProbeAVX2Dispatch checks the AVX2 path through Filter.Test on CPUs
+// that would otherwise prefer AVX-512.
+func TestSIMDProbeAVX2Dispatch(t *testing.T) {
+	if !hasAVX2Probe {
+		t.Skip("CPU does not support AVX2")
+	}
+	saved := hasAVX512Probe
+	hasAVX512Probe = false
+	defer func() { hasAVX512Probe = saved }()
+
+	f := New(1000, 0.01)
+	for i := range 1000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+	for i := range 5000 {
+		key := fmt.Sprintf("probe-%d", i)
+		got := f.TestString(key)
+		var want bool
+		withScalarProbe(func() { want = f.TestString(key) })
+		if got != want {
+			t.Errorf("TestString(%q) = %v, scalar = %v", key, got, want)
+		}
+	}
+}
+
+// TestScalarProbe exercises the scalar fallback used on CPUs without AVX2.
+func TestScalarProbe(t *testing.T) {
+	withScalarProbe(func() {
+		f := New(1000, 0.01)
+		af := NewAtomic(1000, 0.01)
+		f.AddString("hello")
+		af.AddString("hello")
+		if !f.TestString("hello") || !af.TestString("hello") {
+			t.Error("false negative on scalar probe path")
+		}
+		if f.TestString("not-added") != af.TestString("not-added") {
+			t.Error("Filter and AtomicFilter disagree on scalar probe path")
+		}
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !amd64
+
+package gloom
+
+const (
+	hasAVX2Probe   = false
+	hasAVX512Probe = false
+	hasSIMDProbe   = false
+)
+
+func probeAVX2(block *uint64, h, hq float64, inv, primes *float64, offsets *int32, lanes int) bool {
+	panic("unreachable")
+}
+
+func probeAVX512(block *uint64, h, hq float64, inv, primes *float64, offsets *int32, lanes int) bool {
+	panic("unreachable")
+}
@@ -0,0 +1,24 @@
+package gloom
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestProbeTableLayout(t *testing.T) {
+	for k := uint32(MinK); k <= MaxK; k++ {
+		primes := GetPrimePartition(k)
+		pt := newProbeTable(primes, ComputeOffsets(primes))
+
+		if len(pt.inv)%probeLanes != 0 || len(pt.inv) < int(k) {
+			t.Errorf("k=%d: %d lanes is not a multiple of %d covering k", k, len(pt.inv), probeLanes)
+		}
+		if pt.lanesAVX2%4 != 0 || pt.lanesAVX2 < int(k) || pt.lanesAVX2 > len(pt.inv) {
+			t.Errorf("k=%d: invalid AVX2 lane count %d", k, pt.lanesAVX2)
+		}
+		// The vector paths read the table with aligned-width loads
+		if uintptr(unsafe.Pointer(&pt.offsets[0]))%4 != 0 {
+			t.Errorf("k=%d: offsets not 4-byte aligned", k)
+		}
+	}
+}
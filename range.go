@@ -0,0 +1,303 @@
+package gloom
+
+import (
+	"math"
+	"math/bits"
+)
+
+// MaxRangeLevels is the largest number of levels a RangeFilter has, for
+// ranges of up to 2^63 keys.
+const MaxRangeLevels = 63
+
+// RangeParams describes the levels of a RangeFilter, as returned by
+// OptimalRangeParams.
+type RangeParams struct {
+	NumBlocks         []uint64 // Number of 512-bit blocks of each level, from level 0
+	K                 []uint32 // Number of hash functions of each level
+	FalsePositiveRate float64  // Bound on the false positive rate of TestRange for a range of up to MaxRange keys
+}
+
+// RangeFilter answers whether any key in a range of uint64 keys might have
+// been added, so that a scan of the range can be skipped, as described by
+// Luo et al., "Rosetta: A Robust Space-Time Optimized Range Filter for
+// Key-Value Stores" (2020).
+//
+// The filter has one Filter per level. Level l holds the dyadic prefix of
+// each key, the key shifted right by l bits, which stands for the 2^l keys
+// sharing it. TestRange splits a range into aligned dyadic intervals and
+// tests each at its level. An interval that tests positive is not trusted:
+// its two halves are tested one level down, until a single key at level 0
+// confirms it. A range is thus reported present only if the filter finds a
+// key in it, and the lower levels filter out most of the false positives of
+// the upper ones.
+//
+// Ranges longer than MaxRange keys would need levels the filter does not
+// have, so TestRange reports them present. Adding a key touches one cache
+// line per level. A RangeFilter is not safe for concurrent use.
+type RangeFilter struct {
+	levels []*Filter // Level l holds keys shifted right by l
+}
+
+// NewRange creates a RangeFilter that uses about memoryBytes bytes, for the
+// expected number of keys and ranges of up to maxRange keys. See
+// OptimalRangeParams.
+func NewRange(memoryBytes, expectedItems, maxRange uint64) *RangeFilter {
+	p := OptimalRangeParams(memoryBytes, expectedItems, maxRange)
+	return NewRangeWithParams(p.NumBlocks, p.K)
+}
+
+// NewRangeWithParams creates a RangeFilter with explicit parameters: one
+// level for each element of numBlocks, with the k of the same index, for
+// ranges of up to 2^len(numBlocks) keys. It has at least 1 level and at most
+// MaxRangeLevels. A missing or unsupported k falls back as in NewWithParams.
+func NewRangeWithParams(numBlocks []uint64, k []uint32) *RangeFilter {
+	n := min(max(len(numBlocks), 1), MaxRangeLevels)
+	r := &RangeFilter{levels: make([]*Filter, n)}
+	for l := range r.levels {
+		var nb uint64
+		var lk uint32
+		if l < len(numBlocks) {
+			nb = numBlocks[l]
+		}
+		if l < len(k) {
+			lk = k[l]
+		}
+		r.levels[l] = NewWithParams(nb, lk)
+	}
+	return r
+}
+
+// rangeLevels returns the number of levels for ranges of up to maxRange
+// keys.
+func rangeLevels(maxRange uint64) int {
+	return min(max(bits.Len64(max(maxRange, 1)-1), 1), MaxRangeLevels)
+}
+
+// rangeHash returns the raw hash of a dyadic prefix at a level. The level
+// is mixed in so that the same prefix at different levels lands in
+// unrelated positions.
+func rangeHash(prefix uint64, level int) uint64 {
+	return mix64(prefix ^ uint64(level)*0x9e3779b97f4a7c15)
+}
+
+// Add adds a key to the filter, along with its prefix at every level.
+func (r *RangeFilter) Add(key uint64) {
+	for l, f := range r.levels {
+		f.addWithHash(hashSplit(rangeHash(key>>l, l), f.numBlocks))
+	}
+}
+
+// Test checks if key might be in the filter.
+// Returns true if the key might be present (with false positive probability),
+// or false if the key is definitely not present.
+func (r *RangeFilter) Test(key uint64) bool {
+	f := r.levels[0]
+	return f.testWithHash(hashSplit(rangeHash(key, 0), f.numBlocks))
+}
+
+// TestRange checks if any key in [lo, hi] might be in the filter.
+// It returns false if no key in the range was added, so a scan of the range
+// can be skipped, and false for an empty range, where lo > hi. It returns
+// true for a range longer than MaxRange keys, which it cannot rule out.
+func (r *RangeFilter) TestRange(lo, hi uint64) bool {
+	if lo > hi {
+		return false
+	}
+	top := len(r.levels) - 1
+	if (hi-lo)>>len(r.levels) != 0 {
+		return true
+	}
+
+	for {
+		// The largest aligned interval that starts at lo and fits the range
+		l := min(bits.TrailingZeros64(lo), top)
+		for l > 0 && hi-lo < 1<<l-1 {
+			l--
+		}
+		if r.probe(l, lo>>l) {
+			return true
+		}
+		end := lo + (1<<l - 1)
+		if end == hi {
+			return false
+		}
+		lo = end + 1
+	}
+}
+
+// probe reports whether a key under prefix at the given level might have
+// been added, descending to level 0 through the halves that test positive.
+func (r *RangeFilter) probe(level int, prefix uint64) bool {
+	f := r.levels[level]
+	if !f.testWithHash(hashSplit(rangeHash(prefix, level), f.numBlocks)) {
+		return false
+	}
+	if level == 0 {
+		return true
+	}
+	return r.probe(level-1, prefix<<1) || r.probe(level-1, prefix<<1|1)
+}
+
+// MaxRange returns the number of keys in the longest range TestRange can
+// rule out.
+func (r *RangeFilter) MaxRange() uint64 {
+	return 1 << len(r.levels)
+}
+
+// Levels returns the number of levels.
+func (r *RangeFilter) Levels() int {
+	return len(r.levels)
+}
+
+// Level returns the parameters of level l, or zeros if there is none.
+func (r *RangeFilter) Level(l int) (numBlocks uint64, k uint32) {
+	if l < 0 || l >= len(r.levels) {
+		return 0, 0
+	}
+	return r.levels[l].numBlocks, r.levels[l].k
+}
+
+// Cap returns the capacity of the filter in bits, over all levels.
+func (r *RangeFilter) Cap() uint64 {
+	var c uint64
+	for _, f := range r.levels {
+		c += f.Cap()
+	}
+	return c
+}
+
+// Count returns the approximate number of keys added to the filter.
+func (r *RangeFilter) Count() uint64 {
+	return r.levels[0].count
+}
+
+// EstimatedFalsePositiveRate estimates the current false positive rate of
+// TestRange for a range of up to MaxRange keys with none added, from the
+// number of keys added. Each level is taken to hold a distinct prefix per
+// key; when keys share prefixes, as clustered keys do, the rate is lower.
+// See OptimalRangeParams.
+func (r *RangeFilter) EstimatedFalsePositiveRate() float64 {
+	fps := make([]float64, len(r.levels))
+	for l, f := range r.levels {
+		fps[l] = f.EstimatedFalsePositiveRate()
+	}
+	return rangeFalsePositiveRate(fps)
+}
+
+// rangeFalsePositiveRate bounds the false positive rate of a range query
+// given the false positive rate of each level. An interval at level l with
+// no key is reported present with probability
+//
+//	Q₀ = f₀,  Qₗ = fₗ · (1 - (1 - Qₗ₋₁)²)
+//
+// since it must pass its own level and one of its halves. A range of up to
+// 2^L keys splits into at most two intervals per level, so
+//
+//	FP ≤ 1 - ∏ₗ (1 - Qₗ)²
+func rangeFalsePositiveRate(fps []float64) float64 {
+	var q float64
+	pass := 1.0
+	for l, f := range fps {
+		if l == 0 {
+			q = f
+		} else {
+			q = f * (1 - (1-q)*(1-q))
+		}
+		pass *= (1 - q) * (1 - q)
+	}
+	return 1 - pass
+}
+
+// rangeLevelParams returns the k with the lowest false positive rate for a
+// level of numBlocks blocks holding items prefixes, and that rate, caching
+// them by numBlocks.
+func rangeLevelParams(cache map[uint64][2]float64, numBlocks, items uint64) (k uint32, fp float64) {
+	if c, ok := cache[numBlocks]; ok {
+		return uint32(c[0]), c[1]
+	}
+	// Block load variance favors a k at or somewhat below the rounded one
+	_, top := optimalBlockParams(items, float64(numBlocks*BlockBits), BlockBits)
+	fp = math.Inf(1)
+	for lk := max(top, MinK+5) - 5; lk <= top; lk++ {
+		if lfp := EstimateBlockFalsePositiveRate(numBlocks, lk, BlockBits, items); lfp < fp {
+			k, fp = lk, lfp
+		}
+	}
+	cache[numBlocks] = [2]float64{float64(k), fp}
+	return k, fp
+}
+
+// OptimalRangeParams splits a memory budget of about memoryBytes bytes
+// across the levels of a RangeFilter for expectedItems keys and ranges of
+// up to maxRange keys, to minimize the false positive rate of TestRange.
+// maxRange is rounded up to a power of two of at least 2, with 1 level per
+// doubling.
+//
+// A range that holds no key is reported present only if a chain of false
+// positives runs from one of its intervals down to level 0. Each level
+// first gets enough memory for a false positive rate of 1/2, so that
+// TestRange probes a bounded number of intervals, or an even share if the
+// budget is too small. The rest is handed out in small steps, each to the
+// level whose false positive rate lowers the sum of the chain
+// probabilities the most. Level 0 ends every chain, so it gets the most.
+// Each level is taken to hold a distinct prefix per key, as when keys are
+// spread out; the rate of clustered keys, which share prefixes, is lower
+// than FalsePositiveRate.
+func OptimalRangeParams(memoryBytes, expectedItems, maxRange uint64) RangeParams {
+	expectedItems = max(expectedItems, 1)
+	levels := rangeLevels(maxRange)
+	p := RangeParams{NumBlocks: make([]uint64, levels), K: make([]uint32, levels)}
+	total := max(memoryBytes/(BlockBits/8), uint64(levels))
+	cache := make(map[uint64][2]float64)
+
+	// A level that passes more than half of the intervals it tests makes
+	// TestRange probe ever more intervals below it. Fund each level to a
+	// rate of 1/2 first, or split the budget evenly if it cannot
+	base, lo := total/uint64(levels), uint64(1)
+	for lo < base {
+		mid := lo + (base-lo)/2
+		if _, fp := rangeLevelParams(cache, mid, expectedItems); fp <= 0.5 {
+			base = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	for l := range p.NumBlocks {
+		p.NumBlocks[l] = base
+	}
+	remaining := total - base*uint64(levels)
+	step := max(remaining/256, 1)
+
+	// The chain probabilities are nearly Qₗ = 2^l·f₀·…·fₗ, so lowering ln fⱼ
+	// by d lowers the sum of the chains through level j by d times their sum
+	logFP := func(numBlocks uint64) float64 {
+		_, fp := rangeLevelParams(cache, numBlocks, expectedItems)
+		return math.Log(max(fp, math.SmallestNonzeroFloat64))
+	}
+	chains := make([]float64, levels)
+	for ; remaining >= step; remaining -= step {
+		chain := 0.5
+		for l, nb := range p.NumBlocks {
+			chain *= 2 * math.Exp(logFP(nb))
+			chains[l] = chain
+		}
+		best, bestGain := 0, 0.0 // Level 0 if no step helps
+		suffix := 0.0
+		for l := levels - 1; l >= 0; l-- {
+			suffix += chains[l]
+			nb := p.NumBlocks[l]
+			if gain := suffix * (logFP(nb) - logFP(nb+step)); gain > bestGain {
+				best, bestGain = l, gain
+			}
+		}
+		p.NumBlocks[best] += step
+	}
+	p.NumBlocks[0] += remaining
+
+	fps := make([]float64, levels)
+	for l, nb := range p.NumBlocks {
+		p.K[l], fps[l] = rangeLevelParams(cache, nb, expectedItems)
+	}
+	p.FalsePositiveRate = rangeFalsePositiveRate(fps)
+	return p
+}
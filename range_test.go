@@ -0,0 +1,243 @@
+package gloom
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestRangeFilterBasic(t *testing.T) {
+	r := NewRange(1<<16, 1000, 1000)
+	if r.Levels() != 10 || r.MaxRange() != 1024 {
+		t.Errorf("got %d levels, MaxRange() = %d", r.Levels(), r.MaxRange())
+	}
+	keys := make([]uint64, 1000)
+	for i := range keys {
+		keys[i] = uint64(i)*1_000_003 + 1<<20
+		r.Add(keys[i])
+	}
+
+	for _, key := range keys {
+		if !r.Test(key) || !r.TestRange(key, key) {
+			t.Fatalf("false negative for %d", key)
+		}
+		// Ranges of every length that hold the key, at every alignment
+		for _, length := range []uint64{2, 3, 64, 100, 1023, 1024} {
+			lo := key - length/2
+			if !r.TestRange(lo, lo+length-1) {
+				t.Fatalf("false negative for [%d, %d] holding %d", lo, lo+length-1, key)
+			}
+		}
+	}
+
+	if r.TestRange(100, 99) {
+		t.Error("empty range reported present")
+	}
+	// A range too long to rule out is always present
+	if !r.TestRange(1<<40, 1<<40+1024) {
+		t.Error("range longer than MaxRange reported absent")
+	}
+
+	if r.Count() != 1000 {
+		t.Errorf("Count() = %d", r.Count())
+	}
+	if fp := r.EstimatedFalsePositiveRate(); fp <= 0 || fp >= 0.01 {
+		t.Errorf("EstimatedFalsePositiveRate() = %g", fp)
+	}
+	var bits uint64
+	for l := range r.Levels() {
+		numBlocks, k := r.Level(l)
+		if k == 0 {
+			t.Errorf("level %d has k = 0", l)
+		}
+		bits += numBlocks * BlockBits
+	}
+	if r.Cap() != bits || r.Cap() != 1<<19 {
+		t.Errorf("Cap() = %d, levels hold %d", r.Cap(), bits)
+	}
+	if numBlocks, k := r.Level(-1); numBlocks != 0 || k != 0 {
+		t.Errorf("Level(-1) = (%d, %d)", numBlocks, k)
+	}
+	if numBlocks, k := r.Level(r.Levels()); numBlocks != 0 || k != 0 {
+		t.Errorf("Level(%d) = (%d, %d)", r.Levels(), numBlocks, k)
+	}
+}
+
+func TestRangeFilterExact(t *testing.T) {
+	// Against a sorted key set, no range holding a key is ever absent
+	rng := rand.New(rand.NewPCG(1, 2))
+	r := NewRange(1<<12, 500, 1<<8)
+	keys := make([]uint64, 500)
+	for i := range keys {
+		keys[i] = rng.Uint64N(1 << 20)
+		r.Add(keys[i])
+	}
+	slices.Sort(keys)
+
+	var empty, skipped int
+	for range 20_000 {
+		lo := rng.Uint64N(1 << 20)
+		hi := lo + rng.Uint64N(r.MaxRange())
+		i, _ := slices.BinarySearch(keys, lo)
+		present := i < len(keys) && keys[i] <= hi
+		got := r.TestRange(lo, hi)
+		if present && !got {
+			t.Fatalf("false negative for [%d, %d]", lo, hi)
+		}
+		if !present {
+			empty++
+			if !got {
+				skipped++
+			}
+		}
+	}
+	if skipped == 0 {
+		t.Errorf("none of %d empty ranges skipped", empty)
+	}
+}
+
+func TestRangeFilterEdges(t *testing.T) {
+	r := NewRangeWithParams(slices.Repeat([]uint64{64}, MaxRangeLevels), nil)
+	if r.MaxRange() != 1<<63 {
+		t.Errorf("MaxRange() = %d", r.MaxRange())
+	}
+	if r.TestRange(0, math.MaxUint64/2) || r.TestRange(1<<63, math.MaxUint64) || r.TestRange(0, 0) {
+		t.Error("empty filter reported a range present")
+	}
+	if !r.TestRange(0, math.MaxUint64) {
+		t.Error("range of 2^64 keys reported absent")
+	}
+
+	r.Add(0)
+	r.Add(math.MaxUint64)
+	for _, rg := range [][2]uint64{
+		{0, 0},
+		{0, 1<<63 - 1},
+		{math.MaxUint64, math.MaxUint64},
+		{math.MaxUint64 - 5, math.MaxUint64},
+		{1 << 63, math.MaxUint64},
+	} {
+		if !r.TestRange(rg[0], rg[1]) {
+			t.Errorf("false negative for [%d, %d]", rg[0], rg[1])
+		}
+	}
+}
+
+func TestNewRangeWithParams(t *testing.T) {
+	// With no levels, there is one of 1 block
+	r := NewRangeWithParams(nil, nil)
+	if numBlocks, k := r.Level(0); r.Levels() != 1 || r.MaxRange() != 2 || numBlocks != 1 || k != 7 {
+		t.Errorf("got %d levels, level 0 (%d, %d)", r.Levels(), numBlocks, k)
+	}
+
+	// Levels beyond the maximum are dropped, and a missing k falls back
+	r = NewRangeWithParams(make([]uint64, MaxRangeLevels+5), []uint32{3, 99})
+	if r.Levels() != MaxRangeLevels {
+		t.Errorf("got %d levels", r.Levels())
+	}
+	for l, want := range []uint32{3, 7, 7} {
+		if _, k := r.Level(l); k != want {
+			t.Errorf("level %d: k = %d, want %d", l, k, want)
+		}
+	}
+}
+
+func TestRangeFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, maxRange := range []uint64{1 << 8, 1 << 10, 1 << 16} {
+		t.Run(fmt.Sprintf("maxRange_%d", maxRange), func(t *testing.T) {
+			const items = 100_000
+			rng := rand.New(rand.NewPCG(3, 4))
+			r := NewRange(1<<19, items, maxRange)
+			if r.EstimatedFalsePositiveRate() != 0 {
+				t.Errorf("empty filter: EstimatedFalsePositiveRate() = %g", r.EstimatedFalsePositiveRate())
+			}
+			for range items {
+				r.Add(rng.Uint64())
+			}
+
+			// With keys spread over 2^64, random ranges are almost surely empty
+			const probes = 200_000
+			var fps int
+			for range probes {
+				lo := rng.Uint64()
+				if r.TestRange(lo, lo+maxRange-1) {
+					fps++
+				}
+			}
+			rate := float64(fps) / probes
+			bound := r.EstimatedFalsePositiveRate()
+			if want := OptimalRangeParams(1<<19, items, maxRange).FalsePositiveRate; math.Abs(bound-want) > 0.05*want {
+				t.Errorf("EstimatedFalsePositiveRate() = %g, OptimalRangeParams predicts %g", bound, want)
+			}
+			if rate > bound || rate < bound/4 {
+				t.Errorf("measured %g, bound %g", rate, bound)
+			}
+		})
+	}
+}
+
+func TestOptimalRangeParams(t *testing.T) {
+	tests := []struct {
+		memoryBytes, items, maxRange uint64
+		levels                       int
+	}{
+		{1 << 20, 100_000, 1 << 10, 10},
+		{1 << 20, 100_000, 1000, 10},
+		{1 << 20, 100_000, 1 << 16, 16},
+		{1 << 16, 1000, 2, 1},
+		{1 << 16, 1000, 1, 1},
+		{1 << 16, 1000, 0, 1},
+		{1 << 16, 0, 1 << 8, 8},
+		{1 << 20, 100_000, math.MaxUint64, MaxRangeLevels},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d_%d_%d", tt.memoryBytes, tt.items, tt.maxRange), func(t *testing.T) {
+			p := OptimalRangeParams(tt.memoryBytes, tt.items, tt.maxRange)
+			if len(p.NumBlocks) != tt.levels || len(p.K) != tt.levels {
+				t.Fatalf("got %d levels, want %d", len(p.NumBlocks), tt.levels)
+			}
+
+			// The budget is spent exactly, mostly on level 0
+			var total uint64
+			for l, numBlocks := range p.NumBlocks {
+				total += numBlocks
+				if numBlocks > p.NumBlocks[0] {
+					t.Errorf("level %d has %d blocks, more than level 0's %d", l, numBlocks, p.NumBlocks[0])
+				}
+			}
+			if total != tt.memoryBytes/(BlockBits/8) {
+				t.Errorf("got %d blocks, budget is %d", total, tt.memoryBytes/(BlockBits/8))
+			}
+
+			fps := make([]float64, tt.levels)
+			for l := range fps {
+				fps[l] = EstimateBlockFalsePositiveRate(p.NumBlocks[l], p.K[l], BlockBits, max(tt.items, 1))
+			}
+			if got := rangeFalsePositiveRate(fps); got != p.FalsePositiveRate {
+				t.Errorf("FalsePositiveRate = %g, levels give %g", p.FalsePositiveRate, got)
+			}
+		})
+	}
+
+	// A budget too small for every level is split evenly
+	p := OptimalRangeParams(80*64, 1_000_000, 1<<8)
+	if !slices.Equal(p.NumBlocks, slices.Repeat([]uint64{10}, 8)) {
+		t.Errorf("small budget: got %v", p.NumBlocks)
+	}
+	p = OptimalRangeParams(0, 1000, 1<<8)
+	if !slices.Equal(p.NumBlocks, slices.Repeat([]uint64{1}, 8)) {
+		t.Errorf("no budget: got %v", p.NumBlocks)
+	}
+
+	// A larger budget lowers the rate
+	lo, hi := OptimalRangeParams(1<<20, 100_000, 1<<10), OptimalRangeParams(1<<21, 100_000, 1<<10)
+	if hi.FalsePositiveRate >= lo.FalsePositiveRate {
+		t.Errorf("2 MiB: %g, 1 MiB: %g", hi.FalsePositiveRate, lo.FalsePositiveRate)
+	}
+}
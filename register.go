@@ -0,0 +1,436 @@
+package gloom
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// MaxRegisterK is the largest supported number of hash functions for a
+	// RegisterFilter, a quarter of the bits in its 64-bit blocks.
+	MaxRegisterK = 16
+
+	// registerPatternBits is the number of hash bits that index the mask
+	// pattern table, which has 1024 entries (8 KiB) per k and stays in L1.
+	registerPatternBits = 10
+	registerPatternMask = 1<<registerPatternBits - 1
+
+	// registerPatterns is the number of distinct masks available for a k.
+	// Each table entry is rotated by another 6 hash bits, so collisions
+	// between the masks of different items are as rare as with 65536
+	// independent patterns.
+	registerPatterns = 64 << registerPatternBits
+
+	// maxRegisterBitsPerItem bounds the memory OptimalRegisterParams will
+	// spend trying to reach a false positive rate.
+	maxRegisterBitsPerItem = 256
+)
+
+// registerPatternTables holds the mask pattern table for each k, generated
+// on first use.
+var registerPatternTables [MaxRegisterK + 1]struct {
+	once  sync.Once
+	table *[1 << registerPatternBits]uint64
+}
+
+// registerPatternTable returns the mask pattern table for k, or nil if k is
+// not between MinK and MaxRegisterK.
+func registerPatternTable(k uint32) *[1 << registerPatternBits]uint64 {
+	if k < MinK || k > MaxRegisterK {
+		return nil
+	}
+	t := &registerPatternTables[k]
+	t.once.Do(func() { t.table = generateRegisterPatterns(k) })
+	return t.table
+}
+
+// generateRegisterPatterns builds a table of masks with exactly k of their
+// 64 bits set, chosen uniformly by a fixed splitmix64 sequence so that every
+// filter with the same k sets the same bits for the same key.
+func generateRegisterPatterns(k uint32) *[1 << registerPatternBits]uint64 {
+	var table [1 << registerPatternBits]uint64
+	state := uint64(k)
+	for i := range table {
+		var mask uint64
+		for uint32(bits.OnesCount64(mask)) < k {
+			mask |= 1 << (splitmix64(&state) >> 58)
+		}
+		table[i] = mask
+	}
+	return &table
+}
+
+// registerMask returns the mask of bits to set or test for an intra-block
+// hash: a table entry, rotated so that the table covers far more patterns
+// than it has entries.
+func registerMask(table *[1 << registerPatternBits]uint64, intraHash uint32) uint64 {
+	return bits.RotateLeft64(table[intraHash&registerPatternMask], int(intraHash>>registerPatternBits)&63)
+}
+
+// RegisterFilter is a register-blocked bloom filter. It is NOT safe for
+// concurrent use; see AtomicRegisterFilter.
+//
+// Each block is a single 64-bit word, and an item's k bits are a precomputed
+// mask pattern within it, so Add and Test are a single load and a mask
+// compare. This is faster than Filter, but items crowd into far smaller
+// blocks, so it needs more memory for the same false positive rate and cannot
+// reach very low rates at all. It suits uses that tolerate false positive
+// rates of around 1% or more, such as join pre-filters and request routing.
+type RegisterFilter struct {
+	words    []uint64                          // One 64-bit block per word
+	numWords uint64                            // Total number of words
+	k        uint32                            // Number of bits set per item
+	patterns *[1 << registerPatternBits]uint64 // Mask patterns for k
+	count    uint64                            // Number of items added
+	setBits  uint64                            // Number of bits set, maintained incrementally
+}
+
+// NewRegister creates a new register-blocked bloom filter optimized for the
+// expected number of items and desired false positive rate.
+func NewRegister(expectedItems uint64, fpRate float64) *RegisterFilter {
+	numWords, k, _ := OptimalRegisterParams(expectedItems, fpRate)
+	return NewRegisterWithParams(numWords, k)
+}
+
+// NewRegisterWithParams creates a new register-blocked bloom filter with
+// explicit parameters. numWords is the number of 64-bit blocks, and k is the
+// number of bits set per item, from MinK to MaxRegisterK. An unsupported k
+// falls back to 4.
+func NewRegisterWithParams(numWords uint64, k uint32) *RegisterFilter {
+	if numWords == 0 {
+		numWords = 1
+	}
+	patterns := registerPatternTable(k)
+	if patterns == nil {
+		k = 4
+		patterns = registerPatternTable(k)
+	}
+
+	return &RegisterFilter{
+		words:    make([]uint64, numWords),
+		numWords: numWords,
+		k:        k,
+		patterns: patterns,
+	}
+}
+
+// Add adds data to the filter.
+func (f *RegisterFilter) Add(data []byte) {
+	wordIdx, intraHash := hashData(data, f.numWords)
+	f.addWithHash(wordIdx, intraHash)
+}
+
+// AddString adds a string to the filter without allocating.
+func (f *RegisterFilter) AddString(s string) {
+	wordIdx, intraHash := hashString(s, f.numWords)
+	f.addWithHash(wordIdx, intraHash)
+}
+
+// addWithHash sets the item's mask in its word using pre-computed hash values.
+func (f *RegisterFilter) addWithHash(wordIdx uint64, intraHash uint32) {
+	mask := registerMask(f.patterns, intraHash)
+	old := f.words[wordIdx]
+	f.words[wordIdx] = old | mask
+	f.setBits += uint64(bits.OnesCount64(mask &^ old))
+	f.count++
+}
+
+// Test checks if data might be in the filter.
+// Returns true if the item might be present, false if definitely absent.
+func (f *RegisterFilter) Test(data []byte) bool {
+	wordIdx, intraHash := hashData(data, f.numWords)
+	mask := registerMask(f.patterns, intraHash)
+	return f.words[wordIdx]&mask == mask
+}
+
+// TestString checks if a string might be in the filter without allocating.
+func (f *RegisterFilter) TestString(s string) bool {
+	wordIdx, intraHash := hashString(s, f.numWords)
+	mask := registerMask(f.patterns, intraHash)
+	return f.words[wordIdx]&mask == mask
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *RegisterFilter) Cap() uint64 {
+	return f.numWords * 64
+}
+
+// K returns the number of bits set per item.
+func (f *RegisterFilter) K() uint32 {
+	return f.k
+}
+
+// Count returns the number of items added to the filter.
+func (f *RegisterFilter) Count() uint64 {
+	return f.count
+}
+
+// NumWords returns the number of 64-bit blocks in the filter.
+func (f *RegisterFilter) NumWords() uint64 {
+	return f.numWords
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add.
+func (f *RegisterFilter) EstimatedFillRatio() float64 {
+	return float64(f.setBits) / float64(f.Cap())
+}
+
+// ExactFillRatio computes the proportion of bits that are set by counting
+// every bit in the filter.
+func (f *RegisterFilter) ExactFillRatio() float64 {
+	return float64(popCount(f.words)) / float64(f.Cap())
+}
+
+// EstimatedFalsePositiveRate estimates the current false positive rate
+// based on the number of items added.
+func (f *RegisterFilter) EstimatedFalsePositiveRate() float64 {
+	return EstimateRegisterFalsePositiveRate(f.numWords, f.k, f.count)
+}
+
+// AtomicRegisterFilter is a thread-safe register-blocked bloom filter, using
+// an atomic OR per Add. See RegisterFilter.
+type AtomicRegisterFilter struct {
+	words    []atomic.Uint64                   // One 64-bit block per word
+	numWords uint64                            // Total number of words
+	k        uint32                            // Number of bits set per item
+	patterns *[1 << registerPatternBits]uint64 // Mask patterns for k
+	count    stripedCounter                    // Number of items added (approximate)
+	setBits  stripedCounter                    // Number of bits set, maintained incrementally
+}
+
+// NewAtomicRegister creates a new thread-safe register-blocked bloom filter
+// optimized for the expected number of items and desired false positive rate.
+func NewAtomicRegister(expectedItems uint64, fpRate float64) *AtomicRegisterFilter {
+	numWords, k, _ := OptimalRegisterParams(expectedItems, fpRate)
+	return NewAtomicRegisterWithParams(numWords, k)
+}
+
+// NewAtomicRegisterWithParams creates a new thread-safe register-blocked
+// bloom filter with explicit parameters. See NewRegisterWithParams.
+func NewAtomicRegisterWithParams(numWords uint64, k uint32) *AtomicRegisterFilter {
+	if numWords == 0 {
+		numWords = 1
+	}
+	patterns := registerPatternTable(k)
+	if patterns == nil {
+		k = 4
+		patterns = registerPatternTable(k)
+	}
+
+	return &AtomicRegisterFilter{
+		words:    make([]atomic.Uint64, numWords),
+		numWords: numWords,
+		k:        k,
+		patterns: patterns,
+		count:    newStripedCounter(),
+		setBits:  newStripedCounter(),
+	}
+}
+
+// Add adds data to the filter.
+// This operation is thread-safe and lock-free.
+func (f *AtomicRegisterFilter) Add(data []byte) {
+	wordIdx, intraHash := hashData(data, f.numWords)
+	f.addWithHash(wordIdx, intraHash)
+}
+
+// AddString adds a string to the filter without allocating.
+// This operation is thread-safe and lock-free.
+func (f *AtomicRegisterFilter) AddString(s string) {
+	wordIdx, intraHash := hashString(s, f.numWords)
+	f.addWithHash(wordIdx, intraHash)
+}
+
+// addWithHash sets the item's mask in its word using pre-computed hash values.
+func (f *AtomicRegisterFilter) addWithHash(wordIdx uint64, intraHash uint32) {
+	mask := registerMask(f.patterns, intraHash)
+	// Or returns the old value, so exactly one writer observes each bit flipping
+	old := f.words[wordIdx].Or(mask)
+
+	f.count.add(wordIdx, 1)
+	if flipped := uint64(bits.OnesCount64(mask &^ old)); flipped > 0 {
+		f.setBits.add(wordIdx, flipped)
+	}
+}
+
+// Test checks if data might be in the filter.
+// This operation is safe to call concurrently with Add.
+func (f *AtomicRegisterFilter) Test(data []byte) bool {
+	wordIdx, intraHash := hashData(data, f.numWords)
+	mask := registerMask(f.patterns, intraHash)
+	return f.words[wordIdx].Load()&mask == mask
+}
+
+// TestString checks if a string might be in the filter.
+func (f *AtomicRegisterFilter) TestString(s string) bool {
+	wordIdx, intraHash := hashString(s, f.numWords)
+	mask := registerMask(f.patterns, intraHash)
+	return f.words[wordIdx].Load()&mask == mask
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *AtomicRegisterFilter) Cap() uint64 {
+	return f.numWords * 64
+}
+
+// K returns the number of bits set per item.
+func (f *AtomicRegisterFilter) K() uint32 {
+	return f.k
+}
+
+// Count returns the approximate number of items added to the filter.
+// The count is eventually consistent: it includes every Add that completed
+// before the call, and may or may not include Adds running concurrently.
+func (f *AtomicRegisterFilter) Count() uint64 {
+	return f.count.load()
+}
+
+// NumWords returns the number of 64-bit blocks in the filter.
+func (f *AtomicRegisterFilter) NumWords() uint64 {
+	return f.numWords
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add, and is
+// eventually consistent in the same way as Count.
+func (f *AtomicRegisterFilter) EstimatedFillRatio() float64 {
+	return float64(f.setBits.load()) / float64(f.Cap())
+}
+
+// ExactFillRatio computes the proportion of bits that are set by counting
+// every bit in the filter. It is exact only when no Add is running
+// concurrently.
+func (f *AtomicRegisterFilter) ExactFillRatio() float64 {
+	var total uint64
+	for i := range f.words {
+		total += uint64(bits.OnesCount64(f.words[i].Load()))
+	}
+	return float64(total) / float64(f.Cap())
+}
+
+// EstimatedFalsePositiveRate estimates the current false positive rate
+// based on the number of items added.
+func (f *AtomicRegisterFilter) EstimatedFalsePositiveRate() float64 {
+	return EstimateRegisterFalsePositiveRate(f.numWords, f.k, f.count.load())
+}
+
+// OptimalRegisterParams calculates the parameters of a RegisterFilter for the
+// expected number of items and desired false positive rate. Returns the
+// number of 64-bit words, the number of bits set per item (k), and bits per
+// item.
+//
+// Register-blocked filters need more memory than Filter for the same rate,
+// growing quickly below about 0.1%. The memory is capped at 256 bits per
+// item, so very low rates are not reached; check the result with
+// EstimateRegisterFalsePositiveRate when that matters.
+func OptimalRegisterParams(expectedItems uint64, fpRate float64) (numWords uint64, k uint32, bitsPerItem float64) {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if fpRate <= 0 {
+		fpRate = 0.0001 // default to 0.01%
+	}
+	if fpRate >= 1 {
+		fpRate = 0.99
+	}
+
+	// Binary search for the fewest words whose best k reaches fpRate,
+	// starting from the size of an unblocked filter, which is a lower bound
+	n := float64(expectedItems)
+	lo := max(uint64(n*-math.Log(fpRate)/ln2Squared/64), 1)
+	hi := max(uint64(math.Ceil(n*maxRegisterBitsPerItem/64)), lo)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, fp := bestRegisterK(mid, expectedItems); fp <= fpRate {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	numWords = lo
+	k, _ = bestRegisterK(numWords, expectedItems)
+	return numWords, k, float64(numWords*64) / n
+}
+
+// bestRegisterK returns the k with the lowest estimated false positive rate
+// for a RegisterFilter of numWords words holding items, and that rate.
+func bestRegisterK(numWords, items uint64) (bestK uint32, bestFP float64) {
+	bestFP = math.Inf(1)
+	for k := uint32(MinK); k <= MaxRegisterK; k++ {
+		if fp := EstimateRegisterFalsePositiveRate(numWords, k, items); fp < bestFP {
+			bestK, bestFP = k, fp
+		}
+	}
+	return bestK, bestFP
+}
+
+// EstimateRegisterFalsePositiveRate estimates the false positive rate of a
+// RegisterFilter with the given parameters.
+//
+// As for EstimateFalsePositiveRate, the number of items J in a word follows
+// a Poisson distribution. A query's k bits are all set when the union of J
+// uniformly chosen k-bit masks covers them, which by inclusion-exclusion over
+// the query bits left uncovered is
+//
+//	cover(J) = Σᵢ (-1)ⁱ C(k,i) (C(64-i,k) / C(64,k))^J
+//
+// The masks come from a finite table, so a query also matches when it shares
+// a mask exactly with one of the J items, adding 1 - (1 - 1/65536)^J.
+func EstimateRegisterFalsePositiveRate(numWords uint64, k uint32, itemsAdded uint64) float64 {
+	if numWords == 0 || itemsAdded == 0 || k < MinK || k > MaxRegisterK {
+		return 0
+	}
+
+	// missProb[i] is the chance that one item's mask avoids i given bits,
+	// C(64-i,k) / C(64,k), and coef[i] is (-1)ⁱ C(k,i)
+	var missProb, coef [MaxRegisterK + 1]float64
+	for i := uint32(0); i <= k; i++ {
+		p := 1.0
+		for t := range k {
+			p *= float64(64-i-t) / float64(64-t)
+		}
+		missProb[i] = p
+		c := 1.0
+		for t := range i {
+			c = c * float64(k-t) / float64(t+1)
+		}
+		if i%2 == 1 {
+			c = -c
+		}
+		coef[i] = c
+	}
+
+	wordFP := func(j float64) float64 {
+		var cover float64
+		for i := uint32(0); i <= k; i++ {
+			cover += coef[i] * math.Pow(missProb[i], j)
+		}
+		cover = min(max(cover, 0), 1)
+		same := -math.Expm1(j * math.Log1p(-1/float64(registerPatterns)))
+		return same + (1-same)*cover
+	}
+
+	lambda := float64(itemsAdded) / float64(numWords) // expected items per word
+	if lambda > 10000 {
+		return wordFP(lambda)
+	}
+
+	// Poisson-weighted sum over the number of items in a word, in log space
+	// as in EstimateBlockFalsePositiveRate
+	maxJ := int(lambda + 10*math.Sqrt(lambda) + 20)
+	var fp, logFactorial float64
+	logLambda := math.Log(lambda)
+	for j := 1; j <= maxJ; j++ {
+		logFactorial += math.Log(float64(j))
+		prob := math.Exp(-lambda + float64(j)*logLambda - logFactorial)
+		if prob < 1e-15 && j > int(lambda) {
+			break
+		}
+		fp += prob * wordFP(float64(j))
+	}
+	return min(fp, 1)
+}
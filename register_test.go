@@ -0,0 +1,268 @@
+package gloom
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"testing"
+)
+
+func TestRegisterFilterBasic(t *testing.T) {
+	f := NewRegister(10000, 0.02)
+	af := NewAtomicRegister(10000, 0.02)
+
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if i%2 == 0 {
+			f.Add([]byte(key))
+			af.Add([]byte(key))
+		} else {
+			f.AddString(key)
+			af.AddString(key)
+		}
+	}
+
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if !f.Test([]byte(key)) || !f.TestString(key) {
+			t.Fatalf("RegisterFilter: false negative for %q", key)
+		}
+		if !af.Test([]byte(key)) || !af.TestString(key) {
+			t.Fatalf("AtomicRegisterFilter: false negative for %q", key)
+		}
+	}
+
+	// Both variants set exactly the same bits
+	for i := range f.words {
+		if f.words[i] != af.words[i].Load() {
+			t.Fatalf("word %d differs: %x vs %x", i, f.words[i], af.words[i].Load())
+		}
+	}
+
+	if f.Count() != 10000 || af.Count() != 10000 {
+		t.Errorf("Count() = %d, %d, want 10000", f.Count(), af.Count())
+	}
+	if f.K() != af.K() || f.NumWords() != af.NumWords() {
+		t.Errorf("params differ: (%d, %d) vs (%d, %d)", f.K(), f.NumWords(), af.K(), af.NumWords())
+	}
+	if f.Cap() != f.NumWords()*64 || af.Cap() != af.NumWords()*64 {
+		t.Errorf("Cap() = %d, %d, want %d", f.Cap(), af.Cap(), f.NumWords()*64)
+	}
+	if f.EstimatedFillRatio() != f.ExactFillRatio() || af.EstimatedFillRatio() != af.ExactFillRatio() {
+		t.Errorf("fill ratio mismatch: %f/%f vs %f/%f",
+			f.EstimatedFillRatio(), f.ExactFillRatio(), af.EstimatedFillRatio(), af.ExactFillRatio())
+	}
+	if fp := f.EstimatedFalsePositiveRate(); fp > 0.02 || fp != af.EstimatedFalsePositiveRate() {
+		t.Errorf("EstimatedFalsePositiveRate() = %g, %g, want equal and at most 0.02", fp, af.EstimatedFalsePositiveRate())
+	}
+}
+
+func TestRegisterFilterInvalidParams(t *testing.T) {
+	for _, k := range []uint32{0, MaxRegisterK + 1, 100} {
+		f := NewRegisterWithParams(0, k)
+		af := NewAtomicRegisterWithParams(0, k)
+		if f.K() != 4 || af.K() != 4 {
+			t.Errorf("k=%d: K() = %d, %d, want 4", k, f.K(), af.K())
+		}
+		if f.NumWords() != 1 || af.NumWords() != 1 {
+			t.Errorf("NumWords() = %d, %d, want 1", f.NumWords(), af.NumWords())
+		}
+	}
+}
+
+func TestRegisterPatterns(t *testing.T) {
+	for k := uint32(MinK); k <= MaxRegisterK; k++ {
+		table := registerPatternTable(k)
+		if registerPatternTable(k) != table {
+			t.Errorf("k=%d: table regenerated", k)
+		}
+
+		var union uint64
+		for i, mask := range table {
+			if bits.OnesCount64(mask) != int(k) {
+				t.Fatalf("k=%d: pattern %d has %d bits set", k, i, bits.OnesCount64(mask))
+			}
+			union |= mask
+		}
+		if union != ^uint64(0) {
+			t.Errorf("k=%d: patterns never use bits %x", k, ^union)
+		}
+
+		// Every rotation keeps exactly k bits
+		for _, h := range []uint32{0, 1 << registerPatternBits, ^uint32(0)} {
+			if got := bits.OnesCount64(registerMask(table, h)); got != int(k) {
+				t.Errorf("k=%d, h=%#x: mask has %d bits set", k, h, got)
+			}
+		}
+	}
+
+	if registerPatternTable(0) != nil || registerPatternTable(MaxRegisterK+1) != nil {
+		t.Error("expected nil table for unsupported k")
+	}
+}
+
+func TestRegisterPatternsStable(t *testing.T) {
+	// Filters with the same k must set the same bits for the same key, so
+	// the generated patterns must never change
+	want := map[uint32][3]uint64{
+		1:  {0x0000001000000000, 0x0000800000000000, 0x0000008000000000},
+		4:  {0x0240000088000000, 0x0400002012000000, 0x0082000400004000},
+		16: {0x01600f280080c123, 0x8241868870002430, 0x2048291242409045},
+	}
+	for k, patterns := range want {
+		table := registerPatternTable(k)
+		if got := [3]uint64{table[0], table[1], table[len(table)-1]}; got != patterns {
+			t.Errorf("k=%d: got %#x, want %#x", k, got, patterns)
+		}
+	}
+}
+
+func TestAtomicRegisterFilterConcurrent(t *testing.T) {
+	f := NewAtomicRegister(40000, 0.05)
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 10000 {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				f.AddString(key)
+				f.TestString(key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for w := range 4 {
+		for i := range 10000 {
+			if key := fmt.Sprintf("w%d-%d", w, i); !f.TestString(key) {
+				t.Fatalf("false negative for %q", key)
+			}
+		}
+	}
+	if f.Count() != 40000 {
+		t.Errorf("Count() = %d, want 40000", f.Count())
+	}
+	if f.EstimatedFillRatio() != f.ExactFillRatio() {
+		t.Errorf("EstimatedFillRatio %f != ExactFillRatio %f", f.EstimatedFillRatio(), f.ExactFillRatio())
+	}
+}
+
+func TestOptimalRegisterParams(t *testing.T) {
+	tests := []struct {
+		items  uint64
+		fpRate float64
+		wantK  uint32
+	}{
+		{1_000_000, 0.1, 3},
+		{1_000_000, 0.01, 5},
+		{1_000_000, 0.001, 7},
+		{1000, 0.05, 4},
+	}
+	for _, tt := range tests {
+		numWords, k, bitsPerItem := OptimalRegisterParams(tt.items, tt.fpRate)
+		if k != tt.wantK {
+			t.Errorf("OptimalRegisterParams(%d, %g): k=%d, want %d", tt.items, tt.fpRate, k, tt.wantK)
+		}
+		if fp := EstimateRegisterFalsePositiveRate(numWords, k, tt.items); fp > tt.fpRate {
+			t.Errorf("OptimalRegisterParams(%d, %g): estimate %g misses target", tt.items, tt.fpRate, fp)
+		}
+
+		// Register blocks are never more accurate than cache-line blocks
+		_, _, filterBitsPerItem := OptimalParams(tt.items, tt.fpRate)
+		if bitsPerItem < filterBitsPerItem {
+			t.Errorf("OptimalRegisterParams(%d, %g): %.1f bits per item, below Filter's %.1f",
+				tt.items, tt.fpRate, bitsPerItem, filterBitsPerItem)
+		}
+	}
+}
+
+func TestOptimalRegisterParamsEdgeCases(t *testing.T) {
+	// Zero items and out of range rates are clamped like OptimalParams
+	if numWords, k, _ := OptimalRegisterParams(0, 0.01); numWords == 0 || k < MinK || k > MaxRegisterK {
+		t.Errorf("zero items: got (%d, %d)", numWords, k)
+	}
+	if _, k, _ := OptimalRegisterParams(1000, 1.5); k < MinK {
+		t.Errorf("fpRate >= 1: got k=%d", k)
+	}
+
+	// Rates the memory cap cannot reach stop at the cap
+	numWords, k, bitsPerItem := OptimalRegisterParams(1000, 0)
+	if bitsPerItem > maxRegisterBitsPerItem+1 {
+		t.Errorf("fpRate=0: %.1f bits per item exceeds the cap", bitsPerItem)
+	}
+	if k > MaxRegisterK || numWords == 0 {
+		t.Errorf("fpRate=0: got (%d, %d)", numWords, k)
+	}
+	numWords, _, bitsPerItem = OptimalRegisterParams(1000, 1e-9)
+	if bitsPerItem < maxRegisterBitsPerItem || numWords != 4000 {
+		t.Errorf("fpRate=1e-9: got %d words, %.1f bits per item, want the cap", numWords, bitsPerItem)
+	}
+}
+
+func TestEstimateRegisterFalsePositiveRate(t *testing.T) {
+	if got := EstimateRegisterFalsePositiveRate(0, 4, 100); got != 0 {
+		t.Errorf("zero words: got %g", got)
+	}
+	if got := EstimateRegisterFalsePositiveRate(100, 4, 0); got != 0 {
+		t.Errorf("zero items: got %g", got)
+	}
+	if got := EstimateRegisterFalsePositiveRate(100, MaxRegisterK+1, 100); got != 0 {
+		t.Errorf("unsupported k: got %g", got)
+	}
+
+	// A heavily overloaded filter matches everything
+	if got := EstimateRegisterFalsePositiveRate(1, 4, 1_000_000); got < 0.999 || got > 1 {
+		t.Errorf("overloaded filter: got %g", got)
+	}
+
+	// More items never lower the rate
+	prev := 0.0
+	for items := uint64(1000); items <= 100000; items *= 2 {
+		fp := EstimateRegisterFalsePositiveRate(10000, 6, items)
+		if fp < prev {
+			t.Errorf("items=%d: rate %g below %g", items, fp, prev)
+		}
+		prev = fp
+	}
+}
+
+func TestRegisterFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, tc := range []struct {
+		numWords uint64
+		k        uint32
+		items    int
+	}{
+		{20000, 2, 200000},
+		{20000, 4, 100000},
+		{20000, 8, 150000},
+		{40000, 10, 100000},
+	} {
+		t.Run(fmt.Sprintf("k_%d", tc.k), func(t *testing.T) {
+			f := NewRegisterWithParams(tc.numWords, tc.k)
+			for i := range tc.items {
+				f.Add(fmt.Appendf(nil, "item-%d", i))
+			}
+
+			const testItems = 1_000_000
+			var falsePositives int
+			for i := range testItems {
+				if f.Test(fmt.Appendf(nil, "notitem-%d", i)) {
+					falsePositives++
+				}
+			}
+
+			actual := float64(falsePositives) / testItems
+			estimated := f.EstimatedFalsePositiveRate()
+			if actual > estimated*1.2 || actual < estimated/1.2 {
+				t.Errorf("FP rate %.3g too far from estimate %.3g", actual, estimated)
+			}
+			t.Logf("FP rate %.3g (estimate %.3g)", actual, estimated)
+		})
+	}
+}
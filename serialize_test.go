@@ -2,7 +2,10 @@ package gloom
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"slices"
 	"testing"
 )
 
@@ -90,11 +93,14 @@ func TestSerializeRoundtripWithData(t *testing.T) {
 	if restored.EstimatedFillRatio() != original.EstimatedFillRatio() {
 		t.Errorf("EstimatedFillRatio mismatch: got %f, want %f", restored.EstimatedFillRatio(), original.EstimatedFillRatio())
 	}
+	if restored.EstimatedFillRatio() != restored.ExactFillRatio() {
+		t.Errorf("restored EstimatedFillRatio %f does not match ExactFillRatio %f", restored.EstimatedFillRatio(), restored.ExactFillRatio())
+	}
 }
 
 func TestSerializeRoundtripAllKValues(t *testing.T) {
 	// Test serialization with all supported k values
-	for k := uint32(3); k <= 14; k++ {
+	for k := uint32(MinK); k <= MaxK; k++ {
 		t.Run(fmt.Sprintf("k=%d", k), func(t *testing.T) {
 			original := NewWithParams(100, k)
 
@@ -237,7 +243,7 @@ func TestSerializeInvalidK(t *testing.T) {
 	}
 
 	// Test invalid k values
-	invalidKValues := []uint32{0, 1, 2, 15, 16, 100, 255}
+	invalidKValues := []uint32{0, MaxK + 1, 100, 255}
 	for _, invalidK := range invalidKValues {
 		dataCopy := make([]byte, len(data))
 		copy(dataCopy, data)
@@ -480,6 +486,94 @@ func TestSerializeDataFormat(t *testing.T) {
 	}
 }
 
+func TestSerializeRoundtripBlockSizes(t *testing.T) {
+	for _, blockBits := range []uint32{256, 512, 1024} {
+		for _, k := range []uint32{MinK, 7, MaxKForBlockBits(blockBits)} {
+			t.Run(fmt.Sprintf("%d/k=%d", blockBits, k), func(t *testing.T) {
+				original := NewWithBlockParams(30, k, blockBits)
+				for i := range 500 {
+					original.AddString(fmt.Sprintf("item-%d", i))
+				}
+
+				data, err := original.MarshalBinary()
+				if err != nil {
+					t.Fatalf("MarshalBinary failed: %v", err)
+				}
+
+				// 512-bit filters stay readable by older versions
+				wantVersion, wantLen := serializeVersionBlockBits, headerSizeBlockBits+30*int(blockBits)/8
+				if blockBits == BlockBits {
+					wantVersion, wantLen = serializeVersion, headerSize+30*BlockBits/8
+				}
+				if data[0] != wantVersion || len(data) != wantLen {
+					t.Errorf("got version %d and %d bytes, want %d and %d", data[0], len(data), wantVersion, wantLen)
+				}
+
+				restored, err := UnmarshalBinary(data)
+				if err != nil {
+					t.Fatalf("UnmarshalBinary failed: %v", err)
+				}
+				if restored.BlockBits() != blockBits || restored.K() != k || restored.NumBlocks() != 30 {
+					t.Errorf("params mismatch: got (%d, %d, %d), want (%d, %d, 30)",
+						restored.BlockBits(), restored.K(), restored.NumBlocks(), blockBits, k)
+				}
+				if restored.EstimatedFillRatio() != original.EstimatedFillRatio() {
+					t.Errorf("EstimatedFillRatio mismatch: got %f, want %f", restored.EstimatedFillRatio(), original.EstimatedFillRatio())
+				}
+				for i := range 500 {
+					if !restored.TestString(fmt.Sprintf("item-%d", i)) {
+						t.Fatalf("false negative for item-%d", i)
+					}
+				}
+
+				again, err := restored.MarshalBinary()
+				if err != nil {
+					t.Fatalf("MarshalBinary failed: %v", err)
+				}
+				if !bytes.Equal(again, data) {
+					t.Error("second roundtrip produced different bytes")
+				}
+			})
+		}
+	}
+}
+
+func TestSerializeInvalidBlockBits(t *testing.T) {
+	f := NewWithBlockParams(4, 13, 1024)
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	// The block size field is too short to read
+	if _, err := UnmarshalBinary(data[:headerSizeBlockBits-1]); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("truncated header: expected ErrInvalidData, got %v", err)
+	}
+
+	for _, blockBits := range []uint32{0, 64, 384, 2048} {
+		bad := bytes.Clone(data)
+		binary.LittleEndian.PutUint32(bad[21:25], blockBits)
+		if _, err := UnmarshalBinary(bad); !errors.Is(err, ErrInvalidData) {
+			t.Errorf("blockBits=%d: expected ErrInvalidData, got %v", blockBits, err)
+		}
+	}
+
+	// k=14 is valid for 1024-bit blocks but not for 256-bit ones
+	bad := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(bad[1:5], 14)
+	binary.LittleEndian.PutUint32(bad[21:25], 256)
+	if _, err := UnmarshalBinary(bad); !errors.Is(err, ErrInvalidK) {
+		t.Errorf("k=14 with 256-bit blocks: expected ErrInvalidK, got %v", err)
+	}
+
+	// Shrinking the block size leaves the data the wrong length
+	bad = bytes.Clone(data)
+	binary.LittleEndian.PutUint32(bad[21:25], 512)
+	if _, err := UnmarshalBinary(bad); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("mismatched length: expected ErrInvalidData, got %v", err)
+	}
+}
+
 func TestSerializeCacheLineAlignment(t *testing.T) {
 	// Test that deserialized filter maintains cache-line alignment
 	f := New(1000, 0.01)
@@ -623,8 +717,8 @@ func TestSerializeNoFalseNegativesProperty(t *testing.T) {
 		{100, 0.1, 0}, // k=0 means use optimal
 		{1000, 0.01, 0},
 		{10000, 0.001, 0},
-		{100, 0.01, 3},  // minimum k
-		{100, 0.01, 14}, // maximum k
+		{100, 0.01, MinK}, // minimum k
+		{100, 0.01, MaxK}, // maximum k
 	}
 
 	for _, tc := range testCases {
@@ -728,3 +822,198 @@ func FuzzUnmarshalBinaryInvalid(f *testing.F) {
 		_, _ = UnmarshalBinary(data)
 	})
 }
+
+// withPortableEncoding runs fn with the little-endian bulk copy disabled, so
+// the per-word loop used on big-endian hosts is exercised.
+func withPortableEncoding(fn func()) {
+	saved := nativeLittleEndian
+	nativeLittleEndian = false
+	defer func() { nativeLittleEndian = saved }()
+	fn()
+}
+
+func TestSerializePortableEncodingMatches(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := range 5000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+
+	fast, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	var portable []byte
+	var restored *Filter
+	withPortableEncoding(func() {
+		portable, err = f.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		restored, err = UnmarshalBinary(fast)
+		if err != nil {
+			t.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+	})
+
+	if !bytes.Equal(fast, portable) {
+		t.Error("bulk copy and portable encodings differ")
+	}
+	for i := range f.blocks {
+		if restored.blocks[i] != f.blocks[i] {
+			t.Fatalf("word %d mismatch: got %x, want %x", i, restored.blocks[i], f.blocks[i])
+		}
+	}
+}
+
+func TestUnmarshalBinaryIntoReusesBlocks(t *testing.T) {
+	src := New(10000, 0.01)
+	for i := range 1000 {
+		src.AddString(fmt.Sprintf("item-%d", i))
+	}
+	data, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	dst := NewWithParams(src.NumBlocks(), src.K())
+	dst.AddString("stale")
+	before := &dst.blocks[0]
+
+	if err := UnmarshalBinaryInto(data, dst); err != nil {
+		t.Fatalf("UnmarshalBinaryInto failed: %v", err)
+	}
+	if &dst.blocks[0] != before {
+		t.Error("expected existing blocks to be reused")
+	}
+	for i := range src.blocks {
+		if dst.blocks[i] != src.blocks[i] {
+			t.Fatalf("word %d mismatch: got %x, want %x", i, dst.blocks[i], src.blocks[i])
+		}
+	}
+	if dst.Count() != src.Count() {
+		t.Errorf("Count mismatch: got %d, want %d", dst.Count(), src.Count())
+	}
+	if dst.EstimatedFillRatio() != src.EstimatedFillRatio() {
+		t.Errorf("EstimatedFillRatio mismatch: got %f, want %f", dst.EstimatedFillRatio(), src.EstimatedFillRatio())
+	}
+	for i := range 1000 {
+		if !dst.TestString(fmt.Sprintf("item-%d", i)) {
+			t.Fatalf("false negative for item-%d", i)
+		}
+	}
+}
+
+func TestUnmarshalBinaryIntoResizes(t *testing.T) {
+	testCases := []struct {
+		name string
+		dst  func() *Filter
+	}{
+		{"zero filter", func() *Filter { return &Filter{} }},
+		{"different size", func() *Filter { return NewWithParams(3, 7) }},
+		{"different k", func() *Filter { return NewWithParams(20, 3) }},
+		{"mmap backed", func() *Filter { return NewWithParamsAllocator(3, 7, HugePageAllocator) }},
+	}
+
+	src := NewWithParams(20, 5)
+	for i := range 500 {
+		src.AddString(fmt.Sprintf("item-%d", i))
+	}
+	data, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := tc.dst()
+			if err := UnmarshalBinaryInto(data, dst); err != nil {
+				t.Fatalf("UnmarshalBinaryInto failed: %v", err)
+			}
+			if dst.NumBlocks() != src.NumBlocks() || dst.K() != src.K() {
+				t.Errorf("params mismatch: got (%d, %d), want (%d, %d)", dst.NumBlocks(), dst.K(), src.NumBlocks(), src.K())
+			}
+			if dst.Allocator() != HeapAllocator {
+				t.Errorf("Allocator() = %v, want heap", dst.Allocator())
+			}
+			if addr := uintptr(unsafePointer(&dst.blocks[0])); addr%64 != 0 {
+				t.Errorf("blocks not 64-byte aligned: address %x", addr)
+			}
+			for i := range 500 {
+				if !dst.TestString(fmt.Sprintf("item-%d", i)) {
+					t.Fatalf("false negative for item-%d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinaryIntoBlockSizes(t *testing.T) {
+	src := NewWithBlockParams(10, 16, 1024)
+	for i := range 500 {
+		src.AddString(fmt.Sprintf("item-%d", i))
+	}
+	data, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	testCases := []struct {
+		name  string
+		dst   func() *Filter
+		reuse bool
+	}{
+		{"same shape", func() *Filter { return NewWithBlockParams(10, 16, 1024) }, true},
+		{"same words, smaller blocks", func() *Filter { return NewWithBlockParams(20, 16, 512) }, true},
+		{"same words, smallest blocks", func() *Filter { return NewWithBlockParams(40, 7, 256) }, true},
+		{"same blocks, fewer words", func() *Filter { return NewWithBlockParams(10, 7, 512) }, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := tc.dst()
+			dst.AddString("stale")
+			before := &dst.blocks[0]
+
+			if err := UnmarshalBinaryInto(data, dst); err != nil {
+				t.Fatalf("UnmarshalBinaryInto failed: %v", err)
+			}
+			if reused := &dst.blocks[0] == before; reused != tc.reuse {
+				t.Errorf("blocks reused = %v, want %v", reused, tc.reuse)
+			}
+			if dst.BlockBits() != 1024 || dst.NumBlocks() != 10 || dst.K() != 16 {
+				t.Errorf("params mismatch: got (%d, %d, %d), want (1024, 10, 16)", dst.BlockBits(), dst.NumBlocks(), dst.K())
+			}
+			if !slices.Equal(dst.primes, GetBlockPartition(16, 1024)) {
+				t.Errorf("primes not updated: got %v", dst.primes)
+			}
+			for i := range 500 {
+				if !dst.TestString(fmt.Sprintf("item-%d", i)) {
+					t.Fatalf("false negative for item-%d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinaryIntoInvalidLeavesFilter(t *testing.T) {
+	dst := New(1000, 0.01)
+	dst.AddString("keep")
+	want, err := dst.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	bad := bytes.Clone(want)
+	bad = bad[:len(bad)-1]
+	if err := UnmarshalBinaryInto(bad, dst); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("expected ErrInvalidData, got %v", err)
+	}
+
+	got, err := dst.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("filter modified by failed UnmarshalBinaryInto")
+	}
+}
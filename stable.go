@@ -0,0 +1,594 @@
+package gloom
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// stableMaxK is the largest number of hash functions OptimalStableParams
+// considers. More cells per key only shorten the window.
+const stableMaxK = 8
+
+// StableParams describes the parameters and predicted behavior of a
+// StableFilter or AtomicStableFilter, as returned by OptimalStableParams.
+type StableParams struct {
+	NumBlocks         uint64  // Number of 512-bit blocks
+	K                 uint32  // Number of cells set per key
+	CounterBits       uint32  // Bits per cell: 1 or 2
+	Decrements        uint32  // Cells decremented per insert (P)
+	FalsePositiveRate float64 // False positive rate once the filter is stable
+	Window            uint64  // Inserts after a key's own within which it is found with the false negative tolerance
+}
+
+// StableFilter is a stable bloom filter for unbounded streams, as described
+// by Deng and Rafiei, "Approximately Detecting Duplicates for Streaming Data
+// using Stable Bloom Filters" (2006). A Filter that keeps receiving new keys
+// eventually has every bit set; a StableFilter forgets old keys instead, so
+// its false positive rate converges to a fixed value.
+//
+// Each cell is a small counter. Adding a key first decrements P random cells
+// of its block, then sets its K cells to the counter's maximum. The fraction
+// of zero cells settles where the two balance, which fixes the false
+// positive rate at EstimatedFalsePositiveRate, independent of the number of
+// keys added. The price is false negatives: a key not seen for long enough
+// may have had a cell decremented to zero. OptimalStableParams reports how
+// many inserts a key is remembered for.
+//
+// Blocks are 512 bits like a Filter's, so adding or testing a key touches
+// one cache line, and the decremented cells are chosen within the key's
+// block. A StableFilter is not safe for concurrent use; see
+// AtomicStableFilter.
+type StableFilter struct {
+	raw         []byte   // Raw allocation to keep aligned memory alive for GC
+	blocks      []uint64 // BlockWords uint64s per block (cache-line aligned)
+	numBlocks   uint64   // Total number of blocks
+	k           uint32   // Number of cells set per key
+	counterBits uint32   // Bits per cell: 1 or 2
+	decrements  uint32   // Cells decremented per insert
+	primes      []uint32 // Prime partition sizes, in cells
+	offsets     []uint32 // Cumulative offsets within block, in cells
+	count       uint64   // Number of items added
+	rng         uint64   // splitmix64 state for choosing decremented cells
+}
+
+// ValidStableCounterBits reports whether bits is a supported counter size
+// for a StableFilter: 1 or 2, for a maximum value of 1 or 3. A block holds
+// 512 or 256 cells, which the key's K cells are partitioned over as in a
+// Filter's 512-bit or 256-bit block.
+func ValidStableCounterBits(bits uint32) bool {
+	return bits == 1 || bits == 2
+}
+
+// stableCells returns the number of cells in a block with counters of the
+// given size.
+func stableCells(counterBits uint32) uint32 {
+	return BlockBits / counterBits
+}
+
+// NewStable creates a StableFilter that uses about memoryBytes bytes, with
+// a stable false positive rate of at most fpRate and the longest window
+// for a false negative tolerance of fnRate. See OptimalStableParams.
+func NewStable(memoryBytes uint64, fpRate, fnRate float64) *StableFilter {
+	p := OptimalStableParams(memoryBytes, fpRate, fnRate)
+	return NewStableWithParams(p.NumBlocks, p.K, p.CounterBits, p.Decrements)
+}
+
+// NewStableWithParams creates a StableFilter with explicit parameters.
+// An unsupported counter size falls back to 2 bits, an unsupported k to 3,
+// and 0 decrements to 1.
+func NewStableWithParams(numBlocks uint64, k, counterBits, decrements uint32) *StableFilter {
+	f := &StableFilter{}
+	f.numBlocks, f.k, f.counterBits, f.decrements, f.primes, f.offsets = stableParams(numBlocks, k, counterBits, decrements)
+	f.raw, f.blocks, _ = makeAlignedUint64Slice(int(f.numBlocks*BlockWords), HeapAllocator)
+	return f
+}
+
+// stableParams applies the fallbacks of NewStableWithParams and returns the
+// partition of a block's cells.
+func stableParams(numBlocks uint64, k, counterBits, decrements uint32) (uint64, uint32, uint32, uint32, []uint32, []uint32) {
+	numBlocks = max(numBlocks, 1)
+	if !ValidStableCounterBits(counterBits) {
+		counterBits = 2
+	}
+	primes := GetBlockPartition(k, stableCells(counterBits))
+	if primes == nil {
+		k = 3
+		primes = GetBlockPartition(k, stableCells(counterBits))
+	}
+	return numBlocks, k, counterBits, max(decrements, 1), primes, ComputeOffsets(primes)
+}
+
+// cellShift returns the word index within a block and the bit shift of cell
+// c with counters of counterBits bits.
+func cellShift(c, counterBits uint32) (word, shift uint32) {
+	bit := c * counterBits
+	return bit / 64, bit % 64
+}
+
+// Add adds data to the filter.
+func (f *StableFilter) Add(data []byte) {
+	f.addWithHash(hashSplit(hashRaw(data), f.numBlocks))
+}
+
+// AddString adds a string to the filter without allocating.
+func (f *StableFilter) AddString(s string) {
+	f.addWithHash(hashSplit(hashRawString(s), f.numBlocks))
+}
+
+// TestAndAdd reports whether data might have been added recently, then adds
+// it, as when deduplicating a stream.
+func (f *StableFilter) TestAndAdd(data []byte) bool {
+	blockIdx, intraHash := hashSplit(hashRaw(data), f.numBlocks)
+	found := f.testWithHash(blockIdx, intraHash)
+	f.addWithHash(blockIdx, intraHash)
+	return found
+}
+
+// TestAndAddString is TestAndAdd for a string, without allocating.
+func (f *StableFilter) TestAndAddString(s string) bool {
+	blockIdx, intraHash := hashSplit(hashRawString(s), f.numBlocks)
+	found := f.testWithHash(blockIdx, intraHash)
+	f.addWithHash(blockIdx, intraHash)
+	return found
+}
+
+// addWithHash decrements random cells of the block, then sets the key's
+// cells using pre-computed hash values.
+func (f *StableFilter) addWithHash(blockIdx uint64, intraHash uint32) {
+	block := f.blocks[blockIdx*BlockWords : (blockIdx+1)*BlockWords]
+	counterMask := uint64(1)<<f.counterBits - 1
+	cellBits := uint32(bits.TrailingZeros32(stableCells(f.counterBits)))
+
+	var r uint64
+	avail := uint32(0)
+	for range f.decrements {
+		if avail < cellBits {
+			r, avail = splitmix64(&f.rng), 64
+		}
+		word, shift := cellShift(uint32(r)&(1<<cellBits-1), f.counterBits)
+		r >>= cellBits
+		avail -= cellBits
+		// (v + Max) >> counterBits is 1 for any nonzero v up to Max
+		block[word] -= (block[word]>>shift&counterMask + counterMask) >> f.counterBits << shift
+	}
+
+	for i := range f.k {
+		word, shift := cellShift(f.offsets[i]+intraHash%f.primes[i], f.counterBits)
+		block[word] |= counterMask << shift
+	}
+	f.count++
+}
+
+// Test checks if data might have been added recently.
+// Returns true if the item might be present, false if it is absent or was
+// forgotten.
+func (f *StableFilter) Test(data []byte) bool {
+	return f.testWithHash(hashSplit(hashRaw(data), f.numBlocks))
+}
+
+// TestString checks if a string might have been added recently without
+// allocating.
+func (f *StableFilter) TestString(s string) bool {
+	return f.testWithHash(hashSplit(hashRawString(s), f.numBlocks))
+}
+
+// testWithHash checks if all of a key's cells are nonzero using
+// pre-computed hash values.
+func (f *StableFilter) testWithHash(blockIdx uint64, intraHash uint32) bool {
+	block := f.blocks[blockIdx*BlockWords : (blockIdx+1)*BlockWords]
+	counterMask := uint64(1)<<f.counterBits - 1
+	for i := range f.k {
+		word, shift := cellShift(f.offsets[i]+intraHash%f.primes[i], f.counterBits)
+		if block[word]>>shift&counterMask == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *StableFilter) Cap() uint64 {
+	return f.numBlocks * BlockBits
+}
+
+// K returns the number of cells set per key.
+func (f *StableFilter) K() uint32 {
+	return f.k
+}
+
+// NumBlocks returns the number of blocks in the filter.
+func (f *StableFilter) NumBlocks() uint64 {
+	return f.numBlocks
+}
+
+// CounterBits returns the number of bits per cell.
+func (f *StableFilter) CounterBits() uint32 {
+	return f.counterBits
+}
+
+// Decrements returns the number of cells decremented per insert.
+func (f *StableFilter) Decrements() uint32 {
+	return f.decrements
+}
+
+// Count returns the number of items added to the filter.
+func (f *StableFilter) Count() uint64 {
+	return f.count
+}
+
+// ZeroFraction returns the fraction of cells that are zero, by scanning the
+// filter. It converges to a fixed value as keys are added.
+func (f *StableFilter) ZeroFraction() float64 {
+	return stableZeroFraction(len(f.blocks), f.counterBits, func(i int) uint64 { return f.blocks[i] })
+}
+
+// EstimatedFalsePositiveRate returns the filter's false positive rate once
+// it is stable. See EstimateStableFalsePositiveRate.
+func (f *StableFilter) EstimatedFalsePositiveRate() float64 {
+	return EstimateStableFalsePositiveRate(f.k, f.counterBits, f.decrements)
+}
+
+// stableZeroFraction returns the fraction of zero cells in n words read by
+// load.
+func stableZeroFraction(n int, counterBits uint32, load func(i int) uint64) float64 {
+	// Mark the low bit of each cell that has any bit set
+	low := uint64(math.MaxUint64)
+	if counterBits == 2 {
+		low = 0x5555555555555555
+	}
+	var nonzero int
+	for i := range n {
+		w := load(i)
+		if counterBits == 2 {
+			w |= w >> 1
+		}
+		nonzero += bits.OnesCount64(w & low)
+	}
+	cells := n * 64 / int(counterBits)
+	return float64(cells-nonzero) / float64(cells)
+}
+
+// AtomicStableFilter is a thread-safe StableFilter. Cells are updated with
+// atomic operations, and decremented cells are chosen with the goroutine-safe
+// generator of math/rand/v2. Concurrent adds to the same block may each
+// decrement a cell another has just set, as they could in any order.
+type AtomicStableFilter struct {
+	raw         []byte          // Raw allocation to keep aligned memory alive for GC
+	blocks      []atomic.Uint64 // BlockWords words per block (cache-line aligned)
+	numBlocks   uint64          // Total number of blocks
+	k           uint32          // Number of cells set per key
+	counterBits uint32          // Bits per cell: 1 or 2
+	decrements  uint32          // Cells decremented per insert
+	primes      []uint32        // Prime partition sizes, in cells
+	offsets     []uint32        // Cumulative offsets within block, in cells
+	count       stripedCounter  // Number of items added
+}
+
+// NewAtomicStable creates an AtomicStableFilter. See NewStable.
+func NewAtomicStable(memoryBytes uint64, fpRate, fnRate float64) *AtomicStableFilter {
+	p := OptimalStableParams(memoryBytes, fpRate, fnRate)
+	return NewAtomicStableWithParams(p.NumBlocks, p.K, p.CounterBits, p.Decrements)
+}
+
+// NewAtomicStableWithParams creates an AtomicStableFilter with explicit
+// parameters. See NewStableWithParams.
+func NewAtomicStableWithParams(numBlocks uint64, k, counterBits, decrements uint32) *AtomicStableFilter {
+	f := &AtomicStableFilter{count: newStripedCounter()}
+	f.numBlocks, f.k, f.counterBits, f.decrements, f.primes, f.offsets = stableParams(numBlocks, k, counterBits, decrements)
+	f.raw, f.blocks, _ = makeAlignedAtomicUint64Slice(int(f.numBlocks*BlockWords), HeapAllocator)
+	return f
+}
+
+// Add adds data to the filter atomically.
+func (f *AtomicStableFilter) Add(data []byte) {
+	h := hashRaw(data)
+	f.addWithHash(hashSplit(h, f.numBlocks))
+	f.count.add(h, 1)
+}
+
+// AddString adds a string to the filter atomically without allocating.
+func (f *AtomicStableFilter) AddString(s string) {
+	h := hashRawString(s)
+	f.addWithHash(hashSplit(h, f.numBlocks))
+	f.count.add(h, 1)
+}
+
+// TestAndAdd reports whether data might have been added recently, then adds
+// it. The test and the add are not one atomic operation, so two concurrent
+// calls with the same new key may both return false.
+func (f *AtomicStableFilter) TestAndAdd(data []byte) bool {
+	h := hashRaw(data)
+	blockIdx, intraHash := hashSplit(h, f.numBlocks)
+	found := f.testWithHash(blockIdx, intraHash)
+	f.addWithHash(blockIdx, intraHash)
+	f.count.add(h, 1)
+	return found
+}
+
+// TestAndAddString is TestAndAdd for a string, without allocating.
+func (f *AtomicStableFilter) TestAndAddString(s string) bool {
+	h := hashRawString(s)
+	blockIdx, intraHash := hashSplit(h, f.numBlocks)
+	found := f.testWithHash(blockIdx, intraHash)
+	f.addWithHash(blockIdx, intraHash)
+	f.count.add(h, 1)
+	return found
+}
+
+// addWithHash decrements random cells of the block, then sets the key's
+// cells using pre-computed hash values.
+func (f *AtomicStableFilter) addWithHash(blockIdx uint64, intraHash uint32) {
+	block := f.blocks[blockIdx*BlockWords : (blockIdx+1)*BlockWords]
+	counterMask := uint64(1)<<f.counterBits - 1
+	cellBits := uint32(bits.TrailingZeros32(stableCells(f.counterBits)))
+
+	var r uint64
+	avail := uint32(0)
+	for range f.decrements {
+		if avail < cellBits {
+			r, avail = rand.Uint64(), 64
+		}
+		word, shift := cellShift(uint32(r)&(1<<cellBits-1), f.counterBits)
+		r >>= cellBits
+		avail -= cellBits
+		for {
+			old := block[word].Load()
+			if old>>shift&counterMask == 0 || block[word].CompareAndSwap(old, old-1<<shift) {
+				break
+			}
+		}
+	}
+
+	for i := range f.k {
+		word, shift := cellShift(f.offsets[i]+intraHash%f.primes[i], f.counterBits)
+		block[word].Or(counterMask << shift)
+	}
+}
+
+// Test checks if data might have been added recently.
+// Returns true if the item might be present, false if it is absent or was
+// forgotten.
+func (f *AtomicStableFilter) Test(data []byte) bool {
+	return f.testWithHash(hashSplit(hashRaw(data), f.numBlocks))
+}
+
+// TestString checks if a string might have been added recently without
+// allocating.
+func (f *AtomicStableFilter) TestString(s string) bool {
+	return f.testWithHash(hashSplit(hashRawString(s), f.numBlocks))
+}
+
+// testWithHash checks if all of a key's cells are nonzero using
+// pre-computed hash values.
+func (f *AtomicStableFilter) testWithHash(blockIdx uint64, intraHash uint32) bool {
+	block := f.blocks[blockIdx*BlockWords : (blockIdx+1)*BlockWords]
+	counterMask := uint64(1)<<f.counterBits - 1
+	for i := range f.k {
+		word, shift := cellShift(f.offsets[i]+intraHash%f.primes[i], f.counterBits)
+		if block[word].Load()>>shift&counterMask == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *AtomicStableFilter) Cap() uint64 {
+	return f.numBlocks * BlockBits
+}
+
+// K returns the number of cells set per key.
+func (f *AtomicStableFilter) K() uint32 {
+	return f.k
+}
+
+// NumBlocks returns the number of blocks in the filter.
+func (f *AtomicStableFilter) NumBlocks() uint64 {
+	return f.numBlocks
+}
+
+// CounterBits returns the number of bits per cell.
+func (f *AtomicStableFilter) CounterBits() uint32 {
+	return f.counterBits
+}
+
+// Decrements returns the number of cells decremented per insert.
+func (f *AtomicStableFilter) Decrements() uint32 {
+	return f.decrements
+}
+
+// Count returns the number of items added to the filter. Like
+// AtomicFilter.Count, it is eventually consistent under concurrent adds.
+func (f *AtomicStableFilter) Count() uint64 {
+	return f.count.load()
+}
+
+// ZeroFraction returns the fraction of cells that are zero, by scanning the
+// filter. See StableFilter.ZeroFraction.
+func (f *AtomicStableFilter) ZeroFraction() float64 {
+	return stableZeroFraction(len(f.blocks), f.counterBits, func(i int) uint64 { return f.blocks[i].Load() })
+}
+
+// EstimatedFalsePositiveRate returns the filter's false positive rate once
+// it is stable. See EstimateStableFalsePositiveRate.
+func (f *AtomicStableFilter) EstimatedFalsePositiveRate() float64 {
+	return EstimateStableFalsePositiveRate(f.k, f.counterBits, f.decrements)
+}
+
+// EstimateStableFalsePositiveRate returns the false positive rate a stable
+// filter converges to with k cells per key, counters of counterBits bits,
+// and the given number of decrements per insert.
+//
+// With m cells per block and counters of maximum value Max, Deng and Rafiei
+// show that the fraction of zero cells converges to
+// (1 / (1 + 1/(P*(1/k - 1/m))))^Max, and a key absent from the filter is
+// found when all k of its cells are nonzero.
+func EstimateStableFalsePositiveRate(k, counterBits, decrements uint32) float64 {
+	m := float64(stableCells(counterBits))
+	maxValue := float64(uint64(1)<<counterBits - 1)
+	zeros := math.Pow(1/(1+1/(float64(decrements)*(1/float64(k)-1/m))), maxValue)
+	return math.Pow(1-zeros, float64(k))
+}
+
+// stableDecrements returns the fewest decrements per insert for which the
+// stable false positive rate is at most fpRate, inverting the formula of
+// EstimateStableFalsePositiveRate.
+func stableDecrements(k, counterBits uint32, fpRate float64) uint32 {
+	m := float64(stableCells(counterBits))
+	maxValue := float64(uint64(1)<<counterBits - 1)
+	zeros := 1 - math.Pow(fpRate, 1/float64(k))
+	p := 1 / ((math.Pow(1/zeros, 1/maxValue) - 1) * (1/float64(k) - 1/m))
+	return uint32(max(math.Ceil(p), 1))
+}
+
+// stableForgetCurve returns, for each number of later inserts into a key's
+// block, the probability that the key has been forgotten. The curve runs
+// well past the first point above fnRate, so that stableWindow can average
+// over it. It returns nil if the probability converges at or below fnRate.
+//
+// Each of the key's cells starts at the maximum. Every later insert into the
+// block decrements it once for each of its P random cells that lands on it,
+// then sets it back to the maximum with probability k/m, if the cell is one
+// of the new key's. The distribution of the cell's value is followed insert
+// by insert, and the key is forgotten if any of its k cells is zero.
+func stableForgetCurve(k, counterBits, decrements uint32, fnRate float64) []float64 {
+	m := float64(stableCells(counterBits))
+	maxValue := int(uint64(1)<<counterBits - 1)
+	reset := float64(k) / m
+
+	// Binomial(P, 1/m) probabilities of j decrements, for j < maxValue
+	pmf := make([]float64, maxValue)
+	lp, _ := math.Lgamma(float64(decrements) + 1)
+	for j := range pmf {
+		if j > int(decrements) {
+			break
+		}
+		lj, _ := math.Lgamma(float64(j) + 1)
+		lr, _ := math.Lgamma(float64(decrements) - float64(j) + 1)
+		pmf[j] = math.Exp(lp - lj - lr - float64(j)*math.Log(m) + (float64(decrements)-float64(j))*math.Log1p(-1/m))
+	}
+
+	dist := make([]float64, maxValue+1)
+	next := make([]float64, maxValue+1)
+	dist[maxValue] = 1
+	curve := []float64{0}
+	end := 0 // Length of the curve, once fnRate is exceeded
+	for end == 0 || len(curve) < end {
+		clear(next)
+		for v, pr := range dist {
+			remaining := pr
+			for j := range v {
+				next[v-j] += pr * pmf[j]
+				remaining -= pr * pmf[j]
+			}
+			next[0] += remaining
+		}
+		for v := range next {
+			next[v] *= 1 - reset
+		}
+		next[maxValue] += reset
+		dist, next = next, dist
+
+		fn := 1 - math.Pow(1-dist[0], float64(k))
+		if end == 0 {
+			if fn > fnRate {
+				end = 2*len(curve) + 64
+			} else if fn-curve[len(curve)-1] < 1e-15 {
+				// The distribution has converged below fnRate
+				return nil
+			}
+		}
+		curve = append(curve, fn)
+	}
+	return curve
+}
+
+// stableForgetRate returns the probability that a key is forgotten after
+// an average of lambda later inserts into its block. The number of inserts
+// that land in one block of many is about Poisson distributed, and the
+// curve is averaged over it.
+func stableForgetRate(curve []float64, lambda float64) float64 {
+	if lambda == 0 {
+		return curve[0]
+	}
+	spread := 12*math.Sqrt(lambda) + 12
+	lo := int(max(lambda-spread, 0))
+	hi := int(min(lambda+spread, float64(len(curve)-1)))
+	var fn, mass float64
+	for s := lo; s <= hi; s++ {
+		ls, _ := math.Lgamma(float64(s) + 1)
+		pr := math.Exp(float64(s)*math.Log(lambda) - lambda - ls)
+		fn += pr * curve[s]
+		mass += pr
+	}
+	// Any mass past the end of the curve is about as forgotten as its end
+	return fn + max(1-mass, 0)*curve[len(curve)-1]
+}
+
+// stableWindow returns the average number of later inserts per block
+// within which the probability of a key being forgotten stays at or below
+// fnRate, or +Inf if the curve is nil.
+func stableWindow(curve []float64, fnRate float64) float64 {
+	if curve == nil {
+		return math.Inf(1)
+	}
+	lo, hi := 0.0, float64(len(curve))
+	for range 60 {
+		mid := (lo + hi) / 2
+		if stableForgetRate(curve, mid) <= fnRate {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// OptimalStableParams chooses the parameters of a stable filter of about
+// memoryBytes bytes, with a stable false positive rate of at most fpRate,
+// that remembers keys for the most inserts at a false negative tolerance
+// of fnRate.
+//
+// For each counter size and k up to 8, it computes P, the fewest
+// decrements per insert that keep the stable false positive rate at or
+// below fpRate, and the window: how many inserts may follow a key's own
+// before the probability of testing it and finding it forgotten exceeds
+// fnRate. It returns the candidate with the longest window. Keys are
+// assumed to be spread over blocks at random, so the window grows with the
+// number of blocks. A window of math.MaxUint64 means keys are never
+// forgotten more often than fnRate.
+//
+// An fpRate or fnRate of 0 or below falls back to 1%, and one of 1 or above
+// to 0.99.
+func OptimalStableParams(memoryBytes uint64, fpRate, fnRate float64) StableParams {
+	if fpRate <= 0 {
+		fpRate = 0.01
+	}
+	fpRate = min(fpRate, 0.99)
+	if fnRate <= 0 {
+		fnRate = 0.01
+	}
+	fnRate = min(fnRate, 0.99)
+
+	best := StableParams{NumBlocks: max(memoryBytes/(BlockBits/8), 1)}
+	bestWindow := -1.0
+	for _, counterBits := range []uint32{1, 2} {
+		for k := uint32(1); k <= stableMaxK; k++ {
+			decrements := stableDecrements(k, counterBits, fpRate)
+			window := stableWindow(stableForgetCurve(k, counterBits, decrements, fnRate), fnRate)
+			if window > bestWindow || (window == bestWindow && decrements < best.Decrements) {
+				best.K, best.CounterBits, best.Decrements = k, counterBits, decrements
+				bestWindow = window
+			}
+		}
+	}
+
+	best.FalsePositiveRate = EstimateStableFalsePositiveRate(best.K, best.CounterBits, best.Decrements)
+	best.Window = math.MaxUint64
+	if w := bestWindow * float64(best.NumBlocks); w < math.MaxUint64 {
+		best.Window = uint64(w)
+	}
+	return best
+}
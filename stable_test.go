@@ -0,0 +1,296 @@
+package gloom
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+// stableTester is the API shared by StableFilter and AtomicStableFilter.
+type stableTester interface {
+	Tester
+	TestString(s string) bool
+	Add(data []byte)
+	AddString(s string)
+	TestAndAdd(data []byte) bool
+	TestAndAddString(s string) bool
+	Cap() uint64
+	K() uint32
+	NumBlocks() uint64
+	CounterBits() uint32
+	Decrements() uint32
+	Count() uint64
+	ZeroFraction() float64
+	EstimatedFalsePositiveRate() float64
+}
+
+func TestStableBasic(t *testing.T) {
+	for _, counterBits := range []uint32{1, 2} {
+		for name, f := range map[string]stableTester{
+			"StableFilter":       NewStableWithParams(64, 4, counterBits, 20),
+			"AtomicStableFilter": NewAtomicStableWithParams(64, 4, counterBits, 20),
+		} {
+			t.Run(fmt.Sprintf("%s/bits_%d", name, counterBits), func(t *testing.T) {
+				if f.ZeroFraction() != 1 {
+					t.Errorf("empty filter: ZeroFraction() = %f", f.ZeroFraction())
+				}
+
+				// A key just added is always found, however full the filter
+				for i := range 10_000 {
+					key := fmt.Sprintf("stream-%d", i)
+					if i%2 == 0 {
+						f.AddString(key)
+					} else {
+						f.Add([]byte(key))
+					}
+					if !f.TestString(key) || !f.Test([]byte(key)) {
+						t.Fatalf("false negative for %q just after adding it", key)
+					}
+				}
+
+				if f.TestAndAdd([]byte("new-key")) || !f.TestAndAdd([]byte("new-key")) {
+					t.Error("TestAndAdd did not report a new key, then a repeated one")
+				}
+				if f.TestAndAddString("new-string") || !f.TestAndAddString("new-string") {
+					t.Error("TestAndAddString did not report a new key, then a repeated one")
+				}
+
+				if f.Count() != 10_004 || f.Cap() != 64*BlockBits || f.NumBlocks() != 64 {
+					t.Errorf("got Count() = %d, Cap() = %d, NumBlocks() = %d", f.Count(), f.Cap(), f.NumBlocks())
+				}
+				if f.K() != 4 || f.CounterBits() != counterBits || f.Decrements() != 20 {
+					t.Errorf("got K() = %d, CounterBits() = %d, Decrements() = %d", f.K(), f.CounterBits(), f.Decrements())
+				}
+
+				// The zero fraction settles at a fixed value rather than falling
+				// to 0, so keys never added are mostly absent
+				if z := f.ZeroFraction(); z < 0.2 || z > 0.9 {
+					t.Errorf("ZeroFraction() = %f after 10000 keys", z)
+				}
+				if est := f.EstimatedFalsePositiveRate(); est <= 0 || est >= 0.5 {
+					t.Errorf("EstimatedFalsePositiveRate() = %g", est)
+				}
+			})
+		}
+	}
+}
+
+func TestStableWithParamsFallback(t *testing.T) {
+	for name, f := range map[string]stableTester{
+		"StableFilter":       NewStableWithParams(0, 0, 3, 0),
+		"AtomicStableFilter": NewAtomicStableWithParams(0, 0, 3, 0),
+	} {
+		if f.NumBlocks() != 1 || f.K() != 3 || f.CounterBits() != 2 || f.Decrements() != 1 {
+			t.Errorf("%s: got NumBlocks() = %d, K() = %d, CounterBits() = %d, Decrements() = %d",
+				name, f.NumBlocks(), f.K(), f.CounterBits(), f.Decrements())
+		}
+	}
+
+	// 1-bit counters leave room for more partitions than 2-bit ones
+	if f := NewStableWithParams(1, MaxKForBlockBits(512), 1, 1); f.K() != MaxKForBlockBits(512) {
+		t.Errorf("1-bit counters: K() = %d", f.K())
+	}
+	if f := NewStableWithParams(1, MaxKForBlockBits(512), 2, 1); f.K() != 3 {
+		t.Errorf("2-bit counters: K() = %d", f.K())
+	}
+
+	for _, bits := range []uint32{0, 1, 2, 3, 8} {
+		if got := ValidStableCounterBits(bits); got != (bits == 1 || bits == 2) {
+			t.Errorf("ValidStableCounterBits(%d) = %v", bits, got)
+		}
+	}
+}
+
+func TestStableDecrements(t *testing.T) {
+	for _, counterBits := range []uint32{1, 2} {
+		for k := uint32(1); k <= stableMaxK; k++ {
+			for _, fpRate := range []float64{0.1, 0.01, 1e-4, 1e-6} {
+				// The fewest decrements that reach the target
+				p := stableDecrements(k, counterBits, fpRate)
+				if est := EstimateStableFalsePositiveRate(k, counterBits, p); est > fpRate {
+					t.Errorf("k=%d bits=%d fpRate=%g: %d decrements give %g", k, counterBits, fpRate, p, est)
+				}
+				if p > 1 {
+					if est := EstimateStableFalsePositiveRate(k, counterBits, p-1); est <= fpRate {
+						t.Errorf("k=%d bits=%d fpRate=%g: %d decrements already give %g", k, counterBits, fpRate, p-1, est)
+					}
+				}
+			}
+		}
+	}
+
+	// A high target needs at least one decrement
+	if p := stableDecrements(1, 1, 0.99); p != 1 {
+		t.Errorf("stableDecrements for 0.99 = %d", p)
+	}
+}
+
+func TestOptimalStableParams(t *testing.T) {
+	p := OptimalStableParams(1<<20, 0.01, 0.01)
+	if p.NumBlocks != 1<<20/64 || p.FalsePositiveRate > 0.01 {
+		t.Errorf("got %+v", p)
+	}
+	if p.FalsePositiveRate != EstimateStableFalsePositiveRate(p.K, p.CounterBits, p.Decrements) {
+		t.Errorf("FalsePositiveRate = %g does not match the estimate", p.FalsePositiveRate)
+	}
+
+	// Out of range rates fall back
+	if q := OptimalStableParams(1<<20, 0, -1); q != p {
+		t.Errorf("default rates: got %+v, want %+v", q, p)
+	}
+	if q := OptimalStableParams(1<<20, 2, 2); q != OptimalStableParams(1<<20, 0.99, 0.99) {
+		t.Errorf("rates above 1: got %+v", q)
+	}
+
+	// A larger tolerance, or more blocks, remember keys for longer
+	if q := OptimalStableParams(1<<20, 0.01, 0.1); q.Window <= p.Window {
+		t.Errorf("fnRate 0.1: window %d, fnRate 0.01: window %d", q.Window, p.Window)
+	}
+	if q := OptimalStableParams(1<<24, 0.01, 0.01); q.Window < p.Window*15 {
+		t.Errorf("16x the memory: window %d, was %d", q.Window, p.Window)
+	}
+	if q := OptimalStableParams(0, 0.01, 0.01); q.NumBlocks != 1 {
+		t.Errorf("no memory: NumBlocks = %d", q.NumBlocks)
+	}
+
+	// A tolerance above the stable forgetting rate is never exceeded
+	if q := OptimalStableParams(1<<20, 0.99, 0.99); q.Window != math.MaxUint64 {
+		t.Errorf("fnRate 0.99: window %d", q.Window)
+	}
+
+	// The constructors use the same parameters
+	f, af := NewStable(1<<20, 0.01, 0.01), NewAtomicStable(1<<20, 0.01, 0.01)
+	for _, g := range []stableTester{f, af} {
+		if g.NumBlocks() != p.NumBlocks || g.K() != p.K || g.CounterBits() != p.CounterBits || g.Decrements() != p.Decrements {
+			t.Errorf("constructor parameters (%d, %d, %d, %d) differ from %+v", g.NumBlocks(), g.K(), g.CounterBits(), g.Decrements(), p)
+		}
+	}
+}
+
+func TestStableForgetRate(t *testing.T) {
+	curve := stableForgetCurve(4, 2, 20, 0.01)
+	if curve == nil || curve[0] != 0 {
+		t.Fatalf("got curve %v", curve[:min(len(curve), 4)])
+	}
+	if r := stableForgetRate(curve, 0); r != 0 {
+		t.Errorf("stableForgetRate at 0 = %g", r)
+	}
+
+	// The rate rises with the number of inserts, and past the end of the
+	// curve it stays at its last value
+	prev := 0.0
+	for _, lambda := range []float64{1, 10, 100, 1000} {
+		r := stableForgetRate(curve, lambda)
+		if r < prev {
+			t.Errorf("stableForgetRate at %g = %g, below %g", lambda, r, prev)
+		}
+		prev = r
+	}
+	if r := stableForgetRate(curve, 1e9); math.Abs(r-curve[len(curve)-1]) > 1e-9 {
+		t.Errorf("stableForgetRate past the curve = %g, last value %g", r, curve[len(curve)-1])
+	}
+
+	if w := stableWindow(nil, 0.01); !math.IsInf(w, 1) {
+		t.Errorf("stableWindow(nil) = %g", w)
+	}
+	if w := stableWindow(curve, 0.01); stableForgetRate(curve, w) > 0.01 {
+		t.Errorf("stableWindow = %g exceeds the tolerance", w)
+	}
+}
+
+func TestAtomicStableFilterConcurrent(t *testing.T) {
+	f := NewAtomicStableWithParams(64, 4, 2, 20)
+
+	const numGoroutines = 8
+	const itemsPerGoroutine = 10000
+
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+
+	for g := range numGoroutines {
+		go func(goroutineID int) {
+			defer wg.Done()
+			for i := range itemsPerGoroutine {
+				key := fmt.Sprintf("g%d-item-%d", goroutineID, i)
+				if i%2 == 0 {
+					f.AddString(key)
+				} else {
+					f.TestAndAddString(key)
+				}
+				f.TestString(key)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	expectedCount := uint64(numGoroutines * itemsPerGoroutine)
+	if f.Count() != expectedCount {
+		t.Errorf("expected count %d, got %d", expectedCount, f.Count())
+	}
+
+	// Concurrent adds settle at the same zero fraction as sequential ones
+	s := NewStableWithParams(64, 4, 2, 20)
+	for i := range numGoroutines * itemsPerGoroutine {
+		s.AddString(fmt.Sprintf("item-%d", i))
+	}
+	if z, want := f.ZeroFraction(), s.ZeroFraction(); math.Abs(z-want) > 0.05 {
+		t.Errorf("ZeroFraction() = %f, sequential %f", z, want)
+	}
+}
+
+func TestStableFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	// After many times more keys than cells, the rate matches the estimate
+	for _, fpRate := range []float64{0.05, 0.01} {
+		for _, counterBits := range []uint32{1, 2} {
+			t.Run(fmt.Sprintf("fpRate_%g/bits_%d", fpRate, counterBits), func(t *testing.T) {
+				k := uint32(4)
+				f := NewStableWithParams(256, k, counterBits, stableDecrements(k, counterBits, fpRate))
+				for i := range 2_000_000 {
+					f.AddString(fmt.Sprintf("stream-%d", i))
+				}
+				m := MeasureFalsePositiveRate(f, 200_000)
+				est := f.EstimatedFalsePositiveRate()
+				if m.Rate < est*0.8 || m.Rate > est*1.2 {
+					t.Errorf("measured %g [%g, %g], estimated %g", m.Rate, m.Lower, m.Upper, est)
+				}
+			})
+		}
+	}
+}
+
+func TestStableWindow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	// Keys added Window inserts ago are forgotten about fnRate of the time
+	for _, fnRate := range []float64{0.01, 0.1} {
+		t.Run(fmt.Sprintf("fnRate_%g", fnRate), func(t *testing.T) {
+			p := OptimalStableParams(1<<24, 0.01, fnRate)
+			f := NewStableWithParams(p.NumBlocks, p.K, p.CounterBits, p.Decrements)
+			n := int(p.Window) * 2
+			for i := range n {
+				f.AddString(fmt.Sprintf("stream-%d", i))
+			}
+
+			// Probe keys within 5% of the window's age
+			var missing int
+			last, band := n-1-int(p.Window), int(p.Window)/20
+			for i := last - band; i < last+band; i++ {
+				if !f.TestString(fmt.Sprintf("stream-%d", i)) {
+					missing++
+				}
+			}
+			probes := float64(2 * band)
+			if rate := float64(missing) / probes; rate < fnRate*0.7 || rate > fnRate*1.3 {
+				t.Errorf("window %d: forgot %g of keys, tolerance %g", p.Window, rate, fnRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package gloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"math"
+	"slices"
+)
+
+const (
+	// serializeStaticVersion is the serialization format version of a
+	// StaticFilter.
+	serializeStaticVersion byte = 5
+
+	// staticHeaderSize is the size of the StaticFilter serialization header
+	// in bytes: Version (1) + FingerprintBits (4) + Seed (8) +
+	// SegmentLength (4) + SegmentCount (4) + Count (8) = 29 bytes.
+	staticHeaderSize = 29
+)
+
+// StaticFilter is an immutable binary fuse filter, built once from a known
+// set of keys. It needs about 9 bits per key with 8-bit fingerprints and 18
+// with 16-bit fingerprints, for false positive rates of 1/256 and 1/65536,
+// where a bloom filter needs about 44% more memory. Keys cannot be added
+// after construction. It is safe for concurrent use, since it is never
+// modified.
+//
+// Each key maps to three fingerprint slots in three consecutive segments of
+// the array, and construction chooses the slot contents so that the XOR of a
+// key's three slots equals its fingerprint. The slots are in different cache
+// lines, so a lookup makes three memory accesses rather than one.
+type StaticFilter struct {
+	fuseLayout
+	fingerprints []byte // 1 or 2 bytes per slot (little-endian)
+	fpBits       uint32 // Bits per fingerprint: 8 or 16
+	count        uint64 // Number of distinct keys
+}
+
+// BuildStatic builds a StaticFilter from keys with fingerprints of
+// fingerprintBits bits, which must be 8 or 16. An unsupported fingerprint
+// size falls back to 8.
+//
+// Duplicate keys are allowed and counted once. Construction retries with a
+// new seed when the keys' slots cannot be solved, and returns
+// ErrConstructionFailed if fuseMaxAttempts seeds all fail, which distinct
+// keys make vanishingly unlikely. The same keys always build the same
+// filter, whatever their order.
+func BuildStatic(keys [][]byte, fingerprintBits uint32) (*StaticFilter, error) {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hashRaw(key)
+	}
+	return buildStatic(hashes, fingerprintBits, fuseMaxAttempts)
+}
+
+// BuildStaticStrings builds a StaticFilter from string keys without
+// converting them to byte slices. See BuildStatic.
+func BuildStaticStrings(keys []string, fingerprintBits uint32) (*StaticFilter, error) {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = hashRawString(key)
+	}
+	return buildStatic(hashes, fingerprintBits, fuseMaxAttempts)
+}
+
+// BuildStaticSeq builds a StaticFilter from every key in keys. Keys are
+// hashed as they are yielded, so the sequence is consumed once and may reuse
+// the memory of a yielded key. See BuildStatic.
+func BuildStaticSeq(keys iter.Seq[[]byte], fingerprintBits uint32) (*StaticFilter, error) {
+	var hashes []uint64
+	for key := range keys {
+		hashes = append(hashes, hashRaw(key))
+	}
+	return buildStatic(hashes, fingerprintBits, fuseMaxAttempts)
+}
+
+// load returns the fingerprint in slot i.
+func (f *StaticFilter) load(i uint64) uint64 {
+	if f.fpBits == 8 {
+		return uint64(f.fingerprints[i])
+	}
+	return uint64(binary.LittleEndian.Uint16(f.fingerprints[2*i:]))
+}
+
+// store sets the fingerprint in slot i.
+func (f *StaticFilter) store(i, fp uint64) {
+	if f.fpBits == 8 {
+		f.fingerprints[i] = byte(fp)
+		return
+	}
+	binary.LittleEndian.PutUint16(f.fingerprints[2*i:], uint16(fp))
+}
+
+// buildStatic builds a StaticFilter from key hashes, which it may reorder,
+// trying at most maxAttempts seeds.
+func buildStatic(hashes []uint64, fingerprintBits uint32, maxAttempts int) (*StaticFilter, error) {
+	if fingerprintBits != 8 && fingerprintBits != 16 {
+		fingerprintBits = 8
+	}
+
+	// Duplicate keys would share all three slots and never peel
+	slices.Sort(hashes)
+	hashes = slices.Compact(hashes)
+
+	layout := newFuseLayout(uint64(len(hashes)))
+	order, positions, err := layout.peel(hashes, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &StaticFilter{
+		fuseLayout:   layout,
+		fingerprints: make([]byte, layout.numSlots()*uint64(fingerprintBits/8)),
+		fpBits:       fingerprintBits,
+		count:        uint64(len(hashes)),
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		h := f.mix(hashes[order[i]])
+		h0, h1, h2 := f.slots(h)
+		slots := [3]uint64{h0, h1, h2}
+		own := positions[i]
+		fp := fuseFingerprint(h) ^ f.load(slots[(own+1)%3]) ^ f.load(slots[(own+2)%3])
+		f.store(slots[own], fp)
+	}
+	return f, nil
+}
+
+// Test checks if data might be in the filter.
+// Returns true if the item might be present, false if definitely absent.
+func (f *StaticFilter) Test(data []byte) bool {
+	return f.testWithHash(hashRaw(data))
+}
+
+// TestString checks if a string might be in the filter without allocating.
+func (f *StaticFilter) TestString(s string) bool {
+	return f.testWithHash(hashRawString(s))
+}
+
+// testWithHash checks a key using its pre-computed raw hash.
+func (f *StaticFilter) testWithHash(h uint64) bool {
+	h = f.mix(h)
+	h0, h1, h2 := f.slots(h)
+	mask := uint64(1)<<f.fpBits - 1
+	return (fuseFingerprint(h)^f.load(h0)^f.load(h1)^f.load(h2))&mask == 0
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *StaticFilter) Cap() uint64 {
+	return uint64(len(f.fingerprints)) * 8
+}
+
+// Count returns the number of distinct keys the filter was built from.
+func (f *StaticFilter) Count() uint64 {
+	return f.count
+}
+
+// FingerprintBits returns the size of the filter's fingerprints in bits.
+func (f *StaticFilter) FingerprintBits() uint32 {
+	return f.fpBits
+}
+
+// BitsPerItem returns the filter's memory in bits per distinct key.
+func (f *StaticFilter) BitsPerItem() float64 {
+	if f.count == 0 {
+		return 0
+	}
+	return float64(f.Cap()) / float64(f.count)
+}
+
+// EstimatedFalsePositiveRate estimates the false positive rate of the
+// filter. An absent key's three slots XOR to a value unrelated to its
+// fingerprint, so it matches with probability 2^-f regardless of the number
+// of keys.
+func (f *StaticFilter) EstimatedFalsePositiveRate() float64 {
+	return math.Ldexp(1, -int(f.fpBits))
+}
+
+// MarshalBinary serializes the static filter to a byte slice.
+//
+// The serialized format is:
+//   - Version (1 byte): serialization format version, 5
+//   - FingerprintBits (4 bytes): 8 or 16 (little-endian uint32)
+//   - Seed (8 bytes): seed mixed into key hashes (little-endian uint64)
+//   - SegmentLength (4 bytes): slots per segment (little-endian uint32)
+//   - SegmentCount (4 bytes): number of starting segments (little-endian uint32)
+//   - Count (8 bytes): number of distinct keys (little-endian uint64)
+//   - Fingerprints ((segmentCount+2) * segmentLength slots): little-endian
+//     uint8s or uint16s
+func (f *StaticFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, staticHeaderSize+len(f.fingerprints))
+
+	buf[0] = serializeStaticVersion
+	binary.LittleEndian.PutUint32(buf[1:5], f.fpBits)
+	binary.LittleEndian.PutUint64(buf[5:13], f.seed)
+	binary.LittleEndian.PutUint32(buf[13:17], uint32(f.segmentLength))
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(f.segmentCount))
+	binary.LittleEndian.PutUint64(buf[21:29], f.count)
+	copy(buf[staticHeaderSize:], f.fingerprints)
+
+	return buf, nil
+}
+
+// UnmarshalStaticBinary deserializes a static filter from a byte slice
+// written by StaticFilter.MarshalBinary.
+//
+// If the data is invalid or corrupted, an error is returned.
+func UnmarshalStaticBinary(data []byte) (*StaticFilter, error) {
+	if len(data) < staticHeaderSize {
+		return nil, fmt.Errorf("%w: data too short (got %d bytes, need at least %d)", ErrInvalidData, len(data), staticHeaderSize)
+	}
+	if version := data[0]; version != serializeStaticVersion {
+		return nil, fmt.Errorf("%w: got version %d, expected %d", ErrUnsupportedVersion, version, serializeStaticVersion)
+	}
+
+	fingerprintBits := binary.LittleEndian.Uint32(data[1:5])
+	seed := binary.LittleEndian.Uint64(data[5:13])
+	segmentLength := uint64(binary.LittleEndian.Uint32(data[13:17]))
+	segmentCount := uint64(binary.LittleEndian.Uint32(data[17:21]))
+	count := binary.LittleEndian.Uint64(data[21:29])
+
+	if fingerprintBits != 8 && fingerprintBits != 16 {
+		return nil, fmt.Errorf("%w: fingerprint size %d is not supported (valid: 8, 16)", ErrInvalidData, fingerprintBits)
+	}
+	if err := validateFuseLayout(segmentLength, segmentCount); err != nil {
+		return nil, err
+	}
+	// Both fields are 32-bit, so the length cannot overflow
+	if expected := staticHeaderSize + (segmentCount+2)*segmentLength*uint64(fingerprintBits/8); uint64(len(data)) != expected {
+		return nil, fmt.Errorf("%w: data length mismatch (got %d bytes, expected %d)", ErrInvalidData, len(data), expected)
+	}
+
+	return &StaticFilter{
+		fuseLayout:   makeFuseLayout(seed, segmentLength, segmentCount),
+		fingerprints: slices.Clone(data[staticHeaderSize:]),
+		fpBits:       fingerprintBits,
+		count:        count,
+	}, nil
+}
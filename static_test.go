@@ -0,0 +1,287 @@
+package gloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestStaticFilterBasic(t *testing.T) {
+	for _, bits := range []uint32{8, 16} {
+		t.Run(fmt.Sprintf("bits_%d", bits), func(t *testing.T) {
+			keys := make([][]byte, 10000)
+			strs := make([]string, len(keys))
+			for i := range keys {
+				strs[i] = fmt.Sprintf("build-%d", i)
+				keys[i] = []byte(strs[i])
+			}
+
+			f, err := BuildStatic(keys, bits)
+			if err != nil {
+				t.Fatalf("BuildStatic failed: %v", err)
+			}
+			for _, key := range strs {
+				if !f.Test([]byte(key)) || !f.TestString(key) {
+					t.Fatalf("false negative for %q", key)
+				}
+			}
+
+			if f.Count() != 10000 || f.FingerprintBits() != bits {
+				t.Errorf("got Count() = %d, FingerprintBits() = %d", f.Count(), f.FingerprintBits())
+			}
+			if f.Cap() != uint64(len(f.fingerprints))*8 || f.BitsPerItem() != float64(f.Cap())/10000 {
+				t.Errorf("Cap() = %d, BitsPerItem() = %f", f.Cap(), f.BitsPerItem())
+			}
+			if f.BitsPerItem() > 1.3*float64(bits) {
+				t.Errorf("BitsPerItem() = %f for %d-bit fingerprints", f.BitsPerItem(), bits)
+			}
+			if fp := f.EstimatedFalsePositiveRate(); fp != math.Ldexp(1, -int(bits)) {
+				t.Errorf("EstimatedFalsePositiveRate() = %g", fp)
+			}
+
+			// Every constructor builds the same filter
+			fromStrings, err := BuildStaticStrings(strs, bits)
+			if err != nil {
+				t.Fatalf("BuildStaticStrings failed: %v", err)
+			}
+			fromSeq, err := BuildStaticSeq(keySeq(len(keys)), bits)
+			if err != nil {
+				t.Fatalf("BuildStaticSeq failed: %v", err)
+			}
+			for _, g := range []*StaticFilter{fromStrings, fromSeq} {
+				if g.seed != f.seed || !bytes.Equal(g.fingerprints, f.fingerprints) {
+					t.Error("constructors built different filters")
+				}
+			}
+		})
+	}
+}
+
+func TestStaticFilterDuplicates(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	f, err := BuildStaticStrings(keys, 16)
+	if err != nil {
+		t.Fatalf("BuildStaticStrings failed: %v", err)
+	}
+
+	// Repeated and reordered keys build the same filter
+	again := append(slices.Clone(keys), keys...)
+	slices.Reverse(again)
+	g, err := BuildStaticStrings(again, 16)
+	if err != nil {
+		t.Fatalf("BuildStaticStrings with duplicates failed: %v", err)
+	}
+	if g.Count() != uint64(len(keys)) {
+		t.Errorf("Count() = %d, want %d distinct keys", g.Count(), len(keys))
+	}
+	if g.seed != f.seed || !bytes.Equal(g.fingerprints, f.fingerprints) {
+		t.Error("duplicates or order changed the filter")
+	}
+}
+
+func TestStaticFilterSmall(t *testing.T) {
+	for n := range 20 {
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("small-%d", i)
+		}
+		f, err := BuildStaticStrings(keys, 8)
+		if err != nil {
+			t.Fatalf("n=%d: BuildStaticStrings failed: %v", n, err)
+		}
+		for _, key := range keys {
+			if !f.TestString(key) {
+				t.Fatalf("n=%d: false negative for %q", n, key)
+			}
+		}
+	}
+
+	f, err := BuildStatic(nil, 8)
+	if err != nil {
+		t.Fatalf("BuildStatic(nil) failed: %v", err)
+	}
+	if f.Count() != 0 || f.BitsPerItem() != 0 {
+		t.Errorf("empty filter: Count() = %d, BitsPerItem() = %f", f.Count(), f.BitsPerItem())
+	}
+}
+
+func TestStaticFilterInvalidBits(t *testing.T) {
+	for _, bits := range []uint32{0, 4, 12, 32} {
+		f, err := BuildStaticStrings([]string{"a", "b"}, bits)
+		if err != nil {
+			t.Fatalf("bits=%d: BuildStaticStrings failed: %v", bits, err)
+		}
+		if f.FingerprintBits() != 8 {
+			t.Errorf("bits=%d: FingerprintBits() = %d, want 8", bits, f.FingerprintBits())
+		}
+	}
+}
+
+func TestStaticFilterRetry(t *testing.T) {
+	// About 1 in 200 sets of three keys cannot be solved with the first seed
+	var hashes []uint64
+	for trial := 0; hashes == nil; trial++ {
+		candidate := make([]uint64, 3)
+		for i := range candidate {
+			candidate[i] = hashRawString(fmt.Sprintf("retry-%d-%d", trial, i))
+		}
+		if _, err := buildStatic(slices.Clone(candidate), 8, 1); err != nil {
+			if !errors.Is(err, ErrConstructionFailed) {
+				t.Fatalf("expected ErrConstructionFailed, got %v", err)
+			}
+			hashes = candidate
+		}
+	}
+
+	// More seeds succeed
+	f, err := buildStatic(hashes, 8, fuseMaxAttempts)
+	if err != nil {
+		t.Fatalf("retries failed: %v", err)
+	}
+	state := uint64(fuseSeed)
+	if f.seed == splitmix64(&state) {
+		t.Error("filter built with the seed that failed")
+	}
+	for _, h := range hashes {
+		if !f.testWithHash(h) {
+			t.Fatalf("false negative for hash %#x", h)
+		}
+	}
+}
+
+func TestStaticFilterSerialize(t *testing.T) {
+	for _, bits := range []uint32{8, 16} {
+		keys := make([]string, 5000)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("item-%d", i)
+		}
+		original, err := BuildStaticStrings(keys, bits)
+		if err != nil {
+			t.Fatalf("BuildStaticStrings failed: %v", err)
+		}
+
+		data, err := original.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		if data[0] != serializeStaticVersion || len(data) != staticHeaderSize+len(original.fingerprints) {
+			t.Errorf("got version %d and %d bytes", data[0], len(data))
+		}
+
+		restored, err := UnmarshalStaticBinary(data)
+		if err != nil {
+			t.Fatalf("UnmarshalStaticBinary failed: %v", err)
+		}
+		if restored.FingerprintBits() != bits || restored.Count() != 5000 || restored.seed != original.seed {
+			t.Errorf("params mismatch: got (%d, %d, %#x)", restored.FingerprintBits(), restored.Count(), restored.seed)
+		}
+		for _, key := range keys {
+			if !restored.TestString(key) {
+				t.Fatalf("false negative for %q", key)
+			}
+		}
+
+		// The restored filter owns its memory
+		data[staticHeaderSize] ^= 0xff
+		if restored.fingerprints[0] != original.fingerprints[0] {
+			t.Error("restored filter aliases the serialized data")
+		}
+		data[staticHeaderSize] ^= 0xff
+
+		again, err := restored.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Error("second roundtrip produced different bytes")
+		}
+	}
+
+	// The formats of Filter and StaticFilter are not interchangeable
+	data, _ := mustBuildStatic(t).MarshalBinary()
+	if _, err := UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalBinary of a StaticFilter: expected ErrUnsupportedVersion, got %v", err)
+	}
+	filterData, _ := NewWithParams(20, 7).MarshalBinary()
+	if _, err := UnmarshalStaticBinary(filterData); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("UnmarshalStaticBinary of a Filter: expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+// mustBuildStatic builds a small 16-bit StaticFilter.
+func mustBuildStatic(t *testing.T) *StaticFilter {
+	t.Helper()
+	f, err := BuildStatic(slices.Collect(keySeq(100)), 16)
+	if err != nil {
+		t.Fatalf("BuildStatic failed: %v", err)
+	}
+	return f
+}
+
+func TestStaticFilterSerializeInvalid(t *testing.T) {
+	data, err := mustBuildStatic(t).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	corrupt := func(fn func([]byte)) []byte {
+		bad := bytes.Clone(data)
+		fn(bad)
+		return bad
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:staticHeaderSize-1]},
+		{"truncated fingerprints", data[:len(data)-1]},
+		{"extra bytes", append(bytes.Clone(data), 0)},
+		{"fingerprint size", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], 12) })},
+		{"8-bit length", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[1:5], 8) })},
+		{"zero segment length", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[13:17], 0) })},
+		{"segment length not power of 2", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[13:17], 48) })},
+		{"segment length too large", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[13:17], fuseMaxSegmentLength*2) })},
+		{"zero segments", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[17:21], 0) })},
+		{"too many segments", corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b[17:21], math.MaxUint32) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnmarshalStaticBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("expected ErrInvalidData, got %v", err)
+			}
+		})
+	}
+
+	bad := corrupt(func(b []byte) { b[0] = 99 })
+	if _, err := UnmarshalStaticBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestStaticFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, bits := range []uint32{8, 16} {
+		t.Run(fmt.Sprintf("bits_%d", bits), func(t *testing.T) {
+			f, err := BuildStaticSeq(keySeq(1_000_000), bits)
+			if err != nil {
+				t.Fatalf("BuildStaticSeq failed: %v", err)
+			}
+			if f.BitsPerItem() > 1.15*float64(bits) {
+				t.Errorf("BitsPerItem() = %f for %d-bit fingerprints", f.BitsPerItem(), bits)
+			}
+
+			m := MeasureFalsePositiveRate(f, 4_000_000)
+			if est := f.EstimatedFalsePositiveRate(); !m.Contains(est) {
+				t.Errorf("measured %g [%g, %g], estimated %g", m.Rate, m.Lower, m.Upper, est)
+			}
+		})
+	}
+}
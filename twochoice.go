@@ -0,0 +1,274 @@
+package gloom
+
+import "math"
+
+// TwoChoiceFilter is a blocked bloom filter that gives each key two
+// candidate blocks and adds it to the less full one. It is NOT safe for
+// concurrent use.
+//
+// A Filter's false positive rate is driven by its overloaded blocks: block
+// loads follow a Poisson distribution, and the fullest blocks match far more
+// often than average. Choosing the emptier of two blocks ("the power of two
+// choices") keeps every block close to the mean load, at the cost of Test
+// probing two blocks instead of one, which doubles the chance of a match.
+//
+// The balance pays off at low false positive rates, where overloaded blocks
+// dominate: below about 1e-4 a TwoChoiceFilter needs less memory than a
+// Filter with 512-bit blocks. A Filter with 1024-bit blocks, as
+// OptimalBlockParams chooses at those rates, also spreads its load, and its
+// two cache lines are adjacent rather than random. At the same memory it is
+// more accurate down to about 30 bits per key, where both measure about
+// 4.7e-6 with 1M keys, and less accurate below: at 20 bits per key it
+// measures 1.4e-4 against the TwoChoiceFilter's 2.0e-4, and at 40 bits per
+// key 3.2e-7 against 2.2e-7.
+type TwoChoiceFilter struct {
+	base  *Filter  // Blocks, probes, and counters, indexed as in Filter
+	loads []uint16 // Number of bits set in each block
+}
+
+// NewTwoChoice creates a new two-choice bloom filter optimized for the
+// expected number of items and desired false positive rate.
+func NewTwoChoice(expectedItems uint64, fpRate float64) *TwoChoiceFilter {
+	numBlocks, k, _ := OptimalTwoChoiceParams(expectedItems, fpRate)
+	return NewTwoChoiceWithParams(numBlocks, k)
+}
+
+// NewTwoChoiceWithParams creates a new two-choice bloom filter with explicit
+// parameters. numBlocks is the number of 512-bit blocks, k is the number of
+// hash functions, from MinK to MaxK. An unsupported k falls back to 7.
+func NewTwoChoiceWithParams(numBlocks uint64, k uint32) *TwoChoiceFilter {
+	base := NewWithParams(numBlocks, k)
+	return &TwoChoiceFilter{
+		base:  base,
+		loads: make([]uint16, base.numBlocks),
+	}
+}
+
+// twoChoiceBlocks returns the two candidate blocks and the intra-block hash
+// for a key's hash. The first block and the intra-block hash are the same as
+// in Filter; the second comes from remixing the whole hash, so it is
+// independent of both.
+func twoChoiceBlocks(h, numBlocks uint64) (first, second uint64, intraHash uint32) {
+	first, intraHash = hashSplit(h, numBlocks)
+	second = (mix64(h) >> 32) % numBlocks
+	return first, second, intraHash
+}
+
+// Add adds data to the filter.
+func (f *TwoChoiceFilter) Add(data []byte) {
+	f.addWithHash(hashRaw(data))
+}
+
+// AddString adds a string to the filter without allocating.
+func (f *TwoChoiceFilter) AddString(s string) {
+	f.addWithHash(hashRawString(s))
+}
+
+// addWithHash adds a key to the less full of its candidate blocks, using a
+// pre-computed hash. Keys that already test positive set no bits, so adding
+// a key again cannot spread it across both blocks.
+func (f *TwoChoiceFilter) addWithHash(h uint64) {
+	first, second, intraHash := twoChoiceBlocks(h, f.base.numBlocks)
+	f.base.count++
+
+	if f.base.testWithHash(first, intraHash) || f.base.testWithHash(second, intraHash) {
+		return
+	}
+
+	blockIdx := first
+	if f.loads[second] < f.loads[first] {
+		blockIdx = second
+	}
+	flipped := f.base.setProbeBits(blockIdx, intraHash)
+	f.loads[blockIdx] += uint16(flipped)
+	f.base.setBits += flipped
+}
+
+// Test checks if data might be in the filter.
+// Returns true if the item might be present, false if definitely absent.
+func (f *TwoChoiceFilter) Test(data []byte) bool {
+	return f.testWithHash(hashRaw(data))
+}
+
+// TestString checks if a string might be in the filter without allocating.
+func (f *TwoChoiceFilter) TestString(s string) bool {
+	return f.testWithHash(hashRawString(s))
+}
+
+// testWithHash checks both candidate blocks using a pre-computed hash.
+func (f *TwoChoiceFilter) testWithHash(h uint64) bool {
+	first, second, intraHash := twoChoiceBlocks(h, f.base.numBlocks)
+	return f.base.testWithHash(first, intraHash) || f.base.testWithHash(second, intraHash)
+}
+
+// Cap returns the capacity of the filter in bits.
+func (f *TwoChoiceFilter) Cap() uint64 {
+	return f.base.Cap()
+}
+
+// K returns the number of hash functions (partitions) used.
+func (f *TwoChoiceFilter) K() uint32 {
+	return f.base.k
+}
+
+// Count returns the number of items added to the filter.
+func (f *TwoChoiceFilter) Count() uint64 {
+	return f.base.count
+}
+
+// NumBlocks returns the number of 512-bit blocks in the filter.
+func (f *TwoChoiceFilter) NumBlocks() uint64 {
+	return f.base.numBlocks
+}
+
+// EstimatedFillRatio returns the proportion of bits that are set.
+// It runs in constant time using a set-bit count maintained by Add.
+func (f *TwoChoiceFilter) EstimatedFillRatio() float64 {
+	return f.base.EstimatedFillRatio()
+}
+
+// ExactFillRatio computes the proportion of bits that are set by counting
+// every bit in the filter.
+func (f *TwoChoiceFilter) ExactFillRatio() float64 {
+	return f.base.ExactFillRatio()
+}
+
+// EstimatedFalsePositiveRate estimates the current false positive rate
+// based on the number of items added.
+func (f *TwoChoiceFilter) EstimatedFalsePositiveRate() float64 {
+	return EstimateTwoChoiceFalsePositiveRate(f.base.numBlocks, f.base.k, f.base.count)
+}
+
+// OptimalTwoChoiceParams calculates the parameters of a TwoChoiceFilter for
+// the expected number of items and desired false positive rate. Returns the
+// number of 512-bit blocks, the number of hash functions (k), and bits per
+// item.
+func OptimalTwoChoiceParams(expectedItems uint64, fpRate float64) (numBlocks uint64, k uint32, bitsPerItem float64) {
+	expectedItems, fpRate = optimalInputs(expectedItems, fpRate)
+
+	// Binary search for the fewest blocks whose best k reaches fpRate. An
+	// unblocked filter is a lower bound, and four times its size always
+	// suffices for the rates reachable with 512-bit blocks
+	n := float64(expectedItems)
+	bits := n * -math.Log(fpRate) / ln2Squared
+	lo := max(uint64(bits/BlockBits), 1)
+	hi := max(uint64(math.Ceil(4*bits/BlockBits)), lo)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, fp := bestTwoChoiceK(mid, expectedItems); fp <= fpRate {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	numBlocks = lo
+	k, _ = bestTwoChoiceK(numBlocks, expectedItems)
+	return numBlocks, k, float64(numBlocks*BlockBits) / n
+}
+
+// bestTwoChoiceK returns the k with the lowest estimated false positive rate
+// for a TwoChoiceFilter of numBlocks blocks holding items, and that rate.
+// Without overloaded blocks, larger k help more than in a Filter, so every
+// supported k is considered.
+func bestTwoChoiceK(numBlocks, items uint64) (bestK uint32, bestFP float64) {
+	lambda := float64(items) / float64(numBlocks)
+	loads := twoChoiceLoads(lambda)
+	bestFP = math.Inf(1)
+	for k := uint32(MinK); k <= MaxK; k++ {
+		if fp := twoChoiceFP(numBlocks, GetPrimePartition(k), lambda, loads); fp < bestFP {
+			bestK, bestFP = k, fp
+		}
+	}
+	return bestK, bestFP
+}
+
+// EstimateTwoChoiceFalsePositiveRate estimates the false positive rate of a
+// TwoChoiceFilter with the given parameters.
+//
+// Block loads no longer follow a Poisson distribution. In the limit of many
+// blocks, the fraction sᵢ of blocks holding at least i items after t items
+// per block evolves as
+//
+//	dsᵢ/dt = sᵢ₋₁² - sᵢ²
+//
+// since an item lands in a block with i-1 items exactly when both of its
+// choices hold at least i-1 items and not both hold at least i. Integrating
+// this to t = n/B gives the load distribution, which is concentrated within a
+// few items of the mean. A query matches if either of its two blocks does:
+//
+//	FP = 1 - (1 - E[∏ᵢ (1 - (1 - 1/pᵢ)^J)])²
+func EstimateTwoChoiceFalsePositiveRate(numBlocks uint64, k uint32, itemsAdded uint64) float64 {
+	primes := GetPrimePartition(k)
+	if numBlocks == 0 || itemsAdded == 0 || primes == nil {
+		return 0
+	}
+	lambda := float64(itemsAdded) / float64(numBlocks)
+	return twoChoiceFP(numBlocks, primes, lambda, twoChoiceLoads(lambda))
+}
+
+// twoChoiceFP returns the false positive rate of a query against two blocks
+// whose loads follow the distribution loads, for lambda items per block.
+func twoChoiceFP(numBlocks uint64, primes []uint32, lambda float64, loads []float64) float64 {
+	if numBlocks == 1 {
+		// Both choices are the same block, which holds every item
+		return partitionedBlockFP(primes, lambda)
+	}
+
+	var blockFP float64
+	for j, p := range loads {
+		if j > 0 && p > 0 {
+			blockFP += p * partitionedBlockFP(primes, float64(j))
+		}
+	}
+	return 1 - (1-blockFP)*(1-blockFP)
+}
+
+// twoChoiceLoads returns the fraction of blocks holding each number of items
+// when lambda items per block have been added to the less full of two
+// random blocks, by integrating the fluid limit described in
+// EstimateTwoChoiceFalsePositiveRate with the classical Runge-Kutta method.
+func twoChoiceLoads(lambda float64) []float64 {
+	// The tail above the mean falls off doubly exponentially, so a few items
+	// of headroom are enough
+	levels := int(lambda) + 16
+	s := make([]float64, levels+1)
+	s[0] = 1
+
+	deriv := func(s, out []float64) {
+		for i := 1; i <= levels; i++ {
+			out[i] = s[i-1]*s[i-1] - s[i]*s[i]
+		}
+	}
+
+	steps := int(math.Ceil(lambda * 10))
+	dt := lambda / float64(steps)
+	k1, k2, k3, k4, tmp := make([]float64, levels+1), make([]float64, levels+1),
+		make([]float64, levels+1), make([]float64, levels+1), make([]float64, levels+1)
+	tmp[0] = 1
+	for range steps {
+		deriv(s, k1)
+		for i := 1; i <= levels; i++ {
+			tmp[i] = s[i] + dt/2*k1[i]
+		}
+		deriv(tmp, k2)
+		for i := 1; i <= levels; i++ {
+			tmp[i] = s[i] + dt/2*k2[i]
+		}
+		deriv(tmp, k3)
+		for i := 1; i <= levels; i++ {
+			tmp[i] = s[i] + dt*k3[i]
+		}
+		deriv(tmp, k4)
+		for i := 1; i <= levels; i++ {
+			s[i] += dt / 6 * (k1[i] + 2*k2[i] + 2*k3[i] + k4[i])
+		}
+	}
+
+	loads := make([]float64, levels+1)
+	for i := range levels {
+		loads[i] = max(s[i]-s[i+1], 0)
+	}
+	loads[levels] = max(s[levels], 0)
+	return loads
+}
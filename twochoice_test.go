@@ -0,0 +1,237 @@
+package gloom
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+func TestTwoChoiceFilterBasic(t *testing.T) {
+	f := NewTwoChoice(10000, 0.001)
+
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if i%2 == 0 {
+			f.Add([]byte(key))
+		} else {
+			f.AddString(key)
+		}
+	}
+	for i := range 10000 {
+		key := fmt.Sprintf("item-%d", i)
+		if !f.Test([]byte(key)) || !f.TestString(key) {
+			t.Fatalf("false negative for %q", key)
+		}
+	}
+
+	if f.Count() != 10000 {
+		t.Errorf("Count() = %d, want 10000", f.Count())
+	}
+	if f.Cap() != f.NumBlocks()*BlockBits {
+		t.Errorf("Cap() = %d, want %d", f.Cap(), f.NumBlocks()*BlockBits)
+	}
+	if f.K() < MinK || f.K() > MaxK {
+		t.Errorf("K() = %d out of range", f.K())
+	}
+	if f.EstimatedFillRatio() != f.ExactFillRatio() {
+		t.Errorf("EstimatedFillRatio %f != ExactFillRatio %f", f.EstimatedFillRatio(), f.ExactFillRatio())
+	}
+	if fp := f.EstimatedFalsePositiveRate(); fp <= 0 || fp > 0.001 {
+		t.Errorf("EstimatedFalsePositiveRate() = %g, want at most 0.001", fp)
+	}
+}
+
+func TestTwoChoiceFilterLoads(t *testing.T) {
+	f := NewTwoChoiceWithParams(200, 7)
+	g := NewWithParams(200, 7)
+	for i := range 10000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+		g.AddString(fmt.Sprintf("item-%d", i))
+	}
+
+	// The tracked loads are the popcount of each block
+	var maxLoad, maxSingle int
+	for i := range f.NumBlocks() {
+		var load, single int
+		for w := range uint64(BlockWords) {
+			load += bits.OnesCount64(f.base.blocks[i*BlockWords+w])
+			single += bits.OnesCount64(g.blocks[i*BlockWords+w])
+		}
+		if int(f.loads[i]) != load {
+			t.Fatalf("block %d: tracked load %d, popcount %d", i, f.loads[i], load)
+		}
+		maxLoad, maxSingle = max(maxLoad, load), max(maxSingle, single)
+	}
+
+	// Choosing the emptier block keeps the fullest one well below a Filter's
+	if maxLoad >= maxSingle {
+		t.Errorf("fullest block has %d bits set, Filter's has %d", maxLoad, maxSingle)
+	}
+}
+
+func TestTwoChoiceFilterIdempotent(t *testing.T) {
+	f := NewTwoChoiceWithParams(100, 7)
+	for i := range 1000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+	before := f.ExactFillRatio()
+
+	// Re-adding keys must not spread them into their other block
+	for i := range 1000 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+	if f.ExactFillRatio() != before {
+		t.Errorf("fill ratio changed from %f to %f on re-adding keys", before, f.ExactFillRatio())
+	}
+	if f.Count() != 2000 {
+		t.Errorf("Count() = %d, want 2000", f.Count())
+	}
+}
+
+func TestTwoChoiceFilterSingleBlock(t *testing.T) {
+	// With one block both choices coincide
+	f := NewTwoChoiceWithParams(0, 0)
+	if f.NumBlocks() != 1 || f.K() != 7 {
+		t.Errorf("got (%d, %d), want (1, 7)", f.NumBlocks(), f.K())
+	}
+	for i := range 20 {
+		f.AddString(fmt.Sprintf("item-%d", i))
+	}
+	for i := range 20 {
+		if !f.TestString(fmt.Sprintf("item-%d", i)) {
+			t.Fatalf("false negative for item-%d", i)
+		}
+	}
+	if got, want := f.EstimatedFalsePositiveRate(), partitionedBlockFP(GetPrimePartition(7), 20); got != want {
+		t.Errorf("single block estimate %g, want %g", got, want)
+	}
+}
+
+func TestTwoChoiceLoads(t *testing.T) {
+	for _, lambda := range []float64{0.5, 1, 10, 50, 200} {
+		loads := twoChoiceLoads(lambda)
+		var total, mean float64
+		for j, p := range loads {
+			total += p
+			mean += float64(j) * p
+		}
+		if math.Abs(total-1) > 1e-9 {
+			t.Errorf("lambda=%g: probabilities sum to %g", lambda, total)
+		}
+		if math.Abs(mean-lambda) > 1e-6*max(lambda, 1) {
+			t.Errorf("lambda=%g: mean load %g", lambda, mean)
+		}
+
+		// Nearly every block is within a few items of the mean
+		var near float64
+		for j, p := range loads {
+			if math.Abs(float64(j)-lambda) <= 3 {
+				near += p
+			}
+		}
+		if near < 0.99 {
+			t.Errorf("lambda=%g: only %g of blocks within 3 of the mean", lambda, near)
+		}
+	}
+}
+
+func TestOptimalTwoChoiceParams(t *testing.T) {
+	for _, fpRate := range []float64{0.1, 0.01, 0.001, 1e-4, 1e-6} {
+		numBlocks, k, bitsPerItem := OptimalTwoChoiceParams(100000, fpRate)
+		if fp := EstimateTwoChoiceFalsePositiveRate(numBlocks, k, 100000); fp > fpRate {
+			t.Errorf("fpRate=%g: estimate %g misses target", fpRate, fp)
+		}
+		if fp := EstimateTwoChoiceFalsePositiveRate(numBlocks-1, k, 100000); fp <= fpRate {
+			t.Errorf("fpRate=%g: one block fewer also reaches target", fpRate)
+		}
+		if bitsPerItem != float64(numBlocks*BlockBits)/100000 {
+			t.Errorf("fpRate=%g: bitsPerItem %f inconsistent with %d blocks", fpRate, bitsPerItem, numBlocks)
+		}
+	}
+
+	// At low rates, balanced blocks need less memory than Poisson ones
+	twoChoice, _, _ := OptimalTwoChoiceParams(100000, 1e-6)
+	if fp := EstimateFalsePositiveRate(twoChoice, 13, 100000); fp <= 1e-6 {
+		t.Errorf("single-block filter of the same size reaches 1e-6 (%g)", fp)
+	}
+}
+
+func TestOptimalTwoChoiceParamsEdgeCases(t *testing.T) {
+	for _, tc := range []struct {
+		items  uint64
+		fpRate float64
+	}{
+		{0, 0.01},
+		{1000, 0},
+		{1000, -1},
+		{1000, 1},
+		{1000, 1.5},
+	} {
+		numBlocks, k, _ := OptimalTwoChoiceParams(tc.items, tc.fpRate)
+		if numBlocks == 0 || k < MinK || k > MaxK {
+			t.Errorf("OptimalTwoChoiceParams(%d, %g) = (%d, %d)", tc.items, tc.fpRate, numBlocks, k)
+		}
+	}
+}
+
+func TestEstimateTwoChoiceFalsePositiveRate(t *testing.T) {
+	if got := EstimateTwoChoiceFalsePositiveRate(0, 7, 100); got != 0 {
+		t.Errorf("zero blocks: got %g", got)
+	}
+	if got := EstimateTwoChoiceFalsePositiveRate(100, 7, 0); got != 0 {
+		t.Errorf("zero items: got %g", got)
+	}
+	if got := EstimateTwoChoiceFalsePositiveRate(100, MaxK+1, 100); got != 0 {
+		t.Errorf("unsupported k: got %g", got)
+	}
+
+	// Two probed blocks at the same load cost about twice one block's rate,
+	// but losing the overloaded blocks more than makes up for it at low rates
+	single := EstimateFalsePositiveRate(1000, 14, 20000)
+	two := EstimateTwoChoiceFalsePositiveRate(1000, 14, 20000)
+	if two >= single {
+		t.Errorf("two-choice estimate %g not below single-block %g", two, single)
+	}
+	atMean := partitionedBlockFP(GetPrimePartition(14), 20)
+	if two < 2*atMean*0.99 {
+		t.Errorf("two-choice estimate %g below twice the mean-load rate %g", two, 2*atMean)
+	}
+}
+
+func TestTwoChoiceFilterFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping statistical test in short mode")
+	}
+
+	for _, tc := range []struct {
+		k     uint32
+		items int
+	}{
+		{7, 100000},
+		{10, 70000},
+		{12, 50000},
+	} {
+		t.Run(fmt.Sprintf("k_%d", tc.k), func(t *testing.T) {
+			f := NewTwoChoiceWithParams(2000, tc.k)
+			for i := range tc.items {
+				f.Add(fmt.Appendf(nil, "item-%d", i))
+			}
+
+			const testItems = 1_000_000
+			var falsePositives int
+			for i := range testItems {
+				if f.Test(fmt.Appendf(nil, "notitem-%d", i)) {
+					falsePositives++
+				}
+			}
+
+			actual := float64(falsePositives) / testItems
+			estimated := f.EstimatedFalsePositiveRate()
+			if actual > estimated*1.2 || actual < estimated/1.2 {
+				t.Errorf("FP rate %.3g too far from estimate %.3g", actual, estimated)
+			}
+			t.Logf("FP rate %.3g (estimate %.3g)", actual, estimated)
+		})
+	}
+}